@@ -49,6 +49,7 @@ func setupAutoDiscovery(confSearchPaths []string, metaScheduler *scheduler.MetaS
 			Polling:     true,
 			TemplateURL: strings.Join(cf.Endpoints, ","),
 			Token:       cf.ApiKey,
+			Watch:       cf.N9eProviderWatch,
 		})
 	}
 
@@ -769,7 +769,6 @@ func waitForConfigs(checkName string, retryInterval, timeout time.Duration) []in
 	}
 }
 
-// TODO: re-enable when the API endpoint is implemented
 func newListchecksCmd(env *agent.EnvSettings) *cobra.Command {
 	return &cobra.Command{
 		Use:   "list-checks",
@@ -28,6 +28,7 @@ import (
 	"github.com/n9e/n9e-agentd/cmd/agent/common"
 	"github.com/n9e/n9e-agentd/cmd/agent/common/misconfig"
 	"github.com/n9e/n9e-agentd/pkg/config"
+	fconfig "github.com/n9e/n9e-agentd/pkg/config/forwarder"
 	"github.com/n9e/n9e-agentd/pkg/config/settings"
 	commonsettings "github.com/n9e/n9e-agentd/pkg/config/settings"
 	"github.com/n9e/n9e-agentd/pkg/i18n"
@@ -314,10 +315,14 @@ func (p *agentServer) startHealthprobe() error {
 
 func (p *agentServer) startForwarder() error {
 	// setup the forwarder
+	remoteWrite := p.config.Forwarder.Output == fconfig.OutputRemoteWrite
 	keysPerDomain, err := config.GetMultipleEndpoints()
 	if err != nil {
 		klog.Error("Misconfiguration of agent endpoints: ", err)
 	}
+	if remoteWrite {
+		keysPerDomain = config.GetRemoteWriteEndpoints()
+	}
 
 	// Enable core agent specific features like persistence-to-disk
 	options := forwarder.NewOptions(keysPerDomain)
@@ -325,7 +330,9 @@ func (p *agentServer) startForwarder() error {
 	options.CompletionHandler = completionHandler
 
 	var f forwarder.Forwarder
-	if p.config.EnableN9eProvider {
+	if remoteWrite {
+		f = forwarder.NewRemoteWriteForwarder(options, p.config.Forwarder.RemoteWrite.Headers)
+	} else if p.config.EnableN9eProvider {
 		f = forwarder.NewN9eForwarder(options)
 	} else {
 		f = forwarder.NewDefaultForwarder(options)
@@ -5,6 +5,10 @@ const (
 	RoutePathLogs = "/api/v1/logs/input"
 
 	// collect rule
+	// GET RoutePathGetCollectRules?ident=<ident>[&watch=true&timeout=<seconds>]
+	// The response has an ETag header, the server replies 304 Not Modified
+	// if it matches the If-None-Match header of the request.
+	// With watch, the server holds the request until the rules change or timeout.
 	RoutePathGetCollectRules        = "/v1/n9e/collect-rules-belong-to-ident"
 	RoutePathGetCollectRulesSummary = "/v1/n9e/collect-rules-summary"
 	N9eV1SeriesEndpoint             = "/v1/n9e/series" // "series_v1"
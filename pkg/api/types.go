@@ -1,8 +1,11 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"time"
 
 	"sigs.k8s.io/yaml"
@@ -17,6 +20,15 @@ type SettingInput struct {
 	Value   string `param:"query"`
 }
 
+type CheckInput struct {
+	Name string `param:"path" description:"check name or instance id"`
+}
+
+type CheckRunInput struct {
+	Name    string `param:"path" description:"check name"`
+	Timeout int    `param:"query" description:"seconds to wait for the run, default 30"`
+}
+
 type StatsdReplayInput struct {
 	ReplayFile string `param:"query" flag:"file,d" description:"Input file with TCP traffic to replay."`
 	TaggerFile string `param:"query" flag:"tagger" description:"Input file with TCP traffic to replay."`
@@ -69,12 +81,12 @@ type CollectRule struct {
 	Type string `json:"type"` // required Config.Name checkName
 	Data string `json:"data"` // required Config.Instances[$]
 
-	Interval  int    `json:"-"` // -> Config.Instances[$].MinCollectionInterval
-	Tags      string `json:"-"` // -> Config.Instances[$].Tags   a:b,b:c
-	CreatedAt int64  `json:"-"` // deprecated
-	UpdatedAt int64  `json:"-"` // deprecated
-	Creator   string `json:"-"` // deprecated
-	Updater   string `json:"-"` // deprecated
+	Interval  int    `json:"step"`        // option Config.Instances[$].MinCollectionInterval, in seconds
+	Tags      string `json:"append_tags"` // option Config.Instances[$].Tags   a:b,b:c
+	CreatedAt int64  `json:"-"`           // deprecated
+	UpdatedAt int64  `json:"-"`           // deprecated
+	Creator   string `json:"-"`           // deprecated
+	Updater   string `json:"-"`           // deprecated
 }
 
 type CollectRulesWrap struct {
@@ -83,8 +95,25 @@ type CollectRulesWrap struct {
 }
 
 type CollectRulesSummary struct {
-	LatestUpdatedAt int64 `json:"latest_updated_at"`
-	Total           int   `json:"total"`
+	LatestUpdatedAt int64  `json:"latest_updated_at"`
+	Total           int    `json:"total"`
+	Hash            string `json:"hash"` // optional, content hash of the rules, same as the ETag of RoutePathGetCollectRules
+}
+
+// CollectRulesHash returns the content hash of the rules, which is independent of their order
+func CollectRulesHash(rules []CollectRule) string {
+	sorted := make([]CollectRule, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].ID != sorted[j].ID {
+			return sorted[i].ID < sorted[j].ID
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	h := sha256.New()
+	json.NewEncoder(h).Encode(sorted) //nolint:errcheck
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 type CollectRulesSummaryWrap struct {
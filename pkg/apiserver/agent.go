@@ -239,7 +239,3 @@ func statsdSetTaggerStatus(w http.ResponseWriter, r *http.Request, _ *rest.NonPa
 
 	return &pb.TaggerStateResponse{Loaded: true}, nil
 }
-
-func unsupported(w http.ResponseWriter, r *http.Request) error {
-	return fmt.Errorf("unsupported")
-}
@@ -0,0 +1,315 @@
+package apiserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/providers"
+	"github.com/DataDog/datadog-agent/pkg/collector/check"
+	"github.com/DataDog/datadog-agent/pkg/collector/loaders"
+	"github.com/DataDog/datadog-agent/pkg/collector/runner"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/n9e/n9e-agentd/cmd/agent/common"
+	"github.com/n9e/n9e-agentd/pkg/api"
+	"github.com/n9e/n9e-agentd/pkg/apiserver/response"
+	"github.com/n9e/n9e-agentd/pkg/util/capturesender"
+	yaml "gopkg.in/yaml.v2"
+	"k8s.io/klog/v2"
+)
+
+// maxCheckRunConfigSize limits the body of a check run request
+const maxCheckRunConfigSize = 1 << 20
+
+func getChecks(w http.ResponseWriter, r *http.Request) ([]*response.CheckInstance, error) {
+	if common.Coll == nil {
+		return nil, fmt.Errorf("the collector is not running")
+	}
+
+	return checkInstances(common.Coll.GetChecks(), ""), nil
+}
+
+// getCheck returns the instance of the id, or all the instances of the check name
+func getCheck(w http.ResponseWriter, r *http.Request, in *api.CheckInput) ([]*response.CheckInstance, error) {
+	if common.Coll == nil {
+		return nil, fmt.Errorf("the collector is not running")
+	}
+
+	instances := checkInstances(common.Coll.GetChecks(), in.Name)
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("check %s not found", in.Name)
+	}
+
+	return instances, nil
+}
+
+// deleteCheck unschedules the instance of the id, or all the instances of the check name,
+// until autodiscovery schedules its configuration again
+func deleteCheck(w http.ResponseWriter, r *http.Request, in *api.CheckInput) (*response.CheckActionResponse, error) {
+	if common.Coll == nil {
+		return nil, fmt.Errorf("the collector is not running")
+	}
+
+	klog.Infof("Got a request to unschedule check %s", in.Name)
+
+	resp := &response.CheckActionResponse{}
+	for _, c := range common.Coll.GetChecks() {
+		if !matchCheck(c, in.Name) {
+			continue
+		}
+		if err := common.Coll.StopCheck(c.ID()); err != nil {
+			return resp, fmt.Errorf("unable to stop check %s: %s", c.ID(), err)
+		}
+		resp.Stopped = append(resp.Stopped, string(c.ID()))
+	}
+
+	if len(resp.Stopped) == 0 {
+		return nil, fmt.Errorf("check %s not found", in.Name)
+	}
+
+	return resp, nil
+}
+
+// reloadCheck collects the configurations from the providers and schedules the check again
+func reloadCheck(w http.ResponseWriter, r *http.Request, in *api.CheckInput) (*response.CheckActionResponse, error) {
+	if common.AC == nil {
+		return nil, fmt.Errorf("Trying to reload a check before the agent has been initialized.")
+	}
+
+	klog.Infof("Got a request to reload check %s", in.Name)
+
+	n, err := common.AC.ReloadConfigs(r.Context(), in.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response.CheckActionResponse{Reloaded: n}, nil
+}
+
+// matchCheck returns true if name is the id or the check name of c
+func matchCheck(c check.Check, name string) bool {
+	return name == "" || string(c.ID()) == name || c.String() == name
+}
+
+func checkInstances(checks []check.Check, name string) []*response.CheckInstance {
+	stats := runner.GetCheckStats()
+
+	instances := []*response.CheckInstance{}
+	for _, c := range checks {
+		if !matchCheck(c, name) {
+			continue
+		}
+
+		instance := &response.CheckInstance{
+			ID:           string(c.ID()),
+			Name:         c.String(),
+			Version:      c.Version(),
+			ConfigSource: c.ConfigSource(),
+			Interval:     int64(c.Interval().Seconds()),
+		}
+		if s, ok := stats[c.String()][c.ID()]; ok {
+			instance.Stats = &response.CheckInstanceStats{
+				TotalRuns:            s.TotalRuns,
+				TotalErrors:          s.TotalErrors,
+				TotalWarnings:        s.TotalWarnings,
+				MetricSamples:        s.MetricSamples,
+				Events:               s.Events,
+				ServiceChecks:        s.ServiceChecks,
+				AverageExecutionTime: s.AverageExecutionTime,
+				LastExecutionTime:    s.LastExecutionTime,
+				LastSuccessDate:      s.LastSuccessDate,
+				LastError:            s.LastError,
+				LastWarnings:         s.LastWarnings,
+				UpdateTimestamp:      s.UpdateTimestamp,
+			}
+		}
+		instances = append(instances, instance)
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+
+	return instances
+}
+
+// checkRunResponse holds the results of a check run, one per instance,
+// it is not in the response package, which can not import the metrics
+type checkRunResponse struct {
+	Instances []*checkRunResult `json:"instances"`
+}
+
+// checkRunResult holds what an instance submitted during a run
+type checkRunResult struct {
+	ID               string                          `json:"id,omitempty"`
+	Error            string                          `json:"error,omitempty"` // load or run error
+	Warnings         []string                        `json:"warnings,omitempty"`
+	Duration         int64                           `json:"duration"` // milliseconds
+	Metrics          []capturesender.Metric          `json:"metrics"`
+	ServiceChecks    []capturesender.ServiceCheck    `json:"service_checks"`
+	Events           []metrics.Event                 `json:"events"`
+	HistogramBuckets []capturesender.HistogramBucket `json:"histogram_buckets,omitempty"`
+}
+
+// runCheck runs the instances of an ad-hoc config (the conf.yaml format,
+// YAML or JSON) once, and returns what they submitted without forwarding it
+func runCheck(w http.ResponseWriter, r *http.Request, in *api.CheckRunInput) (*checkRunResponse, error) {
+	if common.Coll == nil {
+		return nil, fmt.Errorf("the collector is not running")
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxCheckRunConfigSize))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the config: %s", err)
+	}
+
+	config, err := providers.ParseYAMLConfig(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %s", err)
+	}
+	config.Name = in.Name
+	config.Source = "api"
+
+	klog.Infof("Got a request to run check %s with %d instances", in.Name, len(config.Instances))
+
+	timeout := time.Duration(in.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	running := map[check.ID]bool{}
+	for _, c := range common.Coll.GetChecks() {
+		running[c.ID()] = true
+	}
+
+	resp := &checkRunResponse{Instances: []*checkRunResult{}}
+	for _, instance := range config.Instances {
+		resp.Instances = append(resp.Instances, runCheckInstance(*config, instance, running, timeout))
+	}
+
+	return resp, nil
+}
+
+// adhocChecks are the ids of the ad-hoc checks whose capture sender is
+// registered, until their Run returns
+var adhocChecks = struct {
+	sync.Mutex
+	ids map[check.ID]bool
+}{ids: map[check.ID]bool{}}
+
+func runCheckInstance(config integration.Config, instance integration.Data, running map[check.ID]bool, timeout time.Duration) *checkRunResult {
+	// the id is known before the check is loaded, Configure must not touch
+	// the sender of a running instance, nor of another ad-hoc run
+	id := check.BuildID(config.Name, instance, config.InitConfig)
+	result := &checkRunResult{ID: string(id)}
+	if running[id] {
+		result.Error = fmt.Sprintf("check %s is running with the same config, see /api/v1/checks/%s", id, id)
+		return result
+	}
+
+	adhocChecks.Lock()
+	if adhocChecks.ids[id] {
+		adhocChecks.Unlock()
+		result.Error = fmt.Sprintf("check %s is being run with the same config", id)
+		return result
+	}
+	adhocChecks.ids[id] = true
+	adhocChecks.Unlock()
+
+	// the capture sender is registered before Configure, which sets the
+	// custom tags and the service on it
+	sender := capturesender.New()
+	if err := aggregator.SetSender(sender, id); err != nil {
+		releaseCheck(nil, id)
+		result.Error = err.Error()
+		return result
+	}
+
+	c, err := loadCheck(config, instance)
+	if err != nil {
+		releaseCheck(nil, id)
+		result.Error = err.Error()
+		return result
+	}
+	if c.ID() != id {
+		// the sender of c.ID() is not ours, c is not canceled
+		releaseCheck(nil, id)
+		result.Error = fmt.Sprintf("check %s is loaded with the unexpected id %s", id, c.ID())
+		return result
+	}
+
+	// the loaders of the other languages may not set them
+	commonOptions := integration.CommonInstanceConfig{}
+	if err := yaml.Unmarshal(instance, &commonOptions); err == nil {
+		sender.SetCheckCustomTags(commonOptions.Tags)
+		sender.SetCheckService(commonOptions.Service)
+	}
+
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() { done <- c.Run() }()
+
+	select {
+	case err = <-done:
+		defer releaseCheck(c, id)
+	case <-time.After(timeout):
+		c.Stop()
+		err = fmt.Errorf("check %s did not return within %s", c.ID(), timeout)
+		// the capture sender is kept until Run returns, otherwise the check
+		// would get a new sender that forwards the samples
+		go func() {
+			<-done
+			releaseCheck(c, id)
+		}()
+	}
+	result.Duration = time.Since(start).Milliseconds()
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+	for _, w := range c.GetWarnings() {
+		result.Warnings = append(result.Warnings, w.Error())
+	}
+
+	// the check may be still running after the timeout
+	sender.Lock()
+	defer sender.Unlock()
+	result.Metrics = append([]capturesender.Metric{}, sender.Metrics...)
+	result.ServiceChecks = append([]capturesender.ServiceCheck{}, sender.ServiceChecks...)
+	result.Events = append([]metrics.Event{}, sender.Events...)
+	result.HistogramBuckets = append([]capturesender.HistogramBucket(nil), sender.HistogramBuckets...)
+
+	return result
+}
+
+// releaseCheck cancels the ad-hoc check and destroys its capture sender
+func releaseCheck(c check.Check, id check.ID) {
+	if c != nil {
+		c.Cancel()
+	}
+	aggregator.DestroySender(id)
+
+	adhocChecks.Lock()
+	delete(adhocChecks.ids, id)
+	adhocChecks.Unlock()
+}
+
+// loadCheck loads the instance with the first loader that succeeds
+func loadCheck(config integration.Config, instance integration.Data) (check.Check, error) {
+	var errs []string
+	for _, loader := range loaders.LoaderCatalog() {
+		c, err := loader.Load(config, instance)
+		if err == nil {
+			return c, nil
+		}
+		errs = append(errs, fmt.Sprintf("%v: %s", loader, err))
+	}
+
+	return nil, fmt.Errorf("unable to load check %s: %s", config.Name, strings.Join(errs, "; "))
+}
@@ -0,0 +1,307 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery"
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/providers"
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/scheduler"
+	"github.com/DataDog/datadog-agent/pkg/collector"
+	"github.com/DataDog/datadog-agent/pkg/collector/check"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	"github.com/n9e/n9e-agentd/cmd/agent/common"
+	"github.com/n9e/n9e-agentd/pkg/api"
+	"github.com/n9e/n9e-agentd/pkg/config"
+	"github.com/n9e/n9e-agentd/pkg/util/capturesender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testCheckName     = "apiserver_test"
+	testIdleCheckName = "apiserver_idle_test"
+)
+
+// testCheck submits a gauge, waits for release and submits another gauge
+// with the sender it gets after the wait
+type testCheck struct {
+	core.CheckBase
+	release chan struct{}
+	senders chan aggregator.Sender
+}
+
+func (c *testCheck) Run() error {
+	sender, err := aggregator.GetSender(c.ID())
+	if err != nil {
+		return err
+	}
+	sender.Gauge("test.before", 1, "", nil)
+	sender.Commit()
+
+	<-c.release
+
+	sender, err = aggregator.GetSender(c.ID())
+	if err != nil {
+		return err
+	}
+	sender.Gauge("test.after", 1, "", nil)
+	sender.Commit()
+	c.senders <- sender
+	return nil
+}
+
+func (c *testCheck) Configure(data integration.Data, initConfig integration.Data, source string) error {
+	c.BuildID(data, initConfig)
+	return c.CommonConfigure(data, source)
+}
+
+// idleCheck is scheduled by autodiscovery, it submits nothing
+type idleCheck struct {
+	core.CheckBase
+}
+
+func (c *idleCheck) Run() error {
+	return nil
+}
+
+func (c *idleCheck) Configure(data integration.Data, initConfig integration.Data, source string) error {
+	c.BuildID(data, initConfig)
+	return c.CommonConfigure(data, source)
+}
+
+var testChecks = make(chan *testCheck, 10)
+
+func init() {
+	core.RegisterCheck(testCheckName, func() check.Check {
+		c := &testCheck{
+			CheckBase: core.NewCheckBase(testCheckName),
+			release:   make(chan struct{}),
+			senders:   make(chan aggregator.Sender, 1),
+		}
+		testChecks <- c
+		return c
+	})
+	core.RegisterCheck(testIdleCheckName, func() check.Check {
+		return &idleCheck{CheckBase: core.NewCheckBase(testIdleCheckName)}
+	})
+}
+
+// staticProvider returns the same configs on every Collect
+type staticProvider struct {
+	configs []integration.Config
+}
+
+func (p *staticProvider) Collect(ctx context.Context) ([]integration.Config, error) {
+	return p.configs, nil
+}
+
+func (p *staticProvider) String() string {
+	return "static"
+}
+
+func (p *staticProvider) IsUpToDate(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (p *staticProvider) GetConfigErrors() map[string]providers.ErrorMsgSet {
+	return make(map[string]providers.ErrorMsgSet)
+}
+
+func initAggregator() {
+	config.Mock()
+	aggregator.InitAggregatorWithFlushInterval(nil, nil, "", time.Hour)
+}
+
+func TestRunCheckInstance(t *testing.T) {
+	initAggregator()
+
+	conf := integration.Config{Name: testCheckName, Source: "api"}
+	instance := integration.Data("tags: [env:test]")
+	id := check.BuildID(testCheckName, instance, nil)
+
+	go func() { close((<-testChecks).release) }()
+	result := runCheckInstance(conf, instance, nil, time.Second)
+	assert.Equal(t, string(id), result.ID)
+	assert.Empty(t, result.Error)
+	assert.Equal(t, []capturesender.Metric{
+		{Type: "gauge", Name: "test.before", Value: 1, Tags: []string{"env:test"}},
+		{Type: "gauge", Name: "test.after", Value: 1, Tags: []string{"env:test"}},
+	}, result.Metrics)
+
+	// the capture sender is destroyed after the run
+	sender, err := aggregator.GetSender(id)
+	require.NoError(t, err)
+	assert.NotEqual(t, "*capturesender.CaptureSender", typeName(sender))
+	aggregator.DestroySender(id)
+}
+
+func TestRunCheckInstanceRunning(t *testing.T) {
+	initAggregator()
+
+	conf := integration.Config{Name: testCheckName, Source: "api"}
+	instance := integration.Data("tags: [env:test]")
+	id := check.BuildID(testCheckName, instance, nil)
+
+	// the sender of the running instance is not touched
+	live, err := aggregator.GetSender(id)
+	require.NoError(t, err)
+	defer aggregator.DestroySender(id)
+
+	result := runCheckInstance(conf, instance, map[check.ID]bool{id: true}, time.Second)
+	assert.Contains(t, result.Error, "is running with the same config")
+	assert.Empty(t, testChecks, "the check is not loaded")
+
+	sender, err := aggregator.GetSender(id)
+	require.NoError(t, err)
+	assert.True(t, live == sender)
+}
+
+func TestRunCheckInstanceTimeout(t *testing.T) {
+	initAggregator()
+
+	conf := integration.Config{Name: testCheckName, Source: "api"}
+	instance := integration.Data("tags: [env:timeout]")
+	id := check.BuildID(testCheckName, instance, nil)
+
+	result := runCheckInstance(conf, instance, nil, 50*time.Millisecond)
+	assert.Contains(t, result.Error, "did not return within")
+	assert.Equal(t, []capturesender.Metric{
+		{Type: "gauge", Name: "test.before", Value: 1, Tags: []string{"env:timeout"}},
+	}, result.Metrics)
+
+	// another run is refused while the check is running
+	result = runCheckInstance(conf, instance, nil, 50*time.Millisecond)
+	assert.Contains(t, result.Error, "is being run with the same config")
+
+	// the check outlives the timeout, it still gets the capture sender
+	c := <-testChecks
+	close(c.release)
+	sender := <-c.senders
+	assert.Equal(t, "*capturesender.CaptureSender", typeName(sender))
+
+	// the capture sender is destroyed once Run returns
+	assert.Eventually(t, func() bool {
+		adhocChecks.Lock()
+		defer adhocChecks.Unlock()
+		return !adhocChecks.ids[id]
+	}, time.Second, 10*time.Millisecond)
+	sender, err := aggregator.GetSender(id)
+	require.NoError(t, err)
+	assert.NotEqual(t, "*capturesender.CaptureSender", typeName(sender))
+	aggregator.DestroySender(id)
+}
+
+// startCollector schedules the instances of the idle check with the
+// collector and autodiscovery of the agent
+func startCollector(t *testing.T, instances ...string) {
+	initAggregator()
+
+	coll := collector.NewCollector()
+	metaScheduler := scheduler.NewMetaScheduler()
+	metaScheduler.Register("check", collector.InitCheckScheduler(coll))
+
+	conf := integration.Config{Name: testIdleCheckName}
+	for _, instance := range instances {
+		conf.Instances = append(conf.Instances, integration.Data(instance))
+	}
+	ac := autodiscovery.NewAutoConfig(metaScheduler)
+	ac.AddConfigProvider(&staticProvider{configs: []integration.Config{conf}}, false, 0)
+	ac.LoadAndRun()
+
+	common.Coll, common.AC = coll, ac
+	t.Cleanup(func() {
+		coll.Stop()
+		common.Coll, common.AC = nil, nil
+	})
+}
+
+func checkIDs() []string {
+	var ids []string
+	for _, c := range common.Coll.GetChecks() {
+		ids = append(ids, string(c.ID()))
+	}
+	return ids
+}
+
+func TestReloadCheck(t *testing.T) {
+	startCollector(t, "tags: [a:1]", "tags: [a:2]")
+	req := httptest.NewRequest("POST", "/", nil)
+
+	ids := checkIDs()
+	require.Len(t, ids, 2)
+	prev := map[string]check.Check{}
+	for _, c := range common.Coll.GetChecks() {
+		prev[string(c.ID())] = c
+	}
+
+	// the unchanged configs are unscheduled and scheduled again
+	resp, err := reloadCheck(nil, req, &api.CheckInput{Name: testIdleCheckName})
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.Reloaded)
+	assert.ElementsMatch(t, ids, checkIDs())
+	for _, c := range common.Coll.GetChecks() {
+		assert.False(t, prev[string(c.ID())] == c, "%s is not scheduled again", c.ID())
+	}
+}
+
+func TestDeleteReloadCheck(t *testing.T) {
+	startCollector(t, "tags: [a:1]", "tags: [a:2]")
+	req := httptest.NewRequest("POST", "/", nil)
+
+	ids := checkIDs()
+	require.Len(t, ids, 2)
+
+	// an instance by id
+	resp, err := deleteCheck(nil, req, &api.CheckInput{Name: ids[0]})
+	require.NoError(t, err)
+	assert.Equal(t, []string{ids[0]}, resp.Stopped)
+	assert.Equal(t, ids[1:], checkIDs())
+
+	// the instances by check name
+	resp, err = deleteCheck(nil, req, &api.CheckInput{Name: testIdleCheckName})
+	require.NoError(t, err)
+	assert.Equal(t, ids[1:], resp.Stopped)
+	assert.Empty(t, checkIDs())
+
+	// the reload brings the check back
+	reload, err := reloadCheck(nil, req, &api.CheckInput{Name: testIdleCheckName})
+	require.NoError(t, err)
+	assert.Equal(t, 1, reload.Reloaded)
+	assert.ElementsMatch(t, ids, checkIDs())
+
+	// and again after another delete
+	_, err = deleteCheck(nil, req, &api.CheckInput{Name: testIdleCheckName})
+	require.NoError(t, err)
+	_, err = reloadCheck(nil, req, &api.CheckInput{Name: testIdleCheckName})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, ids, checkIDs())
+}
+
+func TestUnknownCheck(t *testing.T) {
+	startCollector(t, "tags: [a:1]")
+	req := httptest.NewRequest("POST", "/", nil)
+
+	reload, err := reloadCheck(nil, req, &api.CheckInput{Name: "unknown"})
+	assert.Error(t, err)
+	assert.Nil(t, reload)
+
+	resp, err := deleteCheck(nil, req, &api.CheckInput{Name: "unknown"})
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+
+	_, err = getCheck(nil, req, &api.CheckInput{Name: "unknown"})
+	assert.Error(t, err)
+
+	// the check is not touched
+	assert.Len(t, checkIDs(), 1)
+}
+
+func typeName(v interface{}) string {
+	return fmt.Sprintf("%T", v)
+}
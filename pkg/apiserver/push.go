@@ -0,0 +1,62 @@
+package apiserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/collector/check"
+	"github.com/n9e/n9e-agentd/pkg/apiserver/response"
+	"github.com/n9e/n9e-agentd/pkg/util/falcon"
+	apierrors "github.com/yubo/golib/api/errors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// pushCheckID is the id of the sender of the pushed series
+	pushCheckID check.ID = "push"
+
+	// maxPushSize limits the body of a push request
+	maxPushSize = 8 << 20
+)
+
+// push submits the pushed series (n9e / open-falcon MetricValue json, a list
+// or a single one) with the semantics of the script checks, the invalid
+// series are skipped and reported
+func push(w http.ResponseWriter, r *http.Request) (*response.PushResponse, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPushSize))
+	if err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("unable to read the body: %s", err))
+	}
+
+	series, err := falcon.Unmarshal(body)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid body: %s", err))
+	}
+
+	sender, err := aggregator.GetSender(pushCheckID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &response.PushResponse{}
+	for i, serie := range series {
+		if err := serie.Validate(); err != nil {
+			resp.Errors = append(resp.Errors, &response.PushError{Index: i, Metric: serie.Metric, Error: err.Error()})
+			continue
+		}
+		falcon.Send(sender, serie)
+		resp.Accepted++
+	}
+
+	// the series are flushed with the next flush of the aggregator, the
+	// state of the rates and the monotonic counts is kept between commits
+	sender.Commit()
+
+	if len(resp.Errors) > 0 {
+		klog.V(5).Infof("push from %s: %d accepted, %d invalid, first error: %s", r.RemoteAddr, resp.Accepted, len(resp.Errors), resp.Errors[0].Error)
+	}
+
+	return resp, nil
+}
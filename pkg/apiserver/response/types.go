@@ -26,3 +26,48 @@ type TaggerListResponse struct {
 type TaggerListEntity struct {
 	Tags map[string][]string `json:"tags"`
 }
+
+// CheckInstance holds a running check instance and its last run stats
+type CheckInstance struct {
+	ID           string              `json:"id"`
+	Name         string              `json:"name"`
+	Version      string              `json:"version"`
+	ConfigSource string              `json:"config_source"`
+	Interval     int64               `json:"interval"` // seconds, 0 for long running checks
+	Stats        *CheckInstanceStats `json:"stats,omitempty"`
+}
+
+// CheckInstanceStats holds the stats of a check instance, nil if it has not run yet
+type CheckInstanceStats struct {
+	TotalRuns            uint64   `json:"total_runs"`
+	TotalErrors          uint64   `json:"total_errors"`
+	TotalWarnings        uint64   `json:"total_warnings"`
+	MetricSamples        int64    `json:"metric_samples"`
+	Events               int64    `json:"events"`
+	ServiceChecks        int64    `json:"service_checks"`
+	AverageExecutionTime int64    `json:"average_execution_time"` // milliseconds
+	LastExecutionTime    int64    `json:"last_execution_time"`    // milliseconds
+	LastSuccessDate      int64    `json:"last_success_date"`      // unix seconds
+	LastError            string   `json:"last_error"`
+	LastWarnings         []string `json:"last_warnings"`
+	UpdateTimestamp      int64    `json:"update_timestamp"` // unix seconds
+}
+
+// CheckActionResponse holds the result of an unschedule or reload
+type CheckActionResponse struct {
+	Stopped  []string `json:"stopped,omitempty"`  // ids of the unscheduled instances
+	Reloaded int      `json:"reloaded,omitempty"` // number of the rescheduled configurations
+}
+
+// PushResponse is the result of a push, the invalid series are skipped
+type PushResponse struct {
+	Accepted int          `json:"accepted"`
+	Errors   []*PushError `json:"errors,omitempty"`
+}
+
+// PushError is the validation error of a pushed series
+type PushError struct {
+	Index  int    `json:"index"` // index of the series in the request
+	Metric string `json:"metric"`
+	Error  string `json:"error"`
+}
@@ -93,24 +93,29 @@ func (p *module) installChecksWs(c rest.GoRestfulContainer) {
 		GoRestfulContainer: c,
 		Routes: []rest.WsRoute{{
 			Method: "GET", Scope: "read",
-			SubPath: "/checks",
-			Handle:  unsupported,
+			SubPath: "/",
+			Handle:  getChecks,
 			Desc:    "get checks list",
 		}, {
 			Method: "GET", Scope: "read",
-			SubPath: "/checks/{name}",
-			Handle:  unsupported,
+			SubPath: "/{name}",
+			Handle:  getCheck,
 			Desc:    "get check detail",
 		}, {
 			Method: "DELETE", Scope: "write",
-			SubPath: "/checks/{name}",
-			Handle:  unsupported,
-			Desc:    "get check detail",
+			SubPath: "/{name}",
+			Handle:  deleteCheck,
+			Desc:    "unschedule check",
 		}, {
 			Method: "POST", Scope: "write",
-			SubPath: "/checks/{name}/reload",
-			Handle:  unsupported,
+			SubPath: "/{name}/reload",
+			Handle:  reloadCheck,
 			Desc:    "reload check",
+		}, {
+			Method: "POST", Scope: "write",
+			SubPath: "/{name}/run",
+			Handle:  runCheck,
+			Desc:    "run check once with the config in the body (conf.yaml format, yaml or json), return the collected series without forwarding them",
 		}},
 	})
 }
@@ -181,6 +186,11 @@ func (p *module) installGenericWs(c rest.GoRestfulContainer) {
 			SubPath: "/secrets",
 			Handle:  secretInfo,
 			Desc:    "get secrets info",
+		}, {
+			Method: "POST", Scope: "write",
+			SubPath: "/push",
+			Handle:  push,
+			Desc:    "push n9e / open-falcon series (metric, value, type, tags, timestamp, step), gauge, counter and subtract as the script checks",
 		}},
 	})
 }
@@ -100,6 +100,7 @@ type Config struct {
 	Alias                  string `json:"alias" flag:"alias" description:"Alias of the host"`
 	Lang                   string `json:"lang" flag:"lang" description:"Default lang(zh, en)"`
 	EnableN9eProvider      bool   `json:"enable_n9e_provider" flag:"enable-n9e-provider" description:"enable n9e server api as autodiscovery provider"`
+	N9eProviderWatch       bool   `json:"n9e_provider_watch" flag:"n9e-provider-watch" description:"long-poll the n9e server for collect rules changes"`
 	PayloadProcessorConfig string `json:"payload_processor_config"`
 
 	//N9eSeriesFormat   bool     `json:"n9e_series_format"`                                                                                               // the payload format for forwarder
@@ -865,6 +866,8 @@ type ConfigurationProviders struct {
 	KeyFile          string `json:"key_file"`
 	Token            string `json:"token"`
 	GraceTimeSeconds int    `json:"grace_time_seconds"`
+	Watch            bool   `json:"watch"`         // http: long-poll the server for changes
+	WatchTimeout     int    `json:"watch_timeout"` // http: seconds the server may hold a watch request
 }
 
 // Listeners helps unmarshalling `listeners` config param
@@ -321,6 +321,10 @@ func defaultConfig() *Config {
 			RequeueBufferSize:         100,
 			RetryQueueMaxSize:         0,
 			RetryQueuePayloadsMaxSize: resource.MustParse("15Mi"),
+			Output:                    forwarder.OutputN9e,
+			RemoteWrite: forwarder.RemoteWrite{
+				MaxSamplesPerSend: 2000,
+			},
 		},
 		InternalProfiling: internalprofiling.InternalProfiling{
 			Enabled:     false,
@@ -3,11 +3,18 @@ package forwarder
 import (
 	"fmt"
 	"net/url"
+	"strings"
 
 	"github.com/yubo/golib/api"
 	"github.com/yubo/golib/api/resource"
 )
 
+// outputs of the series
+const (
+	OutputN9e         = "n9e"
+	OutputRemoteWrite = "remote_write"
+)
+
 func NewConfig() Config {
 	// defualt config
 	return Config{
@@ -27,6 +34,10 @@ func NewConfig() Config {
 		RequeueBufferSize:         100,
 		RetryQueueMaxSize:         0,
 		RetryQueuePayloadsMaxSize: resource.MustParse("15Mi"),
+		Output:                    OutputN9e,
+		RemoteWrite: RemoteWrite{
+			MaxSamplesPerSend: 2000,
+		},
 	}
 }
 
@@ -54,6 +65,8 @@ type Config struct {
 	RetryQueueMaxSize         int               `json:"retry_queue_max_size"`                             // forwarder_retry_queue_max_size
 	RetryQueuePayloadsMaxSize resource.Quantity `json:"retry_queue_payloads_max_size" description:"15Mi"` // forwarder_retry_queue_payloads_max_size
 
+	Output      string      `json:"output" description:"the format of the series, n9e or remote_write"`
+	RemoteWrite RemoteWrite `json:"remote_write"`
 }
 
 func (p *Config) Validate() error {
@@ -65,6 +78,16 @@ func (p *Config) Validate() error {
 		}
 	}
 
+	switch p.Output {
+	case "", OutputN9e:
+	case OutputRemoteWrite:
+		if err := p.RemoteWrite.Validate(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported agent.forwarder.output %q", p.Output)
+	}
+
 	if p.RecoveryInterval <= 0 {
 		return fmt.Errorf("Configured forwarder.recovery_interval (%v) is not positive", p.RecoveryInterval)
 	}
@@ -75,3 +98,35 @@ type AdditionalEndpoint struct {
 	Endpoints []string `json:"endpoints"`
 	ApiKeys   []string `json:"api_keys"`
 }
+
+// RemoteWrite is the prometheus remote write output, the series are sent to
+// each endpoint, an endpoint may be a comma separated list of urls which are
+// used in turn when one fails
+type RemoteWrite struct {
+	Endpoints         []string          `json:"endpoints" description:"e.g. http://127.0.0.1:8428/api/v1/write"`
+	Headers           map[string]string `json:"headers" description:"the extra headers, e.g. Authorization"`
+	MaxSamplesPerSend int               `json:"max_samples_per_send" description:"the max number of samples of a request, default 2000"`
+}
+
+func (p *RemoteWrite) Validate() error {
+	if len(p.Endpoints) == 0 {
+		return fmt.Errorf("agent.forwarder.remote_write.endpoints is required by the remote_write output")
+	}
+
+	for i, endpoint := range p.Endpoints {
+		for _, rawurl := range strings.Split(endpoint, ",") {
+			u, err := url.Parse(rawurl)
+			if err != nil {
+				return fmt.Errorf("could not parse agent.forwarder.remote_write.endpoints[%d] %s %s", i, rawurl, err)
+			}
+			if u.Scheme != "http" && u.Scheme != "https" {
+				return fmt.Errorf("unsupported scheme of agent.forwarder.remote_write.endpoints[%d] %s", i, rawurl)
+			}
+		}
+	}
+
+	if p.MaxSamplesPerSend <= 0 {
+		p.MaxSamplesPerSend = 2000
+	}
+	return nil
+}
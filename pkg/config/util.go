@@ -205,6 +205,16 @@ func GetMainEndpoint() (host string) {
 	return
 }
 
+// GetRemoteWriteEndpoints returns the remote write endpoints as the domains
+// of the forwarder, the api keys are not used
+func GetRemoteWriteEndpoints() map[string][]string {
+	keysPerDomain := map[string][]string{}
+	for _, endpoint := range C.Forwarder.RemoteWrite.Endpoints {
+		keysPerDomain[endpoint] = []string{""}
+	}
+	return keysPerDomain
+}
+
 // GetMultipleEndpoints returns the api keys per domain specified in the main agent config
 func GetMultipleEndpoints() (map[string][]string, error) {
 	return getMultipleEndpointsWithConfig(C)
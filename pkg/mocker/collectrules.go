@@ -22,6 +22,8 @@ type CollectRules struct {
 	sync.RWMutex
 	rules           []api.CollectRule
 	latestUpdatedAt int64
+	hash            string
+	changed         chan struct{} // closed when the rules change
 }
 
 func (c *CollectRules) String() string {
@@ -42,12 +44,35 @@ func (c *CollectRules) Set(configs []integration.Config) {
 		rs = append(rs, r)
 	}
 
+	hash := api.CollectRulesHash(rs)
+
 	c.Lock()
 	defer c.Unlock()
 
+	if c.changed != nil && hash == c.hash {
+		return
+	}
+
 	c.rules = rs
+	c.hash = hash
 	c.latestUpdatedAt = time.Now().Unix()
-	klog.Infof("rules %d", len(rs))
+	if c.changed != nil {
+		close(c.changed)
+	}
+	c.changed = make(chan struct{})
+	klog.Infof("rules %d hash %s", len(rs), hash)
+}
+
+// Watch returns the rules, their hash, and a channel closed on the next change
+func (c *CollectRules) Watch() ([]api.CollectRule, string, <-chan struct{}) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.changed == nil {
+		c.changed = make(chan struct{})
+	}
+
+	return c.rules, c.hash, c.changed
 }
 
 type RulesPayload struct {
@@ -69,6 +94,7 @@ func (c *CollectRules) GetSummary() *api.CollectRulesSummary {
 	return &api.CollectRulesSummary{
 		LatestUpdatedAt: c.latestUpdatedAt,
 		Total:           len(c.rules),
+		Hash:            c.hash,
 	}
 }
 
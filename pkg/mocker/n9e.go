@@ -3,6 +3,8 @@ package mocker
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/emicklei/go-restful"
 	"github.com/n9e/agent-payload/gogen"
@@ -13,6 +15,8 @@ import (
 	"k8s.io/klog/v2"
 )
 
+const defaultWatchTimeout = 30 * time.Second
+
 func (p *mocker) routesV1N9e() []rest.WsRoute {
 	return []rest.WsRoute{{
 		Method:  "POST",
@@ -63,13 +67,49 @@ func (p *mocker) n9eSeries(w http.ResponseWriter, req *http.Request, _ *rest.Non
 	return "", nil
 }
 
-func (p *mocker) getCollectRules(w http.ResponseWriter, req *http.Request) ([]api.CollectRule, error) {
+type getCollectRulesInput struct {
+	Ident   string `param:"query" name:"ident"`
+	Watch   bool   `param:"query" name:"watch"`
+	Timeout int    `param:"query" name:"timeout" description:"seconds to hold a watch request"`
+}
+
+// getCollectRules writes the rules with an ETag, or 304 if they still match
+// If-None-Match. A watch request is held until the rules change or the timeout.
+func (p *mocker) getCollectRules(w http.ResponseWriter, req *http.Request, in *getCollectRulesInput) {
 	sp, _ := opentracing.StartSpanFromContext(req.Context(), "n9e.get_collect_rules")
 	defer sp.Finish()
 
 	klog.Infof("%s", p.rules.String())
 
-	return p.rules.GetRules(), nil
+	rules, hash, changed := p.rules.Watch()
+	etag := strconv.Quote(hash)
+
+	if req.Header.Get("If-None-Match") == etag && in.Watch {
+		timeout := time.Duration(in.Timeout) * time.Second
+		if timeout <= 0 {
+			timeout = defaultWatchTimeout
+		}
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+
+		select {
+		case <-changed:
+			rules, hash, _ = p.rules.Watch()
+			etag = strconv.Quote(hash)
+		case <-t.C:
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	w.Header().Set("ETag", etag)
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(api.CollectRulesWrap{Data: rules}) //nolint:errcheck
 }
 
 func (p *mocker) getCollectRulesSummary(w http.ResponseWriter, req *http.Request) (*api.CollectRulesSummary, error) {
@@ -0,0 +1,297 @@
+package mocker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/providers"
+	"github.com/emicklei/go-restful"
+	"github.com/n9e/n9e-agentd/pkg/api"
+	"github.com/n9e/n9e-agentd/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testRequest struct {
+	path   string
+	status int
+}
+
+// testServer serves the n9e routes of the mocker and records the requests
+type testServer struct {
+	*httptest.Server
+	mocker *mocker
+
+	sync.Mutex
+	requests []testRequest
+	noETag   bool // as a server without conditional requests
+	down     bool // reply 503
+}
+
+func newTestServer() *testServer {
+	s := &testServer{mocker: &mocker{}}
+
+	container := restful.NewContainer()
+	s.mocker.installN9eWs(container)
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.Lock()
+		noETag, down := s.noETag, s.down
+		s.Unlock()
+
+		rw := &testResponseWriter{ResponseWriter: w, status: http.StatusOK, noETag: noETag}
+		if down {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			if noETag {
+				req.Header.Del("If-None-Match")
+			}
+			container.ServeHTTP(rw, req)
+		}
+
+		s.Lock()
+		s.requests = append(s.requests, testRequest{path: req.URL.Path, status: rw.status})
+		s.Unlock()
+	}))
+
+	return s
+}
+
+func (s *testServer) set(noETag, down bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.noETag, s.down = noETag, down
+}
+
+// takeRequests returns and clears the recorded requests
+func (s *testServer) takeRequests() []testRequest {
+	s.Lock()
+	defer s.Unlock()
+	ret := s.requests
+	s.requests = nil
+	return ret
+}
+
+type testResponseWriter struct {
+	http.ResponseWriter
+	status int
+	noETag bool
+}
+
+func (w *testResponseWriter) WriteHeader(status int) {
+	if w.noETag {
+		w.Header().Del("ETag")
+	}
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *testResponseWriter) Write(b []byte) (int, error) {
+	if w.noETag {
+		w.Header().Del("ETag")
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func portConfig(port int) integration.Config {
+	return integration.Config{
+		Name:      "port",
+		Instances: []integration.Data{integration.Data(fmt.Sprintf("port: %d", port))},
+	}
+}
+
+func newTestProvider(t *testing.T, cf config.ConfigurationProviders) providers.ConfigProvider {
+	dir, err := ioutil.TempDir("", "run_path")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	config.Mock()
+	config.C.Ident = "test"
+	config.C.RunPath = dir
+
+	p, err := providers.NewHttpConfigProvider(cf)
+	require.NoError(t, err)
+	return p
+}
+
+func collectPorts(t *testing.T, p providers.ConfigProvider) []string {
+	configs, err := p.Collect(context.Background())
+	require.NoError(t, err)
+
+	var ports []string
+	for _, c := range configs {
+		for _, instance := range c.Instances {
+			ports = append(ports, string(instance))
+		}
+	}
+	return ports
+}
+
+func TestCollectRulesETag(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	s.mocker.rules.Set([]integration.Config{portConfig(1)})
+
+	p := newTestProvider(t, config.ConfigurationProviders{TemplateURL: s.URL})
+	ctx := context.Background()
+
+	assert.Equal(t, []string{"port: 1\n"}, collectPorts(t, p))
+	s.takeRequests()
+
+	// one conditional request per poll, without the body
+	upToDate, err := p.IsUpToDate(ctx)
+	require.NoError(t, err)
+	assert.True(t, upToDate)
+	assert.Equal(t, []testRequest{{api.RoutePathGetCollectRules, http.StatusNotModified}}, s.takeRequests())
+
+	// the changed rules are fetched by IsUpToDate and returned by Collect
+	s.mocker.rules.Set([]integration.Config{portConfig(2)})
+	upToDate, err = p.IsUpToDate(ctx)
+	require.NoError(t, err)
+	assert.False(t, upToDate)
+	assert.Equal(t, []testRequest{{api.RoutePathGetCollectRules, http.StatusOK}}, s.takeRequests())
+
+	assert.Equal(t, []string{"port: 2\n"}, collectPorts(t, p))
+	assert.Empty(t, s.takeRequests())
+
+	upToDate, err = p.IsUpToDate(ctx)
+	require.NoError(t, err)
+	assert.True(t, upToDate)
+	assert.Equal(t, []testRequest{{api.RoutePathGetCollectRules, http.StatusNotModified}}, s.takeRequests())
+}
+
+func TestCollectRulesSummaryHash(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	s.set(true, false)
+	s.mocker.rules.Set([]integration.Config{portConfig(1)})
+
+	p := newTestProvider(t, config.ConfigurationProviders{TemplateURL: s.URL})
+	ctx := context.Background()
+
+	assert.Equal(t, []string{"port: 1\n"}, collectPorts(t, p))
+
+	// the first summary initializes the hash
+	upToDate, err := p.IsUpToDate(ctx)
+	require.NoError(t, err)
+	assert.False(t, upToDate)
+	collectPorts(t, p)
+
+	upToDate, err = p.IsUpToDate(ctx)
+	require.NoError(t, err)
+	assert.True(t, upToDate)
+
+	// the rule is changed within the same second, the total and the update
+	// time of the summary are the same, only the hash is changed
+	latestUpdatedAt := s.mocker.rules.GetSummary().LatestUpdatedAt
+	s.mocker.rules.Set([]integration.Config{portConfig(2)})
+	s.mocker.rules.Lock()
+	s.mocker.rules.latestUpdatedAt = latestUpdatedAt
+	s.mocker.rules.Unlock()
+
+	s.takeRequests()
+	upToDate, err = p.IsUpToDate(ctx)
+	require.NoError(t, err)
+	assert.False(t, upToDate)
+	assert.Equal(t, []testRequest{{api.RoutePathGetCollectRulesSummary, http.StatusOK}}, s.takeRequests())
+	assert.Equal(t, []string{"port: 2\n"}, collectPorts(t, p))
+
+	upToDate, err = p.IsUpToDate(ctx)
+	require.NoError(t, err)
+	assert.True(t, upToDate)
+}
+
+func TestCollectRulesWatch(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	s.mocker.rules.Set([]integration.Config{portConfig(1)})
+
+	p := newTestProvider(t, config.ConfigurationProviders{TemplateURL: s.URL, Watch: true, WatchTimeout: 1})
+	ctx := context.Background()
+
+	assert.Equal(t, []string{"port: 1\n"}, collectPorts(t, p))
+	s.takeRequests()
+
+	// the server holds the request until the timeout, the next poll
+	// watches again
+	start := time.Now()
+	upToDate, err := p.IsUpToDate(ctx)
+	require.NoError(t, err)
+	assert.True(t, upToDate)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, []testRequest{{api.RoutePathGetCollectRules, http.StatusNotModified}}, s.takeRequests())
+
+	// the request returns on the change
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		s.mocker.rules.Set([]integration.Config{portConfig(2)})
+	}()
+	start = time.Now()
+	upToDate, err = p.IsUpToDate(ctx)
+	require.NoError(t, err)
+	assert.False(t, upToDate)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, []string{"port: 2\n"}, collectPorts(t, p))
+
+	// the server is down, the provider polls the summary until it's back
+	s.set(false, true)
+	_, err = p.IsUpToDate(ctx)
+	assert.Error(t, err)
+	_, err = p.Collect(ctx)
+	assert.Error(t, err)
+	assert.True(t, providers.GetHttpProviderStatus().Stale)
+
+	s.takeRequests()
+	start = time.Now()
+	_, err = p.IsUpToDate(ctx)
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, []testRequest{{api.RoutePathGetCollectRulesSummary, http.StatusServiceUnavailable}}, s.takeRequests())
+
+	s.set(false, false)
+	upToDate, err = p.IsUpToDate(ctx)
+	require.NoError(t, err)
+	assert.False(t, upToDate)
+	assert.Equal(t, []testRequest{{api.RoutePathGetCollectRulesSummary, http.StatusOK}}, s.takeRequests())
+
+	// the watch is resumed once the rules are fetched
+	assert.Equal(t, []string{"port: 2\n"}, collectPorts(t, p))
+	assert.False(t, providers.GetHttpProviderStatus().Stale)
+	s.takeRequests()
+
+	upToDate, err = p.IsUpToDate(ctx)
+	require.NoError(t, err)
+	assert.True(t, upToDate)
+	assert.Equal(t, []testRequest{{api.RoutePathGetCollectRules, http.StatusNotModified}}, s.takeRequests())
+}
+
+func TestCollectRulesWatchUnsupported(t *testing.T) {
+	// the server doesn't support conditional requests, the provider polls
+	// the summary in watch mode
+	s := newTestServer()
+	defer s.Close()
+	s.set(true, false)
+	s.mocker.rules.Set([]integration.Config{portConfig(1)})
+
+	p := newTestProvider(t, config.ConfigurationProviders{TemplateURL: s.URL, Watch: true, WatchTimeout: 10})
+	ctx := context.Background()
+
+	collectPorts(t, p)
+	p.IsUpToDate(ctx) //nolint:errcheck
+	s.takeRequests()
+
+	start := time.Now()
+	upToDate, err := p.IsUpToDate(ctx)
+	require.NoError(t, err)
+	assert.True(t, upToDate)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, []testRequest{{api.RoutePathGetCollectRulesSummary, http.StatusOK}}, s.takeRequests())
+}
@@ -0,0 +1,164 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	agentpayload "github.com/n9e/agent-payload/gogen"
+	"github.com/n9e/n9e-agentd/pkg/util"
+	"github.com/n9e/n9e-agentd/pkg/util/expr"
+)
+
+// DerivedMetric is a metric computed from the samples of a flush, e.g.
+//
+//	name: mem_used_percent
+//	expr: system_mem_used / system_mem_total * 100
+//
+// The variables are the n9e metric names (after renaming), the samples are
+// joined on identical tag sets.
+type DerivedMetric struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"`
+
+	notations expr.Notations
+	vars      []string
+}
+
+func (p *DerivedMetric) init() error {
+	if p.Name == "" {
+		return fmt.Errorf("derived metric: name is required")
+	}
+	p.Name = util.SanitizeMetric(p.Name)
+
+	notations, err := expr.NewNotations([]byte(p.Expr))
+	if err != nil {
+		return fmt.Errorf("derived metric %s: %s", p.Name, err)
+	}
+	if notations.HasRate() {
+		return fmt.Errorf("derived metric %s: rate() is not supported", p.Name)
+	}
+	p.notations = notations
+	p.vars = notations.Vars()
+
+	if len(p.vars) == 0 {
+		return fmt.Errorf("derived metric %s: expr %q has no metric", p.Name, p.Expr)
+	}
+
+	return nil
+}
+
+// newValueExpr returns the value func of the expression, the variable `value`
+// is the value of the sample
+func newValueExpr(src string) (func(float64) float64, error) {
+	notations, err := expr.NewNotations([]byte(src))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range notations.Vars() {
+		if v != "value" {
+			return nil, fmt.Errorf("expr %q: unknown variable %s, only value is allowed", src, v)
+		}
+	}
+	if notations.HasRate() {
+		return nil, fmt.Errorf("expr %q: rate() is not supported", src)
+	}
+
+	get := func(v float64) func(string) (float64, error) {
+		return func(string) (float64, error) { return v, nil }
+	}
+
+	return func(v float64) float64 {
+		ret, err := notations.Calc(get(v))
+		if err != nil {
+			return math.NaN()
+		}
+		return ret
+	}, nil
+}
+
+// sampleGroup is the samples of a flush that have the same tags
+type sampleGroup struct {
+	tags   map[string]string
+	time   int64
+	values map[string]float64
+}
+
+func (p *sampleGroup) get(name string) (float64, error) {
+	if v, ok := p.values[name]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("metric %s not found", name)
+}
+
+// derive returns the derived metrics of the samples
+func (p *seriesProcessor) derive(samples []*agentpayload.N9EMetricsPayload_Sample) []*agentpayload.N9EMetricsPayload_Sample {
+	if len(p.derived) == 0 {
+		return nil
+	}
+
+	var keys []string
+	groups := map[string]*sampleGroup{}
+	for _, s := range samples {
+		key := tagsKey(s.Tags)
+		g, ok := groups[key]
+		if !ok {
+			g = &sampleGroup{tags: s.Tags, values: map[string]float64{}}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		// the last point wins
+		g.values[s.Metric] = s.Value
+		if s.Time > g.time {
+			g.time = s.Time
+		}
+	}
+
+	var ret []*agentpayload.N9EMetricsPayload_Sample
+	for _, d := range p.derived {
+		for _, key := range keys {
+			g := groups[key]
+			if !g.has(d.vars) {
+				continue
+			}
+
+			v, err := d.notations.Calc(g.get)
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+
+			ret = append(ret, &agentpayload.N9EMetricsPayload_Sample{
+				Ident:  p.ident,
+				Alias:  p.alias,
+				Metric: d.Name,
+				Tags:   g.tags,
+				Time:   g.time,
+				Value:  v,
+				Type:   metrics.APIGaugeType.String(), // extra, no used
+			})
+		}
+	}
+
+	return ret
+}
+
+func (p *sampleGroup) has(names []string) bool {
+	for _, name := range names {
+		if _, ok := p.values[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func tagsKey(tags map[string]string) string {
+	kv := make([]string, 0, len(tags))
+	for k, v := range tags {
+		kv = append(kv, k+"="+v)
+	}
+	sort.Strings(kv)
+	return strings.Join(kv, ",")
+}
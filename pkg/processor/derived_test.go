@@ -0,0 +1,105 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	agentpayload "github.com/n9e/agent-payload/gogen"
+	"github.com/n9e/n9e-agentd/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDerivedMetrics(t *testing.T) {
+	config.Mock()
+
+	p, err := newSeriesProcessor(&options{
+		Metrics: map[string]*MetricPolicy{
+			"app_bytes": {NewName: "app_kbytes", Expr: "value / 1024"},
+		},
+		Derived: []*DerivedMetric{{
+			Name: "mem_used_percent",
+			Expr: "system_mem_used / system_mem_total * 100",
+		}},
+	})
+	require.NoError(t, err)
+	p.additionTags = nil
+
+	serie := func(name string, value float64, tags ...string) *metrics.Serie {
+		return &metrics.Serie{Name: name, Tags: tags, Points: []metrics.Point{{Ts: 10, Value: value}}}
+	}
+
+	payload := p.Process(metrics.Series{
+		serie("system.mem.used", 25, "host:a"),
+		serie("system.mem.total", 200, "host:a"),
+		serie("system.mem.used", 50, "host:b"),
+		serie("system.mem.total", 100, "host:b"),
+		serie("system.mem.used", 1, "host:c"), // no total
+		serie("app.bytes", 2048),
+	}).(*agentpayload.N9EMetricsPayload)
+
+	got := map[string]float64{}
+	for _, s := range payload.Samples {
+		got[s.Metric+"|"+s.Tags["host"]] = s.Value
+	}
+
+	assert.Equal(t, float64(12.5), got["mem_used_percent|a"])
+	assert.Equal(t, float64(50), got["mem_used_percent|b"])
+	assert.NotContains(t, got, "mem_used_percent|c")
+	assert.Equal(t, float64(2), got["app_kbytes|"])
+}
+
+func TestDerivedMetricsConfig(t *testing.T) {
+	config.Mock()
+
+	cases := []struct {
+		name string
+		opts *options
+	}{
+		{"unknown variable", &options{Metrics: map[string]*MetricPolicy{"a": {Expr: "x * 2"}}}},
+		{"invalid expr", &options{Metrics: map[string]*MetricPolicy{"a": {Expr: "value *"}}}},
+		{"value_func and expr", &options{Metrics: map[string]*MetricPolicy{"a": {ValueFunc: "b_to_kb", Expr: "value"}}}},
+		{"derived without name", &options{Derived: []*DerivedMetric{{Expr: "a + b"}}}},
+		{"derived without metric", &options{Derived: []*DerivedMetric{{Name: "a", Expr: "1 + 2"}}}},
+		{"rate of value", &options{Metrics: map[string]*MetricPolicy{"a": {Expr: "rate(value)"}}}},
+		{"derived rate", &options{Derived: []*DerivedMetric{{Name: "a", Expr: "rate(a) + b"}}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := newSeriesProcessor(c.opts)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestBuiltinMetricPolicies(t *testing.T) {
+	config.Mock()
+
+	// the builtin polices are not overridden by the config, the builtin
+	// metrics are renamed, their values are sent as collected
+	p, err := newSeriesProcessor(&options{
+		Metrics: map[string]*MetricPolicy{
+			"system_disk_in_use": {NewName: "disk_in_use", Expr: "value * 2"},
+		},
+	})
+	require.NoError(t, err)
+	p.additionTags = nil
+
+	var series metrics.Series
+	for _, name := range []string{"system.disk.in_use", "system.mem.pct_usable", "system.disk.free", "system.cpu.idle"} {
+		series = append(series, &metrics.Serie{Name: name, Points: []metrics.Point{{Ts: 10, Value: 0.5}}})
+	}
+	payload := p.Process(series).(*agentpayload.N9EMetricsPayload)
+
+	got := map[string]float64{}
+	for _, s := range payload.Samples {
+		got[s.Metric] = s.Value
+	}
+	assert.Equal(t, map[string]float64{
+		"system_disk_used_percent": 0.5,
+		"system_mem_used_percent":  0.5,
+		"system_disk_bytes_free":   0.5,
+		"system_cpu_util":          0.5,
+	}, got)
+}
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 
 	"github.com/n9e/n9e-agentd/pkg/config"
+	"github.com/n9e/n9e-agentd/pkg/config/forwarder"
 	"github.com/yubo/golib/util/yaml"
 	"k8s.io/klog/v2"
 )
@@ -15,12 +16,16 @@ type Processor struct {
 }
 
 type options struct {
-	Metrics map[string]*MetricPolicy `json:"metrics"`
+	Metrics  map[string]*MetricPolicy `json:"metrics"`
+	Sketches SketchPolicy             `json:"sketches"`
+	Derived  []*DerivedMetric         `json:"derived_metrics"`
+	TagRules []*TagRule               `json:"tag_rules"`
 }
 
 func NewProcessor() (*Processor, error) {
 	p := &Processor{}
-	if !config.C.EnableN9eProvider {
+	// the remote write samples are also built by the processor
+	if !config.C.EnableN9eProvider && config.C.Forwarder.Output != forwarder.OutputRemoteWrite {
 		klog.V(1).Infof("payload processor disabled")
 		return p, nil
 	}
@@ -0,0 +1,187 @@
+package processor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	prompb "buf.build/gen/go/prometheus/prometheus/protocolbuffers/go"
+	"github.com/DataDog/datadog-agent/pkg/forwarder"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/DataDog/datadog-agent/pkg/serializer"
+	"github.com/golang/snappy"
+	"github.com/n9e/n9e-agentd/pkg/config"
+	fconfig "github.com/n9e/n9e-agentd/pkg/config/forwarder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func decodeRemoteWrite(t *testing.T, data []byte) *prompb.WriteRequest {
+	b, err := snappy.Decode(nil, data)
+	require.NoError(t, err)
+
+	req := &prompb.WriteRequest{}
+	require.NoError(t, proto.Unmarshal(b, req))
+	return req
+}
+
+func labelsMap(labels []*prompb.Label) map[string]string {
+	ret := map[string]string{}
+	for _, l := range labels {
+		ret[l.Name] = l.Value
+	}
+	return ret
+}
+
+// labelPairs returns the labels in order as name=value
+func labelPairs(labels []*prompb.Label) []string {
+	var ret []string
+	for _, l := range labels {
+		ret = append(ret, l.Name+"="+l.Value)
+	}
+	return ret
+}
+
+type testSample struct {
+	value     float64
+	timestamp int64
+}
+
+func testSamples(samples []*prompb.Sample) []testSample {
+	var ret []testSample
+	for _, s := range samples {
+		ret = append(ret, testSample{s.Value, s.Timestamp})
+	}
+	return ret
+}
+
+func TestMarshalRemoteWrite(t *testing.T) {
+	metrics.SetProcessor(&Processor{seriesProcessor: &seriesProcessor{
+		ident:        "host-1",
+		additionTags: []string{"env:test"},
+	}})
+	defer metrics.SetProcessor(nil)
+
+	series := metrics.Series{{
+		Name:   "system.cpu.idle",
+		Tags:   []string{"cpu:0", "__name__:x", "empty:", "1st:a"},
+		Points: []metrics.Point{{Ts: 10, Value: 1}, {Ts: 20, Value: 2}},
+	}, {
+		Name:   "5xx.count",
+		Points: []metrics.Point{{Ts: 10, Value: 3}},
+	}}
+
+	payloads, err := series.MarshalRemoteWrite(2)
+	require.NoError(t, err)
+	require.Len(t, payloads, 2)
+
+	req := decodeRemoteWrite(t, payloads[0])
+	require.Len(t, req.Timeseries, 2)
+	ts := req.Timeseries[0]
+	assert.Equal(t, []string{
+		"_1st=a",
+		"__name__=system_cpu_idle",
+		"cpu=0",
+		"env=test",
+		"ident=host-1",
+	}, labelPairs(ts.Labels))
+	assert.Equal(t, []testSample{{1, 10000}}, testSamples(ts.Samples))
+	assert.Equal(t, int64(20000), req.Timeseries[1].Samples[0].Timestamp)
+
+	req = decodeRemoteWrite(t, payloads[1])
+	require.Len(t, req.Timeseries, 1)
+	assert.Equal(t, "_5xx_count", labelsMap(req.Timeseries[0].Labels)["__name__"])
+
+	metrics.SetProcessor(nil)
+	_, err = series.MarshalRemoteWrite(2)
+	assert.Error(t, err)
+}
+
+func TestMarshalRemoteWriteDuplicateLabels(t *testing.T) {
+	metrics.SetProcessor(&Processor{seriesProcessor: &seriesProcessor{ident: "host-1"}})
+	defer metrics.SetProcessor(nil)
+
+	// 1st and _1st are both the label _1st, the first tag by name is kept
+	// whatever the order of the tags
+	for _, tags := range [][]string{
+		{"1st:a", "_1st:b", "2nd:", "_2nd:c"},
+		{"_2nd:c", "2nd:", "_1st:b", "1st:a"},
+	} {
+		for i := 0; i < 10; i++ {
+			payloads, err := metrics.Series{{
+				Name:   "cpu.idle",
+				Tags:   tags,
+				Points: []metrics.Point{{Ts: 10, Value: 1}},
+			}}.MarshalRemoteWrite(0)
+			require.NoError(t, err)
+
+			req := decodeRemoteWrite(t, payloads[0])
+			require.Len(t, req.Timeseries, 1)
+			assert.Equal(t, []string{
+				"_1st=a",
+				"_2nd=c",
+				"__name__=cpu_idle",
+				"ident=host-1",
+			}, labelPairs(req.Timeseries[0].Labels), "%v", tags)
+		}
+	}
+}
+
+func TestRemoteWriteForwarder(t *testing.T) {
+	config.Mock()
+	config.C.Forwarder.Output = fconfig.OutputRemoteWrite
+	config.C.Forwarder.RemoteWrite.MaxSamplesPerSend = 100
+	defer func() { config.C.Forwarder.Output = fconfig.OutputN9e }()
+
+	metrics.SetProcessor(&Processor{seriesProcessor: &seriesProcessor{ident: "host-1"}})
+	defer metrics.SetProcessor(nil)
+
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	config.C.Forwarder.RemoteWrite.Endpoints = []string{ts.URL + "/api/v1/write"}
+	options := forwarder.NewOptions(config.GetRemoteWriteEndpoints())
+	f := forwarder.NewRemoteWriteForwarder(options, map[string]string{"Authorization": "Bearer token"})
+	require.NoError(t, f.Start())
+	defer f.Stop()
+
+	s := serializer.NewSerializer(f, nil)
+	require.NoError(t, s.SendSeries(metrics.Series{{
+		Name:   "mem.used",
+		Tags:   []string{"region:cn"},
+		Points: []metrics.Point{{Ts: 10, Value: 1024}},
+	}}))
+
+	// the events and the service checks are dropped
+	require.NoError(t, f.SubmitEvents(forwarder.Payloads{}, nil))
+
+	select {
+	case r := <-requests:
+		assert.Equal(t, "/api/v1/write", r.URL.Path)
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "0.1.0", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+	case <-time.After(10 * time.Second):
+		t.Fatal("no remote write request received")
+	}
+
+	req := decodeRemoteWrite(t, <-bodies)
+	require.Len(t, req.Timeseries, 1)
+	assert.Equal(t, map[string]string{
+		"__name__": "mem_used",
+		"ident":    "host-1",
+		"region":   "cn",
+	}, labelsMap(req.Timeseries[0].Labels))
+	assert.Equal(t, []testSample{{1024, 10000}}, testSamples(req.Timeseries[0].Samples))
+}
@@ -3,6 +3,7 @@ package processor
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/DataDog/datadog-agent/pkg/metrics"
 	"github.com/gogo/protobuf/proto"
@@ -13,6 +14,9 @@ import (
 
 type seriesProcessor struct {
 	polices      map[string]*MetricPolicy
+	derived      []*DerivedMetric
+	tagRules     []*TagRule
+	quantiles    []float64
 	ident        string
 	alias        string
 	additionTags []string
@@ -21,6 +25,7 @@ type seriesProcessor struct {
 type MetricPolicy struct {
 	NewName   string `json:"new_name"`
 	ValueFunc string `json:"value_func"`
+	Expr      string `json:"expr"` // value expression, e.g. "value / 1024", exclusive with value_func
 
 	valueFunc func(float64) float64
 }
@@ -32,11 +37,16 @@ func newSeriesProcessor(opts *options) (*seriesProcessor, error) {
 		metrics[k] = v
 	}
 
+	// the builtin polices override the config, only their new names are
+	// used, the values of the builtin metrics are sent as collected
 	for k, v := range _metricPolicies {
-		metrics[k] = v
+		metrics[k] = &MetricPolicy{NewName: v.NewName}
 	}
 
-	for _, v := range metrics {
+	for k, v := range opts.Metrics {
+		if len(v.ValueFunc) > 0 && len(v.Expr) > 0 {
+			return nil, fmt.Errorf("metric %s: value_func and expr are exclusive", k)
+		}
 		if len(v.ValueFunc) > 0 {
 			if fn, ok := valueFuncs[v.ValueFunc]; !ok {
 				return nil, fmt.Errorf("metric func %s not found", v.ValueFunc)
@@ -44,42 +54,70 @@ func newSeriesProcessor(opts *options) (*seriesProcessor, error) {
 				v.valueFunc = fn
 			}
 		}
+		if len(v.Expr) > 0 {
+			fn, err := newValueExpr(v.Expr)
+			if err != nil {
+				return nil, fmt.Errorf("metric %s: %s", k, err)
+			}
+			v.valueFunc = fn
+		}
+	}
+
+	for _, v := range opts.Derived {
+		if err := v.init(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, v := range opts.TagRules {
+		if err := v.init(); err != nil {
+			return nil, err
+		}
+	}
+
+	quantiles, err := opts.Sketches.quantiles()
+	if err != nil {
+		return nil, err
 	}
 
 	return &seriesProcessor{
 		polices:      metrics,
+		derived:      opts.Derived,
+		tagRules:     opts.TagRules,
+		quantiles:    quantiles,
 		ident:        config.C.Ident,
 		alias:        config.C.Alias,
 		additionTags: config.C.Tags,
 	}, nil
 }
 
-func (p *seriesProcessor) ProcessSketch(sl metrics.SketchSeriesList) error {
-	return nil
-}
-
 func (p *seriesProcessor) Process(series metrics.Series) proto.Message {
 	payload := &agentpayload.N9EMetricsPayload{
 		Samples:  []*agentpayload.N9EMetricsPayload_Sample{},
 		Metadata: &agentpayload.CommonMetadata{},
 	}
 
+	now := time.Now()
 	for _, serie := range series {
 		for _, point := range serie.Points {
-			payload.Samples = append(payload.Samples,
-				&agentpayload.N9EMetricsPayload_Sample{
-					Ident:          p.ident,
-					Alias:          p.alias,
-					Metric:         p.MetricName(serie.Name),
-					Tags:           p.TagsMap(serie.Tags),
-					Time:           int64(point.Ts),
-					Value:          p.value(serie.Name, point.Value),
-					Type:           serie.MType.String(), // extra, no used
-					SourceTypeName: serie.SourceTypeName, // extra, nouse
-				})
+			sample := &agentpayload.N9EMetricsPayload_Sample{
+				Ident:          p.ident,
+				Alias:          p.alias,
+				Metric:         p.MetricName(serie.Name),
+				Tags:           p.TagsMap(serie.Tags),
+				Time:           int64(point.Ts),
+				Value:          p.value(serie.Name, point.Value),
+				Type:           serie.MType.String(), // extra, no used
+				SourceTypeName: serie.SourceTypeName, // extra, nouse
+			}
+			if p.applyTagRules(sample, now) {
+				payload.Samples = append(payload.Samples, sample)
+			}
 		}
 	}
 
+	payload.Samples = append(payload.Samples, p.derive(payload.Samples)...)
+
 	return payload
 }
 
@@ -97,7 +135,7 @@ func (p *seriesProcessor) MetricName(name string) string {
 }
 
 func (p *seriesProcessor) value(name string, value float64) float64 {
-	if p := p.polices["name"]; p != nil && p.valueFunc != nil {
+	if p := p.polices[util.SanitizeMetric(name)]; p != nil && p.valueFunc != nil {
 		return p.valueFunc(value)
 	}
 	return value
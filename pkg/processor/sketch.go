@@ -0,0 +1,91 @@
+package processor
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/DataDog/datadog-agent/pkg/quantile"
+	"github.com/gogo/protobuf/proto"
+	agentpayload "github.com/n9e/agent-payload/gogen"
+)
+
+var defaultQuantiles = []float64{0.5, 0.9, 0.99}
+
+type SketchPolicy struct {
+	Quantiles []float64 `json:"quantiles"` // default [0.5, 0.9, 0.99]
+}
+
+func (p SketchPolicy) quantiles() ([]float64, error) {
+	if len(p.Quantiles) == 0 {
+		return defaultQuantiles, nil
+	}
+
+	for _, q := range p.Quantiles {
+		if q < 0 || q > 1 {
+			return nil, fmt.Errorf("sketch quantile %v out of range [0, 1]", q)
+		}
+	}
+
+	return p.Quantiles, nil
+}
+
+// ProcessSketch converts the sketches (distributions) to n9e samples:
+//
+//	<name>_quantile{quantile="0.5"}, ... for each quantile
+//	<name>_count, <name>_sum, <name>_min, <name>_max
+func (p *seriesProcessor) ProcessSketch(sl metrics.SketchSeriesList) proto.Message {
+	payload := &agentpayload.N9EMetricsPayload{
+		Samples:  []*agentpayload.N9EMetricsPayload_Sample{},
+		Metadata: &agentpayload.CommonMetadata{},
+	}
+
+	cf := quantile.Default()
+	now := time.Now()
+	var samples []*agentpayload.N9EMetricsPayload_Sample
+	for _, ss := range sl {
+		for _, point := range ss.Points {
+			if point.Sketch == nil {
+				continue
+			}
+			b := point.Sketch.Basic
+			samples = samples[:0]
+
+			for _, q := range p.quantiles {
+				tags := make([]string, 0, len(ss.Tags)+1)
+				tags = append(tags, ss.Tags...)
+				tags = append(tags, "quantile:"+strconv.FormatFloat(q, 'g', -1, 64))
+				samples = append(samples,
+					p.sketchSample(ss.Name+"_quantile", tags, point.Ts, point.Sketch.Quantile(cf, q), metrics.APIGaugeType))
+			}
+
+			samples = append(samples,
+				p.sketchSample(ss.Name+"_count", ss.Tags, point.Ts, float64(b.Cnt), metrics.APICountType),
+				p.sketchSample(ss.Name+"_sum", ss.Tags, point.Ts, b.Sum, metrics.APICountType),
+				p.sketchSample(ss.Name+"_min", ss.Tags, point.Ts, b.Min, metrics.APIGaugeType),
+				p.sketchSample(ss.Name+"_max", ss.Tags, point.Ts, b.Max, metrics.APIGaugeType),
+			)
+
+			for _, sample := range samples {
+				if p.applyTagRules(sample, now) {
+					payload.Samples = append(payload.Samples, sample)
+				}
+			}
+		}
+	}
+
+	return payload
+}
+
+func (p *seriesProcessor) sketchSample(name string, tags []string, ts int64, value float64, mtype metrics.APIMetricType) *agentpayload.N9EMetricsPayload_Sample {
+	return &agentpayload.N9EMetricsPayload_Sample{
+		Ident:  p.ident,
+		Alias:  p.alias,
+		Metric: p.MetricName(name),
+		Tags:   p.TagsMap(tags[:len(tags):len(tags)]),
+		Time:   ts,
+		Value:  p.value(name, value),
+		Type:   mtype.String(), // extra, no used
+	}
+}
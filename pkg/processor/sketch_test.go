@@ -0,0 +1,61 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/DataDog/datadog-agent/pkg/quantile"
+	agentpayload "github.com/n9e/agent-payload/gogen"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessSketch(t *testing.T) {
+	p := &seriesProcessor{
+		ident:        "host-1",
+		additionTags: []string{"env:test"},
+		quantiles:    []float64{0.5, 0.99},
+	}
+
+	sketch := &quantile.Sketch{}
+	for i := 1; i <= 100; i++ {
+		sketch.Insert(quantile.Default(), float64(i))
+	}
+
+	payload := p.ProcessSketch(metrics.SketchSeriesList{{
+		Name:   "http.latency",
+		Tags:   []string{"service:web"},
+		Points: []metrics.SketchPoint{{Sketch: sketch, Ts: 10}},
+	}}).(*agentpayload.N9EMetricsPayload)
+
+	samples := map[string]*agentpayload.N9EMetricsPayload_Sample{}
+	for _, s := range payload.Samples {
+		key := s.Metric
+		if q, ok := s.Tags["quantile"]; ok {
+			key += "|" + q
+		}
+		samples[key] = s
+	}
+	require.Len(t, samples, 6)
+
+	assert.InDelta(t, 50, samples["http_latency_quantile|0.5"].Value, 2)
+	assert.InDelta(t, 99, samples["http_latency_quantile|0.99"].Value, 2)
+	assert.Equal(t, float64(100), samples["http_latency_count"].Value)
+	assert.Equal(t, float64(5050), samples["http_latency_sum"].Value)
+	assert.Equal(t, float64(1), samples["http_latency_min"].Value)
+	assert.Equal(t, float64(100), samples["http_latency_max"].Value)
+
+	s := samples["http_latency_count"]
+	assert.Equal(t, "host-1", s.Ident)
+	assert.Equal(t, int64(10), s.Time)
+	assert.Equal(t, map[string]string{"service": "web", "env": "test"}, s.Tags)
+}
+
+func TestSketchPolicy(t *testing.T) {
+	q, err := SketchPolicy{}.quantiles()
+	assert.NoError(t, err)
+	assert.Equal(t, defaultQuantiles, q)
+
+	_, err = SketchPolicy{Quantiles: []float64{0.5, 1.5}}.quantiles()
+	assert.Error(t, err)
+}
@@ -0,0 +1,230 @@
+package processor
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	agentpayload "github.com/n9e/agent-payload/gogen"
+	"github.com/n9e/n9e-agentd/pkg/util"
+	"k8s.io/klog/v2"
+)
+
+// cardinalityTTL is how long an unseen tag set still counts for max_cardinality
+const cardinalityTTL = time.Hour
+
+// TagRule acts on the series whose n9e metric name matches metric (glob)
+// or metric_regex. All the matching rules are applied, in order:
+// drop, drop_tags, rename_tags, rewrite_tags, add_tags, max_cardinality.
+type TagRule struct {
+	Metric         string            `json:"metric"`          // glob of the metric name
+	MetricRegex    string            `json:"metric_regex"`    // regex of the metric name
+	Drop           bool              `json:"drop"`            // drop the series
+	DropTags       []string          `json:"drop_tags"`       // tag keys to drop
+	RenameTags     map[string]string `json:"rename_tags"`     // old key: new key, applied in the order of the old keys
+	RewriteTags    []*TagRewrite     `json:"rewrite_tags"`    //
+	AddTags        []string          `json:"add_tags"`        // static tags, key:value
+	MaxCardinality int               `json:"max_cardinality"` // max tag sets per metric, the series with new tag sets beyond it are dropped
+
+	metricRegex *regexp.Regexp
+	renameTags  []tagRename
+	addTags     map[string]string
+	cardinality *cardinalityLimiter
+}
+
+// tagRename is a sanitized rename_tags item
+type tagRename struct {
+	key    string
+	newKey string
+}
+
+// TagRewrite replaces the value of the tag key if it matches regex,
+// the replacement can refer to the capture groups with $1, ${name}.
+// The tag is dropped if the new value is empty.
+type TagRewrite struct {
+	Key         string `json:"key"`
+	Regex       string `json:"regex"` // anchored
+	Replacement string `json:"replacement"`
+
+	regex *regexp.Regexp
+}
+
+func (p *TagRule) init() error {
+	if p.Metric == "" && p.MetricRegex == "" {
+		return fmt.Errorf("tag rule: metric or metric_regex is required")
+	}
+
+	if p.Metric != "" {
+		if _, err := path.Match(p.Metric, ""); err != nil {
+			return fmt.Errorf("tag rule: metric %q: %s", p.Metric, err)
+		}
+	}
+
+	if p.MetricRegex != "" {
+		re, err := regexp.Compile(p.MetricRegex)
+		if err != nil {
+			return fmt.Errorf("tag rule: metric_regex %q: %s", p.MetricRegex, err)
+		}
+		p.metricRegex = re
+	}
+
+	for k, newKey := range p.RenameTags {
+		if k == "" || newKey == "" {
+			return fmt.Errorf("tag rule %s: invalid rename_tags %q: %q", p.name(), k, newKey)
+		}
+		p.renameTags = append(p.renameTags, tagRename{util.SanitizeMetric(k), util.SanitizeMetric(newKey)})
+	}
+	sort.Slice(p.renameTags, func(i, j int) bool {
+		return p.renameTags[i].key < p.renameTags[j].key
+	})
+
+	for _, v := range p.RewriteTags {
+		if v.Key == "" {
+			return fmt.Errorf("tag rule %s: rewrite_tags key is required", p.name())
+		}
+		re, err := regexp.Compile("^(?:" + v.Regex + ")$")
+		if err != nil {
+			return fmt.Errorf("tag rule %s: rewrite_tags regex %q: %s", p.name(), v.Regex, err)
+		}
+		v.regex = re
+	}
+
+	if len(p.AddTags) > 0 {
+		p.addTags = util.SanitizeMapTags(p.AddTags)
+	}
+
+	if p.MaxCardinality < 0 {
+		return fmt.Errorf("tag rule %s: invalid max_cardinality %d", p.name(), p.MaxCardinality)
+	}
+	if p.MaxCardinality > 0 {
+		p.cardinality = newCardinalityLimiter(p.MaxCardinality)
+	}
+
+	return nil
+}
+
+func (p *TagRule) name() string {
+	if p.Metric != "" {
+		return p.Metric
+	}
+	return p.MetricRegex
+}
+
+func (p *TagRule) match(metric string) bool {
+	if p.Metric != "" {
+		if ok, _ := path.Match(p.Metric, metric); ok {
+			return true
+		}
+	}
+
+	return p.metricRegex != nil && p.metricRegex.MatchString(metric)
+}
+
+// apply returns false if the sample should be dropped
+func (p *TagRule) apply(s *agentpayload.N9EMetricsPayload_Sample, now time.Time) bool {
+	if p.Drop {
+		return false
+	}
+
+	for _, k := range p.DropTags {
+		delete(s.Tags, k)
+	}
+
+	for _, r := range p.renameTags {
+		if v, ok := s.Tags[r.key]; ok {
+			delete(s.Tags, r.key)
+			s.Tags[r.newKey] = v
+		}
+	}
+
+	for _, r := range p.RewriteTags {
+		v, ok := s.Tags[r.Key]
+		if !ok || !r.regex.MatchString(v) {
+			continue
+		}
+		if v = r.regex.ReplaceAllString(v, r.Replacement); v == "" {
+			delete(s.Tags, r.Key)
+		} else {
+			s.Tags[r.Key] = v
+		}
+	}
+
+	if len(p.addTags) > 0 && s.Tags == nil {
+		s.Tags = make(map[string]string, len(p.addTags))
+	}
+	for k, v := range p.addTags {
+		s.Tags[k] = v
+	}
+
+	if p.cardinality != nil {
+		return p.cardinality.allow(s.Metric, tagsKey(s.Tags), now)
+	}
+
+	return true
+}
+
+// cardinalityLimiter tracks the tag sets of each metric,
+// the tag sets unseen for cardinalityTTL are forgotten
+type cardinalityLimiter struct {
+	sync.Mutex
+	limit     int
+	seen      map[string]map[string]time.Time // metric: tags key: last seen
+	lastPurge time.Time
+}
+
+func newCardinalityLimiter(limit int) *cardinalityLimiter {
+	return &cardinalityLimiter{
+		limit: limit,
+		seen:  map[string]map[string]time.Time{},
+	}
+}
+
+func (p *cardinalityLimiter) allow(metric, key string, now time.Time) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	if now.Sub(p.lastPurge) > cardinalityTTL/4 {
+		p.purge(now)
+	}
+
+	sets, ok := p.seen[metric]
+	if !ok {
+		sets = map[string]time.Time{}
+		p.seen[metric] = sets
+	}
+
+	if _, ok := sets[key]; !ok && len(sets) >= p.limit {
+		klog.V(5).Infof("metric %s reached max_cardinality %d, drop {%s}", metric, p.limit, key)
+		return false
+	}
+
+	sets[key] = now
+	return true
+}
+
+func (p *cardinalityLimiter) purge(now time.Time) {
+	for metric, sets := range p.seen {
+		for key, t := range sets {
+			if now.Sub(t) > cardinalityTTL {
+				delete(sets, key)
+			}
+		}
+		if len(sets) == 0 {
+			delete(p.seen, metric)
+		}
+	}
+	p.lastPurge = now
+}
+
+// applyTagRules returns false if the sample should be dropped
+func (p *seriesProcessor) applyTagRules(s *agentpayload.N9EMetricsPayload_Sample, now time.Time) bool {
+	for _, rule := range p.tagRules {
+		if rule.match(s.Metric) && !rule.apply(s, now) {
+			return false
+		}
+	}
+	return true
+}
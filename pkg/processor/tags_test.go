@@ -0,0 +1,112 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	agentpayload "github.com/n9e/agent-payload/gogen"
+	"github.com/n9e/n9e-agentd/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagRules(t *testing.T) {
+	config.Mock()
+
+	serie := func(name string, tags ...string) *metrics.Serie {
+		return &metrics.Serie{Name: name, Tags: tags, Points: []metrics.Point{{Ts: 10, Value: 1}}}
+	}
+
+	cases := []struct {
+		name   string
+		rules  []*TagRule
+		series metrics.Series
+		want   []map[string]string // tags of each sample, nil if dropped
+	}{{
+		name:   "drop series",
+		rules:  []*TagRule{{Metric: "app_*", Drop: true}},
+		series: metrics.Series{serie("app.requests", "a:1"), serie("sys.load", "a:1")},
+		want:   []map[string]string{{"a": "1"}},
+	}, {
+		name:   "drop and rename tags",
+		rules:  []*TagRule{{MetricRegex: "^app_", DropTags: []string{"pod"}, RenameTags: map[string]string{"svc": "service"}}},
+		series: metrics.Series{serie("app.requests", "pod:web-1", "svc:web", "code:200")},
+		want:   []map[string]string{{"service": "web", "code": "200"}},
+	}, {
+		name:   "rename tags in order",
+		rules:  []*TagRule{{Metric: "*", RenameTags: map[string]string{"b": "c", "a": "b", "svc": "service.name"}}},
+		series: metrics.Series{serie("app.requests", "a:1", "svc:web")},
+		want:   []map[string]string{{"c": "1", "service_name": "web"}},
+	}, {
+		name: "rewrite tags",
+		rules: []*TagRule{{Metric: "*", RewriteTags: []*TagRewrite{
+			{Key: "code", Regex: `(\d)\d\d`, Replacement: "${1}xx"},
+			{Key: "path", Regex: `/debug/.*`, Replacement: ""},
+		}}},
+		series: metrics.Series{
+			serie("app.requests", "code:404", "path:/api"),
+			serie("app.requests", "code:ok", "path:/debug/vars"),
+		},
+		want: []map[string]string{{"code": "4xx", "path": "/api"}, {"code": "ok"}},
+	}, {
+		name:   "add tags",
+		rules:  []*TagRule{{Metric: "app_requests", AddTags: []string{"team:web"}}},
+		series: metrics.Series{serie("app.requests"), serie("app.errors")},
+		want:   []map[string]string{{"team": "web"}, nil},
+	}, {
+		name:  "max cardinality",
+		rules: []*TagRule{{Metric: "app_*", MaxCardinality: 2}},
+		series: metrics.Series{
+			serie("app.requests", "pod:1"),
+			serie("app.requests", "pod:2"),
+			serie("app.requests", "pod:3"),
+			serie("app.requests", "pod:1"),
+			serie("app.errors", "pod:3"),
+		},
+		want: []map[string]string{{"pod": "1"}, {"pod": "2"}, {"pod": "1"}, {"pod": "3"}},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := newSeriesProcessor(&options{TagRules: c.rules})
+			require.NoError(t, err)
+			p.additionTags = nil
+
+			payload := p.Process(c.series).(*agentpayload.N9EMetricsPayload)
+			var got []map[string]string
+			for _, s := range payload.Samples {
+				got = append(got, s.Tags)
+			}
+			assert.Equal(t, c.want, got)
+		})
+	}
+}
+
+func TestCardinalityLimiter(t *testing.T) {
+	l := newCardinalityLimiter(1)
+	now := time.Now()
+
+	assert.True(t, l.allow("m", "a", now))
+	assert.False(t, l.allow("m", "b", now))
+	assert.True(t, l.allow("n", "b", now))
+
+	// a expired
+	now = now.Add(cardinalityTTL + time.Second)
+	assert.True(t, l.allow("m", "b", now))
+	assert.False(t, l.allow("m", "a", now))
+}
+
+func TestTagRulesConfig(t *testing.T) {
+	for _, rule := range []*TagRule{
+		{},
+		{Metric: "[", Drop: true},
+		{MetricRegex: "(", Drop: true},
+		{Metric: "a", RewriteTags: []*TagRewrite{{Key: "k", Regex: "("}}},
+		{Metric: "a", RewriteTags: []*TagRewrite{{Regex: "a"}}},
+		{Metric: "a", MaxCardinality: -1},
+		{Metric: "a", RenameTags: map[string]string{"k": ""}},
+	} {
+		assert.Error(t, rule.init(), "%+v", rule)
+	}
+}
@@ -0,0 +1,206 @@
+// Package capturesender implements an aggregator.Sender that keeps what
+// a check submits instead of forwarding it, e.g. to test a check config.
+package capturesender
+
+import (
+	"sync"
+
+	"github.com/DataDog/datadog-agent/pkg/collector/check"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/DataDog/datadog-agent/pkg/serializer"
+)
+
+// Metric is a metric sample submitted by a check
+type Metric struct {
+	Type     string   `json:"type"` // gauge, rate, count, monotonic_count, counter, histogram, historate
+	Name     string   `json:"name"`
+	Value    float64  `json:"value"`
+	Hostname string   `json:"hostname,omitempty"`
+	Tags     []string `json:"tags"`
+}
+
+// ServiceCheck is a service check submitted by a check
+type ServiceCheck struct {
+	Name     string   `json:"name"`
+	Status   string   `json:"status"`
+	Hostname string   `json:"hostname,omitempty"`
+	Tags     []string `json:"tags"`
+	Message  string   `json:"message,omitempty"`
+}
+
+// HistogramBucket is a histogram bucket submitted by a check
+type HistogramBucket struct {
+	Name       string   `json:"name"`
+	Value      int64    `json:"value"`
+	LowerBound float64  `json:"lower_bound"`
+	UpperBound float64  `json:"upper_bound"`
+	Monotonic  bool     `json:"monotonic"`
+	Hostname   string   `json:"hostname,omitempty"`
+	Tags       []string `json:"tags"`
+}
+
+// CaptureSender implements aggregator.Sender
+type CaptureSender struct {
+	sync.Mutex
+	Metrics          []Metric          `json:"metrics"`
+	ServiceChecks    []ServiceCheck    `json:"service_checks"`
+	Events           []metrics.Event   `json:"events"`
+	HistogramBuckets []HistogramBucket `json:"histogram_buckets,omitempty"`
+
+	checkTags []string
+	service   string
+	stats     check.SenderStats
+}
+
+// New returns an empty CaptureSender
+func New() *CaptureSender {
+	return &CaptureSender{
+		Metrics:       []Metric{},
+		ServiceChecks: []ServiceCheck{},
+		Events:        []metrics.Event{},
+		stats:         check.NewSenderStats(),
+	}
+}
+
+// tags returns the tags with the custom tags of the check, as the check sender does
+func (s *CaptureSender) tags(tags []string) []string {
+	ret := make([]string, 0, len(tags)+len(s.checkTags)+1)
+	ret = append(ret, tags...)
+	ret = append(ret, s.checkTags...)
+	if s.service != "" {
+		ret = append(ret, "service:"+s.service)
+	}
+	return ret
+}
+
+func (s *CaptureSender) metric(mtype, metric string, value float64, hostname string, tags []string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Metrics = append(s.Metrics, Metric{
+		Type:     mtype,
+		Name:     metric,
+		Value:    value,
+		Hostname: hostname,
+		Tags:     s.tags(tags),
+	})
+	s.stats.MetricSamples++
+}
+
+// Commit is a no-op, the samples are kept as submitted
+func (s *CaptureSender) Commit() {}
+
+// Gauge captures a gauge
+func (s *CaptureSender) Gauge(metric string, value float64, hostname string, tags []string) {
+	s.metric("gauge", metric, value, hostname, tags)
+}
+
+// Rate captures a rate
+func (s *CaptureSender) Rate(metric string, value float64, hostname string, tags []string) {
+	s.metric("rate", metric, value, hostname, tags)
+}
+
+// Count captures a count
+func (s *CaptureSender) Count(metric string, value float64, hostname string, tags []string) {
+	s.metric("count", metric, value, hostname, tags)
+}
+
+// MonotonicCount captures a monotonic count
+func (s *CaptureSender) MonotonicCount(metric string, value float64, hostname string, tags []string) {
+	s.metric("monotonic_count", metric, value, hostname, tags)
+}
+
+// MonotonicCountWithFlushFirstValue captures a monotonic count
+func (s *CaptureSender) MonotonicCountWithFlushFirstValue(metric string, value float64, hostname string, tags []string, flushFirstValue bool) {
+	s.metric("monotonic_count", metric, value, hostname, tags)
+}
+
+// Counter captures a counter
+func (s *CaptureSender) Counter(metric string, value float64, hostname string, tags []string) {
+	s.metric("counter", metric, value, hostname, tags)
+}
+
+// Histogram captures a histogram sample
+func (s *CaptureSender) Histogram(metric string, value float64, hostname string, tags []string) {
+	s.metric("histogram", metric, value, hostname, tags)
+}
+
+// Historate captures a historate sample
+func (s *CaptureSender) Historate(metric string, value float64, hostname string, tags []string) {
+	s.metric("historate", metric, value, hostname, tags)
+}
+
+// ServiceCheck captures a service check
+func (s *CaptureSender) ServiceCheck(checkName string, status metrics.ServiceCheckStatus, hostname string, tags []string, message string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.ServiceChecks = append(s.ServiceChecks, ServiceCheck{
+		Name:     checkName,
+		Status:   status.String(),
+		Hostname: hostname,
+		Tags:     s.tags(tags),
+		Message:  message,
+	})
+	s.stats.ServiceChecks++
+}
+
+// HistogramBucket captures a histogram bucket
+func (s *CaptureSender) HistogramBucket(metric string, value int64, lowerBound, upperBound float64, monotonic bool, hostname string, tags []string, flushFirstValue bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.HistogramBuckets = append(s.HistogramBuckets, HistogramBucket{
+		Name:       metric,
+		Value:      value,
+		LowerBound: lowerBound,
+		UpperBound: upperBound,
+		Monotonic:  monotonic,
+		Hostname:   hostname,
+		Tags:       s.tags(tags),
+	})
+}
+
+// Event captures an event
+func (s *CaptureSender) Event(e metrics.Event) {
+	s.Lock()
+	defer s.Unlock()
+
+	e.Tags = s.tags(e.Tags)
+	s.Events = append(s.Events, e)
+	s.stats.Events++
+}
+
+// EventPlatformEvent is ignored
+func (s *CaptureSender) EventPlatformEvent(rawEvent string, eventType string) {}
+
+// GetSenderStats returns the number of the captured samples
+func (s *CaptureSender) GetSenderStats() check.SenderStats {
+	s.Lock()
+	defer s.Unlock()
+	return s.stats.Copy()
+}
+
+// DisableDefaultHostname is a no-op, the hostname is kept as submitted
+func (s *CaptureSender) DisableDefaultHostname(disable bool) {}
+
+// SetCheckCustomTags sets the tags added to every sample
+func (s *CaptureSender) SetCheckCustomTags(tags []string) {
+	s.Lock()
+	defer s.Unlock()
+	s.checkTags = tags
+}
+
+// SetCheckService sets the service tag added to every sample
+func (s *CaptureSender) SetCheckService(service string) {
+	s.Lock()
+	defer s.Unlock()
+	s.service = service
+}
+
+// FinalizeCheckServiceTag is a no-op
+func (s *CaptureSender) FinalizeCheckServiceTag() {}
+
+// OrchestratorMetadata is ignored
+func (s *CaptureSender) OrchestratorMetadata(msgs []serializer.ProcessMessageBody, clusterID, payloadType string) {
+}
@@ -0,0 +1,37 @@
+package capturesender
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ aggregator.Sender = &CaptureSender{}
+
+func TestCaptureSender(t *testing.T) {
+	s := New()
+	s.SetCheckCustomTags([]string{"env:test"})
+	s.SetCheckService("web")
+
+	s.Gauge("cpu.util", 1.5, "", []string{"core:0"})
+	s.MonotonicCount("net.bytes", 10, "host-a", nil)
+	s.ServiceCheck("web.can_connect", metrics.ServiceCheckOK, "", nil, "")
+	s.Event(metrics.Event{Title: "restarted"})
+	s.Commit()
+
+	assert.Equal(t, []Metric{
+		{Type: "gauge", Name: "cpu.util", Value: 1.5, Tags: []string{"core:0", "env:test", "service:web"}},
+		{Type: "monotonic_count", Name: "net.bytes", Value: 10, Hostname: "host-a", Tags: []string{"env:test", "service:web"}},
+	}, s.Metrics)
+	assert.Equal(t, []ServiceCheck{
+		{Name: "web.can_connect", Status: "OK", Tags: []string{"env:test", "service:web"}},
+	}, s.ServiceChecks)
+	assert.Equal(t, []string{"env:test", "service:web"}, s.Events[0].Tags)
+
+	stats := s.GetSenderStats()
+	assert.Equal(t, int64(2), stats.MetricSamples)
+	assert.Equal(t, int64(1), stats.ServiceChecks)
+	assert.Equal(t, int64(1), stats.Events)
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+type ttlItem struct {
+	value    interface{}
+	expireAt time.Time
+}
+
+// TTLCache is a cache whose entries expire ttl after they were set, when it
+// is full the entry closest to its expiration is evicted
+type TTLCache struct {
+	sync.Mutex
+	maxsize int
+	ttl     time.Duration
+	items   map[string]ttlItem
+}
+
+func NewTTLCache(maxsize int, ttl time.Duration) *TTLCache {
+	return &TTLCache{
+		maxsize: maxsize,
+		ttl:     ttl,
+		items:   make(map[string]ttlItem),
+	}
+}
+
+func (p *TTLCache) Get(key string) (interface{}, bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	item, ok := p.get(key)
+	return item.value, ok
+}
+
+func (p *TTLCache) Set(key string, value interface{}) {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.items[key]; !ok && len(p.items) >= p.maxsize {
+		p.expire()
+		if len(p.items) >= p.maxsize {
+			p.evict()
+		}
+	}
+	p.set(key, value)
+}
+
+func (p *TTLCache) Delete(key string) {
+	p.Lock()
+	defer p.Unlock()
+
+	delete(p.items, key)
+}
+
+// Len returns the number of the entries, including the expired ones not purged yet
+func (p *TTLCache) Len() int {
+	p.Lock()
+	defer p.Unlock()
+
+	return len(p.items)
+}
+
+func (p *TTLCache) get(key string) (ttlItem, bool) {
+	item, ok := p.items[key]
+	if ok && !time.Now().Before(item.expireAt) {
+		delete(p.items, key)
+		return ttlItem{}, false
+	}
+	return item, ok
+}
+
+func (p *TTLCache) set(key string, value interface{}) {
+	p.items[key] = ttlItem{value: value, expireAt: time.Now().Add(p.ttl)}
+}
+
+// expire purges the expired entries
+func (p *TTLCache) expire() {
+	now := time.Now()
+	for k, item := range p.items {
+		if !now.Before(item.expireAt) {
+			delete(p.items, k)
+		}
+	}
+}
+
+// evict removes the entry closest to its expiration
+func (p *TTLCache) evict() {
+	var key string
+	var expireAt time.Time
+	for k, item := range p.items {
+		if expireAt.IsZero() || item.expireAt.Before(expireAt) {
+			key, expireAt = k, item.expireAt
+		}
+	}
+	delete(p.items, key)
+}
+
+// RateLimitingTTLCache is a TTLCache used to limit the rate of events by key,
+// e.g. the number of samples per hour of a query with a ttl of one hour/rate
+type RateLimitingTTLCache struct {
+	*TTLCache
+}
+
+func NewRateLimitingTTLCache(maxsize int, ttl time.Duration) *RateLimitingTTLCache {
+	return &RateLimitingTTLCache{TTLCache: NewTTLCache(maxsize, ttl)}
+}
+
+// Acquire returns true if the key is not in the cache and the cache is not
+// full, the key is then added to the cache
+func (p *RateLimitingTTLCache) Acquire(key string) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.get(key); ok {
+		return false
+	}
+
+	if len(p.items) >= p.maxsize {
+		if p.expire(); len(p.items) >= p.maxsize {
+			return false
+		}
+	}
+
+	p.set(key, true)
+	return true
+}
@@ -43,6 +43,7 @@ type QueryManager struct {
 	check   AgentCheck
 	queries []*Query
 	tags    []string // ?
+	runs    uint64   // number of Execute, passed to the transformers as run
 }
 
 //   - **check** (_AgentCheck_) - an instance of a Check
@@ -68,6 +69,7 @@ func NewQueryManager(c AgentCheck, queries []*CustomQuery, tags []string) (*Quer
 
 	// Deduplicate
 	for i, q := range custom_queries {
+		q := q
 		query := NewQuery(&q)
 		query.query_data.Name = fmt.Sprintf("custom query #%d", i)
 		p.queries = append(p.queries, query)
@@ -104,6 +106,7 @@ func (p *QueryManager) Compile_queries() error {
 func (p *QueryManager) Execute(extra_tags []string) {
 	// This method is what you call every check run."""
 	global_tags := append(p.tags, extra_tags...)
+	p.runs++
 
 	for _, query := range p.queries {
 		query_name := query.name
@@ -121,6 +124,7 @@ func (p *QueryManager) Execute(extra_tags []string) {
 		for _, row := range rows {
 			if num_columns != len(row) {
 				klog.Errorf("Query %s expected %d column, got %d", query_name, num_columns, len(row))
+				continue
 			}
 
 			var tags []string
@@ -149,7 +153,7 @@ func (p *QueryManager) Execute(extra_tags []string) {
 				if transformer == nil {
 					continue
 				}
-				if column_type == "tag" {
+				if column_type == "tag" || column_type == "tag_not_null" {
 					ret, err := transformer(nil, value, nil)
 					if err != nil {
 						klog.ErrorS(err, "transformer",
@@ -157,7 +161,10 @@ func (p *QueryManager) Execute(extra_tags []string) {
 							"column_type", column_type)
 						continue
 					}
-					tags = append(tags, ret.(string))
+					// tag_not_null returns nil for NULL
+					if ret != nil {
+						tags = append(tags, ret.(string))
+					}
 
 				} else if column_type == "tag_list" {
 					ret, err := transformer(nil, value, nil)
@@ -176,7 +183,7 @@ func (p *QueryManager) Execute(extra_tags []string) {
 			}
 
 			for _, v := range submission_queue {
-				_, err := v.transformer(sources, v.value, mapinterface{"tags": tags})
+				_, err := v.transformer(sources, v.value, mapinterface{"tags": tags, "run": p.runs})
 				if err != nil {
 					klog.ErrorS(err, "submission_transformer",
 						"column_name", v.column_name,
@@ -185,7 +192,7 @@ func (p *QueryManager) Execute(extra_tags []string) {
 			}
 
 			for _, v := range query_extras {
-				result, err := v.transformer(sources, mapinterface{"tags": tags}, nil)
+				result, err := v.transformer(sources, mapinterface{"tags": tags, "run": p.runs}, nil)
 				if err != nil {
 					klog.ErrorS(err, "extras_transformer",
 						"extra_name", v.extra_name)
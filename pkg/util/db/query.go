@@ -32,6 +32,12 @@ type extraItem struct {
 	transformer transformHandle
 }
 
+// is_tag_type returns true if the columns of the type are the tags of the
+// row instead of the submissions
+func is_tag_type(column_type string) bool {
+	return column_type == "tag" || column_type == "tag_list" || column_type == "tag_not_null"
+}
+
 func NewQuery(q *CustomQuery) *Query {
 	return &Query{query_data: q}
 }
@@ -115,7 +121,7 @@ func (p *Query) compile(column_transformers, extra_transformers mapinterface) er
 			return fmt.Errorf("unknown type `%s` for column %s of %s err %s",
 				column_type, column_name, p.name, err)
 		} else {
-			if column_type == "tag" || column_type == "tag_list" {
+			if is_tag_type(column_type) {
 				column_data = append(column_data, &columnItem{
 					column_name: column_name,
 					column_type: column_type,
@@ -132,7 +138,7 @@ func (p *Query) compile(column_transformers, extra_transformers mapinterface) er
 
 	submission_transformers := make(mapinterface)
 	for k, v := range column_transformers {
-		if k == "tag" || k == "tag_list" {
+		if is_tag_type(k) {
 			continue
 		}
 		submission_transformers[k] = v
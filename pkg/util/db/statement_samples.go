@@ -1,14 +1,54 @@
 package db
 
-import "k8s.io/klog/v2"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"k8s.io/klog/v2"
+)
 
 var (
 	StatementSamplesClient = statementSamplesClient{}
 )
 
+// StatementSampleEvent is a sample of a statement collected by a check
+type StatementSampleEvent struct {
+	Timestamp      time.Time   // end of the statement
+	Host           string      // db host
+	QuerySignature string      // signature of the normalized statement, the samples of a query are aggregated by it
+	Statement      string      // obfuscated statement, the title of the event
+	Tags           []string    //
+	Sample         interface{} // the sample with its execution plan, marshaled as the event text
+}
+
+// There is no statement samples intake, the samples are submitted as events
+// of the check and forwarded with the other events
 type statementSamplesClient struct{}
 
-func (p *statementSamplesClient) SubmitEvents() {
-	klog.V(5).Infof("SubmitEvents")
+func (p *statementSamplesClient) SubmitEvents(sender aggregator.Sender, source string, events []StatementSampleEvent) (submitted, failed int) {
+	for _, e := range events {
+		text, err := json.Marshal(e.Sample)
+		if err != nil {
+			klog.V(5).Infof("failed to marshal the statement sample %s: %s", e.QuerySignature, err)
+			failed++
+			continue
+		}
+
+		sender.Event(metrics.Event{
+			Title:          e.Statement,
+			Text:           string(text),
+			Ts:             e.Timestamp.Unix(),
+			Host:           e.Host,
+			Tags:           e.Tags,
+			AggregationKey: e.QuerySignature,
+			SourceTypeName: source,
+			EventType:      source + ".statement_sample",
+		})
+		submitted++
+	}
+
+	klog.V(5).Infof("SubmitEvents submitted %d failed %d", submitted, failed)
 	return
 }
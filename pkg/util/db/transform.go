@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/n9e/n9e-agentd/pkg/util/expr"
 )
@@ -20,13 +21,25 @@ var (
 		"tag_list":         get_tag_list,
 		"match":            get_match,
 		"service_check":    get_service_check,
-		//"time_elapsed":     get_time_elapsed,
+		"tag_not_null":     get_tag_not_null,
+		"time_elapsed":     get_time_elapsed,
 	}
 
 	EXTRA_TRANSFORMERS = mapinterface{
 		"expression": get_expression,
 		"percent":    get_percent,
 	}
+
+	// NATIVE_TIME_LAYOUTS are the layouts of the `native` format of time_elapsed
+	NATIVE_TIME_LAYOUTS = []string{
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05.999999999Z07:00",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02T15:04:05.999999999",
+	}
+
+	// timeNow is replaced by the tests
+	timeNow = time.Now
 )
 
 func get_tag(transformers mapinterface, column_name, modifiers interface{}) (interface{}, error) {
@@ -41,8 +54,40 @@ func get_tag(transformers mapinterface, column_name, modifiers interface{}) (int
 	// to the string `true` or `false`. So for example if you named the column `alive` and the result was the
 	// number `0` the tag will be `alive:false`.
 	// """
+	return _compile_tag(column_name, modifiers, false)
+}
+
+func get_tag_not_null(transformers mapinterface, column_name, modifiers interface{}) (interface{}, error) {
+	// Convert a column to a tag that will be used in every subsequent submission, just like `tag`,
+	// but the tag is not applied if the value is NULL.
+	return _compile_tag(column_name, modifiers, true)
+}
+
+func _compile_tag(column_name, modifiers_ interface{}, not_null bool) (interface{}, error) {
+	modifiers, err := _mapinterface(modifiers_)
+	if err != nil {
+		return nil, err
+	}
+
+	boolean := is_affirmative(modifiers.pop("boolean"))
+	if err := _no_modifiers(modifiers); err != nil {
+		return nil, err
+	}
+
 	return func(_ mapinterface, value, _ interface{}) (interface{}, error) {
-		return fmt.Sprintf("%v:%v", column_name, String(value)), nil
+		if not_null && is_null(value) {
+			return nil, nil
+		}
+
+		if boolean {
+			return fmt.Sprintf("%v:%t", column_name, is_affirmative(value)), nil
+		}
+
+		v, err := valueString(value)
+		if err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("%v:%s", column_name, v), nil
 	}, nil
 }
 
@@ -57,11 +102,31 @@ func get_tag_list(transformers mapinterface, column_name, modifiers interface{})
 	// For example, if the column is named `server_tag` and the column returned the value `'us,primary'`, then all
 	// submissions for that row will be tagged by `server_tag:us` and `server_tag:primary`.
 	// """
+	m, err := _mapinterface(modifiers)
+	if err != nil {
+		return nil, err
+	}
+	if err := _no_modifiers(m); err != nil {
+		return nil, err
+	}
 
 	return func(_ mapinterface, value, _ interface{}) (interface{}, error) {
-		var ss []string
-		for _, v := range strings.Split(String(value), ",") {
-			ss = append(ss, fmt.Sprintf("%v:%v", column_name, v))
+		ss := []string{}
+		if is_null(value) {
+			return ss, nil
+		}
+
+		values, err := _string_list(value)
+		if err != nil {
+			v, err := valueString(value)
+			if err != nil {
+				return nil, err
+			}
+			values = strings.Split(v, ",")
+		}
+
+		for _, v := range values {
+			ss = append(ss, fmt.Sprintf("%v:%v", column_name, strings.TrimSpace(v)))
 		}
 		return ss, nil
 	}, nil
@@ -82,9 +147,10 @@ func get_monotonic_gauge(transformers mapinterface, column_name, modifiers inter
 	}
 
 	return func(_ mapinterface, value, kwargs interface{}) (interface{}, error) {
-		gauge(nil, value, kwargs)
-		monotonic_count(nil, value, kwargs)
-		return nil, nil
+		if _, err := gauge(nil, value, kwargs); err != nil {
+			return nil, err
+		}
+		return monotonic_count(nil, value, kwargs)
 	}, nil
 }
 
@@ -113,18 +179,9 @@ func get_temporal_percent(transformers mapinterface, column_name, modifiers_ int
 		return nil, err
 	}
 
-	scale_, err := _string(modifiers.pop("scale"))
+	scale, err := _compile_scale(modifiers.pop("scale"))
 	if err != nil {
-		return nil, fmt.Errorf("the `scale` parameter is required, err %s", err)
-	}
-
-	// try
-	scale, err := strconv.Atoi(scale_)
-	if err != nil {
-		var ok bool
-		if scale, ok = TIME_UNITS[scale_]; !ok {
-			return nil, fmt.Errorf("the `scale` parameter must be one of: microsecond, millisecond, nanosecond, second")
-		}
+		return nil, err
 	}
 
 	rate, err := _transformer5(transformers, "rate", transformers, column_name, modifiers)
@@ -133,8 +190,11 @@ func get_temporal_percent(transformers mapinterface, column_name, modifiers_ int
 	}
 
 	return func(_ mapinterface, value, kwargs interface{}) (interface{}, error) {
-		rate(nil, total_time_to_temporal_percent(Float(value), scale), kwargs)
-		return nil, nil
+		total_time, err := valueFloat(value)
+		if err != nil {
+			return nil, fmt.Errorf("the value of %s %s", column_name, err)
+		}
+		return rate(nil, total_time_to_temporal_percent(total_time, scale), kwargs)
 	}, nil
 }
 
@@ -224,14 +284,23 @@ func get_match(transformers mapinterface, column_name, modifiers interface{}) (i
 	}
 
 	return func(sources mapinterface, value, kwargs interface{}) (interface{}, error) {
-		name, err := _string(value)
+		// the items are the keys of a mapping, so the values of any type are
+		// matched by their string form, e.g. 1 of an integer column
+		name, err := valueString(value)
 		if err != nil {
 			return nil, err
 		}
-		if v, ok := compiled_items[name]; ok {
-			v.transformer(sources, sources[v.source], kwargs)
+
+		v, ok := compiled_items[name]
+		if !ok {
+			return nil, nil
+		}
+
+		source, ok := sources[v.source]
+		if !ok {
+			return nil, fmt.Errorf("the source `%s` of item `%s` is not available", v.source, name)
 		}
-		return nil, nil
+		return v.transformer(sources, source, kwargs)
 	}, nil
 }
 
@@ -266,13 +335,13 @@ func get_service_check(transformers mapinterface, column_name, modifiers_ interf
 	}
 
 	return func(_ mapinterface, value, kwargs interface{}) (interface{}, error) {
-		v, err := _string(value)
+		v, err := valueString(value)
 		if err != nil {
-			return nil, fmt.Errorf("value must be a string, err %s", err)
+			return nil, err
 		}
 
-		status, err := _string(status_map[v])
-		if err != nil {
+		status, ok := status_map[v]
+		if !ok {
 			status = "UNKNOWN"
 		}
 
@@ -281,45 +350,41 @@ func get_service_check(transformers mapinterface, column_name, modifiers_ interf
 }
 
 func get_time_elapsed(transformers mapinterface, column_name, modifiers_ interface{}) (interface{}, error) {
+	// Send the number of seconds elapsed from a time in the past as a `gauge`.
+	//
+	// For example, if the result is a time representing 5 seconds ago, then this would submit with a value of `5`.
+	//
+	// The optional modifier `format` indicates what format the result is in. By default it is `native`, the
+	// value is a time.Time if the driver parses the times, or a string in RFC 3339 or the `2006-01-02 15:04:05`
+	// format of the DATETIME columns. If the driver returns the times as strings in another format, you must
+	// provide the layout of the format, e.g. `02/Jan/2006:15:04:05 -0700`, see https://pkg.go.dev/time#pkg-constants
+	//
+	// The times without a time zone are in UTC.
+	modifiers, err := _mapinterface(modifiers_)
+	if err != nil {
+		return nil, err
+	}
+
+	time_format, err := _string(modifiers.pop("format", "native"))
+	if err != nil {
+		return nil, fmt.Errorf("the `format` parameter must be a string")
+	}
+	if time_format == "" {
+		return nil, fmt.Errorf("the `format` parameter must not be empty")
+	}
+
+	gauge, err := _transformer5(transformers, "gauge", transformers, column_name, modifiers)
+	if err != nil {
+		return nil, err
+	}
 
-	//Send the number of seconds elapsed from a time in the past as a `gauge`.
-
-	//For example, if the result is an instance of
-	//[datetime.datetime](https://docs.python.org/3/library/datetime.html#datetime.datetime) representing 5 seconds ago,
-	//then this would submit with a value of `5`.
-
-	//The optional modifier `format` indicates what format the result is in. By default it is `native`, assuming the
-	//underlying library provides timestamps as `datetime` objects. If it does not and passes them through directly as
-	//strings, you must provide the expected timestamp format using the
-	//[supported codes](https://docs.python.org/3/library/datetime.html#strftime-and-strptime-format-codes).
-
-	//!!! note
-	//    The code `%z` (lower case) is not supported on Windows.
-	// modifiers, err := _mapinterface(modifiers_)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	// time_format, err := _string(modifiers.pop("format", "native"))
-	// if err != nil {
-	// 	return nil, fmt.Errorf("the `format` parameter must be a string")
-	// }
-
-	// gauge, err := _transforer5(transformers, "gauge", transformers, column_name, modifiers)
-
-	// if time_format == "native" {
-	// 	return func(_ mapinterface, value, kwargs interface{}) (interface{}, error) {
-	// 		value = ensure_aware_datetime(value)
-	// 		gauge(_, (datetime.now(value.tzinfo) - value).total_seconds(), kwargs)
-	// 	}, nil
-	// } else {
-
-	// 	return func(_ mapinterface, value, kwargs interface{}) (interface{}, error) {
-	// 		value = ensure_aware_datetime(datetime.strptime(value, time_format))
-	// 		gauge(_, (datetime.now(value.tzinfo) - value).total_seconds(), kwargs)
-	// 	}, nil
-	// }
-	return nil, nil
+	return func(_ mapinterface, value, kwargs interface{}) (interface{}, error) {
+		t, err := parse_time(value, time_format)
+		if err != nil {
+			return nil, fmt.Errorf("the value of %s %s", column_name, err)
+		}
+		return gauge(nil, timeNow().Sub(t).Seconds(), kwargs)
+	}, nil
 }
 
 //
@@ -388,7 +453,11 @@ func get_expression(transformers mapinterface, name, modifiers_ interface{}) (in
 	//	return nil, err
 	//}
 
-	expression := String(modifiers.pop("expression"))
+	// the expression is evaluated with the values of the sources, see
+	// expr.NewNotations for the syntax, rate() is kept for each row
+	modifiers.pop("sources")
+
+	expression, _ := _string(modifiers.pop("expression"))
 	if expression == "" {
 		return nil, fmt.Errorf("the `expression` parameter is required")
 	}
@@ -397,28 +466,82 @@ func get_expression(transformers mapinterface, name, modifiers_ interface{}) (in
 	if err != nil {
 		return nil, fmt.Errorf("parse expr %s err %s", expression, err)
 	}
+	calc := expression_calc(notations)
 
-	if _, ok := modifiers["submit_type"]; !ok {
+	submit_type_ := modifiers.pop("submit_type")
+	if submit_type_ == nil {
+		if err := _no_modifiers(modifiers); err != nil {
+			return nil, err
+		}
 		return func(sources mapinterface, kwargs, _ interface{}) (interface{}, error) {
-			return notations.Calc(sources.getFloat)
+			return calc(sources, kwargs)
 		}, nil
 	}
 
-	submit_method, err := _transformer5(transformers,
-		String(modifiers["submit_type"]), transformers, name, modifiers)
+	submit_type, err := _string(submit_type_)
+	if err != nil {
+		return nil, fmt.Errorf("the `submit_type` parameter must be a string")
+	}
+	if transformers[submit_type] == nil {
+		return nil, fmt.Errorf("unknown `submit_type` %s", submit_type)
+	}
+
+	submit_method, err := _transformer5(transformers, submit_type, transformers, name, modifiers)
 	if err != nil {
 		return nil, err
 	}
 	return func(sources mapinterface, kwargs, _ interface{}) (interface{}, error) {
-		result, err := notations.Calc(sources.getFloat)
+		result, err := calc(sources, kwargs)
 		if err != nil {
 			return nil, err
 		}
-		submit_method(sources, result, kwargs)
+		if _, err := submit_method(sources, result, kwargs); err != nil {
+			return nil, err
+		}
 		return result, nil
 	}, nil
 }
 
+// expression_calc returns the func that evaluates the expression with the
+// sources of a row, the previous values of rate() are kept for each row by
+// the tags of the row. The states of the rows that were not returned by the
+// last Execute are dropped on the next one.
+func expression_calc(notations expr.Notations) func(sources mapinterface, kwargs interface{}) (float64, error) {
+	if !notations.HasRate() {
+		return func(sources mapinterface, _ interface{}) (float64, error) {
+			return notations.Calc(sources.getFloat)
+		}
+	}
+
+	states := map[string]*expr.RateState{}
+	seen := map[string]bool{} // the rows of the current run
+	var run uint64
+	return func(sources mapinterface, kwargs_ interface{}) (float64, error) {
+		kwargs, err := _mapinterface(kwargs_)
+		if err != nil {
+			return 0, err
+		}
+
+		if r := kwargs.run(); r != run {
+			for key := range states {
+				if !seen[key] {
+					delete(states, key)
+				}
+			}
+			run, seen = r, map[string]bool{}
+		}
+
+		key := strings.Join(kwargs.tags(), ",")
+		seen[key] = true
+		state, ok := states[key]
+		if !ok {
+			state = expr.NewRateState()
+			states[key] = state
+		}
+		return notations.CalcWithState(sources.getFloat, state, timeNow())
+	}
+}
+
 func get_percent(transformers mapinterface, name, modifiers_ interface{}) (interface{}, error) {
 	//# type: (Dict[str, Callable], str, Any) -> Callable[[Any, Any, Any], None]
 	//"""
@@ -450,12 +573,13 @@ func get_percent(transformers mapinterface, name, modifiers_ interface{}) (inter
 		return nil, err
 	}
 
-	available_sources, err := _mapinterface(modifiers.pop("sources"))
-	if err != nil {
-		return nil, err
+	// the names of the columns and the extras defined before
+	available_sources, ok := modifiers.pop("sources").(map[string]*sourceItem)
+	if !ok {
+		return nil, fmt.Errorf("the available sources are required")
 	}
 
-	part := String(modifiers.pop("part"))
+	part, _ := _string(modifiers.pop("part"))
 	if part == "" {
 		return nil, fmt.Errorf("the `part` parameter is required")
 	}
@@ -464,7 +588,7 @@ func get_percent(transformers mapinterface, name, modifiers_ interface{}) (inter
 		return nil, fmt.Errorf("the `part` parameter `%s` is not an available source", part)
 	}
 
-	total := String(modifiers.pop("total"))
+	total, _ := _string(modifiers.pop("total"))
 	if total == "" {
 		return nil, fmt.Errorf("the `total` parameter is required")
 	}
@@ -482,8 +606,15 @@ func get_percent(transformers mapinterface, name, modifiers_ interface{}) (inter
 	}
 
 	return func(sources mapinterface, kwargs, _ interface{}) (interface{}, error) {
-		gauge(sources, compute_percent(Float(sources[part]), Float(sources[total])), kwargs)
-		return nil, nil
+		part_value, err := sources.getFloat(part)
+		if err != nil {
+			return nil, err
+		}
+		total_value, err := sources.getFloat(total)
+		if err != nil {
+			return nil, err
+		}
+		return gauge(sources, compute_percent(part_value, total_value), kwargs)
 	}, nil
 }
 
@@ -495,20 +626,21 @@ func compute_percent(part, total float64) float64 {
 	return 0
 }
 
-func _compile_service_check_statuses(modifiers mapinterface) (mapinterface, error) {
+func _compile_service_check_statuses(modifiers mapinterface) (map[string]string, error) {
 	// # type: (Dict[str, Any]) -> Dict[str, ServiceCheckStatus]
 	status_map_ := modifiers.pop("status_map")
 	if status_map_ == nil {
 		return nil, fmt.Errorf("the `status_map` parameter is required")
 	}
-	status_map, ok := status_map_.(mapinterface)
-	if !ok {
+	status_map, err := _mapinterface(status_map_)
+	if err != nil {
 		return nil, fmt.Errorf("the `status_map` parameter must be a mapping")
 	}
 	if len(status_map) == 0 {
 		return nil, fmt.Errorf("the `status_map` parameter must not be empty")
 	}
 
+	ret := make(map[string]string, len(status_map))
 	for value, status_string_ := range status_map {
 		status_string, ok := status_string_.(string)
 		if !ok {
@@ -517,14 +649,13 @@ func _compile_service_check_statuses(modifiers mapinterface) (mapinterface, erro
 
 		switch s := strings.ToUpper(status_string); s {
 		case "OK", "WARNING", "CRITICAL", "UNKNOWN":
-			status_map[value] = s
+			ret[value] = s
 		default:
 			return nil, fmt.Errorf("invalid status `%s` for value `%s` of parameter `status_map`", status_string, value)
 		}
 	}
-	return status_map, nil
+	return ret, nil
 }
-
 func _compile_match_items(transformers, modifiers mapinterface) (map[string]sourceTransform, error) {
 	//# type: (Dict[str, Any], Dict[str, Any]) -> Dict[str, Tuple[str, Any]]
 	items_ := modifiers.pop("items")
@@ -532,8 +663,8 @@ func _compile_match_items(transformers, modifiers mapinterface) (map[string]sour
 		return nil, fmt.Errorf("the `items` parameter is required")
 	}
 
-	items, ok := items_.(mapinterface)
-	if !ok {
+	items, err := _mapinterface(items_)
+	if err != nil {
 		return nil, fmt.Errorf("the `items` parameter must be a mapping")
 	}
 
@@ -541,10 +672,12 @@ func _compile_match_items(transformers, modifiers mapinterface) (map[string]sour
 
 	compiled_items := map[string]sourceTransform{}
 	for item, data_ := range items {
-		data, ok := data_.(mapinterface)
-		if !ok {
+		data, err := _mapinterface(data_)
+		if err != nil {
 			return nil, fmt.Errorf("item `%s` is not a mapping", item)
 		}
+		// the mapping of the config is kept as is
+		data = _copy(data)
 
 		transform_name_ := data.pop("name")
 		if transform_name_ == nil {
@@ -578,15 +711,14 @@ func _compile_match_items(transformers, modifiers mapinterface) (map[string]sour
 			return nil, fmt.Errorf("the `source` parameter for item `%s` must be a string", item)
 		}
 
-		transform_modifiers := make(mapinterface)
+		transform_modifiers := _copy(modifiers)
+		transform_modifiers.update(data)
 
-		transformer, err := _transformer5(transformers, transform_name, transformers, transform_name, transform_modifiers)
+		transformer, err := _transformer5(transformers, transform_type, transformers, transform_name, transform_modifiers)
 		if err != nil {
-			return nil, fmt.Errorf("unknown type `%s` for item `%s` err %s", transform_type, item, err)
+			return nil, fmt.Errorf("error compiling type `%s` for item `%s`: %s", transform_type, item, err)
 		}
 
-		transform_modifiers.update(modifiers)
-		transform_modifiers.update(data)
 		compiled_items[item] = sourceTransform{
 			source:      transform_source,
 			transformer: transformer,
@@ -595,3 +727,72 @@ func _compile_match_items(transformers, modifiers mapinterface) (map[string]sour
 
 	return compiled_items, nil
 }
+
+// _compile_scale returns the parts of the unit of time compared to seconds
+func _compile_scale(scale_ interface{}) (int, error) {
+	switch v := scale_.(type) {
+	case nil:
+		return 0, fmt.Errorf("the `scale` parameter is required")
+	case float64:
+		if v < 1 || v != float64(int(v)) {
+			return 0, fmt.Errorf("the `scale` parameter must be a positive integer")
+		}
+		return int(v), nil
+	case int:
+		if v < 1 {
+			return 0, fmt.Errorf("the `scale` parameter must be a positive integer")
+		}
+		return v, nil
+	case string:
+		if scale, ok := TIME_UNITS[v]; ok {
+			return scale, nil
+		}
+		if scale, err := strconv.Atoi(v); err == nil && scale > 0 {
+			return scale, nil
+		}
+	}
+
+	return 0, fmt.Errorf("the `scale` parameter must be one of: microsecond, millisecond, nanosecond, second")
+}
+
+// parse_time returns the time of a value, see get_time_elapsed
+func parse_time(value interface{}, time_format string) (time.Time, error) {
+	if t, ok := value.(time.Time); ok {
+		return t, nil
+	}
+
+	if is_null(value) {
+		return time.Time{}, fmt.Errorf("is null")
+	}
+
+	v, err := valueString(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if time_format != "native" {
+		return time.Parse(time_format, v)
+	}
+
+	for _, layout := range NATIVE_TIME_LAYOUTS {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable parse time %q, the `format` parameter is required", v)
+}
+
+// _no_modifiers returns an error if any modifier is not consumed by the
+// transformer
+func _no_modifiers(modifiers mapinterface) error {
+	for k := range modifiers {
+		return fmt.Errorf("unknown parameter `%s`", k)
+	}
+	return nil
+}
+
+func _copy(in mapinterface) mapinterface {
+	out := make(mapinterface, len(in))
+	out.update(in)
+	return out
+}
@@ -0,0 +1,407 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/n9e/n9e-agentd/pkg/util/capturesender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+)
+
+// fakeCheck runs the custom queries against the rows keyed by query
+type fakeCheck struct {
+	queries []CustomQuery
+	rows    map[string][][]interface{}
+	sender  *capturesender.CaptureSender
+}
+
+func (c *fakeCheck) CustomQueries() []CustomQuery       { return c.queries }
+func (c *fakeCheck) GlobalCustomQueries() []CustomQuery { return nil }
+func (c *fakeCheck) UseGlobalCustomQueries() string     { return "false" }
+func (c *fakeCheck) Sender() aggregator.Sender          { return c.sender }
+func (c *fakeCheck) Executor(query string) ([][]interface{}, error) {
+	rows, ok := c.rows[query]
+	if !ok {
+		return nil, fmt.Errorf("unknown query %s", query)
+	}
+	return rows, nil
+}
+
+func rawBytes(s string) *sql.RawBytes {
+	b := sql.RawBytes(s)
+	return &b
+}
+
+// execute compiles the query of the config and executes it with the rows
+func execute(t *testing.T, config string, rows ...[]interface{}) (*capturesender.CaptureSender, error) {
+	q := CustomQuery{}
+	require.NoError(t, yaml.Unmarshal([]byte(config), &q))
+
+	c := &fakeCheck{
+		queries: []CustomQuery{q},
+		rows:    map[string][][]interface{}{q.Query: rows},
+		sender:  capturesender.New(),
+	}
+
+	m, err := NewQueryManager(c, nil, nil)
+	require.NoError(t, err)
+	if err := m.Compile_queries(); err != nil {
+		return nil, err
+	}
+
+	m.Execute(nil)
+	return c.sender, nil
+}
+
+func TestColumnTransformers(t *testing.T) {
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	m := func(mtype, name string, value float64, tags ...string) capturesender.Metric {
+		if tags == nil {
+			tags = []string{}
+		}
+		return capturesender.Metric{Type: mtype, Name: name, Value: value, Tags: tags}
+	}
+
+	cases := []struct {
+		name    string
+		config  string
+		rows    [][]interface{}
+		metrics []capturesender.Metric
+	}{{
+		name: "submission methods",
+		config: `
+query: q
+columns:
+  - {name: a, type: gauge}
+  - {name: b, type: count}
+  - {name: c, type: monotonic_count}
+  - {name: d, type: rate}
+  - {name: e, type: histogram}
+  - {name: f, type: historate}
+`,
+		rows: [][]interface{}{{int64(1), 2.5, rawBytes("3"), "4", []byte("5"), true}},
+		metrics: []capturesender.Metric{
+			m("gauge", "a", 1), m("count", "b", 2.5), m("monotonic_count", "c", 3),
+			m("rate", "d", 4), m("histogram", "e", 5), m("historate", "f", 1),
+		},
+	}, {
+		name: "submission modifiers",
+		config: `
+query: q
+columns:
+  - {name: db, type: tag}
+  - {name: a, type: rate, tags: ["unit:s"], hostname: db-1}
+  - {name: b, type: monotonic_count, flush_first_value: true}
+tags: ["env:test"]
+`,
+		rows: [][]interface{}{{"orders", int64(1), int64(2)}},
+		metrics: []capturesender.Metric{
+			{Type: "rate", Name: "a", Value: 1, Hostname: "db-1", Tags: []string{"env:test", "db:orders", "unit:s"}},
+			m("monotonic_count", "b", 2, "env:test", "db:orders"),
+		},
+	}, {
+		name: "tags",
+		config: `
+query: q
+columns:
+  - {name: alive, type: tag, boolean: true}
+  - {name: role, type: tag_list}
+  - {name: zone, type: tag_not_null}
+  - {name: a, type: gauge}
+`,
+		rows: [][]interface{}{
+			{int64(0), "primary, us", "z1", int64(1)},
+			{rawBytes("yes"), []interface{}{"replica"}, (*sql.RawBytes)(nil), int64(2)},
+			{"1", nil, nil, int64(3)},
+		},
+		metrics: []capturesender.Metric{
+			m("gauge", "a", 1, "alive:false", "role:primary", "role:us", "zone:z1"),
+			m("gauge", "a", 2, "alive:true", "role:replica"),
+			m("gauge", "a", 3, "alive:true"),
+		},
+	}, {
+		name: "monotonic_gauge",
+		config: `
+query: q
+columns:
+  - {name: a, type: monotonic_gauge}
+`,
+		rows:    [][]interface{}{{int64(7)}},
+		metrics: []capturesender.Metric{m("gauge", "a.total", 7), m("monotonic_count", "a.count", 7)},
+	}, {
+		name: "temporal_percent",
+		config: `
+query: q
+columns:
+  - {name: a, type: temporal_percent, scale: millisecond}
+  - {name: b, type: temporal_percent, scale: 1000000}
+  - {name: c, type: temporal_percent, scale: "1"}
+`,
+		rows:    [][]interface{}{{int64(1500), int64(500000), "0.25"}},
+		metrics: []capturesender.Metric{m("rate", "a", 150), m("rate", "b", 50), m("rate", "c", 25)},
+	}, {
+		name: "match",
+		config: `
+query: q
+columns:
+  - {name: value1, type: source}
+  - {name: value2, type: source}
+  - name: metric
+    type: match
+    source: value1
+    tags: ["match:yes"]
+    items:
+      foo: {name: test.foo, type: gauge, source: value2}
+      bar: {name: test.bar, type: monotonic_gauge}
+      "1": {name: test.one, type: rate}
+`,
+		rows: [][]interface{}{
+			{int64(1), int64(2), rawBytes("foo")},
+			{int64(3), int64(4), "baz"},
+			{int64(5), int64(6), "bar"},
+			{int64(7), int64(8), int64(1)},
+		},
+		metrics: []capturesender.Metric{
+			m("gauge", "test.foo", 2, "match:yes"),
+			m("gauge", "test.bar.total", 5, "match:yes"),
+			m("monotonic_count", "test.bar.count", 5, "match:yes"),
+			m("rate", "test.one", 7, "match:yes"),
+		},
+	}, {
+		name: "time_elapsed",
+		config: `
+query: q
+columns:
+  - {name: a, type: time_elapsed}
+  - {name: b, type: time_elapsed}
+  - {name: c, type: time_elapsed, format: "02/Jan/2006:15:04:05 -0700"}
+  - {name: d, type: time_elapsed}
+`,
+		rows: [][]interface{}{{
+			now.Add(-5 * time.Second),
+			rawBytes("2021-10-01 11:59:00"),
+			"01/Oct/2021:19:58:00 +0800",
+			"2021-10-01T11:00:00Z",
+		}},
+		metrics: []capturesender.Metric{m("gauge", "a", 5), m("gauge", "b", 60), m("gauge", "c", 120), m("gauge", "d", 3600)},
+	}, {
+		name: "extras",
+		config: `
+query: q
+columns:
+  - {name: used, type: gauge}
+  - {name: total, type: gauge}
+extras:
+  - {name: free, expression: total - used}
+  - {name: disk_free, type: gauge, source: free}
+  - {name: disk_used2, expression: used * 2, submit_type: count, tags: ["x:y"]}
+  - {name: disk_util, type: percent, part: used, total: total}
+`,
+		rows: [][]interface{}{{int64(25), rawBytes("200")}},
+		metrics: []capturesender.Metric{
+			m("gauge", "used", 25), m("gauge", "total", 200),
+			m("gauge", "disk_free", 175), m("count", "disk_used2", 50, "x:y"), m("gauge", "disk_util", 12.5),
+		},
+	}, {
+		name: "expressions",
+		config: `
+query: q
+columns:
+  - {name: disk.used, type: gauge}
+  - {name: disk.total, type: gauge}
+extras:
+  - {name: disk.pct, expression: "if(disk.total > 0, disk.used / disk.total * 100, 0)", submit_type: gauge}
+  - {name: disk.over, expression: "max(disk.used - 100, -disk.used % 7, 0)", submit_type: gauge}
+  - {name: disk.none, expression: disk.used / (disk.total - 200), submit_type: gauge}
+`,
+		rows: [][]interface{}{{int64(25), rawBytes("200")}},
+		metrics: []capturesender.Metric{
+			m("gauge", "disk.used", 25), m("gauge", "disk.total", 200),
+			m("gauge", "disk.pct", 12.5), m("gauge", "disk.over", 0),
+		},
+	}, {
+		name: "invalid values are skipped",
+		config: `
+query: q
+columns:
+  - {name: a, type: gauge}
+  - {name: b, type: time_elapsed}
+`,
+		rows: [][]interface{}{
+			{nil, "yesterday"},
+			{"abc", (*sql.RawBytes)(nil)},
+			{int64(1)},
+			{int64(2), now},
+		},
+		metrics: []capturesender.Metric{m("gauge", "a", 2), m("gauge", "b", 0)},
+	}}
+
+	for _, c := range cases {
+		sender, err := execute(t, c.config, c.rows...)
+		require.NoError(t, err, c.name)
+		assert.Equal(t, c.metrics, sender.Metrics, c.name)
+	}
+}
+
+func TestExpressionRate(t *testing.T) {
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	q := CustomQuery{}
+	require.NoError(t, yaml.Unmarshal([]byte(`
+query: q
+columns:
+  - {name: iface, type: tag}
+  - {name: bytes, type: gauge}
+extras:
+  - {name: bits, expression: rate(bytes) * 8, submit_type: gauge}
+`), &q))
+
+	c := &fakeCheck{
+		queries: []CustomQuery{q},
+		rows:    map[string][][]interface{}{"q": {{"eth0", int64(100)}, {"eth1", int64(1000)}}},
+		sender:  capturesender.New(),
+	}
+	m, err := NewQueryManager(c, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, m.Compile_queries())
+
+	// no previous value
+	m.Execute(nil)
+	assert.Len(t, c.sender.Metrics, 2)
+
+	// the rate is kept for each row by the tags
+	now = now.Add(10 * time.Second)
+	c.rows["q"] = [][]interface{}{{"eth0", int64(200)}, {"eth1", int64(1500)}}
+	c.sender.Metrics = c.sender.Metrics[:0]
+	m.Execute(nil)
+	assert.Equal(t, []capturesender.Metric{
+		{Type: "gauge", Name: "bytes", Value: 200, Tags: []string{"iface:eth0"}},
+		{Type: "gauge", Name: "bits", Value: 80, Tags: []string{"iface:eth0"}},
+		{Type: "gauge", Name: "bytes", Value: 1500, Tags: []string{"iface:eth1"}},
+		{Type: "gauge", Name: "bits", Value: 400, Tags: []string{"iface:eth1"}},
+	}, c.sender.Metrics)
+
+	// eth1 is missing, its state is dropped on the next run
+	now = now.Add(10 * time.Second)
+	c.rows["q"] = [][]interface{}{{"eth0", int64(300)}}
+	m.Execute(nil)
+
+	now = now.Add(10 * time.Second)
+	c.rows["q"] = [][]interface{}{{"eth0", int64(400)}, {"eth1", int64(2000)}}
+	c.sender.Metrics = c.sender.Metrics[:0]
+	m.Execute(nil)
+	assert.Equal(t, []capturesender.Metric{
+		{Type: "gauge", Name: "bytes", Value: 400, Tags: []string{"iface:eth0"}},
+		{Type: "gauge", Name: "bits", Value: 80, Tags: []string{"iface:eth0"}},
+		{Type: "gauge", Name: "bytes", Value: 2000, Tags: []string{"iface:eth1"}},
+	}, c.sender.Metrics)
+}
+
+func TestServiceCheckTransformer(t *testing.T) {
+	sender, err := execute(t, `
+query: q
+columns:
+  - {name: role, type: tag}
+  - name: db.up
+    type: service_check
+    message: replication
+    status_map: {"1": ok, "0": critical, "lag": warning}
+`, []interface{}{"primary", int64(1)}, []interface{}{"replica", rawBytes("0")},
+		[]interface{}{"replica", "lag"}, []interface{}{"replica", "2"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []capturesender.ServiceCheck{
+		{Name: "db.up", Status: "OK", Tags: []string{"role:primary"}, Message: "replication"},
+		{Name: "db.up", Status: "CRITICAL", Tags: []string{"role:replica"}, Message: "replication"},
+		{Name: "db.up", Status: "WARNING", Tags: []string{"role:replica"}, Message: "replication"},
+		{Name: "db.up", Status: "UNKNOWN", Tags: []string{"role:replica"}, Message: "replication"},
+	}, sender.ServiceChecks)
+}
+
+func TestTransformerModifiers(t *testing.T) {
+	for _, config := range []string{
+		// submission methods
+		`{query: q, columns: [{name: a, type: gauge, unknown: 1}]}`,
+		`{query: q, columns: [{name: a, type: gauge, flush_first_value: true}]}`,
+		`{query: q, columns: [{name: a, type: gauge, tags: "a:b"}]}`,
+		`{query: q, columns: [{name: a, type: gauge, tags: [1]}]}`,
+		`{query: q, columns: [{name: a, type: rate, hostname: [a]}]}`,
+		`{query: q, columns: [{name: a, type: unknown}]}`,
+		// tags
+		`{query: q, columns: [{name: a, type: tag, unknown: 1}]}`,
+		`{query: q, columns: [{name: a, type: tag_list, boolean: true}]}`,
+		// temporal_percent
+		`{query: q, columns: [{name: a, type: temporal_percent}]}`,
+		`{query: q, columns: [{name: a, type: temporal_percent, scale: hour}]}`,
+		`{query: q, columns: [{name: a, type: temporal_percent, scale: 0.5}]}`,
+		// match
+		`{query: q, columns: [{name: a, type: match}]}`,
+		`{query: q, columns: [{name: a, type: match, items: [a]}]}`,
+		`{query: q, columns: [{name: a, type: match, items: {x: {type: gauge, source: a}}}]}`,
+		`{query: q, columns: [{name: a, type: match, items: {x: {name: b, source: a}}}]}`,
+		`{query: q, columns: [{name: a, type: match, items: {x: {name: b, type: unknown, source: a}}}]}`,
+		`{query: q, columns: [{name: a, type: match, items: {x: {name: b, type: gauge}}}]}`,
+		`{query: q, columns: [{name: a, type: match, source: a, items: {x: {name: b, type: gauge, unknown: 1}}}]}`,
+		// service_check
+		`{query: q, columns: [{name: a, type: service_check}]}`,
+		`{query: q, columns: [{name: a, type: service_check, status_map: {}}]}`,
+		`{query: q, columns: [{name: a, type: service_check, status_map: {"1": up}}]}`,
+		`{query: q, columns: [{name: a, type: service_check, status_map: {"1": ok}, unknown: 1}]}`,
+		// time_elapsed
+		`{query: q, columns: [{name: a, type: time_elapsed, format: 1}]}`,
+		`{query: q, columns: [{name: a, type: time_elapsed, format: ""}]}`,
+		`{query: q, columns: [{name: a, type: time_elapsed, unknown: 1}]}`,
+		// extras
+		`{query: q, columns: [{name: a, type: gauge}], extras: [{name: b, type: expression}]}`,
+		`{query: q, columns: [{name: a, type: gauge}], extras: [{name: b, expression: a, unknown: 1}]}`,
+		`{query: q, columns: [{name: a, type: gauge}], extras: [{name: b, expression: a, submit_type: unknown}]}`,
+		`{query: q, columns: [{name: a, type: gauge}], extras: [{name: b, expression: "abs(a, 1)"}]}`,
+		`{query: q, columns: [{name: a, type: gauge}], extras: [{name: b, type: percent, total: a}]}`,
+		`{query: q, columns: [{name: a, type: gauge}], extras: [{name: b, type: percent, part: a, total: c}]}`,
+		`{query: q, columns: [{name: a, type: gauge}], extras: [{name: b, type: gauge}]}`,
+	} {
+		_, err := execute(t, config)
+		assert.Error(t, err, config)
+	}
+}
+
+func TestValueConversion(t *testing.T) {
+	for _, c := range []struct {
+		value interface{}
+		f     float64
+		s     string
+	}{
+		{int64(-3), -3, "-3"},
+		{1.5, 1.5, "1.5"},
+		{"2", 2, "2"},
+		{rawBytes("4.5"), 4.5, "4.5"},
+		{[]byte("6"), 6, "6"},
+		{true, 1, "true"},
+	} {
+		f, err := valueFloat(c.value)
+		assert.NoError(t, err, "%#v", c.value)
+		assert.Equal(t, c.f, f, "%#v", c.value)
+		s, err := valueString(c.value)
+		assert.NoError(t, err, "%#v", c.value)
+		assert.Equal(t, c.s, s, "%#v", c.value)
+	}
+
+	for _, value := range []interface{}{nil, (*sql.RawBytes)(nil), rawBytes("a"), struct{}{}} {
+		_, err := valueFloat(value)
+		assert.Error(t, err, "%#v", value)
+	}
+
+	s, err := valueString(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "", s)
+}
@@ -1,10 +1,7 @@
 package db
 
 import (
-	"database/sql"
 	"fmt"
-	"reflect"
-	"strconv"
 
 	"github.com/DataDog/datadog-agent/pkg/metrics"
 )
@@ -57,6 +54,15 @@ func (p mapinterface) tags() (tags []string) {
 	return nil
 }
 
+// run returns the number of the Execute of the row
+func (p mapinterface) run() uint64 {
+	if v, ok := p["run"].(uint64); ok {
+		return v
+	}
+
+	return 0
+}
+
 func (p mapinterface) pop(k string, def ...interface{}) interface{} {
 	if v, ok := p[k]; ok {
 		delete(p, k)
@@ -71,20 +77,14 @@ func (p mapinterface) pop(k string, def ...interface{}) interface{} {
 }
 
 func (p mapinterface) getFloat(k string) (float64, error) {
-	switch v := p[k].(type) {
-	case float64:
-		return v, nil
-	case *sql.RawBytes:
-		i, _ := strconv.ParseFloat(string(*v), 0)
-		return i, nil
-	case int64:
-		return float64(v), nil
-	case string:
-		i, _ := strconv.ParseFloat(v, 0)
-		return i, nil
-	case nil:
+	v, ok := p[k]
+	if !ok {
 		return 0, fmt.Errorf("unable get %s", k)
-	default:
-		return 0, fmt.Errorf("unable convert float64 from %s", reflect.TypeOf(p[k]))
 	}
+
+	f, err := valueFloat(v)
+	if err != nil {
+		return 0, fmt.Errorf("unable convert float64 from %s: %s", k, err)
+	}
+	return f, nil
 }
@@ -1,27 +1,30 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/n9e/n9e-agentd/pkg/config"
 	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/n9e/n9e-agentd/pkg/config"
 	"k8s.io/klog/v2"
 )
 
 var (
 	// AgentCheck methods to transformer name e.g. set_metadata -> metadata
 	SUBMISSION_METHODS = map[string]string{
-		"Gauge":          "gauge",
-		"Count":          "count",
-		"MonotonicCount": "monotonic_count",
-		"Rate":           "rate",
-		"Histogram":      "histogram",
-		"Historate":      "historate",
+		"Gauge": "gauge",
+		"Count": "count",
+		// MonotonicCount with the optional modifier `flush_first_value`
+		"MonotonicCountWithFlushFirstValue": "monotonic_count",
+		"Rate":                              "rate",
+		"Histogram":                         "histogram",
+		"Historate":                         "historate",
 		//"SetMetadata":    "metadata",
 		// These submission methods require more configuration than just a name
 		// and a value and therefore must be defined as a custom transformer.
@@ -35,37 +38,29 @@ func create_submission_transformer(submit_method interface{}) transformHandle {
 	// passed the first arguments (e.g. name) that will be forwarded the actual AgentCheck methods.
 	switch fn := submit_method.(type) {
 	case func(string, float64, string, []string):
+		return create_metric_transformer(func(name string, value float64, m *submission_modifiers, tags []string) {
+			fn(name, value, m.hostname, tags)
+		})
+	case func(string, float64, string, []string, bool):
+		return create_metric_transformer(func(name string, value float64, m *submission_modifiers, tags []string) {
+			fn(name, value, m.hostname, tags, m.flush_first_value)
+		}, "flush_first_value")
+	case func(string, metrics.ServiceCheckStatus, string, []string, string):
 		return func(_ mapinterface, creation_args, modifiers_ interface{}) (interface{}, error) {
 			modifiers, err := _mapinterface(modifiers_)
 			if err != nil {
 				return nil, err
 			}
 
-			metric_name, ok := creation_args.(string)
-			if !ok {
-				panic(fmt.Sprintf("metric_name must be a string %#v", creation_args))
-			}
-
-			return func(_ mapinterface, call_args, kwargs_ interface{}) (interface{}, error) {
-				kwargs, err := _mapinterface(kwargs_)
-				if err != nil {
-					return nil, err
-				}
-
-				kwargs.update(modifiers)
-
-				fn(metric_name, Float(call_args), "", kwargs.tags())
-				return nil, nil
-			}, nil
-		}
-	case func(string, metrics.ServiceCheckStatus, string, []string, string):
-		return func(_ mapinterface, creation_args, modifiers_ interface{}) (interface{}, error) {
-			modifiers, err := _mapinterface(modifiers_)
+			m, err := _compile_submission_modifiers(modifiers, "message")
 			if err != nil {
 				return nil, err
 			}
 
-			check_name := String(creation_args)
+			check_name, err := _string(creation_args)
+			if err != nil {
+				return nil, fmt.Errorf("the service check name must be a string, err %s", err)
+			}
 
 			return func(_ mapinterface, call_args, kwargs_ interface{}) (interface{}, error) {
 				kwargs, err := _mapinterface(kwargs_)
@@ -73,11 +68,9 @@ func create_submission_transformer(submit_method interface{}) transformHandle {
 					return nil, err
 				}
 
-				kwargs.update(modifiers)
-
-				status_string, ok := call_args.(string)
-				if !ok {
-					panic(fmt.Sprintf("metric_value must be a metrics.ServiceCheckStatus %#v", call_args))
+				status_string, err := _string(call_args)
+				if err != nil {
+					return nil, fmt.Errorf("the status of %s must be a string, err %s", check_name, err)
 				}
 
 				status, ok := serviceCheckStatus[status_string]
@@ -85,7 +78,7 @@ func create_submission_transformer(submit_method interface{}) transformHandle {
 					status = metrics.ServiceCheckUnknown
 				}
 
-				fn(check_name, status, "", kwargs.tags(), "")
+				fn(check_name, status, m.hostname, m.tags_of(kwargs), m.message)
 				return nil, nil
 			}, nil
 		}
@@ -94,6 +87,104 @@ func create_submission_transformer(submit_method interface{}) transformHandle {
 	}
 }
 
+// create_metric_transformer returns the transformer of a metric submission
+// method, the value is converted to float64 on every call
+func create_metric_transformer(submit func(string, float64, *submission_modifiers, []string), allowed ...string) transformHandle {
+	return func(_ mapinterface, creation_args, modifiers_ interface{}) (interface{}, error) {
+		modifiers, err := _mapinterface(modifiers_)
+		if err != nil {
+			return nil, err
+		}
+
+		m, err := _compile_submission_modifiers(modifiers, allowed...)
+		if err != nil {
+			return nil, err
+		}
+
+		metric_name, err := _string(creation_args)
+		if err != nil {
+			return nil, fmt.Errorf("the metric name must be a string, err %s", err)
+		}
+
+		return func(_ mapinterface, call_args, kwargs_ interface{}) (interface{}, error) {
+			kwargs, err := _mapinterface(kwargs_)
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := valueFloat(call_args)
+			if err != nil {
+				return nil, fmt.Errorf("the value of %s %s", metric_name, err)
+			}
+
+			submit(metric_name, value, m, m.tags_of(kwargs))
+			return nil, nil
+		}, nil
+	}
+}
+
+// submission_modifiers are the modifiers of the submission methods, tags
+// and hostname are supported by all methods
+type submission_modifiers struct {
+	tags              []string
+	hostname          string
+	flush_first_value bool
+	message           string
+}
+
+// tags_of returns the tags of a submission, the tags of the row followed by
+// the tags of the modifiers
+func (p *submission_modifiers) tags_of(kwargs mapinterface) []string {
+	row_tags := kwargs.tags()
+	if len(p.tags) == 0 {
+		return row_tags
+	}
+
+	tags := make([]string, 0, len(row_tags)+len(p.tags))
+	tags = append(tags, row_tags...)
+	return append(tags, p.tags...)
+}
+
+func _compile_submission_modifiers(modifiers mapinterface, allowed ...string) (*submission_modifiers, error) {
+	ret := &submission_modifiers{}
+	for k, v := range modifiers {
+		switch k {
+		case "tags":
+			tags, err := _string_list(v)
+			if err != nil {
+				return nil, fmt.Errorf("the `tags` parameter %s", err)
+			}
+			ret.tags = tags
+			continue
+		case "hostname":
+			hostname, err := _string(v)
+			if err != nil {
+				return nil, fmt.Errorf("the `hostname` parameter must be a string")
+			}
+			ret.hostname = hostname
+			continue
+		}
+
+		if !_contains(allowed, k) {
+			return nil, fmt.Errorf("unknown parameter `%s`, the parameters are %v",
+				k, append([]string{"tags", "hostname"}, allowed...))
+		}
+
+		switch k {
+		case "flush_first_value":
+			ret.flush_first_value = is_affirmative(v)
+		case "message":
+			message, err := _string(v)
+			if err != nil {
+				return nil, fmt.Errorf("the `message` parameter must be a string")
+			}
+			ret.message = message
+		}
+	}
+
+	return ret, nil
+}
+
 func create_extra_transformer(column_transformer transformHandle, source ...string) (transformHandle, error) {
 	// Every column transformer expects a value to be given but in the post-processing
 	// phase the values are determined by references, so to avoid redefining every
@@ -109,30 +200,43 @@ func create_extra_transformer(column_transformer transformHandle, source ...stri
 }
 
 type ConstantRateLimiter struct {
-	rate_limit_s int
+	rate_limit_s float64
 	period_s     time.Duration
 	last_event   time.Time
 }
 
 // Basic rate limiter that sleeps long enough to ensure the rate limit is not exceeded. Not thread safe.
 // :param rate_limit_s: rate limit in seconds
-func NewConstantRateLimiter(rate_limit_s int) *ConstantRateLimiter {
+func NewConstantRateLimiter(rate_limit_s float64) *ConstantRateLimiter {
 	p := &ConstantRateLimiter{rate_limit_s: rate_limit_s}
 
 	if rate_limit_s > 0 {
-		p.period_s = time.Duration(1/rate_limit_s) * time.Second
+		p.period_s = time.Duration(float64(time.Second) / rate_limit_s)
 	}
 
 	return p
 }
 
-// Sleeps long enough to enforce the rate limit
-func (p *ConstantRateLimiter) sleep() {
-	now := time.Now()
-	if t := p.period_s - (now.Sub(p.last_event)); t > 0 {
-		time.Sleep(t)
+// RateLimit returns the rate limit in events per second
+func (p *ConstantRateLimiter) RateLimit() float64 {
+	return p.rate_limit_s
+}
+
+// Sleeps long enough to enforce the rate limit, returns false if ctx is done first
+func (p *ConstantRateLimiter) Sleep(ctx context.Context) bool {
+	if t := p.period_s - time.Since(p.last_event); t > 0 {
+		timer := time.NewTimer(t)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+		}
 	}
-	p.last_event = now
+	p.last_event = time.Now()
+
+	return ctx.Err() == nil
 }
 
 func resolve_db_host(db_host string) string {
@@ -219,34 +323,129 @@ func Int(a interface{}) int64 {
 }
 
 func Float(a interface{}) float64 {
+	f, err := valueFloat(a)
+	if err != nil {
+		panic(err.Error())
+	}
+	return f
+}
+
+func String(a interface{}) string {
+	s, err := valueString(a)
+	if err != nil {
+		panic(err.Error())
+	}
+	return s
+}
+
+// valueFloat converts a value of a row or a source to float64, the values
+// are in the types returned by the drivers, e.g. *sql.RawBytes or int64
+func valueFloat(a interface{}) (float64, error) {
+	if is_null(a) {
+		return 0, fmt.Errorf("is null")
+	}
+
 	switch v := a.(type) {
 	case *sql.RawBytes:
-		i, _ := strconv.ParseFloat(string(*v), 0)
-		return i
+		return strconv.ParseFloat(string(*v), 64)
+	case []byte:
+		return strconv.ParseFloat(string(v), 64)
 	case *string:
-		f, _ := strconv.ParseFloat(*v, 0)
-		return f
+		return strconv.ParseFloat(*v, 64)
 	case string:
-		f, _ := strconv.ParseFloat(v, 0)
-		return f
+		return strconv.ParseFloat(v, 64)
 	case *float64:
-		return *v
+		return *v, nil
 	case float64:
-		return v
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case *int64:
+		return float64(*v), nil
+	case int64:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
 	default:
-		panic(fmt.Sprintf("unsupported type %s", reflect.TypeOf(a)))
+		return 0, fmt.Errorf("unsupported type %s", reflect.TypeOf(a))
 	}
 }
 
-func String(a interface{}) string {
+// valueString converts a value of a row or a source to string, null is an
+// empty string
+func valueString(a interface{}) (string, error) {
+	if is_null(a) {
+		return "", nil
+	}
+
 	switch v := a.(type) {
 	case *sql.RawBytes:
-		return string(*v)
+		return string(*v), nil
+	case []byte:
+		return string(v), nil
+	case *string:
+		return *v, nil
 	case string:
-		return v
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
 	default:
-		panic(fmt.Sprintf("unsupported type %s", reflect.TypeOf(a)))
+		return "", fmt.Errorf("unsupported type %s", reflect.TypeOf(a))
+	}
+}
+
+// is_null returns true if the value is NULL, which is nil or a nil
+// *sql.RawBytes
+func is_null(a interface{}) bool {
+	switch v := a.(type) {
+	case nil:
+		return true
+	case *sql.RawBytes:
+		return v == nil || *v == nil
+	}
+	return false
+}
+
+// is_affirmative returns true for true, non-zero numbers and the strings
+// 1, y, yes, t, true and on
+func is_affirmative(a interface{}) bool {
+	switch v := a.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case int64:
+		return v != 0
+	case int:
+		return v != 0
+	}
+
+	s, err := valueString(a)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(s) {
+	case "1", "y", "yes", "t", "true", "on":
+		return true
 	}
+	return false
 }
 
 func _result(in interface{}) (interface{}, error) {
@@ -291,12 +490,44 @@ func _transformer5(transformers mapinterface, key string, arg1 mapinterface, arg
 }
 
 func _mapinterface(in interface{}) (mapinterface, error) {
-	v, ok := in.(mapinterface)
-	if !ok {
-		return nil, fmt.Errorf("typeof %s is not a map[string]interface{}", reflect.TypeOf(in))
+	switch v := in.(type) {
+	case mapinterface:
+		return v, nil
+	case map[string]interface{}:
+		// the mappings of the config
+		return mapinterface(v), nil
 	}
 
-	return v, nil
+	return nil, fmt.Errorf("typeof %s is not a map[string]interface{}", reflect.TypeOf(in))
+}
+
+// _string_list returns the strings of a list of the config
+func _string_list(in interface{}) ([]string, error) {
+	switch v := in.(type) {
+	case []string:
+		return v, nil
+	case []interface{}:
+		ret := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("must be a list of strings, got %s", reflect.TypeOf(item))
+			}
+			ret = append(ret, s)
+		}
+		return ret, nil
+	}
+
+	return nil, fmt.Errorf("must be a list of strings, got %s", reflect.TypeOf(in))
+}
+
+func _contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
 
 func _string(in interface{}) (string, error) {
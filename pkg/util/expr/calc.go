@@ -0,0 +1,313 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"go/token"
+	"math"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	ErrDivisionByZero = errors.New("division by zero")
+	ErrDomain         = errors.New("argument out of domain")
+	// ErrNoRate is returned by rate() if there is no previous value, or the
+	// value is decreased, e.g. the counter is reset
+	ErrNoRate = errors.New("no rate")
+)
+
+// VarError is returned by Calc if the variable can't be got
+type VarError struct {
+	Name string
+	Err  error
+}
+
+func (e *VarError) Error() string {
+	return fmt.Sprintf("unable to get variable %s: %s", e.Name, e.Err)
+}
+
+func (e *VarError) Unwrap() error { return e.Err }
+
+// StackError is returned by Calc if the notations are invalid, it can't
+// happen with the notations returned by NewNotations
+type StackError struct {
+	Op   string
+	Want int
+	Got  int
+}
+
+func (e *StackError) Error() string {
+	if e.Op == "" {
+		return fmt.Sprintf("invalid calc, stack len %d expect %d", e.Got, e.Want)
+	}
+	return fmt.Sprintf("invalid calc, missing operand for %s, stack len %d expect %d", e.Op, e.Got, e.Want)
+}
+
+type function struct {
+	minArgs int
+	maxArgs int // -1 is unlimited
+	call    func(args []float64) (float64, error)
+}
+
+func (f function) checkArgc(n int) error {
+	if n < f.minArgs || (f.maxArgs >= 0 && n > f.maxArgs) {
+		if f.minArgs == f.maxArgs {
+			return fmt.Errorf("expect %d argument(s), got %d", f.minArgs, n)
+		}
+		return fmt.Errorf("expect at least %d argument(s), got %d", f.minArgs, n)
+	}
+	return nil
+}
+
+// funcs are the functions of the expression, if and rate are evaluated by
+// Calc, the branch of if that is not taken is not checked for the errors
+var funcs = map[string]function{
+	"abs": {1, 1, func(args []float64) (float64, error) { return math.Abs(args[0]), nil }},
+	"log": {1, 1, func(args []float64) (float64, error) {
+		if args[0] <= 0 {
+			return 0, ErrDomain
+		}
+		return math.Log(args[0]), nil
+	}},
+	"min": {1, -1, func(args []float64) (float64, error) {
+		ret := args[0]
+		for _, v := range args[1:] {
+			ret = math.Min(ret, v)
+		}
+		return ret, nil
+	}},
+	"max": {1, -1, func(args []float64) (float64, error) {
+		ret := args[0]
+		for _, v := range args[1:] {
+			ret = math.Max(ret, v)
+		}
+		return ret, nil
+	}},
+	"if":   {3, 3, nil},
+	"rate": {1, 1, nil},
+}
+
+// RateState is the previous values of rate(), one per call of the
+// expression, it should be kept for each series, e.g. for each row of a
+// query. It's not safe for concurrent use.
+type RateState struct {
+	points map[int]ratePoint
+}
+
+type ratePoint struct {
+	value float64
+	time  time.Time
+}
+
+func NewRateState() *RateState {
+	return &RateState{points: map[int]ratePoint{}}
+}
+
+// rate returns the per-second rate of the value of the i-th notation
+func (s *RateState) rate(i int, v float64, now time.Time) (float64, error) {
+	if s == nil {
+		return 0, ErrNoRate
+	}
+
+	prev, ok := s.points[i]
+	s.points[i] = ratePoint{value: v, time: now}
+	if !ok {
+		return 0, ErrNoRate
+	}
+
+	dt := now.Sub(prev.time).Seconds()
+	if dt <= 0 || v < prev.value {
+		return 0, ErrNoRate
+	}
+	return (v - prev.value) / dt, nil
+}
+
+// operand is the value on the stack of Calc, the error is returned only if
+// the value is used, e.g. the error of the branch of if that's not taken is
+// ignored
+type operand struct {
+	value float64
+	err   error
+}
+
+type StackFloat []operand
+
+func (s *StackFloat) Push(v operand) { *s = append(*s, v) }
+func (s *StackFloat) Pop() operand   { n := (*s)[len(*s)-1]; *s = (*s)[:len(*s)-1]; return n }
+func (s *StackFloat) Len() int       { return len(*s) }
+
+// Calc evaluates the notations, rate() returns ErrNoRate, see CalcWithState
+func (rpn Notations) Calc(get func(key string) (float64, error)) (float64, error) {
+	return rpn.CalcWithState(get, nil, time.Time{})
+}
+
+// CalcWithState evaluates the notations, the values of rate() are kept in
+// the state with the time now
+func (rpn Notations) CalcWithState(get func(key string) (float64, error), state *RateState, now time.Time) (float64, error) {
+	var s StackFloat
+	for i := 0; i < rpn.Len(); i++ {
+		tn := rpn[i]
+		switch tn.tokenType {
+		case tokenVar:
+			v, err := get(tn.tokenVariable)
+			if err != nil {
+				s.Push(operand{err: &VarError{Name: tn.tokenVariable, Err: err}})
+				continue
+			}
+			klog.V(6).Infof("get %s %f", tn.tokenVariable, v)
+			s.Push(operand{value: v})
+		case tokenConst:
+			s.Push(operand{value: tn.tokenConst})
+		case tokenUnary:
+			if s.Len() < 1 {
+				return 0, &StackError{Op: tn.tokenOperator.String(), Want: 1, Got: s.Len()}
+			}
+			s.Push(unary(tn.tokenOperator, s.Pop()))
+		case tokenOperator:
+			if s.Len() < 2 {
+				return 0, &StackError{Op: tn.tokenOperator.String(), Want: 2, Got: s.Len()}
+			}
+			op2 := s.Pop()
+			op1 := s.Pop()
+			s.Push(binary(tn.tokenOperator, op1, op2))
+		case tokenFunc:
+			if s.Len() < tn.tokenArgc {
+				return 0, &StackError{Op: tn.tokenFunc, Want: tn.tokenArgc, Got: s.Len()}
+			}
+			args := make([]operand, tn.tokenArgc)
+			copy(args, s[s.Len()-tn.tokenArgc:])
+			s = s[:s.Len()-tn.tokenArgc]
+
+			switch tn.tokenFunc {
+			case "if":
+				s.Push(ifElse(args[0], args[1], args[2]))
+			case "rate":
+				if args[0].err != nil {
+					s.Push(args[0])
+					continue
+				}
+				v, err := state.rate(i, args[0].value, now)
+				s.Push(operand{value: v, err: err})
+			default:
+				s.Push(call(funcs[tn.tokenFunc], args))
+			}
+		}
+	}
+	if s.Len() != 1 {
+		return 0, &StackError{Want: 1, Got: s.Len()}
+	}
+
+	return s[0].value, s[0].err
+}
+
+func unary(op token.Token, x operand) operand {
+	if x.err != nil {
+		return x
+	}
+
+	switch op {
+	case token.SUB:
+		return operand{value: -x.value}
+	case token.NOT:
+		return operand{value: boolValue(x.value == 0)}
+	default:
+		return x
+	}
+}
+
+func binary(op token.Token, x, y operand) operand {
+	// the error of the right operand is ignored if the left one decides the
+	// result of && and ||
+	switch op {
+	case token.LAND:
+		if x.err == nil && x.value == 0 {
+			return operand{value: 0}
+		}
+	case token.LOR:
+		if x.err == nil && x.value != 0 {
+			return operand{value: 1}
+		}
+	}
+
+	if x.err != nil {
+		return x
+	}
+	if y.err != nil {
+		return y
+	}
+
+	a, b := x.value, y.value
+	switch op {
+	case token.ADD:
+		return operand{value: a + b}
+	case token.SUB:
+		return operand{value: a - b}
+	case token.MUL:
+		return operand{value: a * b}
+	case token.QUO:
+		if b == 0 {
+			return operand{err: ErrDivisionByZero}
+		}
+		return operand{value: a / b}
+	case token.REM:
+		if b == 0 {
+			return operand{err: ErrDivisionByZero}
+		}
+		return operand{value: math.Mod(a, b)}
+	case token.XOR:
+		v := math.Pow(a, b)
+		if math.IsNaN(v) && !math.IsNaN(a) && !math.IsNaN(b) {
+			return operand{err: ErrDomain}
+		}
+		return operand{value: v}
+	case token.EQL:
+		return operand{value: boolValue(a == b)}
+	case token.NEQ:
+		return operand{value: boolValue(a != b)}
+	case token.LSS:
+		return operand{value: boolValue(a < b)}
+	case token.LEQ:
+		return operand{value: boolValue(a <= b)}
+	case token.GTR:
+		return operand{value: boolValue(a > b)}
+	case token.GEQ:
+		return operand{value: boolValue(a >= b)}
+	case token.LAND, token.LOR:
+		// the left operand is true for && and false for ||
+		return operand{value: boolValue(b != 0)}
+	default:
+		return operand{err: fmt.Errorf("unsupport operator %s", op)}
+	}
+}
+
+func ifElse(cond, then, otherwise operand) operand {
+	if cond.err != nil {
+		return cond
+	}
+	if cond.value != 0 {
+		return then
+	}
+	return otherwise
+}
+
+func call(f function, args []operand) operand {
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		if arg.err != nil {
+			return arg
+		}
+		values[i] = arg.value
+	}
+
+	v, err := f.call(values)
+	return operand{value: v, err: err}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
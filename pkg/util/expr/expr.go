@@ -5,9 +5,9 @@ import (
 	"fmt"
 	"go/scanner"
 	"go/token"
+	"regexp"
 	"strconv"
-
-	"k8s.io/klog/v2"
+	"strings"
 )
 
 type tokenType int
@@ -16,6 +16,9 @@ const (
 	tokenOperator tokenType = iota
 	tokenVar
 	tokenConst
+	tokenUnary
+	tokenFunc
+	tokenParen
 )
 
 type TokenNotation struct {
@@ -23,6 +26,8 @@ type TokenNotation struct {
 	tokenOperator token.Token
 	tokenVariable string
 	tokenConst    float64
+	tokenFunc     string
+	tokenArgc     int
 }
 
 type Notations []*TokenNotation
@@ -39,136 +44,304 @@ func (s Notations) String() string {
 		switch tn.tokenType {
 		case tokenOperator:
 			out.WriteString(tn.tokenOperator.String() + " ")
+		case tokenUnary:
+			// u- is the negation, to tell it from the subtraction
+			out.WriteString("u" + tn.tokenOperator.String() + " ")
 		case tokenVar:
 			out.WriteString(tn.tokenVariable + " ")
 		case tokenConst:
-			out.WriteString(fmt.Sprintf("%.0f ", tn.tokenConst))
+			out.WriteString(strconv.FormatFloat(tn.tokenConst, 'g', -1, 64) + " ")
+		case tokenFunc:
+			out.WriteString(fmt.Sprintf("%s/%d ", tn.tokenFunc, tn.tokenArgc))
 		}
 	}
 	return out.String()
 }
 
-type StackOp []token.Token
-
-func (s *StackOp) Push(t token.Token) { *s = append(*s, t) }
-func (s *StackOp) Pop() token.Token   { n := (*s)[len(*s)-1]; *s = (*s)[:len(*s)-1]; return n }
-func (s *StackOp) Top() token.Token   { return (*s)[len(*s)-1] }
-func (s *StackOp) Len() int           { return len(*s) }
-
-type StackFloat []float64
+// StackOp is the stack of the operators, the functions and the left
+// parentheses while the expression is parsed
+type StackOp []*TokenNotation
 
-func (s *StackFloat) Push(f float64) { *s = append(*s, f) }
-func (s *StackFloat) Pop() float64   { n := (*s)[len(*s)-1]; *s = (*s)[:len(*s)-1]; return n }
-func (s *StackFloat) Len() int       { return len(*s) }
+func (s *StackOp) Push(t *TokenNotation) { *s = append(*s, t) }
+func (s *StackOp) Pop() *TokenNotation   { n := (*s)[len(*s)-1]; *s = (*s)[:len(*s)-1]; return n }
+func (s *StackOp) Top() *TokenNotation   { return (*s)[len(*s)-1] }
+func (s *StackOp) Len() int              { return len(*s) }
 
-func (rpn Notations) Calc(get func(key string) (float64, error)) (float64, error) {
-	var s StackFloat
-	for i := 0; i < rpn.Len(); i++ {
-		tn := rpn[i]
-		switch tn.tokenType {
-		case tokenVar:
-			if v, err := get(tn.tokenVariable); err != nil {
-				return 0, fmt.Errorf("unable to get variable %s", tn.tokenVariable)
-			} else {
-				klog.V(6).Infof("get %s %f", tn.tokenVariable, v)
-				s.Push(v)
-			}
-		case tokenConst:
-			s.Push(tn.tokenConst)
-		case tokenOperator:
-			op2 := s.Pop()
-			op1 := s.Pop()
-			switch tn.tokenOperator {
-			case token.ADD:
-				s.Push(op1 + op2)
-			case token.SUB:
-				s.Push(op1 - op2)
-			case token.MUL:
-				s.Push(op1 * op2)
-			case token.QUO:
-				s.Push(op1 / op2)
-			}
+// Vars returns the variables used by the notations, without duplicates
+func (rpn Notations) Vars() []string {
+	var vars []string
+	seen := map[string]bool{}
+	for _, tn := range rpn {
+		if tn.tokenType == tokenVar && !seen[tn.tokenVariable] {
+			seen[tn.tokenVariable] = true
+			vars = append(vars, tn.tokenVariable)
 		}
 	}
-	if s.Len() == 1 {
-		return s[0], nil
+	return vars
+}
+
+// HasRate returns true if the notations call rate(), which is evaluated by
+// CalcWithState only
+func (rpn Notations) HasRate() bool {
+	for _, tn := range rpn {
+		if tn.tokenType == tokenFunc && tn.tokenFunc == "rate" {
+			return true
+		}
 	}
+	return false
+}
+
+// SyntaxError is returned by NewNotations if the expression is invalid
+type SyntaxError struct {
+	Offset int // the byte offset in the expression
+	Msg    string
+}
 
-	return 0, fmt.Errorf("invalid calc, stack len %d expect 1", s.Len())
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d: %s", e.Offset, e.Msg)
 }
 
 // return reverse polish notation stack
+//
+// The operators by priority from low to high:
+//
+//	||
+//	&&
+//	== != < <= > >=
+//	+ -
+//	* / %
+//	- + ! (unary)
+//	^ (power, right associative)
+//
+// The comparisons and the boolean operators return 1 or 0, any non-zero
+// value is true. The functions are abs, min, max, log, if and rate, see
+// funcs. The variables may contain dots, e.g. disk.used.
 func NewNotations(src []byte) (output Notations, err error) {
-	var scan scanner.Scanner
-	var s StackOp
+	toks, err := scanTokens(src)
+	if err != nil {
+		return nil, err
+	}
 
-	fset := token.NewFileSet()
-	file := fset.AddFile("", fset.Base(), len(src))
+	var s StackOp
+	// an operand is expected at the beginning, after an operator, a left
+	// parenthesis or a comma
+	expectOperand := true
 
-	scan.Init(file, src, errorHandler, scanner.ScanComments)
-	var (
-		pos token.Pos
-		tok token.Token
-		lit string
-	)
-	for {
-		pos, tok, lit = scan.Scan()
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		unexpected := &SyntaxError{Offset: t.offset, Msg: fmt.Sprintf("unexpected %s", t.text())}
 
-		switch tok {
-		case token.EOF, token.SEMICOLON:
-			goto out
+		switch t.tok {
 		case token.INT, token.FLOAT:
-			c, err := strconv.ParseFloat(lit, 64)
+			if !expectOperand {
+				return nil, unexpected
+			}
+			c, err := strconv.ParseFloat(t.lit, 64)
 			if err != nil {
-				return nil, fmt.Errorf("parseFloat error %s\t%s\t%q",
-					fset.Position(pos), tok, lit)
+				return nil, &SyntaxError{Offset: t.offset, Msg: fmt.Sprintf("invalid number %q", t.lit)}
 			}
 			output.Push(&TokenNotation{tokenType: tokenConst, tokenConst: c})
+			expectOperand = false
 		case token.IDENT:
-			output.Push(&TokenNotation{tokenType: tokenVar, tokenVariable: lit})
+			if !expectOperand {
+				return nil, unexpected
+			}
+			if i+1 < len(toks) && toks[i+1].tok == token.LPAREN {
+				if _, ok := funcs[t.lit]; !ok {
+					return nil, &SyntaxError{Offset: t.offset, Msg: fmt.Sprintf("unknown function %s", t.lit)}
+				}
+				s.Push(&TokenNotation{tokenType: tokenFunc, tokenFunc: t.lit, tokenArgc: 1})
+				s.Push(&TokenNotation{tokenType: tokenParen, tokenOperator: token.LPAREN})
+				i++
+				continue
+			}
+			output.Push(&TokenNotation{tokenType: tokenVar, tokenVariable: t.lit})
+			expectOperand = false
 		case token.LPAREN: // (
-			s.Push(tok)
-		case token.ADD, token.SUB, token.MUL, token.QUO: // + - * /
-		opRetry:
-			if s.Len() == 0 {
-				s.Push(tok)
-			} else if op := s.Top(); op == token.LPAREN || priority(tok) > priority(op) {
-				s.Push(tok)
-			} else {
-				output.Push(&TokenNotation{tokenType: tokenOperator, tokenOperator: s.Pop()})
-				goto opRetry
+			if !expectOperand {
+				return nil, unexpected
+			}
+			s.Push(&TokenNotation{tokenType: tokenParen, tokenOperator: token.LPAREN})
+		case token.COMMA:
+			if expectOperand {
+				return nil, unexpected
+			}
+			if !popUntilParen(&s, &output) || s.Len() < 2 || s[s.Len()-2].tokenType != tokenFunc {
+				return nil, unexpected
 			}
+			s[s.Len()-2].tokenArgc++
+			expectOperand = true
 		case token.RPAREN: // )
+			if expectOperand || !popUntilParen(&s, &output) {
+				return nil, unexpected
+			}
+			s.Pop()
+			if s.Len() > 0 && s.Top().tokenType == tokenFunc {
+				fn := s.Pop()
+				if err := funcs[fn.tokenFunc].checkArgc(fn.tokenArgc); err != nil {
+					return nil, &SyntaxError{Offset: t.offset, Msg: fmt.Sprintf("%s: %s", fn.tokenFunc, err)}
+				}
+				output.Push(fn)
+			}
+		case token.ADD, token.SUB, token.MUL, token.QUO, token.REM, token.XOR,
+			token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ,
+			token.LAND, token.LOR, token.NOT:
+			if expectOperand {
+				// the prefix operators are applied to the next operand
+				if t.tok != token.ADD && t.tok != token.SUB && t.tok != token.NOT {
+					return nil, unexpected
+				}
+				s.Push(&TokenNotation{tokenType: tokenUnary, tokenOperator: t.tok})
+				continue
+			}
+			if t.tok == token.NOT {
+				return nil, unexpected
+			}
+
+			tn := &TokenNotation{tokenType: tokenOperator, tokenOperator: t.tok}
 			for s.Len() > 0 {
-				if op := s.Pop(); op == token.LPAREN {
+				op := s.Top()
+				if op.tokenType != tokenOperator && op.tokenType != tokenUnary {
 					break
-				} else {
-					output.Push(&TokenNotation{tokenType: tokenOperator, tokenOperator: op})
 				}
+				if priority(op) < priority(tn) || (priority(op) == priority(tn) && rightAssoc(tn)) {
+					break
+				}
+				output.Push(s.Pop())
 			}
+			s.Push(tn)
+			expectOperand = true
 		default:
-			return nil, fmt.Errorf("unsupport token %s", tok)
+			return nil, unexpected
+		}
+	}
+
+	if expectOperand {
+		if len(toks) == 0 {
+			return nil, &SyntaxError{Msg: "empty expression"}
 		}
+		return nil, &SyntaxError{Offset: len(src), Msg: "unexpected end of expression"}
 	}
 
-out:
-	for i, l := 0, s.Len(); i < l; i++ {
-		output.Push(&TokenNotation{tokenType: tokenOperator, tokenOperator: s.Pop()})
+	for s.Len() > 0 {
+		op := s.Pop()
+		if op.tokenType == tokenParen || op.tokenType == tokenFunc {
+			return nil, &SyntaxError{Offset: len(src), Msg: "missing )"}
+		}
+		output.Push(op)
 	}
 	return
 }
 
-func errorHandler(pos token.Position, msg string) {
-	klog.Errorf("error %s\t%s\n", pos, msg)
+// popUntilParen moves the operators to the output until the left
+// parenthesis, which is kept on the stack, it returns false if there is no
+// left parenthesis
+func popUntilParen(s *StackOp, output *Notations) bool {
+	for s.Len() > 0 {
+		if s.Top().tokenType == tokenParen {
+			return true
+		}
+		output.Push(s.Pop())
+	}
+	return false
 }
 
-func priority(tok token.Token) int {
-	switch tok {
-	case token.ADD, token.SUB:
+func priority(tn *TokenNotation) int {
+	if tn.tokenType == tokenUnary {
+		return 6
+	}
+
+	switch tn.tokenOperator {
+	case token.LOR:
 		return 1
-	case token.MUL, token.QUO:
+	case token.LAND:
 		return 2
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		return 3
+	case token.ADD, token.SUB:
+		return 4
+	case token.MUL, token.QUO, token.REM:
+		return 5
+	case token.XOR:
+		return 7
 	default:
 		return 0
 	}
 }
+
+func rightAssoc(tn *TokenNotation) bool {
+	return tn.tokenOperator == token.XOR
+}
+
+type lexToken struct {
+	offset int
+	tok    token.Token
+	lit    string
+}
+
+func (t lexToken) text() string {
+	if t.lit != "" {
+		return t.lit
+	}
+	return t.tok.String()
+}
+
+var namePiece = regexp.MustCompile(`^[.\w]+$`)
+
+// scanTokens returns the tokens until the end or a semicolon, the adjacent
+// tokens of a name with dots are joined into an identifier, e.g. disk.used
+// and cpu.0 are scanned as disk . used and cpu .0
+func scanTokens(src []byte) ([]lexToken, error) {
+	var scan scanner.Scanner
+	var scanErr error
+
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	scan.Init(file, src, func(pos token.Position, msg string) {
+		if scanErr == nil {
+			scanErr = &SyntaxError{Offset: pos.Offset, Msg: msg}
+		}
+	}, 0)
+
+	var toks []lexToken
+	for {
+		pos, tok, lit := scan.Scan()
+		if scanErr != nil {
+			return nil, scanErr
+		}
+		if tok == token.EOF || tok == token.SEMICOLON {
+			break
+		}
+		// the keywords are names here, e.g. if(a, b, c)
+		if tok.IsKeyword() {
+			tok = token.IDENT
+		}
+
+		t := lexToken{offset: file.Offset(pos), tok: tok, lit: lit}
+		if n := len(toks); n > 0 && joinName(toks[n-1], t) {
+			toks[n-1].lit += t.text()
+			continue
+		}
+		toks = append(toks, t)
+	}
+
+	for _, t := range toks {
+		if t.tok == token.IDENT && strings.HasSuffix(t.lit, ".") {
+			return nil, &SyntaxError{Offset: t.offset, Msg: fmt.Sprintf("invalid name %s", t.lit)}
+		}
+	}
+	return toks, nil
+}
+
+// joinName returns true if the token continues the name of the identifier
+func joinName(ident, t lexToken) bool {
+	if ident.tok != token.IDENT || ident.offset+len(ident.lit) != t.offset {
+		return false
+	}
+
+	text := t.text()
+	if !namePiece.MatchString(text) {
+		return false
+	}
+	return strings.HasSuffix(ident.lit, ".") || strings.HasPrefix(text, ".")
+}
@@ -1,6 +1,220 @@
 package expr
 
-import "testing"
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getter(vars map[string]float64) func(string) (float64, error) {
+	return func(key string) (float64, error) {
+		if v, ok := vars[key]; ok {
+			return v, nil
+		}
+		return 0, fmt.Errorf("not found")
+	}
+}
 
 func TestExpr(t *testing.T) {
+	vars := map[string]float64{
+		"a":            2,
+		"b":            3,
+		"zero":         0,
+		"disk.used":    30,
+		"disk.total":   100,
+		"cpu.0.idle":   90,
+		"system.if.up": 1,
+	}
+
+	cases := []struct {
+		expr string
+		want float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"12 / 3 / 2", 2},
+		{"-a", -2},
+		{"-a * b", -6},
+		{"+a - -b", 5},
+		{"2 * -b", -6},
+		{"7 % 4", 3},
+		{"-7 % 4", -3},
+		{"2 ^ 3", 8},
+		{"2 ^ 3 ^ 2", 512},
+		{"-2 ^ 2", -4},
+		{"2 ^ -1", 0.5},
+		{"a * b ^ 2", 18},
+		{"a < b", 1},
+		{"a > b", 0},
+		{"a <= 2 && b >= 3", 1},
+		{"a == 2", 1},
+		{"a != 2", 0},
+		{"1 + 1 == a", 1},
+		{"zero || a", 1},
+		{"zero && a", 0},
+		{"a && zero || b", 1},
+		{"!zero", 1},
+		{"!a", 0},
+		{"!(a > b)", 1},
+		{"if(a > b, a, b)", 3},
+		{"if(a, 1, 2) + 1", 2},
+		{"abs(a - b)", 1},
+		{"min(a, b, 1)", 1},
+		{"max(a)", 2},
+		{"max(min(a, b), abs(-5))", 5},
+		{"log(1)", 0},
+		{"disk.used / disk.total * 100", 30},
+		{"100 - cpu.0.idle", 10},
+		{"system.if.up", 1},
+		{"a; b", 2},
+	}
+
+	for _, c := range cases {
+		notations, err := NewNotations([]byte(c.expr))
+		require.NoError(t, err, c.expr)
+
+		got, err := notations.Calc(getter(vars))
+		require.NoError(t, err, c.expr)
+		assert.Equal(t, c.want, got, "%s => %s", c.expr, notations)
+	}
+}
+
+func TestVars(t *testing.T) {
+	notations, err := NewNotations([]byte("if(disk.used > 0, disk.used / disk.total, rate(x))"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"disk.used", "disk.total", "x"}, notations.Vars())
+	assert.True(t, notations.HasRate())
+
+	notations, err = NewNotations([]byte("a + 1"))
+	require.NoError(t, err)
+	assert.False(t, notations.HasRate())
+}
+
+func TestSyntaxError(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"a +",
+		"* a",
+		"a b",
+		"(a",
+		"a)",
+		"()",
+		"a . b",
+		"disk.",
+		"foo(a)",
+		"abs()",
+		"abs(a, b)",
+		"if(a, b)",
+		"min(a,)",
+		"a, b",
+		"a = 1",
+		"a !b",
+		"'a'",
+		"1e",
+	} {
+		_, err := NewNotations([]byte(src))
+		var serr *SyntaxError
+		assert.True(t, errors.As(err, &serr), "%q: %v", src, err)
+	}
+}
+
+func TestCalcError(t *testing.T) {
+	vars := map[string]float64{"a": 2, "zero": 0}
+
+	cases := []struct {
+		expr string
+		err  error
+	}{
+		{"a / zero", ErrDivisionByZero},
+		{"a % zero", ErrDivisionByZero},
+		{"1 + a / (a - 2)", ErrDivisionByZero},
+		{"log(zero)", ErrDomain},
+		{"log(-a)", ErrDomain},
+		{"(-8) ^ 0.5", ErrDomain},
+		{"rate(a)", ErrNoRate},
+		{"if(a / zero, 1, 2)", ErrDivisionByZero},
+		{"max(a, 1 / zero)", ErrDivisionByZero},
+	}
+
+	for _, c := range cases {
+		notations, err := NewNotations([]byte(c.expr))
+		require.NoError(t, err, c.expr)
+
+		_, err = notations.Calc(getter(vars))
+		assert.True(t, errors.Is(err, c.err), "%s: %v", c.expr, err)
+	}
+
+	// the error of the operand that is not used is ignored
+	for _, src := range []string{
+		"if(a > 1, a, 1 / zero)",
+		"if(zero, missing, a)",
+		"zero && missing",
+		"a || 1 / zero",
+	} {
+		notations, err := NewNotations([]byte(src))
+		require.NoError(t, err, src)
+
+		_, err = notations.Calc(getter(vars))
+		assert.NoError(t, err, src)
+	}
+
+	notations, err := NewNotations([]byte("a + missing.value"))
+	require.NoError(t, err)
+	_, err = notations.Calc(getter(vars))
+	var verr *VarError
+	require.True(t, errors.As(err, &verr), "%v", err)
+	assert.Equal(t, "missing.value", verr.Name)
+
+	// the notations of NewNotations always have enough operands
+	var serr *StackError
+	_, err = Notations{{tokenType: tokenOperator, tokenOperator: '+'}}.Calc(getter(vars))
+	assert.True(t, errors.As(err, &serr), "%v", err)
+	_, err = Notations{}.Calc(getter(vars))
+	assert.True(t, errors.As(err, &serr), "%v", err)
+}
+
+func TestRate(t *testing.T) {
+	notations, err := NewNotations([]byte("rate(bytes) * 8 + rate(packets) * 0"))
+	require.NoError(t, err)
+
+	vars := map[string]float64{"bytes": 100, "packets": 1}
+	state := NewRateState()
+	now := time.Unix(1000, 0)
+
+	_, err = notations.CalcWithState(getter(vars), state, now)
+	assert.True(t, errors.Is(err, ErrNoRate), "%v", err)
+
+	vars["bytes"], vars["packets"] = 200, 2
+	got, err := notations.CalcWithState(getter(vars), state, now.Add(10*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, float64(80), got)
+
+	// the counter is reset
+	vars["bytes"] = 50
+	_, err = notations.CalcWithState(getter(vars), state, now.Add(20*time.Second))
+	assert.True(t, errors.Is(err, ErrNoRate), "%v", err)
+
+	vars["bytes"] = 150
+	got, err = notations.CalcWithState(getter(vars), state, now.Add(30*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, float64(80), got)
+
+	// no state
+	_, err = notations.Calc(getter(vars))
+	assert.True(t, errors.Is(err, ErrNoRate), "%v", err)
+}
+
+func TestNotationsString(t *testing.T) {
+	notations, err := NewNotations([]byte("-a.b * max(1.5, c) ^ 2"))
+	require.NoError(t, err)
+	assert.Equal(t, "a.b u- 1.5 c max/2 2 ^ * ", notations.String())
+
+	got, err := notations.Calc(getter(map[string]float64{"a.b": 1, "c": 2}))
+	require.NoError(t, err)
+	assert.Equal(t, float64(-4), got)
 }
@@ -0,0 +1,179 @@
+// Package falcon handles the MetricValue json of n9e and open-falcon, as
+// written by the script checks and pushed to the agent api.
+package falcon
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+)
+
+// maxFutureTimestamp limits how far in the future a timestamp can be, a
+// larger one is most likely in milliseconds
+const maxFutureTimestamp = 5 * time.Minute
+
+// from github.com/didi/nightingale/src/common/dataobj
+type MetricValue struct {
+	Endpoint    string      `json:"endpoint"`    // hostname, default to the agent's
+	Metric      string      `json:"metric"`      //
+	Value       interface{} `json:"value"`       // number or numeric string
+	Type        string      `json:"type"`        // GAUGE | COUNTER | SUBTRACT | DERIVE, default GAUGE
+	CounterType string      `json:"counterType"` // open-falcon name of type
+	Tags        Tags        `json:"tags"`        //
+	Timestamp   int64       `json:"timestamp"`   // unix seconds, default to now
+	Step        int64       `json:"step"`        // push interval in seconds, informational
+
+	value float64
+}
+
+// Tags is {"k": "v"}, or "k=v,k2=v2" as sent by the open-falcon clients
+type Tags map[string]string
+
+func (p *Tags) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		m := map[string]string{}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("tags must be an object or a k=v,k2=v2 string")
+		}
+		*p = m
+		return nil
+	}
+
+	m := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			return fmt.Errorf("invalid tag %q, must be k=v", kv)
+		}
+		m[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+	}
+	*p = m
+
+	return nil
+}
+
+// Unmarshal accepts a list of MetricValue or a single one
+func Unmarshal(data []byte) ([]*MetricValue, error) {
+	var series []*MetricValue
+	if err := json.Unmarshal(data, &series); err == nil {
+		return series, nil
+	}
+
+	serie := &MetricValue{}
+	if err := json.Unmarshal(data, serie); err != nil {
+		return nil, err
+	}
+	return []*MetricValue{serie}, nil
+}
+
+// Validate checks the MetricValue and parses its value
+func (p *MetricValue) Validate() error {
+	if p.Metric == "" {
+		return fmt.Errorf("metric is empty")
+	}
+
+	value, err := Float(p.Value)
+	if err != nil {
+		return err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("value %v is not a finite number", value)
+	}
+	p.value = value
+
+	if p.Timestamp < 0 {
+		return fmt.Errorf("timestamp %d is negative", p.Timestamp)
+	}
+	if time.Unix(p.Timestamp, 0).After(time.Now().Add(maxFutureTimestamp)) {
+		return fmt.Errorf("timestamp %d is in the future, it must be in seconds", p.Timestamp)
+	}
+
+	if p.Step < 0 {
+		return fmt.Errorf("step %d is negative", p.Step)
+	}
+
+	return nil
+}
+
+// Send submits a validated MetricValue, COUNTER and RATE as a rate (same as
+// rrdtool / open-falcon), SUBTRACT, INCREASE and MONOTONIC_COUNT as a
+// monotonic count, the others as a gauge. The samples with a timestamp are
+// sent with it, if the sender supports it, so that the rates and the
+// monotonic counts are computed on the timestamps of the samples and the
+// gauges are flushed at them.
+func Send(sender aggregator.Sender, m *MetricValue) {
+	tags := m.tags()
+	mtype := m.metricType()
+
+	if ts, ok := sender.(aggregator.TimestampSender); ok && m.Timestamp > 0 {
+		ts.MetricSampleWithTimestamp(m.Metric, m.value, m.Endpoint, tags, mtype, float64(m.Timestamp))
+		return
+	}
+
+	switch mtype {
+	case metrics.RateType:
+		sender.Rate(m.Metric, m.value, m.Endpoint, tags)
+	case metrics.MonotonicCountType:
+		sender.MonotonicCount(m.Metric, m.value, m.Endpoint, tags)
+	default:
+		sender.Gauge(m.Metric, m.value, m.Endpoint, tags)
+	}
+}
+
+func (p *MetricValue) metricType() metrics.MetricType {
+	t := p.Type
+	if t == "" {
+		t = p.CounterType
+	}
+
+	switch strings.ToLower(t) {
+	case "rate", "counter":
+		return metrics.RateType
+	case "monotonic_count", "subtract", "increase":
+		return metrics.MonotonicCountType
+	default:
+		return metrics.GaugeType
+	}
+}
+
+func (p *MetricValue) tags() []string {
+	if len(p.Tags) == 0 {
+		return nil
+	}
+
+	tags := make([]string, 0, len(p.Tags))
+	for k, v := range p.Tags {
+		tags = append(tags, k+":"+v)
+	}
+	sort.Strings(tags)
+
+	return tags
+}
+
+func Float(a interface{}) (float64, error) {
+	switch v := a.(type) {
+	case float64:
+		return v, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("value %q is not a number", v)
+		}
+		return f, nil
+	case nil:
+		return 0, fmt.Errorf("value is empty")
+	default:
+		return 0, fmt.Errorf("unsupported value type %T", a)
+	}
+}
@@ -0,0 +1,153 @@
+package falcon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/collector/check"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/n9e/n9e-agentd/pkg/config"
+	"github.com/n9e/n9e-agentd/pkg/util/capturesender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type timestampSender struct {
+	*capturesender.CaptureSender
+	samples []*metrics.MetricSample
+}
+
+func (p *timestampSender) MetricSampleWithTimestamp(metric string, value float64, hostname string, tags []string, mType metrics.MetricType, timestamp float64) {
+	p.samples = append(p.samples, &metrics.MetricSample{Name: metric, Value: value, Host: hostname, Tags: tags, Mtype: mType, Timestamp: timestamp})
+}
+
+func TestUnmarshal(t *testing.T) {
+	series, err := Unmarshal([]byte(`[
+{"metric": "a", "value": 1, "type": "GAUGE", "tags": {"k": "v"}},
+{"endpoint": "host-1", "metric": "b", "value": "2", "counterType": "COUNTER", "tags": "k=v, k2=v2", "timestamp": 1600000000, "step": 60}
+]`))
+	require.NoError(t, err)
+	require.Len(t, series, 2)
+	assert.Equal(t, Tags{"k": "v"}, series[0].Tags)
+	assert.Equal(t, Tags{"k": "v", "k2": "v2"}, series[1].Tags)
+	assert.Equal(t, int64(60), series[1].Step)
+
+	series, err = Unmarshal([]byte(`{"metric": "a", "value": 1}`))
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+
+	_, err = Unmarshal([]byte(`{"metric": "a", "value": 1, "tags": "k"}`))
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		serie MetricValue
+		err   bool
+	}{
+		{"number", MetricValue{Metric: "a", Value: 1.5}, false},
+		{"numeric string", MetricValue{Metric: "a", Value: " 1.5"}, false},
+		{"empty metric", MetricValue{Value: 1.0}, true},
+		{"empty value", MetricValue{Metric: "a"}, true},
+		{"invalid value", MetricValue{Metric: "a", Value: "x"}, true},
+		{"bool value", MetricValue{Metric: "a", Value: true}, true},
+		{"nan", MetricValue{Metric: "a", Value: "NaN"}, true},
+		{"timestamp in ms", MetricValue{Metric: "a", Value: 1.0, Timestamp: time.Now().UnixNano() / 1e6}, true},
+		{"negative step", MetricValue{Metric: "a", Value: 1.0, Step: -1}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.serie.Validate()
+			if c.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	series, err := Unmarshal([]byte(`[
+{"metric": "gauge", "value": 1, "type": "GAUGE", "tags": {"b": "2", "a": "1"}},
+{"metric": "counter", "value": 2, "counterType": "COUNTER"},
+{"metric": "subtract", "value": 3, "type": "SUBTRACT"},
+{"metric": "unknown", "value": 4, "type": "GUAGE", "endpoint": "host-1"}
+]`))
+	require.NoError(t, err)
+
+	sender := capturesender.New()
+	for _, serie := range series {
+		require.NoError(t, serie.Validate())
+		Send(sender, serie)
+	}
+
+	assert.Equal(t, []capturesender.Metric{
+		{Type: "gauge", Name: "gauge", Value: 1, Tags: []string{"a:1", "b:2"}},
+		{Type: "rate", Name: "counter", Value: 2, Tags: []string{}},
+		{Type: "monotonic_count", Name: "subtract", Value: 3, Tags: []string{}},
+		{Type: "gauge", Name: "unknown", Value: 4, Hostname: "host-1", Tags: []string{}},
+	}, sender.Metrics)
+}
+
+func TestSendTimestamp(t *testing.T) {
+	series, err := Unmarshal([]byte(`[
+{"endpoint": "host-1", "metric": "counter", "value": 10, "counterType": "COUNTER", "timestamp": 1600000000},
+{"metric": "gauge", "value": 1, "timestamp": 1600000000}
+]`))
+	require.NoError(t, err)
+
+	sender := &timestampSender{CaptureSender: capturesender.New()}
+	for _, serie := range series {
+		require.NoError(t, serie.Validate())
+		Send(sender, serie)
+	}
+
+	assert.Empty(t, sender.Metrics)
+	require.Len(t, sender.samples, 2)
+	assert.Equal(t, metrics.RateType, sender.samples[0].Mtype)
+	assert.Equal(t, float64(1600000000), sender.samples[0].Timestamp)
+	assert.Equal(t, "host-1", sender.samples[0].Host)
+	assert.Equal(t, metrics.GaugeType, sender.samples[1].Mtype)
+	assert.Equal(t, float64(1600000000), sender.samples[1].Timestamp)
+}
+
+func TestSendTimestampCheckSender(t *testing.T) {
+	config.Mock()
+	agg := aggregator.InitAggregatorWithFlushInterval(nil, nil, "agent-host", time.Hour)
+
+	id := check.ID("falcon_test:1")
+	sender, err := aggregator.GetSender(id)
+	require.NoError(t, err)
+	defer aggregator.DestroySender(id)
+	sender.SetCheckCustomTags([]string{"env:test"})
+
+	// the rate of the timestamps, not of the commits
+	ts := time.Now().Unix() - 60
+	for i, value := range []float64{100, 200} {
+		serie := &MetricValue{Metric: "falcon.counter", Value: value, Type: "COUNTER", Timestamp: ts + int64(i)*10, Tags: Tags{"k": "v"}}
+		require.NoError(t, serie.Validate())
+		Send(sender, serie)
+		sender.Commit()
+	}
+
+	var got []*metrics.Serie
+	require.Eventually(t, func() bool {
+		series, _ := agg.GetSeriesAndSketches(time.Now())
+		for _, serie := range series {
+			if serie.Name == "falcon.counter" {
+				got = append(got, serie)
+			}
+		}
+		return len(got) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	require.Len(t, got, 1)
+	assert.ElementsMatch(t, []string{"k:v", "env:test"}, got[0].Tags)
+	assert.Equal(t, "agent-host", got[0].Host)
+	require.Len(t, got[0].Points, 1)
+	assert.Equal(t, float64(10), got[0].Points[0].Value)
+}
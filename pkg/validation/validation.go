@@ -8,7 +8,7 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/collector/corechecks"
 )
 
-func dataValidateJSONConfig(checkName string, data []byte) error {
+func ValidateJSONConfig(checkName string, data []byte) error {
 	config, err := providers.ParseJSONConfig(data)
 	if err != nil {
 		return err
@@ -23,6 +23,10 @@ func ValidateConfig(config *integration.Config) error {
 		return fmt.Errorf("unable to get %s check", config.Name)
 	}
 
+	if len(config.Instances) == 0 {
+		return fmt.Errorf("%s check has no instances", config.Name)
+	}
+
 	for _, instance := range config.Instances {
 		if err := factory().Configure(instance, config.InitConfig, config.Source); err != nil {
 			return err
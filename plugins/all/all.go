@@ -3,11 +3,14 @@ package all
 import (
 	// plugin checks
 	_ "github.com/n9e/n9e-agentd/plugins/demo"
+	_ "github.com/n9e/n9e-agentd/plugins/http_response"
 	_ "github.com/n9e/n9e-agentd/plugins/log"
 	_ "github.com/n9e/n9e-agentd/plugins/port"
 	_ "github.com/n9e/n9e-agentd/plugins/proc"
 	_ "github.com/n9e/n9e-agentd/plugins/prometheus"
 	_ "github.com/n9e/n9e-agentd/plugins/script"
+	_ "github.com/n9e/n9e-agentd/plugins/sql"
+	_ "github.com/n9e/n9e-agentd/plugins/x509_cert"
 
 	// register core checks
 	_ "github.com/DataDog/datadog-agent/pkg/collector/corechecks/cluster/ksm"
@@ -0,0 +1,43 @@
+package http_response
+
+import (
+	"github.com/n9e/n9e-agentd/pkg/i18n"
+	"github.com/n9e/n9e-agentd/pkg/registry/metrics"
+)
+
+var langStrings = map[string]map[string]string{
+	"zh": map[string]string{
+		"http_response.status_code":        "HTTP 响应状态码",
+		"http_response.response_time":      "HTTP 请求总耗时(秒)",
+		"http_response.content_length":     "HTTP 响应体长度(字节)",
+		"http_response.dns_time":           "DNS 解析耗时(秒)",
+		"http_response.connect_time":       "TCP 连接耗时(秒)",
+		"http_response.tls_handshake_time": "TLS 握手耗时(秒)",
+		"http_response.response_match":     "HTTP 响应体是否匹配",
+	},
+	"en": map[string]string{
+		"http_response.status_code":        "HTTP response status code",
+		"http_response.response_time":      "HTTP request total latency(seconds)",
+		"http_response.content_length":     "HTTP response body length(bytes)",
+		"http_response.dns_time":           "DNS lookup latency(seconds)",
+		"http_response.connect_time":       "TCP connect latency(seconds)",
+		"http_response.tls_handshake_time": "TLS handshake latency(seconds)",
+		"http_response.response_match":     "HTTP response body matched",
+	},
+}
+
+func registerMetric() {
+	m := metrics.GetMetricGroup("http_response")
+	m.Register("http_response.status_code")
+	m.Register("http_response.response_time")
+	m.Register("http_response.content_length")
+	m.Register("http_response.dns_time")
+	m.Register("http_response.connect_time")
+	m.Register("http_response.tls_handshake_time")
+	m.Register("http_response.response_match")
+}
+
+func init() {
+	registerMetric()
+	i18n.SetLangStrings(langStrings)
+}
@@ -0,0 +1,222 @@
+package http_response
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
+	"github.com/DataDog/datadog-agent/pkg/collector/check"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	"github.com/n9e/n9e-agentd/pkg/util"
+	"github.com/n9e/n9e-agentd/pkg/util/tls"
+	"sigs.k8s.io/yaml"
+)
+
+const checkName = "http_response"
+
+type InitConfig struct {
+	Timeout int `json:"timeout"`
+}
+
+type InstanceConfig struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method" description:"default to GET"`
+	Headers     map[string]string `json:"headers"`
+	Body        string            `json:"body"`
+	Username    string            `json:"username" description:"basic auth"`
+	Password    string            `json:"password" description:"basic auth"`
+	BearerToken string            `json:"bearer_token"`
+	TLSConfig   tls.ClientConfig  `json:"tls_config"`
+	// FollowRedirects is true by default, the last response is checked
+	FollowRedirects bool   `json:"follow_redirects"`
+	Proxy           string `json:"proxy" description:"the proxy url, default to the environment variables"`
+	// ResponseMatch is the regexp the response body must match
+	ResponseMatch string `json:"response_match"`
+	// ExpectedStatusCodes are the status codes which are successful, any
+	// code lower than 400 if empty
+	ExpectedStatusCodes []int `json:"expected_status_codes"`
+	timeout             time.Duration
+	matchReg            *regexp.Regexp
+	InitConfig          `json:"-"`
+}
+
+type checkConfig struct {
+	InstanceConfig
+	InitConfig
+}
+
+func (p checkConfig) String() string {
+	return util.Prettify(p)
+}
+
+func defaultInstanceConfig() InstanceConfig {
+	return InstanceConfig{
+		Method:          http.MethodGet,
+		FollowRedirects: true,
+	}
+}
+
+func buildConfig(rawInstance integration.Data, rawInitConfig integration.Data) (checkConfig, error) {
+	instance := defaultInstanceConfig()
+	initConfig := InitConfig{}
+
+	err := yaml.Unmarshal(rawInitConfig, &initConfig)
+	if err != nil {
+		return checkConfig{}, err
+	}
+
+	err = yaml.Unmarshal(rawInstance, &instance)
+	if err != nil {
+		return checkConfig{}, err
+	}
+
+	if err := instance.validate(); err != nil {
+		return checkConfig{}, err
+	}
+
+	if initConfig.Timeout <= 0 {
+		instance.timeout = time.Second * 10
+	} else {
+		instance.timeout = time.Second * time.Duration(initConfig.Timeout)
+	}
+
+	return checkConfig{
+		InitConfig:     initConfig,
+		InstanceConfig: instance,
+	}, nil
+}
+
+func (p *InstanceConfig) validate() error {
+	if p.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return fmt.Errorf("parse url %s: %s", p.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q of url %s", u.Scheme, p.URL)
+	}
+
+	p.Method = strings.ToUpper(p.Method)
+
+	if p.BearerToken != "" && p.Username != "" {
+		return fmt.Errorf("basic auth and bearer token are mutually exclusive")
+	}
+
+	if p.Proxy != "" {
+		if _, err := url.Parse(p.Proxy); err != nil {
+			return fmt.Errorf("parse proxy %s: %s", p.Proxy, err)
+		}
+	}
+
+	if p.ResponseMatch != "" {
+		reg, err := regexp.Compile(p.ResponseMatch)
+		if err != nil {
+			return fmt.Errorf("compile response_match %s: %s", p.ResponseMatch, err)
+		}
+		p.matchReg = reg
+	}
+
+	for _, code := range p.ExpectedStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid expected status code %d", code)
+		}
+	}
+
+	return nil
+}
+
+// Check doesn't need additional fields
+type Check struct {
+	core.CheckBase
+	config checkConfig
+	client *http.Client
+	tags   []string
+}
+
+// Run executes the check
+func (c *Check) Run() error {
+	sender, err := aggregator.GetSender(c.ID())
+	if err != nil {
+		return err
+	}
+
+	c.probe().send(sender, c.tags)
+
+	sender.Commit()
+	return nil
+}
+
+func (c *Check) createHTTPClient() (*http.Client, error) {
+	cf := c.config
+
+	tlsCfg, err := cf.TLSConfig.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cf.Proxy != "" {
+		u, _ := url.Parse(cf.Proxy)
+		proxy = http.ProxyURL(u)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:             proxy,
+			TLSClientConfig:   tlsCfg,
+			DisableKeepAlives: true,
+		},
+		Timeout: cf.timeout,
+	}
+	if !cf.FollowRedirects {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	return client, nil
+}
+
+// Configure the http_response check
+func (c *Check) Configure(rawInstance integration.Data, rawInitConfig integration.Data, source string) error {
+	// Must be called before c.CommonConfigure
+	c.BuildID(rawInstance, rawInitConfig)
+
+	err := c.CommonConfigure(rawInstance, source)
+	if err != nil {
+		return fmt.Errorf("common configure failed: %s", err)
+	}
+
+	config, err := buildConfig(rawInstance, rawInitConfig)
+	if err != nil {
+		return fmt.Errorf("build config failed: %s", err)
+	}
+
+	c.config = config
+	if c.client, err = c.createHTTPClient(); err != nil {
+		return err
+	}
+
+	c.tags = []string{
+		"url:" + config.URL,
+		"method:" + config.Method,
+	}
+	return nil
+}
+
+func checkFactory() check.Check {
+	return &Check{
+		CheckBase: core.NewCheckBase(checkName),
+	}
+}
+
+func init() {
+	core.RegisterCheck(checkName, checkFactory)
+}
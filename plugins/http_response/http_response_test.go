@@ -0,0 +1,241 @@
+package http_response
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/n9e/n9e-agentd/pkg/config"
+	"github.com/n9e/n9e-agentd/pkg/util/capturesender"
+	"github.com/n9e/n9e-agentd/pkg/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig(t *testing.T) {
+	config.Mock()
+	aggregator.InitAggregatorWithFlushInterval(nil, nil, "", time.Hour)
+
+	cases := []struct {
+		config string
+		ok     bool
+	}{
+		{"", false},
+		{"{}", false},
+		{`
+{
+  "initConfig": { "timeout": 3 },
+  "instances": [{
+    "minCollectionInterval": 10,
+    "tags": ["a:1", "b:2"],
+    "url": "http://127.0.0.1:8080/health",
+    "method": "POST",
+    "headers": {"Content-Type": "application/json"},
+    "response_match": "ok"
+  }]
+}`, true},
+	}
+
+	for _, c := range cases {
+		err := validation.ValidateJSONConfig(checkName, []byte(c.config))
+		assert.Equal(t, c.ok, err == nil, c.config)
+	}
+}
+
+func TestBuildConfig(t *testing.T) {
+	cf, err := buildConfig([]byte(`{url: "http://127.0.0.1/", method: post}`), []byte(`{timeout: 3}`))
+	require.NoError(t, err)
+	assert.Equal(t, "POST", cf.Method)
+	assert.True(t, cf.FollowRedirects)
+	assert.Equal(t, 3*time.Second, cf.timeout)
+
+	for _, config := range []string{
+		`{}`,
+		`{url: "ftp://127.0.0.1/"}`,
+		`{url: "http://127.0.0.1/", response_match: "("}`,
+		`{url: "http://127.0.0.1/", username: a, bearer_token: b}`,
+		`{url: "http://127.0.0.1/", expected_status_codes: [0]}`,
+	} {
+		_, err := buildConfig([]byte(config), nil)
+		assert.Error(t, err, config)
+	}
+}
+
+func newTestCheck(t *testing.T, config string) *Check {
+	c := &Check{}
+	cf, err := buildConfig([]byte(config), nil)
+	require.NoError(t, err)
+	c.config = cf
+	c.client, err = c.createHTTPClient()
+	require.NoError(t, err)
+	return c
+}
+
+func sendProbe(c *Check) *capturesender.CaptureSender {
+	sender := capturesender.New()
+	c.probe().send(sender, []string{"url:test"})
+	return sender
+}
+
+func metricValues(sender *capturesender.CaptureSender) map[string]float64 {
+	ret := map[string]float64{}
+	for _, m := range sender.Metrics {
+		ret[m.Name] = m.Value
+	}
+	return ret
+}
+
+func TestProbe(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/redirect":
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		case r.Method != http.MethodPost || r.Header.Get("X-Test") != "1" || r.Host != "example.com":
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			fmt.Fprintf(w, `{"status": "ok", "body": %q}`, body)
+		}
+	}))
+	defer ts.Close()
+
+	c := newTestCheck(t, fmt.Sprintf(`
+url: %s/redirect
+method: POST
+headers:
+  X-Test: "1"
+  Host: example.com
+body: ping
+response_match: '"body": "ping"'
+`, ts.URL))
+
+	sender := sendProbe(c)
+	values := metricValues(sender)
+	assert.Equal(t, float64(200), values["http_response.status_code"])
+	assert.Equal(t, float64(len(`{"status": "ok", "body": "ping"}`)), values["http_response.content_length"])
+	assert.Equal(t, float64(1), values["http_response.response_match"])
+	assert.Greater(t, values["http_response.response_time"], float64(0))
+	assert.Greater(t, values["http_response.connect_time"], float64(0))
+	// there is no dns lookup of an ip, nor tls handshake
+	assert.NotContains(t, values, "http_response.dns_time")
+	assert.NotContains(t, values, "http_response.tls_handshake_time")
+
+	require.Len(t, sender.ServiceChecks, 1)
+	assert.Equal(t, serviceCheckName, sender.ServiceChecks[0].Name)
+	assert.Equal(t, "OK", sender.ServiceChecks[0].Status)
+	assert.Equal(t, []string{"url:test"}, sender.ServiceChecks[0].Tags)
+
+	// the redirect is not followed
+	c.config.FollowRedirects = false
+	c.client, _ = c.createHTTPClient()
+	sender = sendProbe(c)
+	values = metricValues(sender)
+	assert.Equal(t, float64(307), values["http_response.status_code"])
+	assert.Equal(t, float64(0), values["http_response.response_match"])
+	assert.Equal(t, "CRITICAL", sender.ServiceChecks[0].Status)
+	assert.Contains(t, sender.ServiceChecks[0].Message, "doesn't match")
+
+	// the request without the headers is rejected
+	c = newTestCheck(t, fmt.Sprintf(`{url: %s, method: POST}`, ts.URL))
+	sender = sendProbe(c)
+	assert.Equal(t, float64(400), metricValues(sender)["http_response.status_code"])
+	assert.Equal(t, "CRITICAL", sender.ServiceChecks[0].Status)
+	assert.Equal(t, "unexpected status code 400", sender.ServiceChecks[0].Message)
+
+	c.config.ExpectedStatusCodes = []int{400}
+	sender = sendProbe(c)
+	assert.Equal(t, "OK", sender.ServiceChecks[0].Status)
+}
+
+func TestProbeAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); ok && user == "user" && pass == "pass" {
+			return
+		}
+		if r.Header.Get("Authorization") == "Bearer token" {
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	for config, want := range map[string]float64{
+		`{url: %s}`:                                 401,
+		`{url: %s, username: user, password: pass}`: 200,
+		`{url: %s, username: user, password: no}`:   401,
+		`{url: %s, bearer_token: token}`:            200,
+	} {
+		c := newTestCheck(t, fmt.Sprintf(config, ts.URL))
+		sender := sendProbe(c)
+		assert.Equal(t, want, metricValues(sender)["http_response.status_code"], config)
+	}
+}
+
+func TestProbeTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	c := newTestCheck(t, fmt.Sprintf(`
+url: https://localhost:%s/
+tls_config:
+  insecureSkipVerify: true
+`, u.Port()))
+
+	sender := sendProbe(c)
+	values := metricValues(sender)
+	assert.Equal(t, float64(200), values["http_response.status_code"])
+	assert.Equal(t, float64(2), values["http_response.content_length"])
+	assert.Contains(t, values, "http_response.dns_time")
+	assert.Greater(t, values["http_response.connect_time"], float64(0))
+	assert.Greater(t, values["http_response.tls_handshake_time"], float64(0))
+	assert.Equal(t, "OK", sender.ServiceChecks[0].Status)
+
+	// the certificate of httptest is not trusted
+	c = newTestCheck(t, fmt.Sprintf(`{url: %s}`, ts.URL))
+	sender = sendProbe(c)
+	assert.Empty(t, sender.Metrics)
+	require.Len(t, sender.ServiceChecks, 1)
+	assert.Equal(t, "CRITICAL", sender.ServiceChecks[0].Status)
+	assert.Contains(t, sender.ServiceChecks[0].Message, "certificate")
+}
+
+func TestProbeProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a proxy receives the absolute url
+		fmt.Fprint(w, r.URL.String())
+	}))
+	defer proxy.Close()
+
+	c := newTestCheck(t, fmt.Sprintf(`{url: "http://example.invalid/a", proxy: %s, response_match: "^http://example.invalid/a$"}`, proxy.URL))
+	sender := sendProbe(c)
+	values := metricValues(sender)
+	assert.Equal(t, float64(200), values["http_response.status_code"])
+	assert.Equal(t, float64(1), values["http_response.response_match"])
+}
+
+func TestProbeTimeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	c := newTestCheck(t, fmt.Sprintf(`{url: %s}`, ts.URL))
+	c.config.timeout = 100 * time.Millisecond
+	c.client, _ = c.createHTTPClient()
+
+	start := time.Now()
+	sender := sendProbe(c)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Empty(t, sender.Metrics)
+	assert.Equal(t, "CRITICAL", sender.ServiceChecks[0].Status)
+}
@@ -0,0 +1,225 @@
+package http_response
+
+import (
+	"context"
+	gotls "crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+const serviceCheckName = "http_response.can_connect"
+
+// maxBodySize limits the response body kept to match response_match, the
+// rest is only counted
+const maxBodySize = 1 << 20
+
+// phase is the duration of a phase of the request, summed over the
+// redirects
+type phase struct {
+	start time.Time
+	done  bool
+	time  time.Duration
+}
+
+func (p *phase) begin() {
+	// only the first of concurrent dials is measured
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+}
+
+func (p *phase) end(err error) {
+	if p.start.IsZero() || err != nil {
+		return
+	}
+	p.done = true
+	p.time += time.Since(p.start)
+	p.start = time.Time{}
+}
+
+// probeResult is the result of a request, the timings are set if the phase
+// happened
+type probeResult struct {
+	sync.Mutex
+	// err is set if no response is received, failure if the response is
+	// not the expected one
+	err     error
+	failure string
+
+	statusCode    int
+	responseTime  time.Duration
+	contentLength int64
+
+	dns     phase
+	connect phase
+	tls     phase
+
+	expect  bool
+	matched bool
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *probeResult) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			p.Lock()
+			defer p.Unlock()
+			p.dns.begin()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			p.Lock()
+			defer p.Unlock()
+			p.dns.end(info.Err)
+		},
+		ConnectStart: func(string, string) {
+			p.Lock()
+			defer p.Unlock()
+			p.connect.begin()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			p.Lock()
+			defer p.Unlock()
+			p.connect.end(err)
+		},
+		TLSHandshakeStart: func() {
+			p.Lock()
+			defer p.Unlock()
+			p.tls.begin()
+		},
+		TLSHandshakeDone: func(_ gotls.ConnectionState, err error) {
+			p.Lock()
+			defer p.Unlock()
+			p.tls.end(err)
+		},
+	}
+}
+
+func (p *probeResult) send(sender aggregator.Sender, tags []string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.err != nil {
+		sender.ServiceCheck(serviceCheckName, metrics.ServiceCheckCritical, "", tags, p.err.Error())
+		return
+	}
+
+	sender.Gauge("http_response.status_code", float64(p.statusCode), "", tags)
+	sender.Gauge("http_response.response_time", p.responseTime.Seconds(), "", tags)
+	sender.Gauge("http_response.content_length", float64(p.contentLength), "", tags)
+	if p.dns.done {
+		sender.Gauge("http_response.dns_time", p.dns.time.Seconds(), "", tags)
+	}
+	if p.connect.done {
+		sender.Gauge("http_response.connect_time", p.connect.time.Seconds(), "", tags)
+	}
+	if p.tls.done {
+		sender.Gauge("http_response.tls_handshake_time", p.tls.time.Seconds(), "", tags)
+	}
+	if p.expect {
+		sender.Gauge("http_response.response_match", boolValue(p.matched), "", tags)
+	}
+
+	if p.failure != "" {
+		sender.ServiceCheck(serviceCheckName, metrics.ServiceCheckCritical, "", tags, p.failure)
+		return
+	}
+	sender.ServiceCheck(serviceCheckName, metrics.ServiceCheckOK, "", tags, "")
+}
+
+// probe sends the request and reads the response, the service check is
+// critical if the request failed, the status code is unexpected or the body
+// doesn't match
+func (c *Check) probe() *probeResult {
+	cf := c.config
+	ret := &probeResult{expect: cf.matchReg != nil}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cf.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, ret.trace()),
+		cf.Method, cf.URL, strings.NewReader(cf.Body))
+	if err != nil {
+		ret.err = err
+		return ret
+	}
+	for k, v := range cf.Headers {
+		if strings.EqualFold(k, "host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	if cf.Username != "" || cf.Password != "" {
+		req.SetBasicAuth(cf.Username, cf.Password)
+	}
+	if cf.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cf.BearerToken)
+	}
+
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		klog.V(5).Infof("%s %s err %s", cf.Method, cf.URL, err)
+		ret.err = err
+		return ret
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err == nil {
+		var n int64
+		n, err = io.Copy(ioutil.Discard, resp.Body)
+		ret.contentLength = int64(len(body)) + n
+	}
+	ret.responseTime = time.Since(start)
+
+	ret.Lock()
+	defer ret.Unlock()
+
+	ret.statusCode = resp.StatusCode
+	if err != nil {
+		ret.err = fmt.Errorf("read body: %s", err)
+		return ret
+	}
+
+	if !cf.expectedStatusCode(resp.StatusCode) {
+		ret.failure = fmt.Sprintf("unexpected status code %d", resp.StatusCode)
+	}
+
+	if cf.matchReg != nil {
+		ret.matched = cf.matchReg.Match(body)
+		if !ret.matched && ret.failure == "" {
+			ret.failure = fmt.Sprintf("response doesn't match %s", cf.ResponseMatch)
+		}
+	}
+
+	return ret
+}
+
+func (p *InstanceConfig) expectedStatusCode(code int) bool {
+	if len(p.ExpectedStatusCodes) == 0 {
+		return code < 400
+	}
+	for _, c := range p.ExpectedStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+)
+
+// funcs of the check
+const (
+	funcCount     = "count"
+	funcHistogram = "histogram"
+	funcSum       = "sum"
+	funcMax       = "max"
+	funcMin       = "min"
+	funcAvg       = "avg"
+	funcLast      = "last"
+	funcRate      = "rate"
+)
+
+// parseFunc returns the func of the check, c and cnt are the aliases of
+// count, h of histogram, and count is the default
+func parseFunc(s string) (string, error) {
+	switch s {
+	case "", "c", "cnt", funcCount:
+		return funcCount, nil
+	case "h", funcHistogram:
+		return funcHistogram, nil
+	case funcSum, funcMax, funcMin, funcAvg, funcLast, funcRate:
+		return s, nil
+	}
+	return "", fmt.Errorf("unsupported func %q", s)
+}
+
+// needValue returns true if the func is computed over the value captured
+// by the pattern, the lines without it are skipped
+func needValue(fn string) bool {
+	return fn != funcCount && fn != funcRate
+}
+
+// stat is the aggregation of the values of a series between two flushes
+type stat struct {
+	tags  []string
+	count int64
+	sum   float64
+	min   float64
+	max   float64
+	last  float64
+}
+
+func (p *stat) add(value float64) {
+	if p.count == 0 || value < p.min {
+		p.min = value
+	}
+	if p.count == 0 || value > p.max {
+		p.max = value
+	}
+	p.count++
+	p.sum += value
+	p.last = value
+}
+
+// aggregate keeps the value for the funcs which can't be computed by the
+// aggregator: max, min, avg, last and rate
+func (c *Check) aggregate(value float64, tags []string) {
+	c.Lock()
+	defer c.Unlock()
+
+	key := strings.Join(tags, ",")
+	s, ok := c.stats[key]
+	if !ok {
+		s = &stat{tags: tags}
+		c.stats[key] = s
+	}
+	s.add(value)
+}
+
+// flush sends the aggregated series as gauges, rate is the number of
+// matched lines per second since the previous flush
+func (c *Check) flush(sender aggregator.Sender, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	elapsed := now.Sub(c.lastFlush).Seconds()
+	c.lastFlush = now
+
+	cf := c.config
+	for key, s := range c.stats {
+		switch cf.Func {
+		case funcMax:
+			sender.Gauge(cf.MetricName, s.max, "", s.tags)
+		case funcMin:
+			sender.Gauge(cf.MetricName, s.min, "", s.tags)
+		case funcAvg:
+			sender.Gauge(cf.MetricName, s.sum/float64(s.count), "", s.tags)
+		case funcLast:
+			sender.Gauge(cf.MetricName, s.last, "", s.tags)
+		case funcRate:
+			if elapsed > 0 {
+				sender.Gauge(cf.MetricName, float64(s.count)/elapsed, "", s.tags)
+			}
+			// send 0 once after the last matched line
+			if s.count > 0 {
+				s.count = 0
+				continue
+			}
+		}
+		delete(c.stats, key)
+	}
+}
@@ -0,0 +1,139 @@
+package log
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/n9e/n9e-agentd/pkg/util/capturesender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFunc(t *testing.T) {
+	for in, want := range map[string]string{
+		"":          funcCount,
+		"c":         funcCount,
+		"cnt":       funcCount,
+		"count":     funcCount,
+		"h":         funcHistogram,
+		"histogram": funcHistogram,
+		"sum":       funcSum,
+		"max":       funcMax,
+		"min":       funcMin,
+		"avg":       funcAvg,
+		"last":      funcLast,
+		"rate":      funcRate,
+	} {
+		got, err := parseFunc(in)
+		assert.NoError(t, err)
+		assert.Equalf(t, want, got, "func %q", in)
+	}
+
+	_, err := parseFunc("median")
+	assert.Error(t, err)
+}
+
+func processLines(t *testing.T, c *Check, lines ...string) {
+	for _, line := range lines {
+		require.NoError(t, c.process(&entry{line: line}, "/var/log/app.log"))
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	lines := []string{
+		"GET /api/a 200 cost=10",
+		"GET /api/a 500 cost=30",
+		"GET /api/b 200 cost=5",
+		"GET /api/a 200 cost=20",
+		"GET /api/b 200 no cost",
+		"GET /health 200 cost=1",
+	}
+
+	cases := []struct {
+		fn   string
+		want map[string]float64
+	}{
+		{funcMax, map[string]float64{"api:a": 30, "api:b": 5}},
+		{funcMin, map[string]float64{"api:a": 10, "api:b": 5}},
+		{funcAvg, map[string]float64{"api:a": 20, "api:b": 5}},
+		{funcLast, map[string]float64{"api:a": 20, "api:b": 5}},
+		// the lines without cost are matched, 3 lines in 2s for a, 2 for b
+		{funcRate, map[string]float64{"api:a": 1.5, "api:b": 1}},
+	}
+
+	for _, cs := range cases {
+		c := newTestCheck("test", "/var/log/app.log")
+		c.config.MetricName = "test.cost"
+		c.config.Func = cs.fn
+		c.patternReg = regexp.MustCompile(`cost=(\d+)`)
+		if cs.fn == funcRate {
+			c.patternReg = regexp.MustCompile(`GET`)
+		}
+		c.excludeReg = regexp.MustCompile(`/health`)
+		c.tagRegs["api"] = regexp.MustCompile(`/api/(\w+)`)
+		c.config.TagsPattern = map[string]string{"api": `/api/(\w+)`}
+
+		now := time.Now()
+		c.lastFlush = now.Add(-2 * time.Second)
+		processLines(t, c, lines...)
+
+		sender := capturesender.New()
+		c.flush(sender, now)
+
+		got := map[string]float64{}
+		for _, m := range sender.Metrics {
+			assert.Equal(t, "test.cost", m.Name)
+			assert.Equal(t, "gauge", m.Type)
+			got[m.Tags[0]] = m.Value
+		}
+		assert.Equalf(t, cs.want, got, "func %s", cs.fn)
+	}
+}
+
+func TestFlushRate(t *testing.T) {
+	c := newTestCheck("test", "/var/log/app.log")
+	c.config.MetricName = "test.lines"
+	c.config.Func = funcRate
+	c.patternReg = regexp.MustCompile(`ERROR`)
+
+	now := time.Now()
+	c.lastFlush = now.Add(-5 * time.Second)
+	processLines(t, c, "ERROR", "INFO", "ERROR")
+
+	rates := func(now time.Time) []float64 {
+		sender := capturesender.New()
+		c.flush(sender, now)
+		ret := []float64{}
+		for _, m := range sender.Metrics {
+			ret = append(ret, m.Value)
+		}
+		return ret
+	}
+
+	assert.Equal(t, []float64{0.4}, rates(now))
+	// 0 is sent once after the last matched line
+	assert.Equal(t, []float64{0}, rates(now.Add(5*time.Second)))
+	assert.Equal(t, []float64{}, rates(now.Add(10*time.Second)))
+}
+
+func TestFlushReset(t *testing.T) {
+	c := newTestCheck("test", "/var/log/app.log")
+	c.config.MetricName = "test.cost"
+	c.config.Func = funcMax
+	c.patternReg = regexp.MustCompile(`cost=(\d+)`)
+
+	processLines(t, c, "cost=3", "cost=1")
+
+	sender := capturesender.New()
+	c.flush(sender, time.Now())
+	require.Len(t, sender.Metrics, 1)
+	assert.Equal(t, float64(3), sender.Metrics[0].Value)
+
+	// the max is computed between two flushes
+	processLines(t, c, "cost=2")
+	sender = capturesender.New()
+	c.flush(sender, time.Now())
+	require.Len(t, sender.Metrics, 1)
+	assert.Equal(t, float64(2), sender.Metrics[0].Value)
+}
@@ -3,9 +3,11 @@ package log
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -29,6 +31,8 @@ const (
 	checkName               = "log"
 	DefaultRegistryFilename = "registry-plugins-log.json"
 	numWorkers              = 4
+	// maxCachedFiles bounds the files cache, e.g. with rotated files named by date
+	maxCachedFiles = 1024
 )
 
 var (
@@ -40,11 +44,17 @@ type InstanceConfig struct {
 	MetricName   string            `json:"metric_name"`  //
 	FilePath     string            `json:"file_path"`    //
 	Pattern      string            `json:"pattern"`      //
+	Exclude      string            `json:"exclude"`      // skip the lines matched by pattern and exclude
 	TagsPattern  map[string]string `json:"tags_pattern"` //
-	Func         string            `json:"func"`         // count(c), histogram(h)
+	Func         string            `json:"func"`         // count(c), histogram(h), sum, max, min, avg, last, rate
 	Encoding     string            `json:"encoding"`     //
 	ExcludePaths []string          `json:"exclude_path"` //
 	TailingMode  string            `json:"tailing_mode"` //
+	FileTag      bool              `json:"file_tag"`     // add the file:<path> tag of the matched file
+	Parser       string            `json:"parser"`       // regex(default), json, logfmt
+	ValueField   string            `json:"value_field"`  // json, logfmt: the key or dotted path of the value
+	TagFields    map[string]string `json:"tag_fields"`   // json, logfmt: tag name -> key or dotted path
+	Filters      map[string]string `json:"filters"`      // json, logfmt: key or dotted path -> value of the lines to select
 }
 
 type checkConfig struct {
@@ -69,6 +79,10 @@ type Check struct {
 
 	tagRegs    map[string]*regexp.Regexp
 	patternReg *regexp.Regexp
+	excludeReg *regexp.Regexp
+
+	stats     map[string]*stat
+	lastFlush time.Time
 }
 
 // Run executes the check
@@ -78,54 +92,153 @@ func (c *Check) Run() error {
 	return nil
 }
 
-func (c *Check) process(msg *message.Message) (err error) {
+// match returns true if the file is matched by the file_path glob of the
+// check and not by its exclude_path
+func (c *Check) match(file string) bool {
+	cf := c.config
+	if ok, _ := filepath.Match(cf.FilePath, file); !ok {
+		return false
+	}
+
+	for _, pattern := range cf.ExcludePaths {
+		if ok, _ := filepath.Match(pattern, file); ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Check) process(e *entry, file string) (err error) {
 	klog.V(6).Infof("entering process")
-	line := string(msg.Content)
+	line := e.line
 	cf := c.config
 
-	var value float64
-	if m := c.patternReg.FindStringSubmatch(line); len(m) == 0 || (cf.Func == "histogram" && len(m) < 2) {
+	// the regex parser matches pattern while extracting the value
+	if cf.Parser != parserRegex && c.patternReg != nil && !c.patternReg.MatchString(line) {
+		return nil
+	}
+	if c.excludeReg != nil && c.excludeReg.MatchString(line) {
+		return nil
+	}
+
+	var (
+		value float64
+		tags  []string
+		ok    bool
+	)
+	if cf.Parser == parserRegex {
+		value, tags, ok, err = c.extractRegex(line)
+	} else {
+		value, tags, ok, err = c.extractFields(e)
+	}
+	if !ok || err != nil {
+		klog.V(6).Infof("leaving process")
+		return err
+	}
+
+	if cf.FileTag {
+		tags = append(tags, "file:"+file)
+	}
+	sort.Strings(tags)
+
+	switch cf.Func {
+	case funcCount, funcHistogram, funcSum:
+	default:
+		klog.V(6).Infof(`aggregate(%s, %f, %v)`, cf.MetricName, value, tags)
+		c.aggregate(value, tags)
 		return nil
+	}
+
+	sender, err := aggregator.GetSender(c.ID())
+	if err != nil {
+		klog.V(6).Infof("leaving process")
+		return err
+	}
+
+	switch cf.Func {
+	case funcCount:
+		klog.V(6).Infof(`sender.Count(%s, 1, "", %v)`, cf.MetricName, tags)
+		sender.Count(cf.MetricName, 1, "", tags)
+	case funcSum:
+		klog.V(6).Infof(`sender.Count(%s, %f, "", %v)`, cf.MetricName, value, tags)
+		sender.Count(cf.MetricName, value, "", tags)
+	case funcHistogram:
+		klog.V(6).Infof(`sender.Histogram(%s, %f, "", %v)`, cf.MetricName, value, tags)
+		sender.Histogram(cf.MetricName, value, "", tags)
+	}
+	klog.V(6).Infof("leaving process")
+	return nil
+}
+
+// extractRegex returns the value captured by pattern and the tags captured
+// by tags_pattern, ok is false if the line must be skipped
+func (c *Check) extractRegex(line string) (value float64, tags []string, ok bool, err error) {
+	cf := c.config
+
+	if m := c.patternReg.FindStringSubmatch(line); len(m) == 0 || (needValue(cf.Func) && len(m) < 2) {
+		return 0, nil, false, nil
 	} else if len(m) >= 2 {
 		if value, err = strconv.ParseFloat(m[1], 64); err != nil {
 			klog.V(6).Infof("parsefloat err %s", err)
-			return err
+			return 0, nil, false, err
 		}
 	}
 	//处理tag 正则
-	tags := []string{}
+	tags = []string{}
 	for k, v := range c.config.TagsPattern {
 		var regTag *regexp.Regexp
 		regTag, ok := c.tagRegs[k]
 		if !ok {
-			klog.V(6).Infof("leaving process")
-			return fmt.Errorf("get tag reg error %s:%s", k, v)
+			return 0, nil, false, fmt.Errorf("get tag reg error %s:%s", k, v)
 		}
 		if m := regTag.FindStringSubmatch(line); len(m) < 2 {
-			klog.V(6).Infof("leaving process")
-			return nil
+			return 0, nil, false, nil
 		} else {
 			tags = append(tags, k+":"+m[1])
 		}
 	}
-	sort.Strings(tags)
 
-	sender, err := aggregator.GetSender(c.ID())
+	return value, tags, true, nil
+}
+
+// extractFields returns the value_field and the tag_fields of the lines
+// selected by filters, ok is false if the line must be skipped
+func (c *Check) extractFields(e *entry) (value float64, tags []string, ok bool, err error) {
+	cf := c.config
+
+	fields, err := e.parse(cf.Parser)
 	if err != nil {
-		klog.V(6).Infof("leaving process")
-		return err
+		return 0, nil, false, err
 	}
 
-	switch cf.Func {
-	case "count", "c", "cnt":
-		klog.V(6).Infof(`sender.Count(%s, 1, "", %v)`, cf.MetricName, tags)
-		sender.Count(cf.MetricName, 1, "", tags)
-	case "histogram", "h":
-		klog.V(6).Infof(`sender.Histogram(%s, %f, "", %v)`, cf.MetricName, value, tags)
-		sender.Histogram(cf.MetricName, value, "", tags)
+	for path, want := range cf.Filters {
+		if v, ok := lookup(fields, path); !ok || fieldString(v) != want {
+			return 0, nil, false, nil
+		}
 	}
-	klog.V(6).Infof("leaving process")
-	return nil
+
+	if cf.ValueField != "" {
+		v, ok := lookup(fields, cf.ValueField)
+		if !ok {
+			return 0, nil, false, nil
+		}
+		if value, err = fieldFloat(v); err != nil {
+			return 0, nil, false, fmt.Errorf("value_field %s: %s", cf.ValueField, err)
+		}
+	} else if needValue(cf.Func) {
+		return 0, nil, false, nil
+	}
+
+	tags = []string{}
+	for k, path := range cf.TagFields {
+		v, ok := lookup(fields, path)
+		if !ok {
+			return 0, nil, false, nil
+		}
+		tags = append(tags, k+":"+fieldString(v))
+	}
+
+	return value, tags, true, nil
 }
 
 func (c *Check) Cancel() {
@@ -161,12 +274,42 @@ func (c *Check) Configure(rawInstance integration.Data, rawInitConfig integratio
 	c.config = cf
 	c.agent = agent
 
-	if len(cf.Pattern) == 0 {
-		return fmt.Errorf("pattern and exclude are all empty")
+	// the sources which are not valid are ignored by the scanner
+	c.source = config.NewLogSource(string(c.ID()), &config.LogsConfig{
+		Type:         config.FileType,
+		Source:       "log",
+		Path:         cf.FilePath,
+		Encoding:     cf.Encoding,
+		ExcludePaths: cf.ExcludePaths,
+		TailingMode:  cf.TailingMode,
+	})
+	if err := c.source.Config.Validate(); err != nil {
+		return err
+	}
+
+	if cf.Parser, err = parseParser(cf.Parser); err != nil {
+		return err
 	}
 
-	if c.patternReg, err = regexp.Compile(cf.Pattern); err != nil {
-		return fmt.Errorf("compile pattern regexp failed %s %v", cf.Pattern, err)
+	if cf.Parser == parserRegex && len(cf.Pattern) == 0 {
+		return fmt.Errorf("pattern is empty")
+	}
+
+	// with the json and logfmt parsers, pattern only selects the lines
+	if cf.Pattern != "" {
+		if c.patternReg, err = regexp.Compile(cf.Pattern); err != nil {
+			return fmt.Errorf("compile pattern regexp failed %s %v", cf.Pattern, err)
+		}
+	}
+
+	if cf.Exclude != "" {
+		if c.excludeReg, err = regexp.Compile(cf.Exclude); err != nil {
+			return fmt.Errorf("compile exclude regexp failed %s %v", cf.Exclude, err)
+		}
+	}
+
+	if cf.Func, err = parseFunc(cf.Func); err != nil {
+		return err
 	}
 
 	for k, v := range cf.TagsPattern {
@@ -203,12 +346,15 @@ type Agent struct {
 	cancel  context.CancelFunc
 	workers int
 	checks  map[string]*Check
-	files   map[string]map[string]*Check
+	// files caches the checks of the tailed files, it is reset when a
+	// check is added or removed
+	files map[string][]*Check
 
 	fn string
 }
 
 func (a *Agent) start() {
+	a.auditor.Start()
 	a.scanner.Start()
 
 	for i := 0; i < numWorkers; i++ {
@@ -222,13 +368,14 @@ func (a *Agent) flush() {
 
 	for {
 		select {
-		case <-t.C:
+		case now := <-t.C:
 			a.RLock()
 			for _, c := range a.checks {
 				sender, err := aggregator.GetSender(c.ID())
 				if err != nil {
 					continue
 				}
+				c.flush(sender, now)
 				sender.Commit()
 			}
 			a.RUnlock()
@@ -241,6 +388,7 @@ func (a *Agent) flush() {
 func (a *Agent) stop() {
 	a.scanner.Stop()
 	a.cancel()
+	a.auditor.Stop()
 }
 
 func (a *Agent) addWork() {
@@ -252,26 +400,14 @@ func (a *Agent) addCheck(c *Check) {
 	a.Lock()
 	defer a.Unlock()
 
-	cf := c.config
 	id := string(c.ID())
 	if _, ok := a.checks[id]; ok {
 		return
 	}
 
-	if _, ok := a.files[cf.FilePath]; !ok {
-		a.files[cf.FilePath] = make(map[string]*Check)
-	}
-	a.files[cf.FilePath][id] = c
 	a.checks[id] = c
+	a.files = make(map[string][]*Check)
 
-	c.source = config.NewLogSource(id, &config.LogsConfig{
-		Type:         config.FileType,
-		Source:       "log",
-		Path:         cf.FilePath,
-		Encoding:     cf.Encoding,
-		ExcludePaths: cf.ExcludePaths,
-		TailingMode:  cf.TailingMode,
-	})
 	a.sources.AddSource(c.source)
 }
 
@@ -281,7 +417,7 @@ func (a *Agent) removeCheck(c *Check) {
 
 	a.sources.RemoveSource(c.source)
 	delete(a.checks, string(c.ID()))
-	delete(a.files[c.config.FilePath], string(c.ID()))
+	a.files = make(map[string][]*Check)
 }
 
 func (a *Agent) getCheckByID(id string) *Check {
@@ -290,19 +426,58 @@ func (a *Agent) getCheckByID(id string) *Check {
 	return a.checks[id]
 }
 
-func (a *Agent) getChecksByFile(file string) (checks map[string]*Check) {
+// getChecksByFile returns the checks whose file_path matches the file,
+// a file is tailed once even if it is matched by several checks
+func (a *Agent) getChecksByFile(file string) []*Check {
+	a.RLock()
+	checks, ok := a.files[file]
+	a.RUnlock()
+	if ok {
+		return checks
+	}
+
 	a.Lock()
 	defer a.Unlock()
 
-	return a.files[file]
+	if len(a.files) >= maxCachedFiles {
+		a.files = make(map[string][]*Check)
+	}
+
+	checks = []*Check{}
+	for _, c := range a.checks {
+		if c.match(file) {
+			checks = append(checks, c)
+		}
+	}
+	a.files[file] = checks
+
+	return checks
 }
 
 func (a *Agent) process(msg *message.Message) {
-	checks := a.getChecksByFile(msg.Origin.LogSource.Config.Path)
+	file := messageFile(msg)
+	e := newEntry(msg)
+
+	for _, c := range a.getChecksByFile(file) {
+		if err := c.process(e, file); err != nil {
+			klog.V(5).Infof("check %s process err %s", c.ID(), err)
+		}
+	}
+
+	// commit the offset of the line to registry-plugins-log.json, the
+	// tailers resume from it after a restart
+	if ch := a.auditor.Channel(); ch != nil {
+		ch <- msg
+	}
+}
 
-	for _, c := range checks {
-		c.process(msg)
+// messageFile returns the path of the file the line was read from, the
+// tailer identifier is file:<path>
+func messageFile(msg *message.Message) string {
+	if id := msg.Origin.Identifier; strings.HasPrefix(id, "file:") {
+		return strings.TrimPrefix(id, "file:")
 	}
+	return msg.Origin.LogSource.Config.Path
 }
 
 func (a *Agent) work(id int) {
@@ -339,7 +514,7 @@ func NewAgent() (*Agent, error) {
 		ctx:     ctx,
 		cancel:  cancel,
 		checks:  make(map[string]*Check),
-		files:   make(map[string]map[string]*Check),
+		files:   make(map[string][]*Check),
 	}, nil
 }
 
@@ -371,6 +546,8 @@ func checkFactory() check.Check {
 	return &Check{
 		CheckBase: core.NewCheckBaseWithInterval(checkName, 0),
 		tagRegs:   make(map[string]*regexp.Regexp),
+		stats:     make(map[string]*stat),
+		lastFlush: time.Now(),
 	}
 }
 
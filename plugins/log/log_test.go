@@ -1 +1,194 @@
 package log
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	"github.com/DataDog/datadog-agent/pkg/logs/config"
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+	coreConfig "github.com/n9e/n9e-agentd/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCheck(id, filePath string, excludePaths ...string) *Check {
+	coreConfig.Mock()
+
+	c := checkFactory().(*Check)
+	c.config = &checkConfig{InstanceConfig{FilePath: filePath, ExcludePaths: excludePaths, Parser: parserRegex}}
+	c.BuildID([]byte("name: "+id), nil)
+	c.source = config.NewLogSource(id, &config.LogsConfig{Type: config.FileType, Path: filePath})
+	return c
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		filePath string
+		exclude  []string
+		file     string
+		want     bool
+	}{
+		{"/var/log/app.log", nil, "/var/log/app.log", true},
+		{"/var/log/app.log", nil, "/var/log/app.log.1", false},
+		{"/var/log/app/*.log", nil, "/var/log/app/a.log", true},
+		{"/var/log/app/*.log", nil, "/var/log/app/sub/a.log", false},
+		{"/var/log/app/*.log", []string{"/var/log/app/debug*"}, "/var/log/app/debug.log", false},
+		{"/var/log/app/*.log", []string{"/var/log/app/debug*"}, "/var/log/app/info.log", true},
+	}
+
+	for _, c := range cases {
+		ck := newTestCheck("test", c.filePath, c.exclude...)
+		assert.Equalf(t, c.want, ck.match(c.file), "file_path %s file %s", c.filePath, c.file)
+	}
+}
+
+func TestGetChecksByFile(t *testing.T) {
+	a := &Agent{
+		sources: config.NewLogSources(),
+		checks:  make(map[string]*Check),
+		files:   make(map[string][]*Check),
+	}
+
+	all := newTestCheck("all", "/var/log/app/*.log")
+	one := newTestCheck("one", "/var/log/app/a.log")
+	a.addCheck(all)
+
+	assert.Equal(t, []*Check{all}, a.getChecksByFile("/var/log/app/a.log"))
+	assert.Equal(t, []*Check{}, a.getChecksByFile("/var/log/other.log"))
+
+	// the cache is reset when a check is added or removed
+	a.addCheck(one)
+	assert.ElementsMatch(t, []*Check{all, one}, a.getChecksByFile("/var/log/app/a.log"))
+	assert.Equal(t, []*Check{all}, a.getChecksByFile("/var/log/app/b.log"))
+
+	a.removeCheck(all)
+	assert.Equal(t, []*Check{one}, a.getChecksByFile("/var/log/app/a.log"))
+	assert.Equal(t, []*Check{}, a.getChecksByFile("/var/log/app/b.log"))
+}
+
+func TestMessageFile(t *testing.T) {
+	source := config.NewLogSource("test", &config.LogsConfig{Path: "/var/log/app/*.log"})
+
+	origin := message.NewOrigin(source)
+	origin.Identifier = "file:/var/log/app/a.log"
+	assert.Equal(t, "/var/log/app/a.log", messageFile(message.NewMessage(nil, origin, "", 0)))
+
+	origin = message.NewOrigin(source)
+	assert.Equal(t, "/var/log/app/*.log", messageFile(message.NewMessage(nil, origin, "", 0)))
+}
+
+func appendLines(t *testing.T, file string, lines ...string) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, line := range lines {
+		_, err := fmt.Fprintln(f, line)
+		require.NoError(t, err)
+	}
+}
+
+// counter counts the Count calls by tags, the lines are processed by the
+// workers of the agent
+type counter struct {
+	sync.Mutex
+	counts map[string]int
+}
+
+func (p *counter) inc(args mock.Arguments) {
+	p.Lock()
+	defer p.Unlock()
+	p.counts[strings.Join(args.Get(3).([]string), ",")]++
+}
+
+func (p *counter) get(tags string) int {
+	p.Lock()
+	defer p.Unlock()
+	return p.counts[tags]
+}
+
+func TestAgent(t *testing.T) {
+	coreConfig.Mock()
+	dir, err := ioutil.TempDir("", "plugins-log")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	logs := &coreConfig.C.Logs
+	logs.RunPath = dir
+	logs.OpenFilesLimit = 10
+	logs.FileScanPeriod.Duration = 100 * time.Millisecond
+	logs.AuditorTTL.Duration = time.Hour
+
+	a, err := NewAgent()
+	require.NoError(t, err)
+	a.start()
+	agent = a
+	defer func() {
+		agent = nil
+	}()
+
+	logDir := filepath.Join(dir, "app")
+	require.NoError(t, os.Mkdir(logDir, 0755))
+
+	check := checkFactory().(*Check)
+	err = check.Configure([]byte(fmt.Sprintf(`
+metric_name: test.errors
+file_path: %s/*.log
+pattern: ERROR
+func: count
+file_tag: true
+`, logDir)), nil, "test")
+	require.NoError(t, err)
+
+	sender := mocksender.NewMockSender(check.ID())
+	counts := &counter{counts: map[string]int{}}
+	sender.On("Count", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(counts.inc).Return()
+	sender.On("Commit").Return()
+
+	require.NoError(t, check.Run())
+
+	// wildcard paths can't be tailed from the beginning
+	err = checkFactory().Configure([]byte(fmt.Sprintf(`
+metric_name: test.errors
+file_path: %s/*.log
+pattern: ERROR
+tailing_mode: beginning
+`, logDir)), nil, "test")
+	assert.Error(t, err)
+
+	aTags := "file:" + filepath.Join(logDir, "a.log")
+	bTags := "file:" + filepath.Join(logDir, "b.log")
+
+	// the files created after the check are tailed from their beginning
+	appendLines(t, filepath.Join(logDir, "a.log"), "ERROR 1", "INFO 2", "ERROR 3")
+	appendLines(t, filepath.Join(logDir, "b.log"), "ERROR 1")
+
+	assert.Eventually(t, func() bool {
+		return counts.get(aTags) == 2 && counts.get(bTags) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// rotate a.log, the new file is read from its beginning
+	require.NoError(t, os.Rename(filepath.Join(logDir, "a.log"), filepath.Join(logDir, "a.log.1")))
+	appendLines(t, filepath.Join(logDir, "a.log"), "ERROR 4")
+
+	assert.Eventually(t, func() bool {
+		return counts.get(aTags) == 3
+	}, 5*time.Second, 50*time.Millisecond)
+
+	check.Cancel()
+	a.stop()
+
+	// the offsets are committed by the auditor
+	registry, err := ioutil.ReadFile(filepath.Join(dir, DefaultRegistryFilename))
+	require.NoError(t, err)
+	assert.Contains(t, string(registry), "file:"+filepath.Join(logDir, "a.log"))
+	assert.Contains(t, string(registry), "file:"+filepath.Join(logDir, "b.log"))
+}
@@ -0,0 +1,183 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+)
+
+// parsers of the lines
+const (
+	parserRegex  = "regex"
+	parserJSON   = "json"
+	parserLogfmt = "logfmt"
+)
+
+func parseParser(s string) (string, error) {
+	switch s {
+	case "":
+		return parserRegex, nil
+	case parserRegex, parserJSON, parserLogfmt:
+		return s, nil
+	}
+	return "", fmt.Errorf("unsupported parser %q", s)
+}
+
+// entry is a line read from a file, it is parsed once by parser for all the
+// checks of the file, by the worker which processes it
+type entry struct {
+	line   string
+	fields map[string]map[string]interface{}
+	errs   map[string]error
+}
+
+func newEntry(msg *message.Message) *entry {
+	return &entry{line: string(msg.Content)}
+}
+
+// parse returns the fields of the line parsed as json or logfmt
+func (p *entry) parse(parser string) (map[string]interface{}, error) {
+	if fields, ok := p.fields[parser]; ok {
+		return fields, p.errs[parser]
+	}
+
+	var (
+		fields map[string]interface{}
+		err    error
+	)
+	switch parser {
+	case parserJSON:
+		err = json.Unmarshal([]byte(p.line), &fields)
+	case parserLogfmt:
+		fields, err = parseLogfmt(p.line)
+	default:
+		err = fmt.Errorf("unsupported parser %q", parser)
+	}
+
+	if p.fields == nil {
+		p.fields = make(map[string]map[string]interface{})
+		p.errs = make(map[string]error)
+	}
+	p.fields[parser] = fields
+	p.errs[parser] = err
+
+	return fields, err
+}
+
+// parseLogfmt parses key=value pairs separated by spaces, the values may
+// be double quoted, a key without a value is true
+// https://brandur.org/logfmt
+func parseLogfmt(line string) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		key := line[start:i]
+
+		if i >= len(line) || line[i] != '=' {
+			fields[key] = true
+			continue
+		}
+		i++ // =
+
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for ; end < len(line) && line[end] != '"'; end++ {
+				if line[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated quoted value of %s", key)
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted value of %s: %s", key, err)
+			}
+			fields[key] = value
+			i = end + 1
+			continue
+		}
+
+		start = i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		fields[key] = line[start:i]
+	}
+
+	return fields, nil
+}
+
+// lookup returns the field by key, or by dotted path in the nested objects
+// and arrays, e.g. request.headers.0
+func lookup(fields map[string]interface{}, path string) (interface{}, bool) {
+	if v, ok := fields[path]; ok {
+		return v, true
+	}
+
+	var v interface{} = fields
+	for _, key := range strings.Split(path, ".") {
+		switch o := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = o[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(o) {
+				return nil, false
+			}
+			v = o[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+// fieldString returns the value of a field as a tag value
+func fieldString(v interface{}) string {
+	switch o := v.(type) {
+	case string:
+		return o
+	case float64:
+		return strconv.FormatFloat(o, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(o)
+	case nil:
+		return ""
+	}
+
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// fieldFloat returns the value of a field as a metric value
+func fieldFloat(v interface{}) (float64, error) {
+	switch o := v.(type) {
+	case float64:
+		return o, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(o), 64)
+	case bool:
+		if o {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unsupported value type %T", v)
+}
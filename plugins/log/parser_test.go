@@ -0,0 +1,167 @@
+package log
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/n9e/n9e-agentd/pkg/util/capturesender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	fields, err := parseLogfmt(`level=info msg="GET /api \"a\"" status=200  cost=1.5 debug`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"level":  "info",
+		"msg":    `GET /api "a"`,
+		"status": "200",
+		"cost":   "1.5",
+		"debug":  true,
+	}, fields)
+
+	_, err = parseLogfmt(`msg="unterminated`)
+	assert.Error(t, err)
+}
+
+func TestLookup(t *testing.T) {
+	e := &entry{line: `{"a": {"b": {"c": 1}}, "a.b": "dotted", "list": [{"x": "y"}], "n": null}`}
+	fields, err := e.parse(parserJSON)
+	require.NoError(t, err)
+
+	cases := []struct {
+		path string
+		want interface{}
+		ok   bool
+	}{
+		{"a.b.c", float64(1), true},
+		{"a.b", "dotted", true},
+		{"list.0.x", "y", true},
+		{"list.1.x", nil, false},
+		{"a.x", nil, false},
+		{"a.b.c.d", nil, false},
+		{"n", nil, true},
+	}
+	for _, c := range cases {
+		got, ok := lookup(fields, c.path)
+		assert.Equalf(t, c.ok, ok, "path %s", c.path)
+		assert.Equalf(t, c.want, got, "path %s", c.path)
+	}
+}
+
+func TestEntryParseOnce(t *testing.T) {
+	e := &entry{line: `{"status": 200}`}
+
+	fields, err := e.parse(parserJSON)
+	require.NoError(t, err)
+
+	// the cached fields are returned to the next checks
+	fields["status"] = float64(500)
+	fields, err = e.parse(parserJSON)
+	require.NoError(t, err)
+	assert.Equal(t, float64(500), fields["status"])
+
+	e = &entry{line: `not json`}
+	_, err = e.parse(parserJSON)
+	assert.Error(t, err)
+	_, err = e.parse(parserJSON)
+	assert.Error(t, err)
+}
+
+func newFieldsCheck(parser, fn string) *Check {
+	c := newTestCheck("test", "/var/log/app.log")
+	c.config.MetricName = "test.cost"
+	c.config.Parser = parser
+	c.config.Func = fn
+	c.config.ValueField = "req.cost"
+	c.config.TagFields = map[string]string{"code": "req.status", "level": "level"}
+	c.config.Filters = map[string]string{"service": "api"}
+	return c
+}
+
+func TestProcessJSON(t *testing.T) {
+	c := newFieldsCheck(parserJSON, funcMax)
+	c.excludeReg = regexp.MustCompile(`/health`)
+
+	processLines(t, c,
+		`{"level": "info", "service": "api", "req": {"status": 200, "cost": 10, "path": "/a"}}`,
+		`{"level": "info", "service": "api", "req": {"status": 200, "cost": "30", "path": "/b"}}`,
+		`{"level": "info", "service": "web", "req": {"status": 200, "cost": 50, "path": "/a"}}`,
+		`{"level": "info", "service": "api", "req": {"status": 200, "cost": 70, "path": "/health"}}`,
+		`{"level": "warn", "service": "api", "req": {"status": 500, "cost": 20, "path": "/a"}}`,
+		`{"level": "warn", "service": "api", "req": {"status": 500, "path": "/a"}}`,
+	)
+	assert.Error(t, c.process(&entry{line: "not json"}, "/var/log/app.log"))
+
+	sender := capturesender.New()
+	c.flush(sender, time.Now())
+
+	got := map[string]float64{}
+	for _, m := range sender.Metrics {
+		got[m.Tags[0]+","+m.Tags[1]] = m.Value
+	}
+	assert.Equal(t, map[string]float64{
+		"code:200,level:info": 30,
+		"code:500,level:warn": 20,
+	}, got)
+}
+
+func TestProcessLogfmt(t *testing.T) {
+	c := newFieldsCheck(parserLogfmt, funcRate)
+	c.config.TagFields = map[string]string{"code": "status"}
+	c.config.ValueField = ""
+	c.patternReg = regexp.MustCompile(`GET`)
+
+	now := time.Now()
+	c.lastFlush = now.Add(-time.Second)
+	processLines(t, c,
+		`service=api method=GET status=200`,
+		`service=api method=GET status=200`,
+		`service=api method=POST status=200`,
+		`service=web method=GET status=200`,
+		`service=api method=GET status=500`,
+		`service=api method=GET`,
+	)
+
+	sender := capturesender.New()
+	c.flush(sender, now)
+
+	got := map[string]float64{}
+	for _, m := range sender.Metrics {
+		got[m.Tags[0]] = m.Value
+	}
+	assert.Equal(t, map[string]float64{"code:200": 2, "code:500": 1}, got)
+}
+
+func TestConfigureParser(t *testing.T) {
+	rawInstance := []byte(`
+metric_name: test.cost
+file_path: /var/log/app.log
+parser: json
+value_field: cost
+func: avg
+`)
+	c := checkFactory().(*Check)
+	agent = &Agent{}
+	defer func() {
+		agent = nil
+	}()
+
+	require.NoError(t, c.Configure(rawInstance, nil, "test"))
+	assert.Equal(t, parserJSON, c.config.Parser)
+	assert.Nil(t, c.patternReg)
+
+	err := checkFactory().Configure([]byte(`
+metric_name: test.cost
+file_path: /var/log/app.log
+parser: xml
+`), nil, "test")
+	assert.Error(t, err)
+
+	err = checkFactory().Configure([]byte(`
+metric_name: test.cost
+file_path: /var/log/app.log
+`), nil, "test")
+	assert.Error(t, err, "pattern is required by the regex parser")
+}
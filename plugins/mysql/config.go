@@ -4,8 +4,8 @@ import (
 	"net"
 	"time"
 
-	"github.com/go-sql-driver/mysql"
 	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
+	"github.com/go-sql-driver/mysql"
 	"github.com/n9e/n9e-agentd/pkg/util"
 	"github.com/n9e/n9e-agentd/pkg/util/db"
 	"github.com/n9e/n9e-agentd/pkg/util/tls"
@@ -42,21 +42,21 @@ type InstanceConfig struct {
 }
 
 type StatementSamples struct {
-	Enabled                            bool   `json:"enabled"`
-	RunSync                            bool   `json:"run_sync"`
-	CollectionsPerSecond               int    `json:"collections_per_second"`
-	SamplesPerHourPerQuery             int    `json:"samples_per_hour_per_query"`
-	ExplainedStatementsCacheMaxsize    int    `json:"explained_statements_cache_maxsize"`
-	ExplainedStatementsPerHourPerQuery int    `json:"explained_statements_per_hour_per_query"`
-	SeenSamplesCacheMaxsize            int    `json:"seen_samples_cache_maxsize"`
-	EventsStatementsRowLimit           int    `json:"events_statements_row_limit"`
-	EventsStatementsTable              string `json:"events_statements_table"`
-	ExplainProcedure                   string `json:"explain_procedure"`
-	FullyQualifiedExplainProcedure     string `json:"fully_qualified_explain_procedure"`
-	EventsStatementsEnableProcedure    string `json:"events_statements_enable_procedure"`
-	EventsStatementsTempTableName      string `json:"events_statements_temp_table_name"`
-	CollectionStrategyCacheMaxsize     int    `json:"collection_strategy_cache_maxsize"`
-	CollectionStrategyCacheTtl         int    `json:"collection_strategy_cache_ttl"`
+	Enabled                            bool    `json:"enabled"`
+	RunSync                            bool    `json:"run_sync"`
+	CollectionsPerSecond               float64 `json:"collections_per_second"`
+	SamplesPerHourPerQuery             int     `json:"samples_per_hour_per_query"`
+	ExplainedStatementsCacheMaxsize    int     `json:"explained_statements_cache_maxsize"`
+	ExplainedStatementsPerHourPerQuery int     `json:"explained_statements_per_hour_per_query"`
+	SeenSamplesCacheMaxsize            int     `json:"seen_samples_cache_maxsize"`
+	EventsStatementsRowLimit           int     `json:"events_statements_row_limit"`
+	EventsStatementsTable              string  `json:"events_statements_table"`
+	ExplainProcedure                   string  `json:"explain_procedure"`
+	FullyQualifiedExplainProcedure     string  `json:"fully_qualified_explain_procedure"`
+	EventsStatementsEnableProcedure    string  `json:"events_statements_enable_procedure"`
+	EventsStatementsTempTableName      string  `json:"events_statements_temp_table_name"`
+	CollectionStrategyCacheMaxsize     int     `json:"collection_strategy_cache_maxsize"`
+	CollectionStrategyCacheTtl         int     `json:"collection_strategy_cache_ttl"`
 }
 
 type Options struct {
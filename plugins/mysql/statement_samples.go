@@ -1,8 +1,22 @@
 package mysql
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/collector/check/defaults"
+	"github.com/DataDog/datadog-agent/pkg/trace/obfuscate"
+	"github.com/go-sql-driver/mysql"
+	"github.com/n9e/n9e-agentd/pkg/config/apm"
+	"github.com/n9e/n9e-agentd/pkg/util/db"
 	"k8s.io/klog/v2"
 )
 
@@ -48,7 +62,7 @@ var (
 
 	// columns from events_statements_summary tables which correspond to attributes common to all databases and are
 	// therefore stored under other standard keys
-	EVENTS_STATEMENTS_SAMPLE_EXCLUDE_KEYS = []string{
+	EVENTS_STATEMENTS_SAMPLE_EXCLUDE_KEYS = frozenset{
 		// gets obfuscated
 		"sql_text",
 		// stored as "instance"
@@ -154,18 +168,142 @@ var (
     WHERE enabled = 'YES'
     AND name LIKE 'events_statements_%'`
 
-	PYMYSQL_NON_RETRYABLE_ERRORS = frozenset{
+	NON_RETRYABLE_ERRORS = frozenset{
 		1044, // access denied on database
 		1046, // no permission on statement
 		1049, // unknown database
 		1305, // procedure does not exist
 		1370, // no execute on procedure
 	}
+
+	// the obfuscation and the normalization of the mysql execution plans, the
+	// values of the keys not listed are obfuscated
+	SQL_EXEC_PLAN_KEEP_VALUES = []string{
+		"access_type",
+		"backward_index_scan",
+		"cacheable",
+		"delete",
+		"dependent",
+		"first_match",
+		"key",
+		"key_length",
+		"possible_keys",
+		"ref",
+		"select_id",
+		"table_name",
+		"update",
+		"used_columns",
+		"used_key_parts",
+		"using_MRR",
+		"using_filesort",
+		"using_index",
+		"using_join_buffer",
+		"using_temporary_table",
+	}
+	SQL_EXEC_PLAN_OBFUSCATION = &apm.ObfuscationConfig{
+		SQLExecPlan: apm.JSONObfuscationConfig{
+			Enabled:            true,
+			ObfuscateSQLValues: []string{"attached_condition"},
+			KeepValues: append([]string{
+				"cost_info",
+				"filtered",
+				"rows_examined_per_join",
+				"rows_examined_per_scan",
+				"rows_produced_per_join",
+			}, SQL_EXEC_PLAN_KEEP_VALUES...),
+		},
+		SQLExecPlanNormalize: apm.JSONObfuscationConfig{
+			Enabled:            true,
+			ObfuscateSQLValues: []string{"attached_condition"},
+			KeepValues:         SQL_EXEC_PLAN_KEEP_VALUES,
+		},
+	}
+
+	// explain strategies in the default order of preference
+	EXPLAIN_STRATEGY_PROCEDURE    = "PROCEDURE"
+	EXPLAIN_STRATEGY_FQ_PROCEDURE = "FQ_PROCEDURE"
+	EXPLAIN_STRATEGY_STATEMENT    = "STATEMENT"
+
+	// cached for a schema when an explain strategy fails with a non retryable error
+	EXPLAIN_STRATEGY_ERROR = "ERROR"
 )
 
-// TODO: use sender.Event() instead of statement samples client
-func _new_statement_samples_client() interface{} {
-	return nil
+// Executor runs the queries of the statement sampler on a single connection,
+// the session state (USE, variables, temporary tables) is kept between the calls
+type Executor interface {
+	Exec(query string, args ...interface{}) error
+	QueryMapRows(query string, args ...interface{}) ([]map[string]interface{}, error)
+	Close() error
+}
+
+// connExecutor is the Executor of a dedicated connection, the sampler does
+// not share the connection of the check, which is closed after each run
+type connExecutor struct {
+	db   *sql.DB
+	conn *sql.Conn
+}
+
+func newConnExecutor(dsn string) (*connExecutor, error) {
+	d, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := d.Conn(context.Background())
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+
+	return &connExecutor{db: d, conn: conn}, nil
+}
+
+func (p *connExecutor) Exec(query string, args ...interface{}) error {
+	_, err := p.conn.ExecContext(context.Background(), query, args...)
+	return err
+}
+
+// QueryMapRows returns the rows as column -> value, the []byte values are
+// converted to string
+func (p *connExecutor) QueryMapRows(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := p.conn.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		for i := range values {
+			values[i] = new(interface{})
+		}
+		if err := rows.Scan(values...); err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			v := *(values[i].(*interface{}))
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			row[col] = v
+		}
+		ret = append(ret, row)
+	}
+
+	return ret, rows.Err()
+}
+
+func (p *connExecutor) Close() error {
+	p.conn.Close()
+	return p.db.Close()
 }
 
 func NewMySQLStatementSamples(c *Check) *MySQLStatementSamples {
@@ -184,6 +322,12 @@ func NewMySQLStatementSamples(c *Check) *MySQLStatementSamples {
 		_events_statements_temp_table:       cfg.EventsStatementsTempTableName,
 		_events_statements_enable_procedure: cfg.EventsStatementsEnableProcedure,
 		_preferred_events_statements_tables: EVENTS_STATEMENTS_PREFERRED_TABLES,
+		_rate_limiter:                       db.NewConstantRateLimiter(1),
+		_obfuscator:                         obfuscate.NewObfuscator(SQL_EXEC_PLAN_OBFUSCATION),
+	}
+
+	if c.config.Service != "" {
+		p._service = c.config.Service
 	}
 
 	if events_statements_table := cfg.EventsStatementsTable; events_statements_table != "" {
@@ -195,67 +339,738 @@ func NewMySQLStatementSamples(c *Check) *MySQLStatementSamples {
 		}
 	}
 
-	p._explain_strategies = map[string]interface{}{
-		"PROCEDURE":    p._run_explain_procedure,
-		"FQ_PROCEDURE": p._run_fully_qualified_explain_procedure,
-		"STATEMENT":    p._run_explain,
+	p._explain_strategies = map[string]func(statement, obfuscated_statement string) (string, error){
+		EXPLAIN_STRATEGY_PROCEDURE:    p._run_explain_procedure,
+		EXPLAIN_STRATEGY_FQ_PROCEDURE: p._run_fully_qualified_explain_procedure,
+		EXPLAIN_STRATEGY_STATEMENT:    p._run_explain,
 	}
-	p._preferred_explain_strategies = []string{"PROCEDURE", "FQ_PROCEDURE", "STATEMENT"}
+	p._preferred_explain_strategies = []string{EXPLAIN_STRATEGY_PROCEDURE, EXPLAIN_STRATEGY_FQ_PROCEDURE, EXPLAIN_STRATEGY_STATEMENT}
 	p._init_caches()
-	p._statement_samples_client = _new_statement_samples_client()
 
 	return p
 }
 
+// MySQLStatementSamples collects samples of the statements from the
+// performance_schema.events_statements_* tables, with their execution plans.
+// The collection runs in the background unless run_sync is set, at the rate of
+// collections_per_second, and stops when the check is not run anymore
 type MySQLStatementSamples struct {
 	*Check
+	sync.Mutex
 	_version_processed                  bool
-	_connection_args                    bool
-	_checkpoint                         interface{}
-	_last_check_run                     interface{}
-	_tags                               interface{}
-	_tags_str                           interface{}
+	_checkpoint                         int64 // timer_start of the last collected statement
+	_last_check_run                     time.Time
+	_tags                               []string
+	_sender                             aggregator.Sender
 	_service                            string
-	_collection_loop_future             interface{}
-	_cancel_event                       interface{}
-	_rate_limiter                       interface{}
+	_collection_loop_running            bool
+	_cancel_event                       context.CancelFunc
+	_rate_limiter                       *db.ConstantRateLimiter
+	_executor                           Executor
+	_obfuscator                         *obfuscate.Obfuscator
 	_db_hostname                        string
 	_enabled                            bool
 	_run_sync                           bool
-	_collections_per_second             int
+	_collections_per_second             float64
 	_events_statements_row_limit        int
 	_explain_procedure                  string
 	_fully_qualified_explain_procedure  string
 	_events_statements_temp_table       string
 	_events_statements_enable_procedure string
 	_preferred_events_statements_tables []string
-	_has_window_functions               interface{}
-	_explain_strategies                 interface{}
-	_preferred_explain_strategies       interface{}
-	_statement_samples_client           interface{}
+	_has_window_functions               bool
+	_global_status_table                string
+	_explain_strategies                 map[string]func(statement, obfuscated_statement string) (string, error)
+	_preferred_explain_strategies       []string
+	_collection_strategy_cache          *db.TTLCache
+	_explained_statements_cache         *db.RateLimitingTTLCache
+	_seen_samples_cache                 *db.RateLimitingTTLCache
 }
 
-// TODO
+// eventsStatementsStrategy is the events_statements table to read from, and
+// the collection rate of this table
+type eventsStatementsStrategy struct {
+	table      string
+	rate_limit float64
+}
+
+// statementSample is the text of a statement sample event
+type statementSample struct {
+	Timestamp float64                `json:"timestamp"` // milliseconds
+	Host      string                 `json:"host"`
+	Service   string                 `json:"service"`
+	Source    string                 `json:"source"`
+	Tags      string                 `json:"tags"`
+	Duration  float64                `json:"duration"` // nanoseconds
+	Network   statementSampleNetwork `json:"network"`
+	DB        statementSampleDB      `json:"db"`
+	MySQL     map[string]interface{} `json:"mysql"`
+}
+
+type statementSampleNetwork struct {
+	Client struct {
+		IP string `json:"ip,omitempty"`
+	} `json:"client"`
+}
+
+type statementSampleDB struct {
+	Instance       string              `json:"instance"`
+	Plan           statementSamplePlan `json:"plan"`
+	QuerySignature string              `json:"query_signature"`
+	ResourceHash   string              `json:"resource_hash"`
+	Statement      string              `json:"statement"`
+}
+
+type statementSamplePlan struct {
+	Definition string   `json:"definition,omitempty"`
+	Cost       *float64 `json:"cost,omitempty"`
+	Signature  string   `json:"signature,omitempty"`
+}
+
+// run_sampler is called by each run of the check, it collects the samples if
+// run_sync is set, or makes sure the collection loop is running
 func (p *MySQLStatementSamples) run_sampler(tags []string) {
-	klog.Warningf("unsupported statement samples")
-}
-func (p *MySQLStatementSamples) _init_caches()                              {}
-func (p *MySQLStatementSamples) cancel()                                    {}
-func (p *MySQLStatementSamples) _get_db_connection()                        {}
-func (p *MySQLStatementSamples) _close_db_conn()                            {}
-func (p *MySQLStatementSamples) collection_loop()                           {}
-func (p *MySQLStatementSamples) _cursor_run()                               {}
-func (p *MySQLStatementSamples) _get_new_events_statements()                {}
-func (p *MySQLStatementSamples) _filter_valid_statement_rows()              {}
-func (p *MySQLStatementSamples) _collect_plan_for_statement()               {}
-func (p *MySQLStatementSamples) _collect_plans_for_statements()             {}
-func (p *MySQLStatementSamples) _get_enabled_performance_schema_consumers() {}
-func (p *MySQLStatementSamples) _enable_events_statements_consumers()       {}
-func (p *MySQLStatementSamples) _get_sample_collection_strategy()           {}
-func (p *MySQLStatementSamples) _collect_statement_samples()                {}
-func (p *MySQLStatementSamples) _explain_statement()                        {}
-func (p *MySQLStatementSamples) _run_explain()                              {}
-func (p *MySQLStatementSamples) _run_explain_procedure()                    {}
-func (p *MySQLStatementSamples) _run_fully_qualified_explain_procedure()    {}
-func (p *MySQLStatementSamples) _can_explain()                              {}
-func (p *MySQLStatementSamples) _parse_execution_plan_cost()                {}
+	if !p._enabled {
+		klog.V(6).Infof("Statement sampler not enabled")
+		return
+	}
+
+	p.Lock()
+	p._last_check_run = time.Now()
+	p._tags = tags
+	p._sender = p.sender
+	if !p._version_processed && p.version.version != "" {
+		p._has_window_functions = p.version.versionCompatible(8, 0, 0)
+		if p.version.versionCompatible(5, 7, 0) {
+			p._global_status_table = "performance_schema.global_status"
+		} else {
+			p._global_status_table = "information_schema.global_status"
+		}
+		p._version_processed = true
+	}
+	ready := p._version_processed
+	p.Unlock()
+
+	if !ready {
+		klog.V(5).Infof("Statement sampler waits for the mysql version")
+		return
+	}
+
+	if p._run_sync {
+		klog.V(6).Infof("Running statement sampler synchronously")
+		p._collect_statement_samples()
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if p._collection_loop_running {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p._cancel_event = cancel
+	p._collection_loop_running = true
+	go p.collection_loop(ctx)
+}
+
+func (p *MySQLStatementSamples) _init_caches() {
+	cfg := p.config.StatementSamples
+
+	p._collection_strategy_cache = db.NewTTLCache(
+		cfg.CollectionStrategyCacheMaxsize,
+		time.Duration(cfg.CollectionStrategyCacheTtl)*time.Second,
+	)
+
+	// explained_statements_cache: limit how often we try to re-explain the same query
+	p._explained_statements_cache = db.NewRateLimitingTTLCache(
+		cfg.ExplainedStatementsCacheMaxsize,
+		perHourTTL(cfg.ExplainedStatementsPerHourPerQuery),
+	)
+
+	// seen_samples_cache: limit the ingestion rate per (query_signature, plan_signature)
+	p._seen_samples_cache = db.NewRateLimitingTTLCache(
+		cfg.SeenSamplesCacheMaxsize,
+		perHourTTL(cfg.SamplesPerHourPerQuery),
+	)
+}
+
+func perHourTTL(per_hour int) time.Duration {
+	if per_hour <= 0 {
+		return time.Hour
+	}
+	return time.Hour / time.Duration(per_hour)
+}
+
+func (p *MySQLStatementSamples) cancel() {
+	p.Lock()
+	defer p.Unlock()
+
+	if p._cancel_event != nil {
+		p._cancel_event()
+		p._cancel_event = nil
+	}
+
+	// the loop closes its connection when it stops
+	if !p._collection_loop_running {
+		p._close_db_conn()
+	}
+}
+
+func (p *MySQLStatementSamples) _get_db_connection() (Executor, error) {
+	if p._executor == nil {
+		executor, err := newConnExecutor(p.config.Dsn)
+		if err != nil {
+			return nil, err
+		}
+		p._executor = executor
+	}
+	return p._executor, nil
+}
+
+func (p *MySQLStatementSamples) _close_db_conn() {
+	if p._executor != nil {
+		if err := p._executor.Close(); err != nil {
+			klog.V(5).Infof("Failed to close the statement sampler connection: %s", err)
+		}
+		p._executor = nil
+	}
+}
+
+func (p *MySQLStatementSamples) collection_loop(ctx context.Context) {
+	defer func() {
+		p._close_db_conn()
+
+		p.Lock()
+		p._collection_loop_running = false
+		p.Unlock()
+	}()
+
+	inactive_stop := 2 * p.Interval()
+	if inactive_stop <= 0 {
+		inactive_stop = 2 * defaults.DefaultCheckInterval
+	}
+
+	klog.V(5).Infof("Starting statement sampler collection loop")
+	for {
+		p.Lock()
+		last_check_run := p._last_check_run
+		p.Unlock()
+
+		if time.Since(last_check_run) > inactive_stop {
+			klog.V(5).Infof("Stopping statement sampler collection loop due to check inactivity")
+			return
+		}
+
+		p._collect_statement_samples_recover()
+
+		if !p._rate_limiter.Sleep(ctx) {
+			klog.V(5).Infof("Statement sampler collection loop cancelled")
+			return
+		}
+	}
+}
+
+// _collect_statement_samples_recover keeps the loop running if a collection
+// panics, e.g. on a column of an unexpected type, as Run does for the
+// synchronous collections
+func (p *MySQLStatementSamples) _collect_statement_samples_recover() {
+	defer func() {
+		if e := recover(); e != nil {
+			klog.Errorf("Statement sampler collection failed: %v", e)
+			p._count_error("collection-loop-crash", 1)
+		}
+	}()
+
+	p._collect_statement_samples()
+}
+
+func (p *MySQLStatementSamples) tags() []string {
+	p.Lock()
+	defer p.Unlock()
+	return p._tags
+}
+
+func (p *MySQLStatementSamples) sender_() aggregator.Sender {
+	p.Lock()
+	defer p.Unlock()
+	return p._sender
+}
+
+func (p *MySQLStatementSamples) _count_error(error_tag string, value float64) {
+	tags := append(append([]string{}, p.tags()...), "error:"+error_tag)
+	p.sender_().Count("mysql.statement_samples.error", value, "", tags)
+}
+
+func (p *MySQLStatementSamples) _count_db_error(err error) {
+	tags := append(append([]string{}, p.tags()...), "error:"+errorCode(err))
+	p.sender_().Count("mysql.statement_samples.db.error", 1, "", tags)
+}
+
+func (p *MySQLStatementSamples) _cursor_run(query string, args ...interface{}) error {
+	executor, err := p._get_db_connection()
+	if err != nil {
+		return err
+	}
+
+	if err := executor.Exec(query, args...); err != nil {
+		p._count_db_error(err)
+		return err
+	}
+
+	return nil
+}
+
+func (p *MySQLStatementSamples) _cursor_query(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	executor, err := p._get_db_connection()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := executor.QueryMapRows(query, args...)
+	if err != nil {
+		p._count_db_error(err)
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// errorCode returns the mysql error number, or the type of err
+func errorCode(err error) string {
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		return strconv.Itoa(int(me.Number))
+	}
+	return fmt.Sprintf("%T", err)
+}
+
+func (p *MySQLStatementSamples) _get_new_events_statements(events_statements_table string, row_limit int) ([]map[string]interface{}, error) {
+	// Select the most recent events with a bias towards events which have higher wait times
+	start := time.Now()
+	drop_temp_table_query := "DROP TEMPORARY TABLE IF EXISTS " + p._events_statements_temp_table
+	checkpoint := strconv.FormatInt(p._checkpoint, 10)
+	limit := strconv.Itoa(row_limit)
+
+	// silence expected warnings to avoid spam
+	if err := p._cursor_run("SET @@SESSION.sql_notes = 0"); err != nil {
+		return nil, err
+	}
+
+	if err := p._cursor_run(drop_temp_table_query); err != nil {
+		return nil, err
+	}
+
+	create_temp_table := strings.NewReplacer(
+		"{temp_table}", p._events_statements_temp_table,
+		"{statements_table}", "performance_schema."+events_statements_table,
+	).Replace(CREATE_TEMP_TABLE)
+	if err := p._cursor_run(fmt.Sprintf(create_temp_table, checkpoint, limit)); err != nil {
+		p._count_error("create-temp-table-"+events_statements_table, 1)
+		return nil, err
+	}
+
+	var sub_select string
+	if p._has_window_functions {
+		sub_select = SUB_SELECT_EVENTS_WINDOW
+	} else {
+		if err := p._cursor_run("set @row_num = 0"); err != nil {
+			return nil, err
+		}
+		if err := p._cursor_run("set @current_digest = ''"); err != nil {
+			return nil, err
+		}
+		sub_select = SUB_SELECT_EVENTS_NUMBERED
+	}
+
+	startup_time := strings.Replace(STARTUP_TIME_SUBQUERY, "{global_status_table}", p._global_status_table, 1)
+	if err := p._cursor_run("set @startup_time_s = " + startup_time); err != nil {
+		return nil, err
+	}
+
+	query := strings.Replace(EVENTS_STATEMENTS_QUERY, "{statements_numbered}",
+		strings.Replace(sub_select, "{statements_table}", p._events_statements_temp_table, 1), 1)
+	rows, err := p._cursor_query(fmt.Sprintf(query, checkpoint, limit))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p._cursor_run(drop_temp_table_query); err != nil {
+		return nil, err
+	}
+
+	tags := append(append([]string{}, p.tags()...), "events_statements_table:"+events_statements_table)
+	p.sender_().Histogram("mysql.statement_samples.get_new_events_statements.time", float64(time.Since(start).Milliseconds()), "", tags)
+	p.sender_().Histogram("mysql.statement_samples.get_new_events_statements.rows", float64(len(rows)), "", tags)
+	klog.V(6).Infof("Read %d rows from %s", len(rows), events_statements_table)
+
+	return rows, nil
+}
+
+func (p *MySQLStatementSamples) _filter_valid_statement_rows(rows []map[string]interface{}) []map[string]interface{} {
+	num_truncated := 0
+	ret := []map[string]interface{}{}
+
+	for _, row := range rows {
+		sql_text := String(row["sql_text"])
+		if sql_text == "" {
+			continue
+		}
+
+		// The SQL_TEXT column will store 1024 chars by default. Plans cannot be captured on truncated
+		// queries, so the `performance_schema_max_sql_text_length` variable must be raised.
+		if strings.HasSuffix(sql_text, "...") {
+			num_truncated++
+			continue
+		}
+
+		ret = append(ret, row)
+
+		// only save the checkpoint for rows that we have successfully processed
+		// else rows that we ignore can push the checkpoint forward causing us to miss some on the next run
+		if timer_start := Int(row["timer_start"]); timer_start > p._checkpoint {
+			p._checkpoint = timer_start
+		}
+	}
+
+	if num_truncated > 0 {
+		klog.Warningf("Unable to collect %d/%d statement samples due to truncated SQL text. Consider raising "+
+			"`performance_schema_max_sql_text_length` to capture these queries.", num_truncated, num_truncated+len(ret))
+		p._count_error("truncated-sql-text", float64(num_truncated))
+	}
+
+	return ret
+}
+
+func (p *MySQLStatementSamples) _collect_plan_for_statement(row map[string]interface{}) (*db.StatementSampleEvent, bool) {
+	obfuscated, err := p._obfuscator.ObfuscateSQLString(String(row["sql_text"]))
+	if err != nil {
+		klog.V(5).Infof("Failed to obfuscate statement: %s", err)
+		p._count_error("sql-obfuscate", 1)
+		return nil, false
+	}
+	obfuscated_statement := obfuscated.Query
+
+	apm_resource_hash := computeSignature(obfuscated_statement)
+	query_signature := apm_resource_hash
+	if digest, err := p._obfuscator.ObfuscateSQLString(String(row["digest_text"])); err == nil {
+		query_signature = computeSignature(digest.Query)
+	}
+
+	schema := String(row["current_schema"])
+	query_cache_key := schema + "|" + query_signature
+	if !p._explained_statements_cache.Acquire(query_cache_key) {
+		return nil, false
+	}
+
+	plan, err := p._explain_statement(String(row["sql_text"]), schema, obfuscated_statement)
+	if err != nil {
+		klog.V(5).Infof("Failed to explain statement %s: %s", obfuscated_statement, err)
+		p._count_error("explain-"+errorCode(err), 1)
+	}
+
+	var sample_plan statementSamplePlan
+	if plan != "" {
+		normalized_plan, err := p._obfuscator.ObfuscateSQLExecPlan(plan, true)
+		if err != nil {
+			klog.V(5).Infof("Failed to normalize the plan of %s: %s", obfuscated_statement, err)
+		}
+		obfuscated_plan, err := p._obfuscator.ObfuscateSQLExecPlan(plan, false)
+		if err != nil {
+			klog.V(5).Infof("Failed to obfuscate the plan of %s: %s", obfuscated_statement, err)
+		}
+
+		sample_plan.Definition = obfuscated_plan
+		if normalized_plan != "" {
+			sample_plan.Signature = computeSignature(normalized_plan)
+		}
+		sample_plan.Cost = p._parse_execution_plan_cost(plan)
+	}
+
+	if !p._seen_samples_cache.Acquire(query_cache_key + "|" + sample_plan.Signature) {
+		return nil, false
+	}
+
+	timer_end_time_s := Float(row["timer_end_time_s"])
+	sample := &statementSample{
+		Timestamp: timer_end_time_s * 1000,
+		Host:      p._db_hostname,
+		Service:   p._service,
+		Source:    "mysql",
+		Tags:      strings.Join(p.tags(), ","),
+		Duration:  Float(row["timer_wait_ns"]),
+		DB: statementSampleDB{
+			Instance:       schema,
+			Plan:           sample_plan,
+			QuerySignature: query_signature,
+			ResourceHash:   apm_resource_hash,
+			Statement:      obfuscated_statement,
+		},
+		MySQL: map[string]interface{}{},
+	}
+	sample.Network.Client.IP = String(row["processlist_host"])
+	for k, v := range row {
+		if !EVENTS_STATEMENTS_SAMPLE_EXCLUDE_KEYS.has(k) {
+			sample.MySQL[k] = v
+		}
+	}
+
+	return &db.StatementSampleEvent{
+		Timestamp:      time.Unix(0, int64(timer_end_time_s*float64(time.Second))),
+		Host:           p._db_hostname,
+		QuerySignature: query_signature,
+		Statement:      obfuscated_statement,
+		Tags:           append(append([]string{}, p.tags()...), "query_signature:"+query_signature),
+		Sample:         sample,
+	}, true
+}
+
+func (p *MySQLStatementSamples) _collect_plans_for_statements(rows []map[string]interface{}) []db.StatementSampleEvent {
+	events := []db.StatementSampleEvent{}
+	for _, row := range rows {
+		if event, ok := p._collect_plan_for_statement(row); ok {
+			events = append(events, *event)
+		}
+	}
+	return events
+}
+
+func (p *MySQLStatementSamples) _get_enabled_performance_schema_consumers() (frozenset, error) {
+	rows, err := p._cursor_query(ENABLED_STATEMENTS_CONSUMERS_QUERY)
+	if err != nil {
+		return nil, err
+	}
+
+	consumers := frozenset{}
+	for _, row := range rows {
+		consumers = append(consumers, String(row["name"]))
+	}
+	return consumers, nil
+}
+
+func (p *MySQLStatementSamples) _enable_events_statements_consumers() {
+	// Enable events statements consumers
+	if err := p._cursor_run("CALL " + p._events_statements_enable_procedure + "()"); err != nil {
+		klog.V(5).Infof("Failed to enable events_statements consumers using procedure=%s: %s", p._events_statements_enable_procedure, err)
+	}
+}
+
+// _get_sample_collection_strategy decides which events_statements table to
+// read from, and the rate of the collection
+func (p *MySQLStatementSamples) _get_sample_collection_strategy() (*eventsStatementsStrategy, error) {
+	if cached, ok := p._collection_strategy_cache.Get("events_statements_strategy"); ok {
+		klog.V(6).Infof("Using cached events_statements_strategy: %+v", cached)
+		return cached.(*eventsStatementsStrategy), nil
+	}
+
+	enabled_consumers, err := p._get_enabled_performance_schema_consumers()
+	if err != nil {
+		return nil, err
+	}
+	if len(enabled_consumers) < 3 {
+		p._enable_events_statements_consumers()
+		if enabled_consumers, err = p._get_enabled_performance_schema_consumers(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(enabled_consumers) == 0 {
+		return nil, fmt.Errorf("cannot collect statement samples as there are no enabled performance_schema.events_statements_* consumers, " +
+			"enable performance_schema and at least one events_statements consumer")
+	}
+
+	var events_statements_table string
+	for _, table := range p._preferred_events_statements_tables {
+		if !enabled_consumers.has(table) {
+			klog.V(6).Infof("performance_schema.%s is not enabled", table)
+			continue
+		}
+		rows, err := p._get_new_events_statements(table, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			klog.V(6).Infof("No statements found in %s table. checking next one.", table)
+			continue
+		}
+		events_statements_table = table
+		break
+	}
+
+	if events_statements_table == "" {
+		return nil, fmt.Errorf("cannot collect statement samples as all enabled events_statements_consumers %v are empty", enabled_consumers)
+	}
+
+	rate_limit := p._collections_per_second
+	if rate_limit < 0 {
+		rate_limit = DEFAULT_EVENTS_STATEMENTS_COLLECTIONS_PER_SECOND[events_statements_table]
+	}
+
+	// cache only successful strategies
+	// should be short enough that we'll reflect updates relatively quickly
+	// i.e., an aurora replica becomes a master (or vice versa).
+	strategy := &eventsStatementsStrategy{table: events_statements_table, rate_limit: rate_limit}
+	klog.V(5).Infof("Chose plan collection strategy: events_statements_table=%s, collections_per_sec=%v", strategy.table, strategy.rate_limit)
+	p._collection_strategy_cache.Set("events_statements_strategy", strategy)
+
+	return strategy, nil
+}
+
+func (p *MySQLStatementSamples) _collect_statement_samples() {
+	start := time.Now()
+
+	strategy, err := p._get_sample_collection_strategy()
+	if err != nil {
+		klog.Warningf("statement samples: %s", err)
+		p._close_db_conn()
+		return
+	}
+
+	if p._rate_limiter.RateLimit() != strategy.rate_limit {
+		p._rate_limiter = db.NewConstantRateLimiter(strategy.rate_limit)
+	}
+
+	rows, err := p._get_new_events_statements(strategy.table, p._events_statements_row_limit)
+	if err != nil {
+		klog.Warningf("statement samples: read %s: %s", strategy.table, err)
+		p._close_db_conn()
+		return
+	}
+
+	rows = p._filter_valid_statement_rows(rows)
+	events := p._collect_plans_for_statements(rows)
+	submitted_count, failed_count := db.StatementSamplesClient.SubmitEvents(p.sender_(), "mysql", events)
+
+	tags := append(append([]string{}, p.tags()...), "events_statements_table:"+strategy.table)
+	p.sender_().Count("mysql.statement_samples.events_submitted.count", float64(submitted_count), "", tags)
+	if failed_count > 0 {
+		p._count_error("submit-events", float64(failed_count))
+	}
+	p.sender_().Histogram("mysql.statement_samples.collect_statement_samples.time", float64(time.Since(start).Milliseconds()), "", tags)
+	p.sender_().Gauge("mysql.statement_samples.seen_samples_cache.len", float64(p._seen_samples_cache.Len()), "", tags)
+	p.sender_().Gauge("mysql.statement_samples.explained_statements_cache.len", float64(p._explained_statements_cache.Len()), "", tags)
+}
+
+// _explain_statement tries the explain strategies until one returns a plan
+func (p *MySQLStatementSamples) _explain_statement(statement, schema, obfuscated_statement string) (string, error) {
+	if !p._can_explain(obfuscated_statement) {
+		klog.V(6).Infof("Skipping statement which cannot be explained: %s", obfuscated_statement)
+		return "", nil
+	}
+
+	strategy_cache_key := "explain_strategy:" + schema
+	cached, _ := p._collection_strategy_cache.Get(strategy_cache_key)
+	if cached == EXPLAIN_STRATEGY_ERROR {
+		klog.V(6).Infof("Skipping EXPLAIN, cached strategy error for schema %q", schema)
+		return "", nil
+	}
+
+	if schema != "" {
+		if err := p._cursor_run("USE `" + strings.Replace(schema, "`", "``", -1) + "`"); err != nil {
+			p._collection_strategy_cache.Set(strategy_cache_key, EXPLAIN_STRATEGY_ERROR)
+			p._count_error("explain-use-schema", 1)
+			klog.V(5).Infof("Cannot collect execution plan because %s schema could not be accessed: %s", schema, err)
+			return "", nil
+		}
+	}
+
+	// Use a cached strategy for the schema, if any, or try each strategy to collect plans
+	strategies := p._preferred_explain_strategies
+	if s, ok := cached.(string); ok {
+		strategies = []string{s}
+		for _, v := range p._preferred_explain_strategies {
+			if v != s {
+				strategies = append(strategies, v)
+			}
+		}
+	}
+
+	for _, strategy := range strategies {
+		if schema == "" && strategy == EXPLAIN_STRATEGY_PROCEDURE {
+			klog.V(6).Infof("skipping PROCEDURE strategy as there is no default schema for this statement")
+			continue
+		}
+
+		plan, err := p._explain_strategies[strategy](statement, obfuscated_statement)
+		if err != nil {
+			var me *mysql.MySQLError
+			if !errors.As(err, &me) {
+				return "", err
+			}
+			if NON_RETRYABLE_ERRORS.has(int(me.Number)) {
+				p._collection_strategy_cache.Set(strategy_cache_key, EXPLAIN_STRATEGY_ERROR)
+			}
+			p._count_error(fmt.Sprintf("explain-%s-%d", strategy, me.Number), 1)
+			klog.V(6).Infof("Failed to collect execution plan with strategy %s: %s", strategy, err)
+			continue
+		}
+
+		if plan != "" {
+			p._collection_strategy_cache.Set(strategy_cache_key, strategy)
+			klog.V(6).Infof("Successfully collected execution plan. strategy=%s, schema=%s", strategy, schema)
+			return plan, nil
+		}
+	}
+
+	return "", nil
+}
+
+func (p *MySQLStatementSamples) _explain_value(query string, args ...interface{}) (string, error) {
+	rows, err := p._cursor_query(query, args...)
+	if err != nil {
+		return "", err
+	}
+
+	// the plan is the single column of the first row
+	if len(rows) > 0 && len(rows[0]) == 1 {
+		for _, v := range rows[0] {
+			return String(v), nil
+		}
+	}
+	return "", nil
+}
+
+func (p *MySQLStatementSamples) _run_explain(statement, obfuscated_statement string) (string, error) {
+	klog.V(6).Infof("collecting plan. strategy=STATEMENT, statement='%s'", obfuscated_statement)
+	return p._explain_value("EXPLAIN FORMAT=json " + statement)
+}
+
+func (p *MySQLStatementSamples) _run_explain_procedure(statement, obfuscated_statement string) (string, error) {
+	klog.V(6).Infof("collecting plan. strategy=PROCEDURE, statement='%s'", obfuscated_statement)
+	return p._explain_value("CALL "+p._explain_procedure+"(?)", statement)
+}
+
+func (p *MySQLStatementSamples) _run_fully_qualified_explain_procedure(statement, obfuscated_statement string) (string, error) {
+	klog.V(6).Infof("collecting plan. strategy=FQ_PROCEDURE, statement='%s'", obfuscated_statement)
+	return p._explain_value("CALL "+p._fully_qualified_explain_procedure+"(?)", statement)
+}
+
+func (p *MySQLStatementSamples) _can_explain(obfuscated_statement string) bool {
+	// TODO: cleanup and factor out into a statement_samples util package
+	return VALID_EXPLAIN_STATEMENTS.has(strings.ToLower(strings.SplitN(obfuscated_statement, " ", 2)[0]))
+}
+
+func (p *MySQLStatementSamples) _parse_execution_plan_cost(execution_plan string) *float64 {
+	// Parses the total cost from the execution plan, if set. If not set, returns nil.
+	var plan struct {
+		QueryBlock struct {
+			CostInfo struct {
+				QueryCost string `json:"query_cost"`
+			} `json:"cost_info"`
+		} `json:"query_block"`
+	}
+	if err := json.Unmarshal([]byte(execution_plan), &plan); err != nil {
+		return nil
+	}
+
+	cost, err := strconv.ParseFloat(plan.QueryBlock.CostInfo.QueryCost, 64)
+	if err != nil {
+		return nil
+	}
+	return &cost
+}
+
+// computeSignature returns the signature of a normalized statement or plan
+func computeSignature(s string) string {
+	return fmt.Sprintf("%x", hash64(s))
+}
@@ -0,0 +1,340 @@
+package mysql
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/n9e/n9e-agentd/pkg/util/capturesender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeResponse struct {
+	match string // substring of the query
+	rows  []map[string]interface{}
+	err   error
+}
+
+// fakeExecutor answers with the first response matching the query
+type fakeExecutor struct {
+	responses []fakeResponse
+	queries   []string
+}
+
+func (p *fakeExecutor) response(query string) fakeResponse {
+	p.queries = append(p.queries, query)
+	for _, r := range p.responses {
+		if strings.Contains(query, r.match) {
+			return r
+		}
+	}
+	return fakeResponse{}
+}
+
+func (p *fakeExecutor) Exec(query string, args ...interface{}) error {
+	return p.response(query).err
+}
+
+func (p *fakeExecutor) QueryMapRows(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	r := p.response(query)
+	return r.rows, r.err
+}
+
+func (p *fakeExecutor) Close() error { return nil }
+
+func (p *fakeExecutor) called(match string) int {
+	n := 0
+	for _, q := range p.queries {
+		if strings.Contains(q, match) {
+			n++
+		}
+	}
+	return n
+}
+
+const testPlan = `{"query_block": {"select_id": 1, "cost_info": {"query_cost": "1.20"}, "table": {"table_name": "users", "attached_condition": "(id = 42)"}}}`
+
+func eventRow(sqlText string, timerStart int64) map[string]interface{} {
+	return map[string]interface{}{
+		"current_schema":   "app",
+		"sql_text":         sqlText,
+		"digest":           "d1",
+		"digest_text":      "SELECT * FROM users WHERE id = ?",
+		"timer_start":      timerStart,
+		"timer_end_time_s": "1600000000.5",
+		"timer_wait_ns":    "12000.0",
+		"rows_sent":        "1",
+		"processlist_host": "10.0.0.1",
+	}
+}
+
+func consumersRows(names ...string) []map[string]interface{} {
+	rows := []map[string]interface{}{}
+	for _, name := range names {
+		rows = append(rows, map[string]interface{}{"name": name})
+	}
+	return rows
+}
+
+func newTestSampler(t *testing.T, instance string, executor Executor) (*MySQLStatementSamples, *capturesender.CaptureSender) {
+	cfg, err := buildConfig([]byte("dsn: user:pass@tcp(127.0.0.1:3306)/\n"+instance), nil)
+	require.NoError(t, err)
+
+	sender := capturesender.New()
+	c := &Check{config: cfg, sender: sender}
+	c.version.version = "8.0.25"
+
+	p := NewMySQLStatementSamples(c)
+	p._executor = executor
+	p._sender = sender
+	return p, sender
+}
+
+func TestStatementSamples(t *testing.T) {
+	executor := &fakeExecutor{responses: []fakeResponse{
+		{match: "setup_consumers", rows: consumersRows("events_statements_current", "events_statements_history", "events_statements_history_long")},
+		{match: "row_num = 1", rows: []map[string]interface{}{
+			eventRow("SELECT * FROM users WHERE id = 42", 100),
+			eventRow("SELECT * FROM users WHERE id = 43", 200),
+			eventRow("SELECT * FROM users WHERE name = 'a very long...", 300),
+		}},
+		{match: "CALL explain_statement(?)", rows: []map[string]interface{}{{"plan": testPlan}}},
+	}}
+
+	p, sender := newTestSampler(t, "statement_samples:\n  enabled: true\n  run_sync: true\n", executor)
+	p.run_sampler([]string{"server:127.0.0.1"})
+
+	// the two statements share a digest, the second one is not explained
+	require.Len(t, sender.Events, 1)
+	e := sender.Events[0]
+	assert.Equal(t, "SELECT * FROM users WHERE id = ?", e.Title)
+	assert.Equal(t, "mysql", e.SourceTypeName)
+	assert.Equal(t, int64(1600000000), e.Ts)
+	assert.Contains(t, e.Tags, "server:127.0.0.1")
+
+	sample := statementSample{}
+	require.NoError(t, json.Unmarshal([]byte(e.Text), &sample))
+	assert.Equal(t, "app", sample.DB.Instance)
+	assert.Equal(t, "SELECT * FROM users WHERE id = ?", sample.DB.Statement)
+	assert.NotContains(t, sample.DB.Plan.Definition, "42")
+	assert.NotEmpty(t, sample.DB.Plan.Signature)
+	require.NotNil(t, sample.DB.Plan.Cost)
+	assert.Equal(t, 1.2, *sample.DB.Plan.Cost)
+	assert.Equal(t, "10.0.0.1", sample.Network.Client.IP)
+	assert.Equal(t, "1", sample.MySQL["rows_sent"])
+	assert.NotContains(t, sample.MySQL, "sql_text")
+
+	// the checkpoint skips the truncated statement
+	assert.Equal(t, int64(200), p._checkpoint)
+	assert.Contains(t, sender.Metrics, capturesender.Metric{
+		Type: "count", Name: "mysql.statement_samples.error", Value: 1,
+		Tags: []string{"server:127.0.0.1", "error:truncated-sql-text"},
+	})
+
+	// the preferred table is used, at its default rate
+	assert.Equal(t, 0.1, p._rate_limiter.RateLimit())
+	assert.NotZero(t, executor.called("FROM performance_schema.events_statements_history_long"))
+	assert.NotZero(t, executor.called("USE `app`"))
+	assert.Zero(t, executor.called("enable_events_statements_consumers"))
+
+	// the collection strategy is cached
+	n := executor.called("setup_consumers")
+	p.run_sampler([]string{"server:127.0.0.1"})
+	assert.Equal(t, n, executor.called("setup_consumers"))
+}
+
+func TestStatementSamplesLoopRecover(t *testing.T) {
+	// the driver returns the unsigned columns as uint64, Int panics
+	row := eventRow("SELECT * FROM users WHERE id = 42", 100)
+	row["timer_start"] = uint64(100)
+	executor := &fakeExecutor{responses: []fakeResponse{
+		{match: "setup_consumers", rows: consumersRows("events_statements_current", "events_statements_history", "events_statements_history_long")},
+		{match: "row_num = 1", rows: []map[string]interface{}{row}},
+	}}
+
+	p, sender := newTestSampler(t, "statement_samples:\n  enabled: true\n  collections_per_second: 100\n", executor)
+	p.run_sampler([]string{"server:127.0.0.1"})
+
+	// the loop keeps running after the panics
+	time.Sleep(100 * time.Millisecond)
+	p.cancel()
+	require.Eventually(t, func() bool {
+		p.Lock()
+		defer p.Unlock()
+		return !p._collection_loop_running
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Greater(t, executor.called("row_num = 1"), 1)
+	assert.Contains(t, sender.Metrics, capturesender.Metric{
+		Type: "count", Name: "mysql.statement_samples.error", Value: 1,
+		Tags: []string{"server:127.0.0.1", "error:collection-loop-crash"},
+	})
+}
+
+func TestStatementSamplesStrategy(t *testing.T) {
+	cases := []struct {
+		name      string
+		instance  string
+		responses []fakeResponse
+		table     string
+		rate      float64
+		err       bool
+	}{{
+		name: "enable the consumers",
+		responses: []fakeResponse{
+			{match: "setup_consumers", rows: consumersRows("events_statements_current")},
+			{match: "row_num = 1", rows: []map[string]interface{}{eventRow("SELECT 1", 1)}},
+		},
+		table: "events_statements_current",
+		rate:  1,
+	}, {
+		name:     "configured table and rate",
+		instance: "  events_statements_table: events_statements_history\n  collections_per_second: 2\n",
+		responses: []fakeResponse{
+			{match: "setup_consumers", rows: consumersRows("events_statements_current", "events_statements_history", "events_statements_history_long")},
+			{match: "row_num = 1", rows: []map[string]interface{}{eventRow("SELECT 1", 1)}},
+		},
+		table: "events_statements_history",
+		rate:  2,
+	}, {
+		name: "no consumers",
+		responses: []fakeResponse{
+			{match: "setup_consumers", rows: consumersRows()},
+		},
+		err: true,
+	}, {
+		name: "empty tables",
+		responses: []fakeResponse{
+			{match: "setup_consumers", rows: consumersRows("events_statements_current", "events_statements_history", "events_statements_history_long")},
+		},
+		err: true,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			executor := &fakeExecutor{responses: c.responses}
+			p, _ := newTestSampler(t, "statement_samples:\n  enabled: true\n"+c.instance, executor)
+			p._has_window_functions = true
+
+			strategy, err := p._get_sample_collection_strategy()
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.table, strategy.table)
+			assert.Equal(t, c.rate, strategy.rate_limit)
+		})
+	}
+}
+
+func TestExplainStatement(t *testing.T) {
+	const statement = "SELECT * FROM users WHERE id = 42"
+	const obfuscated = "SELECT * FROM users WHERE id = ?"
+
+	t.Run("fall back and cache the strategy", func(t *testing.T) {
+		executor := &fakeExecutor{responses: []fakeResponse{
+			{match: "CALL explain_statement(?)", err: &mysql.MySQLError{Number: 1044, Message: "access denied"}},
+			{match: "CALL n9e.explain_statement(?)", rows: []map[string]interface{}{{"plan": testPlan}}},
+		}}
+		p, sender := newTestSampler(t, "", executor)
+
+		plan, err := p._explain_statement(statement, "app", obfuscated)
+		require.NoError(t, err)
+		assert.Equal(t, testPlan, plan)
+		assert.Contains(t, sender.Metrics, capturesender.Metric{
+			Type: "count", Name: "mysql.statement_samples.error", Value: 1,
+			Tags: []string{"error:explain-PROCEDURE-1044"},
+		})
+
+		cached, _ := p._collection_strategy_cache.Get("explain_strategy:app")
+		assert.Equal(t, EXPLAIN_STRATEGY_FQ_PROCEDURE, cached)
+	})
+
+	t.Run("non retryable errors disable the explain of the schema", func(t *testing.T) {
+		executor := &fakeExecutor{responses: []fakeResponse{
+			{match: "CALL", err: &mysql.MySQLError{Number: 1370, Message: "no execute on procedure"}},
+			{match: "EXPLAIN FORMAT=json", err: &mysql.MySQLError{Number: 1046, Message: "no permission"}},
+		}}
+		p, _ := newTestSampler(t, "", executor)
+
+		plan, err := p._explain_statement(statement, "app", obfuscated)
+		require.NoError(t, err)
+		assert.Empty(t, plan)
+
+		cached, _ := p._collection_strategy_cache.Get("explain_strategy:app")
+		assert.Equal(t, EXPLAIN_STRATEGY_ERROR, cached)
+
+		executor.queries = nil
+		_, err = p._explain_statement(statement, "app", obfuscated)
+		require.NoError(t, err)
+		assert.Empty(t, executor.queries)
+	})
+
+	t.Run("the successful strategy is tried first", func(t *testing.T) {
+		executor := &fakeExecutor{responses: []fakeResponse{
+			{match: "CALL explain_statement(?)", err: &mysql.MySQLError{Number: 1064, Message: "syntax error"}},
+			{match: "CALL n9e.explain_statement(?)", rows: []map[string]interface{}{}},
+			{match: "EXPLAIN FORMAT=json", rows: []map[string]interface{}{{"EXPLAIN": testPlan}}},
+		}}
+		p, _ := newTestSampler(t, "", executor)
+
+		plan, err := p._explain_statement(statement, "app", obfuscated)
+		require.NoError(t, err)
+		assert.Equal(t, testPlan, plan)
+
+		cached, _ := p._collection_strategy_cache.Get("explain_strategy:app")
+		assert.Equal(t, EXPLAIN_STRATEGY_STATEMENT, cached)
+
+		executor.queries = nil
+		_, err = p._explain_statement(statement, "app", obfuscated)
+		require.NoError(t, err)
+		assert.Zero(t, executor.called("CALL"))
+	})
+
+	t.Run("no procedure without schema", func(t *testing.T) {
+		executor := &fakeExecutor{responses: []fakeResponse{
+			{match: "CALL n9e.explain_statement(?)", rows: []map[string]interface{}{{"plan": testPlan}}},
+		}}
+		p, _ := newTestSampler(t, "", executor)
+
+		plan, err := p._explain_statement(statement, "", obfuscated)
+		require.NoError(t, err)
+		assert.Equal(t, testPlan, plan)
+		assert.Zero(t, executor.called("USE"))
+		assert.Zero(t, executor.called("CALL explain_statement"))
+	})
+
+	t.Run("statements which can not be explained", func(t *testing.T) {
+		executor := &fakeExecutor{}
+		p, _ := newTestSampler(t, "", executor)
+
+		plan, err := p._explain_statement("SHOW TABLES", "app", "SHOW TABLES")
+		require.NoError(t, err)
+		assert.Empty(t, plan)
+		assert.Empty(t, executor.queries)
+	})
+}
+
+func TestVersionCompatible(t *testing.T) {
+	cases := []struct {
+		version string
+		compat  []int
+		want    bool
+	}{
+		{"8.0.25", []int{8, 0, 0}, true},
+		{"8.0.25", []int{5, 7, 0}, true},
+		{"8.0.20", []int{8, 0, 22}, false},
+		{"5.7.31a", []int{5, 7, 31}, true},
+		{"5.6.51", []int{5, 7, 0}, false},
+		{"10.5.8", []int{10, 5, 1}, true},
+	}
+
+	for _, c := range cases {
+		v := MySQLVersion{version: c.version}
+		assert.Equal(t, c.want, v.versionCompatible(c.compat...), "%s >= %v", c.version, c.compat)
+	}
+}
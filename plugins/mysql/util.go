@@ -75,6 +75,8 @@ func Float(a interface{}) float64 {
 	switch v := a.(type) {
 	case float64:
 		return v
+	case int64:
+		return float64(v)
 	case *sql.RawBytes:
 		i, _ := strconv.ParseFloat(string(*v), 0)
 		return i
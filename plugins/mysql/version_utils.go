@@ -56,17 +56,21 @@ func (p *MySQLVersion) versionCompatible(compatVersion ...int) bool {
 	mysqlVersion := strings.Split(p.version, ".")
 	klog.V(5).Infof("MySQL version %v", mysqlVersion)
 
-	for i := 0; i < len(mysqlVersion[2]); i++ {
-		c := mysqlVersion[2][i]
-		if c < '0' || c > '9' {
-			mysqlVersion[2] = mysqlVersion[2][:i]
-			break
+	for i := 0; i < 3; i++ {
+		n := 0
+		if i < len(mysqlVersion) {
+			// strip the non-numeric suffix, e.g. 5.7.31a
+			v := mysqlVersion[i]
+			for j := 0; j < len(v); j++ {
+				if v[j] < '0' || v[j] > '9' {
+					v = v[:j]
+					break
+				}
+			}
+			n, _ = strconv.Atoi(v)
 		}
-	}
-
-	for i := 0; i < 2; i++ {
-		if n, _ := strconv.Atoi(mysqlVersion[i]); n < compatVersion[i] {
-			return false
+		if n != compatVersion[i] {
+			return n > compatVersion[i]
 		}
 	}
 
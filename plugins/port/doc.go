@@ -7,16 +7,33 @@ import (
 
 var langStrings = map[string]map[string]string{
 	"zh": map[string]string{
-		"proc.port.listen": "进程监听端口",
+		"proc.port.listen":           "进程监听端口",
+		"port.connect.latency":       "端口连接耗时(秒)",
+		"port.tls.success":           "TLS 握手是否成功",
+		"port.tls.handshake.latency": "TLS 握手耗时(秒)",
+		"port.tls.cert.expire_days":  "证书剩余有效天数",
+		"port.response.match":        "端口响应是否匹配",
 	},
 	"en": map[string]string{
-		"proc.port.listen": "Process listening port",
+		"proc.port.listen":           "Process listening port",
+		"port.connect.latency":       "Port connect latency(seconds)",
+		"port.tls.success":           "TLS handshake succeeded",
+		"port.tls.handshake.latency": "TLS handshake latency(seconds)",
+		"port.tls.cert.expire_days":  "Days to the certificate expiry",
+		"port.response.match":        "Port response matched",
 	},
 }
 
 func registerMetric() {
 	m := metrics.GetMetricGroup("process")
 	m.Register("proc.port.listen")
+
+	m = metrics.GetMetricGroup("port")
+	m.Register("port.connect.latency")
+	m.Register("port.tls.success")
+	m.Register("port.tls.handshake.latency")
+	m.Register("port.tls.cert.expire_days")
+	m.Register("port.response.match")
 }
 
 func init() {
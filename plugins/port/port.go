@@ -1,16 +1,20 @@
 package port
 
 import (
+	gotls "crypto/tls"
 	"fmt"
 	"net"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
 	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
 	"github.com/DataDog/datadog-agent/pkg/collector/check"
 	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
 	"github.com/n9e/n9e-agentd/pkg/util"
-	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/n9e/n9e-agentd/pkg/util/tls"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/yaml"
 )
@@ -22,11 +26,21 @@ type InitConfig struct {
 }
 
 type InstanceConfig struct {
-	Protocol   string `json:"protocol" description:"udp or tcp"`
-	Port       int    `json:"port"`
-	addrs      []string
-	timeout    time.Duration
-	InitConfig `json:"-"`
+	Protocol string `json:"protocol" description:"udp or tcp"`
+	Port     int    `json:"port"`
+	// Host is the remote target, a hostname, ipv4 or ipv6 address, the
+	// addresses of the local interfaces are checked if empty
+	Host          string           `json:"host"`
+	TLS           bool             `json:"tls" description:"tcp only, perform a tls handshake"`
+	TLSServerName string           `json:"tls_server_name" description:"default to host"`
+	TLSConfig     tls.ClientConfig `json:"tls_config"`
+	Send          string           `json:"send" description:"the payload sent after connecting, required by udp"`
+	Expect        string           `json:"expect" description:"the regexp the response must match"`
+	addrs         []string
+	timeout       time.Duration
+	tlsConfig     *gotls.Config
+	expectReg     *regexp.Regexp
+	InitConfig    `json:"-"`
 }
 
 type checkConfig struct {
@@ -58,16 +72,12 @@ func buildConfig(rawInstance integration.Data, rawInitConfig integration.Data) (
 		return checkConfig{}, err
 	}
 
-	ifAddrs, err := net.InterfaceAddrs()
-	if err != nil {
-		return checkConfig{}, err
-	}
-	for _, addr := range ifAddrs {
-		ip, _, err := net.ParseCIDR(addr.String())
-		if err != nil {
-			klog.Warningf("parse cidr %s %s", addr.String(), err)
+	if instance.Host != "" {
+		if err := instance.buildRemote(); err != nil {
+			return checkConfig{}, err
 		}
-		instance.addrs = append(instance.addrs, fmt.Sprintf("%s:%d", ip.String(), instance.Port))
+	} else if err := instance.buildLocal(); err != nil {
+		return checkConfig{}, err
 	}
 
 	if initConfig.Timeout <= 0 {
@@ -83,6 +93,66 @@ func buildConfig(rawInstance integration.Data, rawInitConfig integration.Data) (
 
 }
 
+func (p *InstanceConfig) buildLocal() error {
+	ifAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return err
+	}
+	for _, addr := range ifAddrs {
+		ip, _, err := net.ParseCIDR(addr.String())
+		if err != nil {
+			klog.Warningf("parse cidr %s %s", addr.String(), err)
+		}
+		p.addrs = append(p.addrs, net.JoinHostPort(ip.String(), strconv.Itoa(p.Port)))
+	}
+	return nil
+}
+
+func (p *InstanceConfig) buildRemote() error {
+	if p.Port <= 0 || p.Port > 65535 {
+		return fmt.Errorf("invalid port %d", p.Port)
+	}
+	p.addrs = []string{net.JoinHostPort(p.Host, strconv.Itoa(p.Port))}
+
+	switch p.Protocol {
+	case "tcp":
+	case "udp":
+		if p.Send == "" {
+			return fmt.Errorf("send is required to probe a remote udp port")
+		}
+		if p.TLS {
+			return fmt.Errorf("tls is not supported by udp")
+		}
+	default:
+		return fmt.Errorf("unsupported protocol %q", p.Protocol)
+	}
+
+	if p.TLS {
+		cfg, err := p.TLSConfig.TLSConfig()
+		if err != nil {
+			return err
+		}
+		if cfg == nil {
+			cfg = &gotls.Config{}
+		}
+		cfg.ServerName = p.TLSServerName
+		if cfg.ServerName == "" {
+			cfg.ServerName = p.Host
+		}
+		p.tlsConfig = cfg
+	}
+
+	if p.Expect != "" {
+		reg, err := regexp.Compile(p.Expect)
+		if err != nil {
+			return fmt.Errorf("compile expect %s: %s", p.Expect, err)
+		}
+		p.expectReg = reg
+	}
+
+	return nil
+}
+
 // Check doesn't need additional fields
 type Check struct {
 	core.CheckBase
@@ -97,6 +167,12 @@ func (c *Check) Run() error {
 		return err
 	}
 
+	if c.config.Host != "" {
+		c.probe().send(sender, c.tags)
+		sender.Commit()
+		return nil
+	}
+
 	value := 0
 	if ok := c.check(); ok {
 		value = 1
@@ -127,6 +203,9 @@ func (c *Check) Configure(rawInstance integration.Data, rawInitConfig integratio
 		fmt.Sprintf("port:%d", config.Port),
 		fmt.Sprintf("protocol:%s", config.Protocol),
 	}
+	if config.Host != "" {
+		c.tags = append(c.tags, "target_host:"+config.Host)
+	}
 	return nil
 }
 
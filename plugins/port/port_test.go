@@ -2,11 +2,19 @@ package port
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/n9e/n9e-agentd/pkg/config"
+	"github.com/n9e/n9e-agentd/pkg/util/capturesender"
 	"github.com/n9e/n9e-agentd/pkg/validation"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCheckTCP(t *testing.T) {
@@ -74,6 +82,9 @@ func listenTCP() net.Listener {
 }
 
 func TestConfig(t *testing.T) {
+	config.Mock()
+	aggregator.InitAggregatorWithFlushInterval(nil, nil, "", time.Hour)
+
 	cases := []struct {
 		config string
 		ok     bool
@@ -97,3 +108,167 @@ func TestConfig(t *testing.T) {
 		assert.Equal(t, c.ok, err == nil)
 	}
 }
+
+func newRemoteCheck(t *testing.T, config string) *Check {
+	c := &Check{}
+	cf, err := buildConfig([]byte(config), nil)
+	require.NoError(t, err)
+	c.config = cf
+	return c
+}
+
+func echoTCP(banner string) net.Listener {
+	l := listenTCP()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte(banner))
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l
+}
+
+func TestProbeTCP(t *testing.T) {
+	l := echoTCP("")
+	port := l.Addr().(*net.TCPAddr).Port
+
+	for _, host := range []string{"127.0.0.1", "localhost"} {
+		c := newRemoteCheck(t, fmt.Sprintf(`{host: %s, port: %d}`, host, port))
+		ret := c.probe()
+		assert.True(t, ret.connected, host)
+		assert.Greater(t, int64(ret.connectLatency), int64(0))
+		assert.False(t, ret.tls)
+		assert.False(t, ret.expect)
+	}
+
+	c := newRemoteCheck(t, fmt.Sprintf(`{host: 127.0.0.1, port: %d, send: "PING\r\n", expect: "^PING"}`, port))
+	ret := c.probe()
+	assert.True(t, ret.connected)
+	assert.True(t, ret.matched)
+
+	c = newRemoteCheck(t, fmt.Sprintf(`{host: 127.0.0.1, port: %d, send: "PING\r\n", expect: "^PONG"}`, port))
+	c.config.timeout = 200 * time.Millisecond
+	ret = c.probe()
+	assert.True(t, ret.connected)
+	assert.True(t, ret.expect)
+	assert.False(t, ret.matched)
+
+	l.Close()
+	ret = c.probe()
+	assert.False(t, ret.connected)
+}
+
+func TestProbeTCPBanner(t *testing.T) {
+	l := echoTCP("220 smtp.example.com ESMTP\r\n")
+	defer l.Close()
+
+	c := newRemoteCheck(t, fmt.Sprintf(`{host: 127.0.0.1, port: %d, expect: "^220 "}`, l.Addr().(*net.TCPAddr).Port))
+	ret := c.probe()
+	assert.True(t, ret.connected)
+	assert.True(t, ret.matched)
+}
+
+func TestProbeIPv6(t *testing.T) {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("ipv6 is not available: %s", err)
+	}
+	defer l.Close()
+
+	c := newRemoteCheck(t, fmt.Sprintf(`{host: "::1", port: %d}`, l.Addr().(*net.TCPAddr).Port))
+	assert.Equal(t, []string{fmt.Sprintf("[::1]:%d", l.Addr().(*net.TCPAddr).Port)}, c.config.addrs)
+	assert.True(t, c.probe().connected)
+}
+
+func TestProbeTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	addr := ts.Listener.Addr().(*net.TCPAddr)
+	c := newRemoteCheck(t, fmt.Sprintf(`
+host: 127.0.0.1
+port: %d
+tls: true
+tls_config:
+  insecureSkipVerify: true
+send: "GET / HTTP/1.0\r\n\r\n"
+expect: "HTTP/1.0 200 OK"
+`, addr.Port))
+
+	ret := c.probe()
+	assert.True(t, ret.connected)
+	assert.True(t, ret.tlsOK)
+	assert.Greater(t, int64(ret.tlsLatency), int64(0))
+	assert.Equal(t, ts.Certificate().NotAfter, ret.certExpiry)
+	assert.True(t, ret.matched)
+
+	sender := capturesender.New()
+	ret.send(sender, []string{"port:1"})
+	names := []string{}
+	for _, m := range sender.Metrics {
+		names = append(names, m.Name)
+	}
+	assert.Equal(t, []string{
+		"proc.port.listen",
+		"port.connect.latency",
+		"port.tls.success",
+		"port.tls.handshake.latency",
+		"port.tls.cert.expire_days",
+		"port.response.match",
+	}, names)
+
+	// the certificate of httptest is not trusted
+	c = newRemoteCheck(t, fmt.Sprintf(`{host: 127.0.0.1, port: %d, tls: true}`, addr.Port))
+	ret = c.probe()
+	assert.True(t, ret.connected)
+	assert.False(t, ret.tlsOK)
+}
+
+func TestProbeUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	c := newRemoteCheck(t, fmt.Sprintf(`{protocol: udp, host: 127.0.0.1, port: %d, send: "ping", expect: "^ping$"}`, port))
+	ret := c.probe()
+	assert.True(t, ret.connected)
+	assert.True(t, ret.matched)
+
+	conn.Close()
+	c.config.timeout = 200 * time.Millisecond
+	ret = c.probe()
+	assert.False(t, ret.connected)
+}
+
+func TestBuildRemoteConfig(t *testing.T) {
+	for _, config := range []string{
+		`{host: 127.0.0.1}`,
+		`{host: 127.0.0.1, port: 70000}`,
+		`{host: 127.0.0.1, port: 53, protocol: udp}`,
+		`{host: 127.0.0.1, port: 53, protocol: udp, send: a, tls: true}`,
+		`{host: 127.0.0.1, port: 53, protocol: sctp}`,
+		`{host: 127.0.0.1, port: 53, expect: "("}`,
+	} {
+		_, err := buildConfig([]byte(config), nil)
+		assert.Error(t, err, config)
+	}
+}
@@ -0,0 +1,124 @@
+package port
+
+import (
+	gotls "crypto/tls"
+	"net"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"k8s.io/klog/v2"
+)
+
+// maxResponseSize limits the response read to match expect
+const maxResponseSize = 4096
+
+// probeResult is the result of a remote probe, the latencies are set if
+// the phase succeeded
+type probeResult struct {
+	connected      bool
+	connectLatency time.Duration
+
+	tls        bool
+	tlsOK      bool
+	tlsLatency time.Duration
+	certExpiry time.Time
+
+	expect  bool
+	matched bool
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *probeResult) send(sender aggregator.Sender, tags []string) {
+	sender.Gauge("proc.port.listen", boolValue(p.connected), "", tags)
+	if !p.connected {
+		return
+	}
+	sender.Gauge("port.connect.latency", p.connectLatency.Seconds(), "", tags)
+
+	if p.tls {
+		sender.Gauge("port.tls.success", boolValue(p.tlsOK), "", tags)
+		if p.tlsOK {
+			sender.Gauge("port.tls.handshake.latency", p.tlsLatency.Seconds(), "", tags)
+			sender.Gauge("port.tls.cert.expire_days", time.Until(p.certExpiry).Hours()/24, "", tags)
+		}
+	}
+
+	if p.expect {
+		sender.Gauge("port.response.match", boolValue(p.matched), "", tags)
+	}
+}
+
+// probe connects to the remote target, performs the tls handshake, sends
+// the payload and reads the response, as configured
+func (c *Check) probe() *probeResult {
+	cf := c.config
+	addr := cf.addrs[0]
+	ret := &probeResult{tls: cf.tlsConfig != nil, expect: cf.expectReg != nil}
+
+	start := time.Now()
+	conn, err := net.DialTimeout(cf.Protocol, addr, cf.timeout)
+	if err != nil {
+		klog.V(5).Infof("dial %s %s err %s", cf.Protocol, addr, err)
+		return ret
+	}
+	defer conn.Close()
+	conn.SetDeadline(start.Add(cf.timeout))
+
+	// there is no connection with udp, the port is open if it responds
+	if cf.Protocol == "tcp" {
+		ret.connected = true
+		ret.connectLatency = time.Since(start)
+	}
+
+	if cf.tlsConfig != nil {
+		tlsStart := time.Now()
+		tlsConn := gotls.Client(conn, cf.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			klog.V(5).Infof("tls handshake with %s err %s", addr, err)
+			return ret
+		}
+		ret.tlsOK = true
+		ret.tlsLatency = time.Since(tlsStart)
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			ret.certExpiry = certs[0].NotAfter
+		}
+		conn = tlsConn
+	}
+
+	if cf.Send != "" {
+		if _, err := conn.Write([]byte(cf.Send)); err != nil {
+			klog.V(5).Infof("send to %s err %s", addr, err)
+			return ret
+		}
+	}
+
+	if cf.Protocol == "tcp" && cf.expectReg == nil {
+		return ret
+	}
+
+	buf := make([]byte, 0, maxResponseSize)
+	for len(buf) < maxResponseSize {
+		n, err := conn.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if n > 0 && cf.Protocol == "udp" && !ret.connected {
+			ret.connected = true
+			ret.connectLatency = time.Since(start)
+		}
+		if cf.expectReg == nil || cf.expectReg.Match(buf) {
+			ret.matched = cf.expectReg != nil
+			break
+		}
+		if err != nil {
+			klog.V(5).Infof("read from %s err %s", addr, err)
+			break
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,322 @@
+package checks
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/user"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/DataDog/datadog-agent/pkg/process/procutil"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"k8s.io/klog/v2"
+)
+
+// collect methods of the process filter
+const (
+	CollectByName    = "name"
+	CollectByCmdline = "cmdline"
+	CollectByRegex   = "regex"
+	CollectByExe     = "exe"
+	CollectByUser    = "user"
+	CollectByCgroup  = "cgroup"
+	CollectByPidfile = "pidfile"
+)
+
+// matchFunc returns true and the identifying tags of the process if it is
+// matched, f is the filter being matched, which holds the state of the
+// collection, e.g. the pids of the pidfiles
+type matchFunc func(f *ProcessFilter, p *procutil.Process) ([]string, bool)
+
+type ProcessFilter struct {
+	Target        string `json:"target"`
+	CollectMethod string `json:"collect_method" description:"name, cmdline, regex, exe, user, cgroup or pidfile"`
+	// Exclude are the targets of the processes which are not collected,
+	// matched with the same collect method
+	Exclude []string `json:"exclude"`
+	// AggregateChildren folds the descendants of a matched process into it
+	AggregateChildren bool    `json:"aggregate_children"`
+	Pids              []int32 `json:"-"`
+	// Tags are the identifying tags of the matched processes by pid
+	Tags map[int32][]string `json:"-"`
+	// Children are the descendants of the matched processes by pid, set if
+	// AggregateChildren is true
+	Children map[int32][]int32 `json:"-"`
+
+	match    matchFunc
+	excludes []matchFunc
+	pidfiles map[string]int32
+}
+
+// Validate validates the filter and builds the matchers, it must be called
+// before Match
+func (f *ProcessFilter) Validate() error {
+	if f.Target == "" {
+		return fmt.Errorf("target is required")
+	}
+
+	var err error
+	if f.match, err = f.newMatchFunc(f.Target); err != nil {
+		return err
+	}
+
+	f.excludes = make([]matchFunc, 0, len(f.Exclude))
+	for _, target := range f.Exclude {
+		fn, err := f.newMatchFunc(target)
+		if err != nil {
+			return fmt.Errorf("exclude %s: %s", target, err)
+		}
+		f.excludes = append(f.excludes, fn)
+	}
+
+	return nil
+}
+
+// Select matches the processes of a collection, and returns the matched
+// processes and their descendants if AggregateChildren is true
+func (f *ProcessFilter) Select(ps map[int32]*procutil.Process) map[int32]*procutil.Process {
+	f.reset()
+
+	ret := map[int32]*procutil.Process{}
+	for pid, p := range ps {
+		if tags, ok := f.Match(p); ok {
+			ret[pid] = p
+			f.Pids = append(f.Pids, pid)
+			f.Tags[pid] = tags
+		}
+	}
+
+	if f.AggregateChildren {
+		f.aggregate(ps, ret)
+	}
+	return ret
+}
+
+// aggregate drops the matched processes whose ancestor is also matched,
+// e.g. the workers of a master, and adds the descendants of the others
+func (f *ProcessFilter) aggregate(ps, selected map[int32]*procutil.Process) {
+	children := map[int32][]int32{}
+	for pid, p := range ps {
+		if p.Ppid != pid {
+			children[p.Ppid] = append(children[p.Ppid], pid)
+		}
+	}
+
+	roots := f.Pids[:0]
+	for _, pid := range f.Pids {
+		if !f.hasMatchedAncestor(ps, pid) {
+			roots = append(roots, pid)
+			continue
+		}
+		delete(f.Tags, pid)
+	}
+	f.Pids = roots
+
+	for _, pid := range f.Pids {
+		var descendants []int32
+		queue := append([]int32{}, children[pid]...)
+		for len(queue) > 0 {
+			child := queue[0]
+			queue = append(queue[1:], children[child]...)
+			descendants = append(descendants, child)
+			selected[child] = ps[child]
+		}
+		f.Children[pid] = descendants
+	}
+}
+
+func (f *ProcessFilter) hasMatchedAncestor(ps map[int32]*procutil.Process, pid int32) bool {
+	// the depth is limited in case of a loop
+	for i := 0; i < 1024; i++ {
+		p, ok := ps[pid]
+		if !ok || p.Ppid == pid {
+			return false
+		}
+		pid = p.Ppid
+		if _, ok := f.Tags[pid]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// reset is called before the processes of a collection are matched
+func (f *ProcessFilter) reset() {
+	f.Pids = f.Pids[:0]
+	f.Tags = make(map[int32][]string)
+	f.Children = make(map[int32][]int32)
+
+	if f.CollectMethod != CollectByPidfile {
+		return
+	}
+
+	f.pidfiles = make(map[string]int32)
+	for _, file := range append([]string{f.Target}, f.Exclude...) {
+		pid, err := readPidfile(file)
+		if err != nil {
+			klog.V(5).Infof("read pidfile %s err %s", file, err)
+			continue
+		}
+		f.pidfiles[file] = pid
+	}
+}
+
+// Match returns true and the identifying tags if the process is matched by
+// the target and none of the excludes
+func (f *ProcessFilter) Match(p *procutil.Process) ([]string, bool) {
+	if f.match == nil {
+		return nil, false
+	}
+
+	tags, ok := f.match(f, p)
+	if !ok {
+		return nil, false
+	}
+
+	for _, exclude := range f.excludes {
+		if _, ok := exclude(f, p); ok {
+			return nil, false
+		}
+	}
+
+	return tags, true
+}
+
+func (f *ProcessFilter) newMatchFunc(target string) (matchFunc, error) {
+	switch f.CollectMethod {
+	case CollectByName:
+		return func(f *ProcessFilter, p *procutil.Process) ([]string, bool) {
+			return nil, p.Name == target
+		}, nil
+	case CollectByCmdline:
+		return func(f *ProcessFilter, p *procutil.Process) ([]string, bool) {
+			return nil, strings.Contains(strings.Join(p.Cmdline, " "), target)
+		}, nil
+	case CollectByRegex:
+		reg, err := regexp.Compile(target)
+		if err != nil {
+			return nil, fmt.Errorf("compile regex %s: %s", target, err)
+		}
+		return func(f *ProcessFilter, p *procutil.Process) ([]string, bool) {
+			if !reg.MatchString(strings.Join(p.Cmdline, " ")) {
+				return nil, false
+			}
+			return []string{"name:" + p.Name}, true
+		}, nil
+	case CollectByExe:
+		// a glob pattern is supported
+		if _, err := filepath.Match(target, ""); err != nil {
+			return nil, fmt.Errorf("invalid exe pattern %s: %s", target, err)
+		}
+		return func(f *ProcessFilter, p *procutil.Process) ([]string, bool) {
+			if p.Exe == "" {
+				return nil, false
+			}
+			if ok, _ := filepath.Match(target, p.Exe); !ok {
+				return nil, false
+			}
+			return []string{"exe:" + p.Exe}, true
+		}, nil
+	case CollectByUser:
+		// the name or the uid of the effective user
+		return func(f *ProcessFilter, p *procutil.Process) ([]string, bool) {
+			uid, ok := effectiveUID(p)
+			if !ok {
+				return nil, false
+			}
+			name := lookupUsername(uid)
+			if target != name && target != strconv.Itoa(int(uid)) {
+				return nil, false
+			}
+			return []string{"user:" + name}, true
+		}, nil
+	case CollectByCgroup:
+		// the cgroup path, its parent or the systemd unit, e.g. nginx.service
+		target := strings.TrimSuffix(target, "/")
+		return func(f *ProcessFilter, p *procutil.Process) ([]string, bool) {
+			for _, cgroup := range readCgroups(p.Pid) {
+				if cgroup == target || strings.HasPrefix(cgroup, target+"/") ||
+					(!strings.Contains(target, "/") && path.Base(cgroup) == target) {
+					return []string{"cgroup:" + cgroup}, true
+				}
+			}
+			return nil, false
+		}, nil
+	case CollectByPidfile:
+		return func(f *ProcessFilter, p *procutil.Process) ([]string, bool) {
+			if pid, ok := f.pidfiles[target]; !ok || pid != p.Pid {
+				return nil, false
+			}
+			return []string{"name:" + p.Name}, true
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported collect_method %q", f.CollectMethod)
+}
+
+func readPidfile(file string) (int32, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.ParseInt(string(bytes.TrimSpace(b)), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pidfile %s: %s", file, err)
+	}
+	return int32(pid), nil
+}
+
+// effectiveUID returns the effective uid, the uids are real, effective,
+// saved and filesystem uids in order
+func effectiveUID(p *procutil.Process) (int32, bool) {
+	switch len(p.Uids) {
+	case 0:
+		return 0, false
+	case 1:
+		return p.Uids[0], true
+	}
+	return p.Uids[1], true
+}
+
+var usernames sync.Map
+
+// lookupUsername returns the name of the uid, or the uid if the user is
+// unknown
+func lookupUsername(uid int32) string {
+	if name, ok := usernames.Load(uid); ok {
+		return name.(string)
+	}
+
+	id := strconv.Itoa(int(uid))
+	name := id
+	if u, err := user.LookupId(id); err == nil {
+		name = u.Username
+	}
+	usernames.Store(uid, name)
+	return name
+}
+
+// readCgroups returns the cgroup paths of the process from
+// /proc/<pid>/cgroup, each line is hierarchy-ID:controller-list:cgroup-path
+func readCgroups(pid int32) []string {
+	b, err := ioutil.ReadFile(util.HostProc(strconv.Itoa(int(pid)), "cgroup"))
+	if err != nil {
+		return nil
+	}
+
+	var ret []string
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) == 3 && fields[2] != "" {
+			ret = append(ret, fields[2])
+		}
+	}
+	return ret
+}
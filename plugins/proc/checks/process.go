@@ -3,7 +3,6 @@ package checks
 import (
 	"context"
 	"errors"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -27,22 +26,6 @@ var (
 	errEmptyCPUTime = errors.New("empty CPU time information returned")
 )
 
-type ProcessFilter struct {
-	Target        string  `json:"target"`
-	CollectMethod string  `json:"collect_method" description:"name or cmdline"`
-	Pids          []int32 `json:"-"`
-}
-
-func (f *ProcessFilter) Match(p *procutil.Process) bool {
-	switch f.CollectMethod {
-	case "name":
-		return p.Name == f.Target
-	case "cmdline":
-		return strings.Contains(strings.Join(p.Cmdline, " "), f.Target)
-	}
-	return false
-}
-
 // ProcessCheck collects full state, including cmdline args and related metadata,
 // for live and running processes. The instance will store some state between
 // checks that will be used for rates, cpu calculations, etc.
@@ -84,6 +67,38 @@ func (p *ProcessCheck) DelFilter(filter *ProcessFilter) {
 	}
 }
 
+// Matches are the processes matched by a filter in a collection
+type Matches struct {
+	Pids []int32
+	// Tags are the identifying tags by pid
+	Tags map[int32][]string
+	// Children are the descendants by pid if the children are aggregated
+	Children map[int32][]int32
+}
+
+// Matched returns the processes matched by the filter in the last
+// collection, ok is false if the filter has not been collected yet
+func (p *ProcessCheck) Matched(filter *ProcessFilter) (m Matches, ok bool) {
+	p.RLock()
+	defer p.RUnlock()
+
+	if filter.Tags == nil {
+		return m, false
+	}
+
+	m.Pids = make([]int32, len(filter.Pids))
+	copy(m.Pids, filter.Pids)
+	m.Tags = make(map[int32][]string, len(filter.Tags))
+	for pid, v := range filter.Tags {
+		m.Tags[pid] = v
+	}
+	m.Children = make(map[int32][]int32, len(filter.Children))
+	for pid, v := range filter.Children {
+		m.Children[pid] = v
+	}
+	return m, true
+}
+
 func (p *ProcessCheck) GetProcs() map[int32]*model.Process {
 	p.RLock()
 	defer p.RUnlock()
@@ -138,12 +153,8 @@ func (p *ProcessCheck) Run(cfg *config.AgentConfig, groupID int32) ([]model.Mess
 	}
 	procs := map[int32]*procutil.Process{}
 	for _, filter := range p.filters {
-		filter.Pids = filter.Pids[:0]
-		for pid, p := range ps {
-			if filter.Match(p) {
-				procs[pid] = p
-				filter.Pids = append(filter.Pids, pid)
-			}
+		for pid, p := range filter.Select(ps) {
+			procs[pid] = p
 		}
 	}
 
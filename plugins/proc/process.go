@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -47,6 +48,10 @@ func buildConfig(rawInstance integration.Data, _ integration.Data) (*checkConfig
 		return nil, err
 	}
 
+	if err := instance.ProcessFilter.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &checkConfig{
 		InstanceConfig: instance,
 	}, nil
@@ -67,10 +72,75 @@ func (c *Check) Run() error {
 		return err
 	}
 
-	procs := c.process.GetProcs()
-	stats := c.rtProcess.GetStats()
+	m, ok := c.process.Matched(c.filter)
+	if ok {
+		c.collect(sender, c.process.GetProcs(), c.rtProcess.GetStats(), m)
+	}
+
+	sender.Commit()
+	return nil
+}
+
+// procGroup are the totals of the processes with the same tags, the
+// counters are summed before sent as rates, the fd utilization is the max
+type procGroup struct {
+	tags                   []string
+	num                    int
+	voluntaryCtxSwitches   uint64
+	involuntaryCtxSwitches uint64
+	majorFaults            uint64
+	openFdLimit            uint64
+	openFdUtil             float64
+}
+
+func (p *procGroup) add(stat *model.ProcessStat, fs procfsStat) {
+	p.voluntaryCtxSwitches += stat.VoluntaryCtxSwitches
+	p.involuntaryCtxSwitches += stat.InvoluntaryCtxSwitches
+	p.majorFaults += fs.majorFaults
+
+	if fs.openFdLimit == 0 {
+		return
+	}
+	fdUtil := float64(stat.OpenFdCount) / float64(fs.openFdLimit) * 100
+	if p.openFdLimit == 0 || fdUtil > p.openFdUtil {
+		p.openFdLimit = fs.openFdLimit
+		p.openFdUtil = fdUtil
+	}
+}
+
+func (p *procGroup) send(sender aggregator.Sender) {
+	sender.Rate("proc.ctx_switches.voluntary_rate", float64(p.voluntaryCtxSwitches), "", p.tags)
+	sender.Rate("proc.ctx_switches.involuntary_rate", float64(p.involuntaryCtxSwitches), "", p.tags)
+	sender.Rate("proc.mem.major_faults_rate", float64(p.majorFaults), "", p.tags)
+	if p.openFdLimit > 0 {
+		sender.Gauge("proc.open_fd_limit", float64(p.openFdLimit), "", p.tags)
+		sender.Gauge("proc.open_fd_util", p.openFdUtil, "", p.tags)
+	}
+}
+
+// collect sends the metrics of the matched processes, the usage of the
+// descendants are added to the matched process if the children are
+// aggregated. proc.num{target} is the number of all the matched processes,
+// 0 if none is matched, the processes with identifying tags are also
+// counted by their tags
+func (c *Check) collect(sender aggregator.Sender, procs map[int32]*model.Process, stats map[int32]*model.ProcessStat, m checks.Matches) {
+	tags := []string{"target:" + c.filter.Target}
+	num := 0
+
+	groups := map[string]*procGroup{}
+	var keys []string
+	group := func(tags []string) *procGroup {
+		key := strings.Join(tags, ",")
+		g, ok := groups[key]
+		if !ok {
+			g = &procGroup{tags: tags}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		return g
+	}
 
-	for _, pid := range c.filter.Pids {
+	for _, pid := range m.Pids {
 		proc, ok := procs[pid]
 		if !ok {
 			continue
@@ -79,20 +149,45 @@ func (c *Check) Run() error {
 		if !ok {
 			continue
 		}
-		collectProc(sender, proc, stat, []string{"target:" + c.filter.Target})
+		procTags := append(append([]string{}, tags...), m.Tags[pid]...)
+		collectProc(sender, proc, stat, procTags)
+		num++
+
+		g := group(procTags)
+		g.num++
+		g.add(stat, readProcfsStat(pid))
+
+		for _, child := range m.Children[pid] {
+			stat, ok := stats[child]
+			if !ok {
+				continue
+			}
+			collectUsage(sender, stat, procTags)
+			g.add(stat, readProcfsStat(child))
+		}
 	}
 
-	sender.Commit()
-	return nil
+	sender.Gauge("proc.num", float64(num), "", tags)
+	for _, key := range keys {
+		g := groups[key]
+		if len(g.tags) > len(tags) {
+			sender.Gauge("proc.num", float64(g.num), "", g.tags)
+		}
+		g.send(sender)
+	}
 }
 
 func collectProc(sender aggregator.Sender, proc *model.Process, stat *model.ProcessStat, tags []string) {
-	sender.Count("proc.num", 1, "", tags)
-
 	// uptime
 	sender.Gauge("proc.uptime", float64(time.Now().Unix()-stat.CreateTime/1000), "", tags)
 	sender.Gauge("proc.createtime", float64(stat.CreateTime)/1000, "", tags)
 
+	collectUsage(sender, stat, tags)
+}
+
+// collectUsage sends the usage of a process, the values of the processes
+// with the same tags are summed
+func collectUsage(sender aggregator.Sender, stat *model.ProcessStat, tags []string) {
 	// fd
 	sender.Count("proc.open_fd_count", float64(stat.OpenFdCount), "", tags)
 
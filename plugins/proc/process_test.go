@@ -0,0 +1,245 @@
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/process/procutil"
+	model "github.com/n9e/agent-payload/process"
+	"github.com/n9e/n9e-agentd/pkg/util/capturesender"
+	"github.com/n9e/n9e-agentd/plugins/proc/checks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+}
+
+// testProcs are the processes of the fake procfs
+func testProcs(t *testing.T) map[int32]*procutil.Process {
+	root := t.TempDir()
+	t.Setenv("HOST_PROC", root)
+
+	writeFile(t, filepath.Join(root, "1", "cgroup"), "0::/init.scope\n")
+	writeFile(t, filepath.Join(root, "100", "cgroup"),
+		"12:cpu,cpuacct:/system.slice/nginx.service\n0::/system.slice/nginx.service\n")
+	writeFile(t, filepath.Join(root, "101", "cgroup"), "0::/system.slice/nginx.service\n")
+	writeFile(t, filepath.Join(root, "200", "cgroup"), "0::/user.slice/user-1000.slice/session-1.scope\n")
+
+	return map[int32]*procutil.Process{
+		1: {Pid: 1, Name: "systemd", Exe: "/usr/lib/systemd/systemd",
+			Cmdline: []string{"/sbin/init"}, Uids: []int32{0, 0, 0, 0}},
+		100: {Pid: 100, Ppid: 1, Name: "nginx", Exe: "/usr/sbin/nginx",
+			Cmdline: []string{"nginx: master process /usr/sbin/nginx"}, Uids: []int32{0, 0, 0, 0}},
+		101: {Pid: 101, Ppid: 100, Name: "nginx", Exe: "/usr/sbin/nginx",
+			Cmdline: []string{"nginx: worker process"}, Uids: []int32{0, 65534, 0, 0}},
+		102: {Pid: 102, Ppid: 101, Name: "sh", Exe: "/bin/sh",
+			Cmdline: []string{"sh", "-c", "logrotate"}, Uids: []int32{0, 0, 0, 0}},
+		200: {Pid: 200, Ppid: 1, Name: "java", Exe: "/opt/jdk/bin/java",
+			Cmdline: []string{"java", "-jar", "/opt/app/order-service.jar"}, Uids: []int32{1000, 1000, 1000, 1000}},
+	}
+}
+
+// match returns the sorted pids matched by the filter, and the tags of
+// each pid
+func match(t *testing.T, filter *checks.ProcessFilter, procs map[int32]*procutil.Process) ([]int32, map[int32][]string) {
+	require.NoError(t, filter.Validate())
+	filter.Select(procs)
+
+	sort.Slice(filter.Pids, func(i, j int) bool { return filter.Pids[i] < filter.Pids[j] })
+	return filter.Pids, filter.Tags
+}
+
+func TestFilter(t *testing.T) {
+	procs := testProcs(t)
+
+	dir := t.TempDir()
+	pidfile := filepath.Join(dir, "nginx.pid")
+	writeFile(t, pidfile, "100\n")
+
+	cases := []struct {
+		filter checks.ProcessFilter
+		pids   []int32
+		tags   []string
+	}{
+		{checks.ProcessFilter{CollectMethod: "name", Target: "nginx"}, []int32{100, 101}, nil},
+		{checks.ProcessFilter{CollectMethod: "cmdline", Target: "order-service"}, []int32{200}, nil},
+		{checks.ProcessFilter{CollectMethod: "regex", Target: `-jar \S+order-\w+\.jar`}, []int32{200}, []string{"name:java"}},
+		{checks.ProcessFilter{CollectMethod: "regex", Target: `^nginx`, Exclude: []string{"worker"}}, []int32{100}, []string{"name:nginx"}},
+		{checks.ProcessFilter{CollectMethod: "exe", Target: "/usr/sbin/nginx"}, []int32{100, 101}, []string{"exe:/usr/sbin/nginx"}},
+		{checks.ProcessFilter{CollectMethod: "exe", Target: "/opt/*/bin/java"}, []int32{200}, []string{"exe:/opt/jdk/bin/java"}},
+		{checks.ProcessFilter{CollectMethod: "user", Target: "root", Exclude: []string{"1"}}, []int32{1, 100, 102}, []string{"user:root"}},
+		{checks.ProcessFilter{CollectMethod: "user", Target: "65534"}, []int32{101}, nil},
+		{checks.ProcessFilter{CollectMethod: "cgroup", Target: "nginx.service"}, []int32{100, 101}, []string{"cgroup:/system.slice/nginx.service"}},
+		{checks.ProcessFilter{CollectMethod: "cgroup", Target: "/user.slice/"}, []int32{200}, []string{"cgroup:/user.slice/user-1000.slice/session-1.scope"}},
+		{checks.ProcessFilter{CollectMethod: "cgroup", Target: "/system.slice", Exclude: []string{"nginx.service"}}, nil, nil},
+		{checks.ProcessFilter{CollectMethod: "pidfile", Target: pidfile}, []int32{100}, []string{"name:nginx"}},
+		{checks.ProcessFilter{CollectMethod: "pidfile", Target: filepath.Join(dir, "missing.pid")}, nil, nil},
+	}
+
+	for _, c := range cases {
+		filter := c.filter
+		name := fmt.Sprintf("%s %s %v", filter.CollectMethod, filter.Target, filter.Exclude)
+		pids, tags := match(t, &filter, procs)
+		if len(c.pids) == 0 {
+			assert.Empty(t, pids, name)
+			continue
+		}
+		assert.Equal(t, c.pids, pids, name)
+		if c.tags != nil {
+			assert.Equal(t, c.tags, tags[c.pids[0]], name)
+		}
+	}
+}
+
+func TestFilterBuildConfig(t *testing.T) {
+	procs := testProcs(t)
+
+	pidfile := filepath.Join(t.TempDir(), "nginx.pid")
+	writeFile(t, pidfile, "100\n")
+
+	// the filter is copied into the config after it's validated, the
+	// matchers must read the pidfiles of the copy
+	config, err := buildConfig([]byte(fmt.Sprintf(`{target: %s, collect_method: pidfile}`, pidfile)), nil)
+	require.NoError(t, err)
+
+	filter := &config.ProcessFilter
+	filter.Select(procs)
+	assert.Equal(t, []int32{100}, filter.Pids)
+	assert.Equal(t, []string{"name:nginx"}, filter.Tags[100])
+}
+
+func TestFilterValidate(t *testing.T) {
+	for _, filter := range []checks.ProcessFilter{
+		{CollectMethod: "name"},
+		{CollectMethod: "unknown", Target: "a"},
+		{CollectMethod: "regex", Target: "("},
+		{CollectMethod: "regex", Target: "a", Exclude: []string{"("}},
+		{CollectMethod: "exe", Target: "/usr/bin/["},
+	} {
+		assert.Error(t, filter.Validate(), "%s %s", filter.CollectMethod, filter.Target)
+	}
+
+	_, err := buildConfig([]byte(`{target: nginx, collect_method: name, exclude: [a]}`), nil)
+	assert.NoError(t, err)
+}
+
+func TestCollect(t *testing.T) {
+	c := &Check{filter: &checks.ProcessFilter{Target: "nginx"}}
+
+	// proc.num is 0 if nothing is matched
+	sender := capturesender.New()
+	c.collect(sender, nil, nil, checks.Matches{})
+	require.Len(t, sender.Metrics, 1)
+	assert.Equal(t, "proc.num", sender.Metrics[0].Name)
+	assert.Equal(t, "gauge", sender.Metrics[0].Type)
+	assert.Equal(t, float64(0), sender.Metrics[0].Value)
+	assert.Equal(t, []string{"target:nginx"}, sender.Metrics[0].Tags)
+
+	procs := map[int32]*model.Process{100: {Pid: 100}, 101: {Pid: 101}, 200: {Pid: 200}}
+	stats := map[int32]*model.ProcessStat{
+		100: {Pid: 100, OpenFdCount: 8},
+		101: {Pid: 101, OpenFdCount: 2},
+		200: {Pid: 200, OpenFdCount: 1},
+	}
+	sender = capturesender.New()
+	c.collect(sender, procs, stats, checks.Matches{
+		Pids: []int32{100, 101, 200},
+		Tags: map[int32][]string{100: {"user:root"}, 101: {"user:root"}, 200: {"user:nobody"}},
+	})
+
+	// the total is the same series as the 0 above
+	values := map[string]float64{}
+	for _, m := range sender.Metrics {
+		values[m.Name+" "+strings.Join(m.Tags, ",")] += m.Value
+		if m.Name == "proc.num" {
+			assert.Equal(t, "gauge", m.Type)
+		}
+	}
+	assert.Equal(t, float64(3), values["proc.num target:nginx"])
+	assert.Equal(t, float64(2), values["proc.num target:nginx,user:root"])
+	assert.Equal(t, float64(1), values["proc.num target:nginx,user:nobody"])
+	assert.Equal(t, float64(10), values["proc.open_fd_count target:nginx,user:root"])
+	assert.NotContains(t, values, "proc.open_fd_count target:nginx")
+}
+
+func TestAggregateChildren(t *testing.T) {
+	procs := testProcs(t)
+
+	// the worker is folded into the master, the grandchild is added
+	filter := &checks.ProcessFilter{CollectMethod: "name", Target: "nginx", AggregateChildren: true}
+	require.NoError(t, filter.Validate())
+	selected := filter.Select(procs)
+	assert.Equal(t, []int32{100}, filter.Pids)
+	assert.ElementsMatch(t, []int32{101, 102}, filter.Children[100])
+	assert.Len(t, selected, 3)
+
+	filter = &checks.ProcessFilter{CollectMethod: "name", Target: "nginx"}
+	require.NoError(t, filter.Validate())
+	assert.Len(t, filter.Select(procs), 2)
+	assert.Empty(t, filter.Children)
+}
+
+func TestCollectChildren(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("HOST_PROC", root)
+	writeFile(t, filepath.Join(root, "100", "limits"), `Limit                     Soft Limit           Hard Limit           Units
+Max cpu time              unlimited            unlimited            seconds
+Max open files            1024                 4096                 files
+`)
+	writeFile(t, filepath.Join(root, "100", "stat"),
+		"100 (nginx: master) S 1 100 100 0 -1 4194560 1500 0 7 0 1 2 0 0 20 0 1 0 100 0 0\n")
+	writeFile(t, filepath.Join(root, "101", "limits"), "Max open files            100                  4096                 files\n")
+	writeFile(t, filepath.Join(root, "101", "stat"),
+		"101 (a (b) c) S 100 100 100 0 -1 4194560 1500 0 5 0 1 2 0 0 20 0 1 0 100 0 0\n")
+
+	c := &Check{filter: &checks.ProcessFilter{Target: "nginx"}}
+	procs := map[int32]*model.Process{100: {Pid: 100}, 101: {Pid: 101}}
+	stats := map[int32]*model.ProcessStat{
+		100: {Pid: 100, OpenFdCount: 8, Memory: &model.MemoryStat{Rss: 1000},
+			VoluntaryCtxSwitches: 10, InvoluntaryCtxSwitches: 1},
+		101: {Pid: 101, OpenFdCount: 50, Memory: &model.MemoryStat{Rss: 200},
+			VoluntaryCtxSwitches: 20, InvoluntaryCtxSwitches: 2},
+	}
+
+	sender := capturesender.New()
+	c.collect(sender, procs, stats, checks.Matches{
+		Pids:     []int32{100},
+		Tags:     map[int32][]string{},
+		Children: map[int32][]int32{100: {101}},
+	})
+
+	values := map[string]float64{}
+	types := map[string]string{}
+	for _, m := range sender.Metrics {
+		assert.Equal(t, []string{"target:nginx"}, m.Tags)
+		values[m.Name] += m.Value
+		types[m.Name] = m.Type
+	}
+	assert.Equal(t, float64(1), values["proc.num"])
+	assert.Equal(t, float64(58), values["proc.open_fd_count"])
+	assert.Equal(t, float64(1200), values["proc.mem.rss"])
+	assert.Equal(t, float64(30), values["proc.ctx_switches.voluntary_rate"])
+	assert.Equal(t, float64(3), values["proc.ctx_switches.involuntary_rate"])
+	assert.Equal(t, float64(12), values["proc.mem.major_faults_rate"])
+	assert.Equal(t, "rate", types["proc.mem.major_faults_rate"])
+	// the child is the closest to its limit
+	assert.Equal(t, float64(100), values["proc.open_fd_limit"])
+	assert.Equal(t, float64(50), values["proc.open_fd_util"])
+}
+
+func TestReadProcfsStat(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("HOST_PROC", root)
+	writeFile(t, filepath.Join(root, "1", "limits"), "Max open files            unlimited            unlimited            files\n")
+
+	assert.Equal(t, procfsStat{}, readProcfsStat(1))
+	assert.Equal(t, procfsStat{}, readProcfsStat(2))
+}
@@ -0,0 +1,74 @@
+package process
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+// procfsStat are the stats of a process which are read from procfs by the
+// check, they are not collected by the process probe
+type procfsStat struct {
+	// openFdLimit is the soft limit of RLIMIT_NOFILE, 0 if unknown or
+	// unlimited
+	openFdLimit uint64
+	majorFaults uint64
+}
+
+func readProcfsStat(pid int32) procfsStat {
+	return procfsStat{
+		openFdLimit: readOpenFdLimit(pid),
+		majorFaults: readMajorFaults(pid),
+	}
+}
+
+// readOpenFdLimit reads the soft limit of the "Max open files" line of
+// /proc/<pid>/limits
+func readOpenFdLimit(pid int32) uint64 {
+	b, err := ioutil.ReadFile(util.HostProc(strconv.Itoa(int(pid)), "limits"))
+	if err != nil {
+		return 0
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) == 0 {
+			return 0
+		}
+		// "unlimited" is not a number
+		limit, _ := strconv.ParseUint(fields[0], 10, 64)
+		return limit
+	}
+	return 0
+}
+
+// readMajorFaults reads majflt, the 12th field of /proc/<pid>/stat, the
+// comm field may contain spaces and parentheses, so the fields are counted
+// from the last ')'
+func readMajorFaults(pid int32) uint64 {
+	b, err := ioutil.ReadFile(util.HostProc(strconv.Itoa(int(pid)), "stat"))
+	if err != nil {
+		return 0
+	}
+
+	i := bytes.LastIndexByte(b, ')')
+	if i < 0 {
+		return 0
+	}
+	// state ppid pgrp session tty_nr tpgid flags minflt cminflt majflt
+	fields := strings.Fields(string(b[i+1:]))
+	if len(fields) < 10 {
+		return 0
+	}
+	n, _ := strconv.ParseUint(fields[9], 10, 64)
+	return n
+}
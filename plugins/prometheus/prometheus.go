@@ -8,14 +8,18 @@ import (
 	"io/ioutil"
 	"mime"
 	"net/http"
+	"path"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/matttproud/golang_protobuf_extensions/pbutil"
-	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
-	"github.com/n9e/n9e-agentd/pkg/util/tls"
 	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
 	"github.com/DataDog/datadog-agent/pkg/collector/check"
 	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/matttproud/golang_protobuf_extensions/pbutil"
+	"github.com/n9e/n9e-agentd/pkg/util/tls"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 	"sigs.k8s.io/yaml"
@@ -27,30 +31,42 @@ type InitConfig struct {
 }
 
 type InstanceConfig struct {
-	PrometheusUrl           string            `json:"prometheus_url"`            // prometheus_url
-	Namespace               string            `json:"namespace"`                 // namespace
-	Metrics                 []string          `json:"metrics"`                   // metrics
-	PrometheusMetricsPrefix string            `json:"prometheus_metrics_prefix"` // prometheus_metrics_prefix
-	HealthServiceCheck      bool              `json:"health_service_check"`      // health_service_check
-	LabelToHostname         string            `json:"label_to_hostname"`         // label_to_hostname
-	LabelJoins              interface{}       `json:"label_joins"`               // label_joins
-	LabelsMapper            map[string]string `json:"labels_mapper"`             // labels_mapper
-	TypeOverrides           map[string]string `json:"type_overrides"`            // type_overrides
-	Tags                    []string          `json:"tags"`                      // tags
-	SendHistogramsBuckets   bool              `json:"send_histograms_buckets"`   // send_histograms_buckets
-	SendMonotonicCounter    bool              `json:"send_monotonic_counter"`    // send_monotonic_counter
-	ExcludeLabels           []string          `json:"exclude_labels"`            // exclude_labels
-	SslCert                 string            `json:"ssl_cert"`                  // ssl_cert
-	SslPrivateKey           string            `json:"ssl_private_key"`           // ssl_private_key
-	SslCaCert               string            `json:"ssl_ca_cert"`               // ssl_ca_cert
-	PrometheusTimeout       int               `json:"prometheus_timeout"`        // prometheus_timeout
-	MaxReturnedMetrics      int               `json:"max_returned_metrics"`      // max_returned_metrics
+	PrometheusUrl           string               `json:"prometheus_url"`            // prometheus_url
+	Namespace               string               `json:"namespace"`                 // namespace
+	Metrics                 []interface{}        `json:"metrics"`                   // metrics
+	PrometheusMetricsPrefix string               `json:"prometheus_metrics_prefix"` // prometheus_metrics_prefix
+	HealthServiceCheck      bool                 `json:"health_service_check"`      // health_service_check
+	LabelToHostname         string               `json:"label_to_hostname"`         // label_to_hostname
+	LabelJoins              map[string]LabelJoin `json:"label_joins"`               // label_joins
+	LabelsMapper            map[string]string    `json:"labels_mapper"`             // labels_mapper
+	TypeOverrides           map[string]string    `json:"type_overrides"`            // type_overrides
+	Tags                    []string             `json:"tags"`                      // tags
+	SendHistogramsBuckets   bool                 `json:"send_histograms_buckets"`   // send_histograms_buckets
+	SendMonotonicCounter    bool                 `json:"send_monotonic_counter"`    // send_monotonic_counter
+	ExcludeLabels           []string             `json:"exclude_labels"`            // exclude_labels
+	SslCert                 string               `json:"ssl_cert"`                  // ssl_cert
+	SslPrivateKey           string               `json:"ssl_private_key"`           // ssl_private_key
+	SslCaCert               string               `json:"ssl_ca_cert"`               // ssl_ca_cert
+	PrometheusTimeout       int                  `json:"prometheus_timeout"`        // prometheus_timeout
+	MaxReturnedMetrics      int                  `json:"max_returned_metrics"`      // max_returned_metrics
 
 	timeout          time.Duration
+	metricsMapper    map[string]string // exact name -> new name
+	metricsWildcards []string          // glob patterns, the name is kept
+	typeOverrides    map[string]dto.MetricType
+	typeWildcards    []string // sorted glob patterns of typeOverrides
+	excludeLabels    map[string]bool
 	tls.ClientConfig `json:"-"`
 	InitConfig       `json:"-"`
 }
 
+// LabelJoin retrieves the labels of a target metric via a 1:1 mapping
+// on label_to_match, and adds them to every metric carrying the same label value
+type LabelJoin struct {
+	LabelToMatch string   `json:"label_to_match"`
+	LabelsToGet  []string `json:"labels_to_get"` // "*" gets all the labels of the target metric
+}
+
 type promConfig struct {
 	InstanceConfig
 	InitConfig
@@ -58,13 +74,68 @@ type promConfig struct {
 
 func (p *promConfig) Validate() error {
 	p.timeout = time.Second * time.Duration(p.PrometheusTimeout)
+
+	// metrics items are either a name/glob, or a map of `<METRIC_TO_FETCH>: <NEW_METRIC_NAME>`
+	p.metricsMapper = map[string]string{}
+	for _, item := range p.Metrics {
+		switch v := item.(type) {
+		case string:
+			if strings.Contains(v, "*") {
+				p.metricsWildcards = append(p.metricsWildcards, v)
+			} else {
+				p.metricsMapper[v] = v
+			}
+		case map[string]interface{}:
+			for name, newName := range v {
+				s, ok := newName.(string)
+				if !ok {
+					return fmt.Errorf("metrics: invalid new name %v for %s", newName, name)
+				}
+				p.metricsMapper[name] = s
+			}
+		default:
+			return fmt.Errorf("metrics: unsupported item %v", item)
+		}
+	}
+
+	p.typeOverrides = map[string]dto.MetricType{}
+	for name, typ := range p.TypeOverrides {
+		t, ok := metricTypes[strings.ToLower(typ)]
+		if !ok {
+			return fmt.Errorf("type_overrides: unsupported type %q for %s", typ, name)
+		}
+		p.typeOverrides[name] = t
+		if strings.Contains(name, "*") {
+			p.typeWildcards = append(p.typeWildcards, name)
+		}
+	}
+	sort.Strings(p.typeWildcards)
+
+	p.excludeLabels = map[string]bool{}
+	for _, label := range p.ExcludeLabels {
+		p.excludeLabels[label] = true
+	}
+
+	for name, join := range p.LabelJoins {
+		if join.LabelToMatch == "" {
+			return fmt.Errorf("label_joins: label_to_match is empty for %s", name)
+		}
+	}
+
 	return nil
 }
 
+var metricTypes = map[string]dto.MetricType{
+	"gauge":   dto.MetricType_GAUGE,
+	"counter": dto.MetricType_COUNTER,
+	"untyped": dto.MetricType_UNTYPED,
+}
+
 func defaultInstanceConfig() InstanceConfig {
 	return InstanceConfig{
 		SendHistogramsBuckets: true,
 		SendMonotonicCounter:  true,
+		HealthServiceCheck:    true,
 		PrometheusTimeout:     10,
 		MaxReturnedMetrics:    2000,
 	}
@@ -110,56 +181,246 @@ func (c *Check) Run() error {
 		return err
 	}
 
-	req, err := http.NewRequest("GET", c.PrometheusUrl, nil)
+	mfs, err := c.scrape()
+	if c.HealthServiceCheck {
+		if err != nil {
+			sender.ServiceCheck(c.healthServiceCheckName(), metrics.ServiceCheckCritical, "", nil, err.Error())
+		} else {
+			sender.ServiceCheck(c.healthServiceCheckName(), metrics.ServiceCheckOK, "", nil, "")
+		}
+	}
 	if err != nil {
+		sender.Commit()
 		return err
 	}
+
+	c.collectMetrics(sender, mfs)
+
+	sender.Commit()
+	return nil
+}
+
+func (c *Check) scrape() (map[string]*dto.MetricFamily, error) {
+	req, err := http.NewRequest("GET", c.PrometheusUrl, nil)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Add("Accept", "*/*")
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("error making HTTP request to %s: %s", c.PrometheusUrl, err)
+		return nil, fmt.Errorf("error making HTTP request to %s: %s", c.PrometheusUrl, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("%s returned HTTP status %s", c.PrometheusUrl, resp.Status)
+		return nil, fmt.Errorf("%s returned HTTP status %s", c.PrometheusUrl, resp.Status)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("error reading body: %s", err)
+		return nil, fmt.Errorf("error reading body: %s", err)
 	}
 
 	mfs, err := readBody(body, resp.Header)
 	if err != nil {
-		return fmt.Errorf("error reading metrics for %s: %s", c.PrometheusUrl, err)
+		return nil, fmt.Errorf("error reading metrics for %s: %s", c.PrometheusUrl, err)
 	}
 
-	collectMetrics(sender, mfs)
+	return mfs, nil
+}
 
-	sender.Commit()
-	return nil
+func (c *Check) healthServiceCheckName() string {
+	if c.Namespace == "" {
+		return "prometheus.health"
+	}
+	return c.Namespace + ".prometheus.health"
 }
 
-func collectMetrics(sender aggregator.Sender, mfs map[string]*dto.MetricFamily) error {
-	for metricName, mf := range mfs {
+func (c *Check) collectMetrics(sender aggregator.Sender, mfs map[string]*dto.MetricFamily) {
+	s := &limitedSender{Sender: sender, limit: c.MaxReturnedMetrics}
+	joins := c.labelJoins(mfs)
+
+	// the families are sent by name, the same series are dropped by
+	// max_returned_metrics on every run
+	for _, name := range familyNames(mfs) {
+		mf := mfs[name]
+		metricName, ok := c.metricName(mf.GetName())
+		if !ok {
+			continue
+		}
+
+		typ := c.metricType(mf)
 		for _, m := range mf.Metric {
-			switch mf.GetType() {
+			hostname, tags := c.metricTags(m, joins)
+
+			switch typ {
 			case dto.MetricType_SUMMARY:
-				sendSummary(metricName, m, sender)
+				sendSummary(s, metricName, m, hostname, tags)
 			case dto.MetricType_HISTOGRAM:
-				sendHistogram(metricName, m, sender)
+				sendHistogram(s, metricName, m, hostname, tags, c.SendHistogramsBuckets)
 			case dto.MetricType_COUNTER:
-				sendCounter(metricName, m, sender)
-			case dto.MetricType_GAUGE:
-				sendGauge(metricName, m, sender)
-			case dto.MetricType_UNTYPED:
-				sendUntyped(metricName, m, sender)
+				sendCounter(s, metricName, m, hostname, tags, c.SendMonotonicCounter)
+			case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+				sendGauge(s, metricName, m, hostname, tags)
 			}
 		}
 	}
-	return nil
+
+	if s.dropped > 0 {
+		c.Warnf("the check exceeded max_returned_metrics(%d), %d series were dropped", s.limit, s.dropped)
+	}
+}
+
+// familyNames returns the sorted names of the metric families
+func familyNames(mfs map[string]*dto.MetricFamily) []string {
+	names := make([]string, 0, len(mfs))
+	for name := range mfs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// metricName strips prometheus_metrics_prefix, applies the metrics allow-list
+// and renaming, and prepends the namespace
+func (c *Check) metricName(name string) (string, bool) {
+	name = strings.TrimPrefix(name, c.PrometheusMetricsPrefix)
+
+	// all metrics are sent if the allow-list is empty
+	if len(c.metricsMapper) > 0 || len(c.metricsWildcards) > 0 {
+		newName, ok := c.metricsMapper[name]
+		if !ok {
+			for _, pattern := range c.metricsWildcards {
+				if matched, _ := path.Match(pattern, name); matched {
+					newName, ok = name, true
+					break
+				}
+			}
+		}
+		if !ok {
+			return "", false
+		}
+		name = newName
+	}
+
+	if c.Namespace != "" {
+		name = c.Namespace + "." + name
+	}
+
+	return name, true
+}
+
+// metricType returns the type of the metric family after type_overrides,
+// only the scalar types (gauge, counter, untyped) can be overridden
+func (c *Check) metricType(mf *dto.MetricFamily) dto.MetricType {
+	typ := mf.GetType()
+	if typ != dto.MetricType_GAUGE && typ != dto.MetricType_COUNTER && typ != dto.MetricType_UNTYPED {
+		return typ
+	}
+
+	name := strings.TrimPrefix(mf.GetName(), c.PrometheusMetricsPrefix)
+	if t, ok := c.typeOverrides[name]; ok {
+		return t
+	}
+	for _, pattern := range c.typeWildcards {
+		if matched, _ := path.Match(pattern, name); matched {
+			return c.typeOverrides[pattern]
+		}
+	}
+
+	return typ
+}
+
+// labelJoins returns label_to_match -> label value -> labels to add,
+// built from the target metrics of label_joins
+func (c *Check) labelJoins(mfs map[string]*dto.MetricFamily) map[string]map[string][]*dto.LabelPair {
+	joins := map[string]map[string][]*dto.LabelPair{}
+
+	for _, name := range familyNames(mfs) {
+		mf := mfs[name]
+		join, ok := c.LabelJoins[strings.TrimPrefix(mf.GetName(), c.PrometheusMetricsPrefix)]
+		if !ok {
+			continue
+		}
+
+		getAll := false
+		toGet := map[string]bool{}
+		for _, label := range join.LabelsToGet {
+			if label == "*" {
+				getAll = true
+			}
+			toGet[label] = true
+		}
+
+		mapping, ok := joins[join.LabelToMatch]
+		if !ok {
+			mapping = map[string][]*dto.LabelPair{}
+			joins[join.LabelToMatch] = mapping
+		}
+
+		for _, m := range mf.Metric {
+			value, ok := labelValue(m, join.LabelToMatch)
+			if !ok {
+				continue
+			}
+			for _, lp := range m.Label {
+				if lp.GetName() == join.LabelToMatch {
+					continue
+				}
+				if getAll || toGet[lp.GetName()] {
+					mapping[value] = append(mapping[value], lp)
+				}
+			}
+		}
+	}
+
+	return joins
+}
+
+// metricTags returns the hostname from label_to_hostname and the tags of the metric,
+// joined labels are added before exclude_labels and labels_mapper are applied
+func (c *Check) metricTags(m *dto.Metric, joins map[string]map[string][]*dto.LabelPair) (string, []string) {
+	labels := m.Label
+	if len(joins) > 0 {
+		labels = append([]*dto.LabelPair{}, m.Label...)
+		for labelToMatch, mapping := range joins {
+			if value, ok := labelValue(m, labelToMatch); ok {
+				for _, lp := range mapping[value] {
+					if _, exist := labelValue(m, lp.GetName()); !exist {
+						labels = append(labels, lp)
+					}
+				}
+			}
+		}
+	}
+
+	var hostname string
+	tags := make([]string, 0, len(labels))
+	for _, lp := range labels {
+		name := lp.GetName()
+		if c.LabelToHostname != "" && name == c.LabelToHostname {
+			hostname = lp.GetValue()
+		}
+		if c.excludeLabels[name] {
+			continue
+		}
+		if newName, ok := c.LabelsMapper[name]; ok {
+			name = newName
+		}
+		tags = append(tags, name+":"+lp.GetValue())
+	}
+
+	return hostname, tags
+}
+
+func labelValue(m *dto.Metric, name string) (string, bool) {
+	for _, lp := range m.Label {
+		if lp.GetName() == name {
+			return lp.GetValue(), true
+		}
+	}
+	return "", false
 }
 
 func readBody(buf []byte, header http.Header) (map[string]*dto.MetricFamily, error) {
@@ -226,7 +487,7 @@ func (c *Check) Configure(rawInstance integration.Data, rawInitConfig integratio
 	c.promConfig = config
 
 	if c.client, err = c.createHTTPClient(); err != nil {
-		return nil
+		return err
 	}
 
 	return nil
@@ -2,13 +2,16 @@ package prometheus
 
 import (
 	"fmt"
-	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/mock"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
 	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/n9e/n9e-agentd/pkg/config"
 )
 
 const sampleTextFormat = `
@@ -33,41 +36,216 @@ go_goroutines 15
 test_metric{label="value"} 1.0
 `
 
-func TestCollect(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, sampleTextFormat)
+func newMockSender(t *testing.T, check *Check) *mocksender.MockSender {
+	config.Mock()
+
+	sender := mocksender.NewMockSender(check.ID())
+	sender.On("Gauge", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+	sender.On("Rate", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+	sender.On("MonotonicCount", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+	sender.On("ServiceCheck", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+	sender.On("Commit").Return()
+
+	return sender
+}
+
+func newExporter(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
 	}))
+}
+
+func TestCollect(t *testing.T) {
+	ts := newExporter(sampleTextFormat)
 	defer ts.Close()
 
 	check := new(Check)
 	err := check.Configure([]byte(fmt.Sprintf(`
-prometheusUrl: %s
+prometheus_url: %s
 namespace: test
 `, ts.URL)), nil, "test")
 	assert.Nil(t, err)
 
-	sender := mocksender.NewMockSender(check.ID())
-
-	sender.On("Gauge", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
-	sender.On("Rate", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
-	sender.On("MonotonicCount", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
-	sender.On("Commit").Return()
+	sender := newMockSender(t, check)
 
 	err = check.Run()
 	assert.Nil(t, err)
 
-	sender.AssertCalled(t, "Gauge", "go_gc_duration_seconds_quantile", float64(0.00010425500000000001), "", []string{"quantile:0"})
-	sender.AssertCalled(t, "Gauge", "go_gc_duration_seconds_quantile", float64(0.000139108), "", []string{"quantile:0.25"})
-	sender.AssertCalled(t, "Gauge", "go_gc_duration_seconds_quantile", float64(0.00015749400000000002), "", []string{"quantile:0.5"})
-	sender.AssertCalled(t, "Gauge", "go_gc_duration_seconds_quantile", float64(0.000331463), "", []string{"quantile:0.75"})
-	sender.AssertCalled(t, "Gauge", "go_gc_duration_seconds_quantile", float64(0.000331463), "", []string{"quantile:0.75"})
-	sender.AssertCalled(t, "Gauge", "go_gc_duration_seconds_quantile", float64(0.000667154), "", []string{"quantile:1"})
-	sender.AssertCalled(t, "MonotonicCount", "go_gc_duration_seconds_sum", float64(0.0018183950000000002), "", []string{})
-	sender.AssertCalled(t, "MonotonicCount", "go_gc_duration_seconds_count", float64(7), "", []string{})
+	sender.AssertCalled(t, "Gauge", "test.go_gc_duration_seconds_quantile", float64(0.00010425500000000001), "", []string{"quantile:0"})
+	sender.AssertCalled(t, "Gauge", "test.go_gc_duration_seconds_quantile", float64(0.000139108), "", []string{"quantile:0.25"})
+	sender.AssertCalled(t, "Gauge", "test.go_gc_duration_seconds_quantile", float64(0.00015749400000000002), "", []string{"quantile:0.5"})
+	sender.AssertCalled(t, "Gauge", "test.go_gc_duration_seconds_quantile", float64(0.000331463), "", []string{"quantile:0.75"})
+	sender.AssertCalled(t, "Gauge", "test.go_gc_duration_seconds_quantile", float64(0.000667154), "", []string{"quantile:1"})
+	sender.AssertCalled(t, "MonotonicCount", "test.go_gc_duration_seconds_sum", float64(0.0018183950000000002), "", []string{})
+	sender.AssertCalled(t, "MonotonicCount", "test.go_gc_duration_seconds_count", float64(7), "", []string{})
+
+	sender.AssertCalled(t, "Gauge", "test.test_histogram_bucket", float64(1), "", []string{"start:positive", "le:0.1"})
+	sender.AssertCalled(t, "Gauge", "test.test_histogram_bucket", float64(2), "", []string{"start:positive", "le:0.2"})
+
+	sender.AssertCalled(t, "Gauge", "test.test_metric", float64(1), "", []string{"label:value"})
+	sender.AssertCalled(t, "ServiceCheck", "test.prometheus.health", metrics.ServiceCheckOK, "", []string(nil), "")
+	sender.AssertCalled(t, "Commit")
+}
+
+func TestCollectOptions(t *testing.T) {
+	const exporter = `
+# TYPE app_requests_total counter
+app_requests_total{code="200",pod="web-1",timestamp="1"} 10
+app_requests_total{code="500",pod="web-2",timestamp="1"} 2
+# TYPE app_inflight gauge
+app_inflight{pod="web-1"} 3
+# TYPE app_pod_info gauge
+app_pod_info{pod="web-1",node="node-a",version="v1"} 1
+app_pod_info{pod="web-2",node="node-b",version="v2"} 1
+# TYPE app_latency_seconds histogram
+app_latency_seconds_bucket{le="0.5"} 4
+app_latency_seconds_bucket{le="+Inf"} 5
+app_latency_seconds_sum 1.5
+app_latency_seconds_count 5
+app_untyped 7
+`
+
+	type call struct {
+		method   string
+		name     string
+		value    float64
+		hostname string
+		tags     []string
+	}
+
+	cases := []struct {
+		name      string
+		config    string
+		called    []call
+		notCalled []call
+		calls     int // number of metric submissions, ignored if 0
+	}{{
+		name:   "allow-list with renaming and wildcards",
+		config: "metrics:\n- app_inflight: inflight\n- app_latency_*\n",
+		called: []call{
+			{"Gauge", "inflight", 3, "", []string{"pod:web-1"}},
+			{"MonotonicCount", "app_latency_seconds_count", 5, "", []string{}},
+		},
+		notCalled: []call{
+			{"Gauge", "app_inflight", 3, "", []string{"pod:web-1"}},
+			{"Gauge", "app_untyped", 7, "", []string{}},
+		},
+	}, {
+		name:   "namespace and metrics prefix",
+		config: "namespace: ns\nprometheus_metrics_prefix: app_\nmetrics:\n- inflight\n",
+		called: []call{
+			{"Gauge", "ns.inflight", 3, "", []string{"pod:web-1"}},
+		},
+		calls: 1,
+	}, {
+		name:   "labels_mapper and exclude_labels",
+		config: "labels_mapper:\n  pod: pod_name\nexclude_labels:\n- timestamp\n",
+		called: []call{
+			{"MonotonicCount", "app_requests_total", 10, "", []string{"code:200", "pod_name:web-1"}},
+		},
+		notCalled: []call{
+			{"MonotonicCount", "app_requests_total", 10, "", []string{"code:200", "pod:web-1", "timestamp:1"}},
+		},
+	}, {
+		name:   "label_joins",
+		config: "exclude_labels:\n- timestamp\nlabel_joins:\n  app_pod_info:\n    label_to_match: pod\n    labels_to_get:\n    - node\n",
+		called: []call{
+			{"MonotonicCount", "app_requests_total", 10, "", []string{"code:200", "pod:web-1", "node:node-a"}},
+			{"MonotonicCount", "app_requests_total", 2, "", []string{"code:500", "pod:web-2", "node:node-b"}},
+			{"Gauge", "app_inflight", 3, "", []string{"pod:web-1", "node:node-a"}},
+		},
+	}, {
+		name:   "label_to_hostname",
+		config: "metrics:\n- app_pod_info\nlabel_to_hostname: node\n",
+		called: []call{
+			{"Gauge", "app_pod_info", 1, "node-a", []string{"pod:web-1", "node:node-a", "version:v1"}},
+			{"Gauge", "app_pod_info", 1, "node-b", []string{"pod:web-2", "node:node-b", "version:v2"}},
+		},
+	}, {
+		name:   "type_overrides",
+		config: "metrics:\n- app_untyped\n- app_inflight\ntype_overrides:\n  app_untyped: counter\n  app_*: counter\n",
+		called: []call{
+			{"MonotonicCount", "app_untyped", 7, "", []string{}},
+			{"MonotonicCount", "app_inflight", 3, "", []string{"pod:web-1"}},
+		},
+	}, {
+		name:   "type_overrides wildcards in order",
+		config: "metrics:\n- app_inflight\ntype_overrides:\n  app_in*: counter\n  app_*: untyped\n",
+		called: []call{
+			{"Gauge", "app_inflight", 3, "", []string{"pod:web-1"}},
+		},
+		calls: 1,
+	}, {
+		name:   "send_monotonic_counter disabled",
+		config: "metrics:\n- app_requests_total\nexclude_labels:\n- timestamp\n- pod\nsend_monotonic_counter: false\n",
+		called: []call{
+			{"Gauge", "app_requests_total", 10, "", []string{"code:200"}},
+		},
+		notCalled: []call{
+			{"MonotonicCount", "app_requests_total", 10, "", []string{"code:200"}},
+		},
+	}, {
+		name:   "send_histograms_buckets disabled",
+		config: "metrics:\n- app_latency_seconds\nsend_histograms_buckets: false\n",
+		called: []call{
+			{"MonotonicCount", "app_latency_seconds_sum", 1.5, "", []string{}},
+			{"MonotonicCount", "app_latency_seconds_count", 5, "", []string{}},
+		},
+		calls: 2,
+	}, {
+		name:   "max_returned_metrics",
+		config: "max_returned_metrics: 3\n",
+		called: []call{
+			{"Gauge", "app_inflight", 3, "", []string{"pod:web-1"}},
+			{"Gauge", "app_latency_seconds_bucket", 4, "", []string{"le:0.5"}},
+			{"Gauge", "app_latency_seconds_bucket", 5, "", []string{"le:+Inf"}},
+		},
+		calls: 3,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newExporter(exporter)
+			defer ts.Close()
+
+			check := new(Check)
+			err := check.Configure([]byte(fmt.Sprintf("prometheus_url: %s\n%s", ts.URL, c.config)), nil, "test")
+			assert.NoError(t, err)
+
+			sender := newMockSender(t, check)
+			assert.NoError(t, check.Run())
+
+			for _, call := range c.called {
+				sender.AssertCalled(t, call.method, call.name, call.value, call.hostname, call.tags)
+			}
+			for _, call := range c.notCalled {
+				sender.AssertNotCalled(t, call.method, call.name, call.value, call.hostname, call.tags)
+			}
+			if c.calls > 0 {
+				n := 0
+				for _, call := range sender.Calls {
+					if call.Method == "Gauge" || call.Method == "MonotonicCount" {
+						n++
+					}
+				}
+				assert.Equal(t, c.calls, n)
+			}
+		})
+	}
+}
+
+func TestHealthServiceCheck(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	check := new(Check)
+	err := check.Configure([]byte(fmt.Sprintf("prometheus_url: %s\nnamespace: app\n", ts.URL)), nil, "test")
+	assert.NoError(t, err)
 
-	sender.AssertCalled(t, "Gauge", "test_histogram_bucket", float64(1), "", []string{"start:positive", "le:0.1"})
-	sender.AssertCalled(t, "Gauge", "test_histogram_bucket", float64(2), "", []string{"start:positive", "le:0.2"})
+	sender := newMockSender(t, check)
+	assert.Error(t, check.Run())
 
-	sender.AssertCalled(t, "Gauge", "test_metric", float64(1), "", []string{"label:value"})
-	//sender.AssertCalled(t, "Commit")
+	sender.AssertCalled(t, "ServiceCheck", "app.prometheus.health", metrics.ServiceCheckCritical, "", []string(nil), mock.Anything)
 }
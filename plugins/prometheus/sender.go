@@ -4,14 +4,42 @@ import (
 	"fmt"
 	"math"
 
-	dto "github.com/prometheus/client_model/go"
 	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	dto "github.com/prometheus/client_model/go"
 )
 
+// limitedSender drops the series beyond max_returned_metrics
+type limitedSender struct {
+	aggregator.Sender
+	limit   int
+	sent    int
+	dropped int
+}
+
+func (s *limitedSender) allow() bool {
+	if s.limit > 0 && s.sent >= s.limit {
+		s.dropped++
+		return false
+	}
+	s.sent++
+	return true
+}
+
+func (s *limitedSender) Gauge(metric string, value float64, hostname string, tags []string) {
+	if s.allow() {
+		s.Sender.Gauge(metric, value, hostname, tags)
+	}
+}
+
+func (s *limitedSender) MonotonicCount(metric string, value float64, hostname string, tags []string) {
+	if s.allow() {
+		s.Sender.MonotonicCount(metric, value, hostname, tags)
+	}
+}
+
 // https://prometheus.io/docs/concepts/metric_types/#summary
 // https://github.com/OpenObservability/OpenMetrics/blob/master/specification/OpenMetrics.md#summary-1
-func sendSummary(metricName string, m *dto.Metric, sender aggregator.Sender) {
-	tags := makeTags(m)
+func sendSummary(sender aggregator.Sender, metricName string, m *dto.Metric, hostname string, tags []string) {
 	data := m.GetSummary()
 
 	quantileMetric := metricName + "_quantile"
@@ -19,54 +47,66 @@ func sendSummary(metricName string, m *dto.Metric, sender aggregator.Sender) {
 	countMetric := metricName + "_count"
 
 	for _, v := range data.GetQuantile() {
-		sender.Gauge(quantileMetric, v.GetValue(), "", append(tags, fmt.Sprintf("quantile:%v", v.GetQuantile())))
+		sender.Gauge(quantileMetric, v.GetValue(), hostname, appendTag(tags, fmt.Sprintf("quantile:%v", v.GetQuantile())))
 	}
-	sender.MonotonicCount(sumMetric, data.GetSampleSum(), "", tags)
-	sender.MonotonicCount(countMetric, float64(data.GetSampleCount()), "", tags)
+	sender.MonotonicCount(sumMetric, data.GetSampleSum(), hostname, tags)
+	sender.MonotonicCount(countMetric, float64(data.GetSampleCount()), hostname, tags)
 }
 
 // https://prometheus.io/docs/concepts/metric_types/#histogram
 // https://github.com/OpenObservability/OpenMetrics/blob/master/specification/OpenMetrics.md#histogram-1
-func sendHistogram(metricName string, m *dto.Metric, sender aggregator.Sender) {
-	tags := makeTags(m)
+func sendHistogram(sender aggregator.Sender, metricName string, m *dto.Metric, hostname string, tags []string, sendBuckets bool) {
 	data := m.GetHistogram()
 
 	quantileMetric := metricName + "_bucket"
 	sumMetric := metricName + "_sum"
 	countMetric := metricName + "_count"
 
-	for _, v := range data.GetBucket() {
-		sender.Gauge(quantileMetric, float64(v.GetCumulativeCount()), "",
-			append(tags, fmt.Sprintf("le:%v", v.GetUpperBound())))
+	if sendBuckets {
+		for _, v := range data.GetBucket() {
+			sender.Gauge(quantileMetric, float64(v.GetCumulativeCount()), hostname,
+				appendTag(tags, fmt.Sprintf("le:%v", v.GetUpperBound())))
+		}
 	}
-	sender.MonotonicCount(sumMetric, data.GetSampleSum(), "", tags)
-	sender.MonotonicCount(countMetric, float64(data.GetSampleCount()), "", tags)
+	sender.MonotonicCount(sumMetric, data.GetSampleSum(), hostname, tags)
+	sender.MonotonicCount(countMetric, float64(data.GetSampleCount()), hostname, tags)
 
 }
 
-func sendGauge(metricName string, m *dto.Metric, sender aggregator.Sender) {
-	if v := m.GetGauge().GetValue(); !math.IsNaN(v) {
-		sender.Gauge(metricName, v, "", makeTags(m))
+func sendGauge(sender aggregator.Sender, metricName string, m *dto.Metric, hostname string, tags []string) {
+	if v := scalarValue(m); !math.IsNaN(v) {
+		sender.Gauge(metricName, v, hostname, tags)
 	}
 }
 
-func sendCounter(metricName string, m *dto.Metric, sender aggregator.Sender) {
-	if v := m.GetCounter().GetValue(); !math.IsNaN(v) {
-		sender.MonotonicCount(metricName, v, "", makeTags(m))
+func sendCounter(sender aggregator.Sender, metricName string, m *dto.Metric, hostname string, tags []string, monotonic bool) {
+	v := scalarValue(m)
+	if math.IsNaN(v) {
+		return
 	}
-}
 
-func sendUntyped(metricName string, m *dto.Metric, sender aggregator.Sender) {
-	if v := m.GetUntyped().GetValue(); !math.IsNaN(v) {
-		sender.Gauge(metricName, v, "", makeTags(m))
+	if monotonic {
+		sender.MonotonicCount(metricName, v, hostname, tags)
+	} else {
+		sender.Gauge(metricName, v, hostname, tags)
 	}
 }
 
-// Get labels from metric
-func makeTags(m *dto.Metric) []string {
-	tags := make([]string, len(m.Label))
-	for i, lp := range m.Label {
-		tags[i] = lp.GetName() + ":" + lp.GetValue()
+// scalarValue returns the value of a gauge, counter or untyped metric,
+// whatever its type was overridden to
+func scalarValue(m *dto.Metric) float64 {
+	switch {
+	case m.Gauge != nil:
+		return m.GetGauge().GetValue()
+	case m.Counter != nil:
+		return m.GetCounter().GetValue()
+	case m.Untyped != nil:
+		return m.GetUntyped().GetValue()
 	}
-	return tags
+	return math.NaN()
+}
+
+// appendTag returns a new slice, tags may be shared by several series
+func appendTag(tags []string, tag string) []string {
+	return append(tags[:len(tags):len(tags)], tag)
 }
@@ -0,0 +1,55 @@
+// +build !windows
+
+package script
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+type credential = syscall.Credential
+
+// lookupCredential returns the uid, gid and groups of the user the scripts
+// run as, the agent must be run as root to switch to it
+func lookupCredential(name string) (*credential, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %q of user %s", u.Uid, name)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %q of user %s", u.Gid, name)
+	}
+
+	cred := &credential{Uid: uint32(uid), Gid: uint32(gid)}
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+				cred.Groups = append(cred.Groups, uint32(g))
+			}
+		}
+	}
+
+	return cred, nil
+}
+
+// setProcAttr runs the script in its own process group, so that its
+// children are killed with it
+func setProcAttr(cmd *exec.Cmd, cred *credential) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:    true,
+		Credential: cred,
+	}
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,250 @@
+// +build !windows
+
+package script
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/n9e/n9e-agentd/pkg/config"
+	"github.com/n9e/n9e-agentd/pkg/util/capturesender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCheck(dir, pattern string) *Check {
+	return &Check{config: &checkConfig{
+		filePath:      filepath.Join(dir, pattern),
+		env:           os.Environ(),
+		timeout:       5 * time.Second,
+		outputFormat:  formatN9eJSON,
+		maxOutputSize: defaultMaxOutputSize,
+		concurrency:   defaultConcurrency,
+	}}
+}
+
+func createTestScripts(t *testing.T, files []templateFile) string {
+	dir := createTestDir(files)
+	for _, file := range files {
+		require.NoError(t, os.Chmod(filepath.Join(dir, file.name), 0755))
+	}
+	return dir
+}
+
+// selfMetrics returns the script.* gauges by name and script tag
+func selfMetrics(sender *capturesender.CaptureSender) map[string]float64 {
+	ret := map[string]float64{}
+	for _, m := range sender.Metrics {
+		if strings.HasPrefix(m.Name, "script.") {
+			ret[m.Name+" "+strings.Join(m.Tags, ",")] = m.Value
+		}
+	}
+	return ret
+}
+
+func TestCollectSelfMetrics(t *testing.T) {
+	dir := createTestScripts(t, []templateFile{
+		{"ok.sh", "#!/bin/sh\necho '[{\"metric\":\"test1\", \"value\":1}]'\n"},
+		{"fail.sh", "#!/bin/sh\nexit 3\n"},
+	})
+	defer os.RemoveAll(dir)
+
+	sender := capturesender.New()
+	c := newTestCheck(dir, "*.sh")
+	require.NoError(t, c.collect(sender))
+
+	metrics := selfMetrics(sender)
+	assert.Equal(t, float64(0), metrics["script.exit_code script:ok.sh"])
+	assert.Equal(t, float64(0), metrics["script.timeout script:ok.sh"])
+	assert.Equal(t, float64(3), metrics["script.exit_code script:fail.sh"])
+	assert.Equal(t, float64(0), metrics["script.timeout script:fail.sh"])
+	assert.Contains(t, metrics, "script.duration script:ok.sh")
+	assert.Contains(t, metrics, "script.duration script:fail.sh")
+	assert.Len(t, sender.Metrics, 7)
+}
+
+func TestCollectTimeoutKillsProcessGroup(t *testing.T) {
+	// the grandchild keeps stdout open, the check would wait for it if
+	// only the script was killed
+	dir := createTestScripts(t, []templateFile{
+		{"slow.sh", "#!/bin/sh\nsleep 30 &\nsleep 30\n"},
+	})
+	defer os.RemoveAll(dir)
+
+	sender := capturesender.New()
+	c := newTestCheck(dir, "slow.sh")
+	c.config.timeout = 200 * time.Millisecond
+
+	start := time.Now()
+	require.NoError(t, c.collect(sender))
+	assert.Less(t, int64(time.Since(start)), int64(10*time.Second))
+
+	metrics := selfMetrics(sender)
+	assert.Equal(t, float64(-1), metrics["script.exit_code script:slow.sh"])
+	assert.Equal(t, float64(1), metrics["script.timeout script:slow.sh"])
+}
+
+func TestCollectDaemonKeepsOutput(t *testing.T) {
+	// the daemon double-forks out of the process group of the script and
+	// keeps stdout open, it's not killed with the script
+	daemon := `(setsid sh -c 'echo $$ > "$0.pid"; exec sleep 30' "$0" &)`
+	dir := createTestScripts(t, []templateFile{
+		{"exit.sh", "#!/bin/sh\necho '[{\"metric\":\"test1\", \"value\":1}]'\n" + daemon + "\n"},
+		{"slow.sh", "#!/bin/sh\n" + daemon + "\nsleep 30\n"},
+	})
+	defer os.RemoveAll(dir)
+	defer func() {
+		pids, _ := filepath.Glob(filepath.Join(dir, "*.pid"))
+		for _, file := range pids {
+			if b, err := os.ReadFile(file); err == nil {
+				if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+					syscall.Kill(pid, syscall.SIGKILL)
+				}
+			}
+		}
+	}()
+
+	sender := capturesender.New()
+	c := newTestCheck(dir, "*.sh")
+	c.config.timeout = 2 * time.Second
+
+	start := time.Now()
+	require.NoError(t, c.collect(sender))
+	assert.Less(t, int64(time.Since(start)), int64(2*time.Second+outputWaitDelay+time.Second))
+
+	metrics := selfMetrics(sender)
+	assert.Equal(t, float64(0), metrics["script.exit_code script:exit.sh"])
+	assert.Equal(t, float64(1), metrics["script.timeout script:slow.sh"])
+
+	var names []string
+	for _, m := range sender.Metrics {
+		names = append(names, m.Name)
+	}
+	assert.Contains(t, names, "test1")
+}
+
+func TestCollectMaxOutputSize(t *testing.T) {
+	dir := createTestScripts(t, []templateFile{
+		{"big.sh", "#!/bin/sh\necho '[{\"metric\":\"test1\", \"value\":1}]'\nhead -c 100000 /dev/zero\n"},
+	})
+	defer os.RemoveAll(dir)
+
+	sender := capturesender.New()
+	c := newTestCheck(dir, "big.sh")
+	c.config.maxOutputSize = 1024
+	require.NoError(t, c.collect(sender))
+
+	// only the self metrics are sent
+	assert.Len(t, sender.Metrics, 3)
+	assert.Equal(t, float64(0), selfMetrics(sender)["script.exit_code script:big.sh"])
+}
+
+func TestCollectConcurrency(t *testing.T) {
+	var files []templateFile
+	for i := 0; i < 4; i++ {
+		files = append(files, templateFile{fmt.Sprintf("%d.sh", i), "#!/bin/sh\nsleep 1\n"})
+	}
+	dir := createTestScripts(t, files)
+	defer os.RemoveAll(dir)
+
+	sender := capturesender.New()
+	c := newTestCheck(dir, "*.sh")
+	c.config.concurrency = 4
+
+	start := time.Now()
+	require.NoError(t, c.collect(sender))
+	assert.Less(t, int64(time.Since(start)), int64(3*time.Second))
+	assert.Len(t, sender.Metrics, 12)
+}
+
+// series returns the series of the check samplers flushed by the commits
+func series(t *testing.T, agg *aggregator.BufferedAggregator, want int) map[string]*metrics.Serie {
+	ret := map[string]*metrics.Serie{}
+	require.Eventually(t, func() bool {
+		series, _ := agg.GetSeriesAndSketches(time.Now())
+		for _, serie := range series {
+			if !strings.HasPrefix(serie.Name, "script.") {
+				ret[serie.Name] = serie
+			}
+		}
+		return len(ret) >= want
+	}, time.Second, 10*time.Millisecond)
+	return ret
+}
+
+func TestCollectInstanceTags(t *testing.T) {
+	config.Mock()
+	agg := aggregator.InitAggregatorWithFlushInterval(nil, nil, "", time.Hour)
+
+	// the samples have a timestamp, the counter is increased on every run
+	dir := createTestScripts(t, []templateFile{
+		{"influx.sh", "#!/bin/sh\necho \"cpu,core=0 usage=1.5 $(date +%s)000000000\"\n"},
+		{"prometheus.sh", `#!/bin/sh
+f=$(dirname $0)/count
+n=$(( $(cat $f 2>/dev/null || echo 0) + 10 ))
+echo $n > $f
+echo "# TYPE req_total counter"
+echo "req_total{code=\"200\"} $n $(( $(date +%s) - 100 + n ))000"
+`},
+	})
+	defer os.RemoveAll(dir)
+
+	run := func(format string) *Check {
+		c := checkFactory().(*Check)
+		instance := fmt.Sprintf("{file_path: %s/%s.sh, output_format: %s, tags: [env:test]}", dir, format, format)
+		require.NoError(t, c.Configure([]byte(instance), nil, "test"))
+		t.Cleanup(func() { aggregator.DestroySender(c.ID()) })
+
+		require.NoError(t, c.Run())
+		require.NoError(t, c.Run())
+		return c
+	}
+
+	run(formatInflux)
+	got := series(t, agg, 1)
+	require.Contains(t, got, "cpu.usage")
+	assert.ElementsMatch(t, []string{"core:0", "env:test"}, got["cpu.usage"].Tags)
+
+	run(formatPrometheus)
+	got = series(t, agg, 1)
+	require.Contains(t, got, "req_total")
+	assert.ElementsMatch(t, []string{"code:200", "env:test"}, got["req_total"].Tags)
+	assert.Equal(t, float64(10), got["req_total"].Points[0].Value)
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &limitedBuffer{max: 5}
+
+	n, err := b.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.False(t, b.truncated)
+
+	n, err = b.Write([]byte("defg"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.True(t, b.truncated)
+	assert.Equal(t, "abcde", b.String())
+}
+
+func TestLookupCredential(t *testing.T) {
+	u, err := user.Current()
+	require.NoError(t, err)
+
+	cred, err := lookupCredential(u.Username)
+	require.NoError(t, err)
+	assert.Equal(t, u.Uid, fmt.Sprintf("%d", cred.Uid))
+	assert.Equal(t, u.Gid, fmt.Sprintf("%d", cred.Gid))
+
+	_, err = lookupCredential("no-such-user-n9e")
+	assert.Error(t, err)
+}
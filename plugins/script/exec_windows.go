@@ -0,0 +1,20 @@
+// +build windows
+
+package script
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+type credential struct{}
+
+func lookupCredential(name string) (*credential, error) {
+	return nil, fmt.Errorf("user is not supported on windows")
+}
+
+func setProcAttr(cmd *exec.Cmd, cred *credential) {}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
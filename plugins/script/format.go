@@ -0,0 +1,368 @@
+package script
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/n9e/n9e-agentd/pkg/util/falcon"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// output formats of the scripts
+const (
+	formatN9eJSON    = "n9e-json"
+	formatPrometheus = "prometheus"
+	formatInflux     = "influx"
+	formatNagios     = "nagios"
+)
+
+// parseFunc converts the stdout of a script to series
+type parseFunc func(data []byte) ([]*falcon.MetricValue, error)
+
+var parsers = map[string]parseFunc{
+	formatN9eJSON:    falcon.Unmarshal,
+	formatPrometheus: parsePrometheus,
+	formatInflux:     parseInflux,
+	formatNagios:     parseNagios,
+}
+
+func gauge(metric string, value float64, tags falcon.Tags, timestamp int64) *falcon.MetricValue {
+	return &falcon.MetricValue{
+		Metric:    metric,
+		Value:     value,
+		Type:      "GAUGE",
+		Tags:      tags,
+		Timestamp: timestamp,
+	}
+}
+
+func monotonicCount(metric string, value float64, tags falcon.Tags, timestamp int64) *falcon.MetricValue {
+	m := gauge(metric, value, tags, timestamp)
+	m.Type = "MONOTONIC_COUNT"
+	return m
+}
+
+// withTag returns a copy of tags with k=v, tags may be shared by several series
+func withTag(tags falcon.Tags, k, v string) falcon.Tags {
+	ret := make(falcon.Tags, len(tags)+1)
+	for k, v := range tags {
+		ret[k] = v
+	}
+	ret[k] = v
+	return ret
+}
+
+// parsePrometheus parses the prometheus text exposition format, the
+// counters are sent as monotonic counts, the summaries and the histograms
+// as in the prometheus check. The samples are sent with their timestamps,
+// see falcon.Send
+func parsePrometheus(data []byte) ([]*falcon.MetricValue, error) {
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var series []*falcon.MetricValue
+	for name, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			tags := falcon.Tags{}
+			for _, lp := range m.GetLabel() {
+				tags[lp.GetName()] = lp.GetValue()
+			}
+			ts := m.GetTimestampMs() / 1000
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				series = append(series, monotonicCount(name, m.GetCounter().GetValue(), tags, ts))
+			case dto.MetricType_GAUGE:
+				series = append(series, gauge(name, m.GetGauge().GetValue(), tags, ts))
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					series = append(series, gauge(name+"_quantile", q.GetValue(),
+						withTag(tags, "quantile", fmt.Sprintf("%v", q.GetQuantile())), ts))
+				}
+				series = append(series,
+					monotonicCount(name+"_sum", s.GetSampleSum(), tags, ts),
+					monotonicCount(name+"_count", float64(s.GetSampleCount()), tags, ts))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.GetBucket() {
+					series = append(series, gauge(name+"_bucket", float64(b.GetCumulativeCount()),
+						withTag(tags, "le", fmt.Sprintf("%v", b.GetUpperBound())), ts))
+				}
+				series = append(series,
+					monotonicCount(name+"_sum", h.GetSampleSum(), tags, ts),
+					monotonicCount(name+"_count", float64(h.GetSampleCount()), tags, ts))
+			default:
+				series = append(series, gauge(name, m.GetUntyped().GetValue(), tags, ts))
+			}
+		}
+	}
+
+	return series, nil
+}
+
+// parseInflux parses the influx line protocol, each numeric or boolean
+// field is sent as a gauge named measurement.field, the string fields are
+// ignored. The timestamps are in nanoseconds, the gauges are sent with them.
+// https://docs.influxdata.com/influxdb/v1.8/write_protocols/line_protocol_reference/
+func parseInflux(data []byte) ([]*falcon.MetricValue, error) {
+	var series []*falcon.MetricValue
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		s, err := parseInfluxLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		series = append(series, s...)
+	}
+
+	return series, nil
+}
+
+func parseInfluxLine(line string) ([]*falcon.MetricValue, error) {
+	parts := splitEscaped(line, ' ', true)
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid line %q", line)
+	}
+
+	var ts int64
+	if len(parts) == 3 {
+		ns, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", parts[2])
+		}
+		ts = ns / 1e9
+	}
+
+	keys := splitEscaped(parts[0], ',', false)
+	measurement := unescape(keys[0])
+	if measurement == "" {
+		return nil, fmt.Errorf("measurement is empty")
+	}
+
+	tags := falcon.Tags{}
+	for _, kv := range keys[1:] {
+		k, v, err := splitKeyValue(kv)
+		if err != nil {
+			return nil, err
+		}
+		tags[k] = v
+	}
+
+	var series []*falcon.MetricValue
+	for _, kv := range splitEscaped(parts[1], ',', true) {
+		k, v, err := splitKeyValue(kv)
+		if err != nil {
+			return nil, err
+		}
+
+		value, ok, err := influxFieldValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %s", k, err)
+		}
+		if !ok {
+			continue
+		}
+		series = append(series, gauge(measurement+"."+k, value, tags, ts))
+	}
+
+	return series, nil
+}
+
+// influxFieldValue returns false for the string fields
+func influxFieldValue(v string) (float64, bool, error) {
+	switch v {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+
+	if strings.HasPrefix(v, `"`) {
+		return 0, false, nil
+	}
+
+	if n := len(v); n > 0 && (v[n-1] == 'i' || v[n-1] == 'u') {
+		v = v[:n-1]
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid value %q", v)
+	}
+	return f, true, nil
+}
+
+func splitKeyValue(kv string) (string, string, error) {
+	parts := splitEscaped(kv, '=', false)
+	if len(parts) < 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("invalid key value %q", kv)
+	}
+	return unescape(parts[0]), unescape(strings.Join(parts[1:], "=")), nil
+}
+
+// splitEscaped splits s on the sep which are not escaped by a backslash,
+// nor double quoted if quoted is set
+func splitEscaped(s string, sep byte, quoted bool) []string {
+	var (
+		parts   []string
+		start   int
+		inQuote bool
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"' && quoted:
+			inQuote = !inQuote
+		case c == sep && !inQuote:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+var influxUnescaper = strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=", `\"`, `"`, `\\`, `\`)
+
+func unescape(s string) string {
+	return influxUnescaper.Replace(s)
+}
+
+// parseNagios parses the performance data of the nagios plugins output,
+// each label is sent as nagios.value, and its thresholds as nagios.warning,
+// nagios.critical, nagios.min and nagios.max, with the perfdata and unit
+// tags. The status of the plugin is sent by the check from its exit code.
+// https://nagios-plugins.org/doc/guidelines.html#AEN200
+func parseNagios(data []byte) ([]*falcon.MetricValue, error) {
+	_, perfdata := splitNagiosOutput(data)
+
+	var series []*falcon.MetricValue
+	for _, item := range splitPerfdata(perfdata) {
+		s, err := parsePerfdataItem(item)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, s...)
+	}
+
+	return series, nil
+}
+
+// splitNagiosOutput returns the text of the first line and the perfdata,
+// which are after the | of the first line, and after the first | of the
+// long text until the end of the output
+func splitNagiosOutput(data []byte) (string, string) {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	var (
+		text     string
+		perfdata []string
+	)
+
+	text = lines[0]
+	if i := strings.Index(text, "|"); i >= 0 {
+		perfdata = append(perfdata, text[i+1:])
+		text = text[:i]
+	}
+
+	for j, line := range lines[1:] {
+		if i := strings.Index(line, "|"); i >= 0 {
+			perfdata = append(perfdata, line[i+1:])
+			perfdata = append(perfdata, lines[j+2:]...)
+			break
+		}
+	}
+
+	return strings.TrimSpace(text), strings.Join(perfdata, " ")
+}
+
+// splitPerfdata splits on the spaces which are not in a quoted label
+func splitPerfdata(s string) []string {
+	var (
+		items   []string
+		start   int
+		inQuote bool
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'':
+			inQuote = !inQuote
+		case ' ', '\t':
+			if !inQuote {
+				if item := s[start:i]; item != "" {
+					items = append(items, item)
+				}
+				start = i + 1
+			}
+		}
+	}
+	if item := s[start:]; item != "" {
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// parsePerfdataItem parses 'label'=value[UOM];[warn];[crit];[min];[max],
+// the thresholds which are ranges are ignored
+func parsePerfdataItem(item string) ([]*falcon.MetricValue, error) {
+	i := strings.LastIndex(item, "=")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid perfdata %q", item)
+	}
+
+	label := strings.Trim(item[:i], "'")
+	fields := strings.Split(item[i+1:], ";")
+
+	value, uom := splitUOM(fields[0])
+	if value == "U" {
+		// the value could not be determined
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid perfdata %q: value %q is not a number", item, value)
+	}
+
+	tags := falcon.Tags{"perfdata": label}
+	if uom != "" {
+		tags["unit"] = uom
+	}
+
+	series := []*falcon.MetricValue{gauge("nagios.value", v, tags, 0)}
+	for j, name := range []string{"nagios.warning", "nagios.critical", "nagios.min", "nagios.max"} {
+		if j+1 >= len(fields) || fields[j+1] == "" {
+			continue
+		}
+		if f, err := strconv.ParseFloat(fields[j+1], 64); err == nil {
+			series = append(series, gauge(name, f, tags, 0))
+		}
+	}
+
+	return series, nil
+}
+
+func splitUOM(s string) (string, string) {
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r == '.' || r == '-' || r == '+' || r == 'e' || r == 'E' || r == 'U')
+	})
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
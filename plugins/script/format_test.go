@@ -0,0 +1,212 @@
+package script
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/collector/check"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/n9e/n9e-agentd/pkg/config"
+	"github.com/n9e/n9e-agentd/pkg/util/capturesender"
+	"github.com/n9e/n9e-agentd/pkg/util/falcon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sortSeries(series []*falcon.MetricValue) {
+	sort.SliceStable(series, func(i, j int) bool {
+		return series[i].Metric < series[j].Metric
+	})
+}
+
+func TestParsePrometheus(t *testing.T) {
+	series, err := parsePrometheus([]byte(`# TYPE requests_total counter
+requests_total{code="200"} 10 1600000000000
+# TYPE temperature gauge
+temperature 21.5
+# TYPE latency summary
+latency{quantile="0.5"} 0.2
+latency_sum 12
+latency_count 30
+`))
+	require.NoError(t, err)
+	sortSeries(series)
+
+	assert.Equal(t, []*falcon.MetricValue{
+		{Metric: "latency_count", Value: float64(30), Type: "MONOTONIC_COUNT", Tags: falcon.Tags{}},
+		{Metric: "latency_quantile", Value: 0.2, Type: "GAUGE", Tags: falcon.Tags{"quantile": "0.5"}},
+		{Metric: "latency_sum", Value: float64(12), Type: "MONOTONIC_COUNT", Tags: falcon.Tags{}},
+		{Metric: "requests_total", Value: float64(10), Type: "MONOTONIC_COUNT", Tags: falcon.Tags{"code": "200"}, Timestamp: 1600000000},
+		{Metric: "temperature", Value: 21.5, Type: "GAUGE", Tags: falcon.Tags{}},
+	}, series)
+
+	_, err = parsePrometheus([]byte("metric{ 1\n"))
+	assert.Error(t, err)
+}
+
+func TestParseInflux(t *testing.T) {
+	series, err := parseInflux([]byte(`
+# comment
+cpu,host=a,region=us\ west usage=0.5,count=3i,up=true,name="x y" 1600000000000000000
+disk\,io,path=/ used=10u
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []*falcon.MetricValue{
+		{Metric: "cpu.usage", Value: 0.5, Type: "GAUGE", Tags: falcon.Tags{"host": "a", "region": "us west"}, Timestamp: 1600000000},
+		{Metric: "cpu.count", Value: float64(3), Type: "GAUGE", Tags: falcon.Tags{"host": "a", "region": "us west"}, Timestamp: 1600000000},
+		{Metric: "cpu.up", Value: float64(1), Type: "GAUGE", Tags: falcon.Tags{"host": "a", "region": "us west"}, Timestamp: 1600000000},
+		{Metric: "disk,io.used", Value: float64(10), Type: "GAUGE", Tags: falcon.Tags{"path": "/"}},
+	}, series)
+
+	for _, line := range []string{
+		"cpu",
+		"cpu usage=abc",
+		"cpu usage=1 abc",
+		"cpu,host usage=1",
+	} {
+		_, err := parseInflux([]byte(line))
+		assert.Errorf(t, err, "line %q", line)
+	}
+}
+
+func TestParseNagios(t *testing.T) {
+	out := []byte(`DISK OK - free space: / 3326 MB (56%); | /=2643MB;5948;5958;0;5968
+/ 15272 MB (77%);
+/boot 68 MB (69%);
+/home 69357 MB (27%);
+/var/log 819 MB (84%); | /boot=68MB;88;93;0;98
+/home=69357MB;253404;253409;0;253414
+'/var log'=818MB;970;975;0;980 time=U;~:1
+`)
+
+	text, _ := splitNagiosOutput(out)
+	assert.Equal(t, "DISK OK - free space: / 3326 MB (56%);", text)
+
+	series, err := parseNagios(out)
+	require.NoError(t, err)
+
+	values := map[string]float64{}
+	for _, s := range series {
+		values[s.Metric+" "+s.Tags["perfdata"]+" "+s.Tags["unit"]] = s.Value.(float64)
+	}
+	assert.Equal(t, map[string]float64{
+		"nagios.value / MB":           2643,
+		"nagios.warning / MB":         5948,
+		"nagios.critical / MB":        5958,
+		"nagios.min / MB":             0,
+		"nagios.max / MB":             5968,
+		"nagios.value /boot MB":       68,
+		"nagios.warning /boot MB":     88,
+		"nagios.critical /boot MB":    93,
+		"nagios.min /boot MB":         0,
+		"nagios.max /boot MB":         98,
+		"nagios.value /home MB":       69357,
+		"nagios.warning /home MB":     253404,
+		"nagios.critical /home MB":    253409,
+		"nagios.min /home MB":         0,
+		"nagios.max /home MB":         253414,
+		"nagios.value /var log MB":    818,
+		"nagios.warning /var log MB":  970,
+		"nagios.critical /var log MB": 975,
+		"nagios.min /var log MB":      0,
+		"nagios.max /var log MB":      980,
+	}, values)
+
+	_, err = parseNagios([]byte("OK | a=abc"))
+	assert.Error(t, err)
+
+	series, err = parseNagios([]byte("OK"))
+	assert.NoError(t, err)
+	assert.Len(t, series, 0)
+}
+
+func TestSendNagiosStatus(t *testing.T) {
+	cases := []struct {
+		script  string
+		status  string
+		message string
+	}{
+		{"echo 'PING OK | rta=1ms'", "OK", "PING OK"},
+		{"echo 'PING WARNING'; exit 1", "WARNING", "PING WARNING"},
+		{"echo 'PING CRITICAL'; exit 2", "CRITICAL", "PING CRITICAL"},
+		{"echo 'PING UNKNOWN'; exit 3", "UNKNOWN", "PING UNKNOWN"},
+		{"exit 4", "UNKNOWN", ""},
+	}
+
+	for _, c := range cases {
+		sender := capturesender.New()
+
+		cmd := exec.CommandContext(context.Background(), "/bin/sh", "-c", c.script)
+		out, err := cmd.Output()
+		sendNagiosStatus(sender, "/etc/script.d/check_ping.sh", cmd, err, out)
+
+		require.Len(t, sender.ServiceChecks, 1, c.script)
+		sc := sender.ServiceChecks[0]
+		assert.Equal(t, nagiosServiceCheckName, sc.Name)
+		assert.Equal(t, c.status, sc.Status, c.script)
+		assert.Equal(t, c.message, sc.Message, c.script)
+		assert.Equal(t, []string{"script:check_ping.sh"}, sc.Tags)
+	}
+}
+
+func TestSendFormat(t *testing.T) {
+	sender := capturesender.New()
+
+	err := send(sender, formatInflux, []byte("cpu,host=a usage=0.5,count=NaN"))
+	require.NoError(t, err)
+	require.Len(t, sender.Metrics, 1)
+	assert.Equal(t, "cpu.usage", sender.Metrics[0].Name)
+	assert.Equal(t, "gauge", sender.Metrics[0].Type)
+	assert.Equal(t, []string{"host:a"}, sender.Metrics[0].Tags)
+
+	assert.Error(t, send(sender, "xml", []byte("<a/>")))
+}
+
+func TestSendInfluxTimestamp(t *testing.T) {
+	config.Mock()
+	agg := aggregator.InitAggregatorWithFlushInterval(nil, nil, "agent-host", time.Hour)
+
+	id := check.ID("script_test:1")
+	sender, err := aggregator.GetSender(id)
+	require.NoError(t, err)
+	defer aggregator.DestroySender(id)
+
+	// the gauge is flushed at the time of the line, not of the commit
+	ts := time.Now().Unix() - 60
+	line := fmt.Sprintf("cpu,host=a usage=0.5 %d", ts*1e9)
+	require.NoError(t, send(sender, formatInflux, []byte(line)))
+	sender.Commit()
+
+	var got []*metrics.Serie
+	require.Eventually(t, func() bool {
+		series, _ := agg.GetSeriesAndSketches(time.Now())
+		for _, serie := range series {
+			if serie.Name == "cpu.usage" {
+				got = append(got, serie)
+			}
+		}
+		return len(got) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	require.Len(t, got, 1)
+	assert.Equal(t, []metrics.Point{{Ts: float64(ts), Value: 0.5}}, got[0].Points)
+}
+
+func TestBuildConfigOutputFormat(t *testing.T) {
+	config, err := buildConfig([]byte(`file_path: /bin/true`), nil)
+	require.NoError(t, err)
+	assert.Equal(t, formatN9eJSON, config.outputFormat)
+
+	config, err = buildConfig([]byte(`{file_path: /bin/true, output_format: nagios}`), nil)
+	require.NoError(t, err)
+	assert.Equal(t, formatNagios, config.outputFormat)
+
+	_, err = buildConfig([]byte(`{file_path: /bin/true, output_format: xml}`), nil)
+	assert.Error(t, err)
+}
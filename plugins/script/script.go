@@ -4,31 +4,43 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
-	"github.com/n9e/n9e-agentd/pkg/util"
 	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
 	"github.com/DataDog/datadog-agent/pkg/collector/check"
 	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/n9e/n9e-agentd/pkg/util"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/yaml"
 )
 
 const (
-	checkName      = "script"
-	defaultTimeout = 5
+	checkName            = "script"
+	defaultTimeout       = 5
+	defaultMaxOutputSize = 1 << 20
+	defaultConcurrency   = 4
+
+	// maxStderrSize limits the stderr of the scripts, which is only logged
+	maxStderrSize = 4 << 10
+
+	nagiosServiceCheckName = "script.nagios.status"
 )
 
 type InitConfig struct {
-	Root    string            `json:"root"`
-	Env     map[string]string `json:"env"`
-	Timeout int               `json:"timeout"`
+	Root          string            `json:"root"`
+	Env           map[string]string `json:"env"`
+	Timeout       int               `json:"timeout"`
+	MaxOutputSize int               `json:"max_output_size"`
+	Concurrency   int               `json:"concurrency"`
 }
 
 type InstanceConfig struct {
@@ -38,14 +50,27 @@ type InstanceConfig struct {
 	Env      map[string]string `json:"env"`
 	Stdin    string            `json:"stdin"`
 	Timeout  int               `json:"timeout"`
+	// OutputFormat is n9e-json, prometheus, influx or nagios
+	OutputFormat string `json:"output_format"`
+	// MaxOutputSize is the max size in bytes of the stdout of a script,
+	// the output of the scripts which exceed it is dropped
+	MaxOutputSize int `json:"max_output_size"`
+	// Concurrency is the max number of the matched files run at the same time
+	Concurrency int `json:"concurrency"`
+	// User runs the scripts as another user
+	User string `json:"user"`
 }
 
 type checkConfig struct {
-	filePath string
-	env      []string
-	params   []string
-	stdin    string
-	timeout  time.Duration
+	filePath      string
+	env           []string
+	params        []string
+	stdin         string
+	timeout       time.Duration
+	outputFormat  string
+	maxOutputSize int
+	concurrency   int
+	credential    *credential
 }
 
 func (p checkConfig) String() string {
@@ -72,7 +97,9 @@ func (c *Check) Configure(rawInstance integration.Data, rawInitConfig integratio
 }
 
 func defaultInstanceConfig() InstanceConfig {
-	return InstanceConfig{}
+	return InstanceConfig{
+		OutputFormat: formatN9eJSON,
+	}
 }
 
 func buildConfig(rawInstance integration.Data, rawInitConfig integration.Data) (*checkConfig, error) {
@@ -80,8 +107,10 @@ func buildConfig(rawInstance integration.Data, rawInitConfig integration.Data) (
 	pwd, _ := os.Getwd()
 
 	initConfig := InitConfig{
-		Root:    filepath.Join(pwd, "script.d"),
-		Timeout: defaultTimeout,
+		Root:          filepath.Join(pwd, "script.d"),
+		Timeout:       defaultTimeout,
+		MaxOutputSize: defaultMaxOutputSize,
+		Concurrency:   defaultConcurrency,
 	}
 
 	err := yaml.Unmarshal(rawInitConfig, &initConfig)
@@ -110,7 +139,37 @@ func buildConfig(rawInstance integration.Data, rawInitConfig integration.Data) (
 		instance.Timeout = defaultTimeout
 	}
 
-	config := &checkConfig{}
+	if instance.MaxOutputSize <= 0 {
+		instance.MaxOutputSize = initConfig.MaxOutputSize
+	}
+
+	if instance.MaxOutputSize <= 0 {
+		instance.MaxOutputSize = defaultMaxOutputSize
+	}
+
+	if instance.Concurrency <= 0 {
+		instance.Concurrency = initConfig.Concurrency
+	}
+
+	if instance.Concurrency <= 0 {
+		instance.Concurrency = defaultConcurrency
+	}
+
+	if _, ok := parsers[instance.OutputFormat]; !ok {
+		return nil, fmt.Errorf("unsupported output_format %q", instance.OutputFormat)
+	}
+
+	config := &checkConfig{
+		outputFormat:  instance.OutputFormat,
+		maxOutputSize: instance.MaxOutputSize,
+		concurrency:   instance.Concurrency,
+	}
+
+	if instance.User != "" {
+		if config.credential, err = lookupCredential(instance.User); err != nil {
+			return nil, fmt.Errorf("user %s: %s", instance.User, err)
+		}
+	}
 
 	if !filepath.IsAbs(instance.FilePath) && instance.Root != "" {
 		if !isDir(instance.Root) {
@@ -163,9 +222,21 @@ func (c *Check) Run() error {
 }
 
 func (c *Check) collect(sender aggregator.Sender) error {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, c.config.concurrency)
+
 	for _, file := range c.getFiles() {
-		c._collect(sender, file)
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(file string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			c._collect(sender, file)
+		}(file)
 	}
+	wg.Wait()
 
 	sender.Commit()
 	return nil
@@ -178,10 +249,11 @@ func (c *Check) _collect(sender aggregator.Sender, file string) {
 	ctx, cancel := context.WithTimeout(context.Background(), cf.timeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, file, cf.params...)
+	cmd := exec.Command(file, cf.params...)
+	setProcAttr(cmd, cf.credential)
 
-	stdout := bytes.NewBuffer([]byte{})
-	stderr := bytes.NewBuffer([]byte{})
+	stdout := &limitedBuffer{max: cf.maxOutputSize}
+	stderr := &limitedBuffer{max: maxStderrSize}
 
 	cmd.Stdin = bytes.NewReader([]byte(cf.stdin))
 	cmd.Stdout = stdout
@@ -189,12 +261,26 @@ func (c *Check) _collect(sender aggregator.Sender, file string) {
 
 	cmd.Env = cf.env
 
-	err := cmd.Run()
+	start := time.Now()
+	err := run(ctx, cmd)
+	sendSelfMetrics(sender, file, cmd, time.Since(start), ctx.Err() == context.DeadlineExceeded)
+
+	if cf.outputFormat == formatNagios {
+		// the nagios plugins report their status with the exit code
+		sendNagiosStatus(sender, file, cmd, err, stdout.Bytes())
+	}
 	if err != nil {
 		klog.Warningf("%s run err %s", cmd.String(), err)
 		if err := stderr.String(); err != "" {
 			klog.Warningf("stderr: %s", err)
 		}
+		if _, ok := err.(*exec.ExitError); !ok || cf.outputFormat != formatNagios || ctx.Err() != nil {
+			return
+		}
+	}
+
+	if stdout.truncated {
+		klog.Warningf("stdout of %s exceeds max_output_size %d, dropped", file, cf.maxOutputSize)
 		return
 	}
 
@@ -205,12 +291,158 @@ func (c *Check) _collect(sender aggregator.Sender, file string) {
 	}
 	klog.V(6).Infof("%s stdout: %s", file, string(out))
 
-	if err := send(sender, out); err != nil {
+	if err := send(sender, cf.outputFormat, out); err != nil {
 		klog.Warningf("send of %s err %s", file, err)
 		return
 	}
 }
 
+// sendNagiosStatus sends the script.nagios.status service check, 0 is OK,
+// 1 WARNING, 2 CRITICAL, and the others, or a failure to run, UNKNOWN
+func sendNagiosStatus(sender aggregator.Sender, file string, cmd *exec.Cmd, err error, out []byte) {
+	status := metrics.ServiceCheckUnknown
+	message, _ := splitNagiosOutput(out)
+
+	if err == nil || cmd.ProcessState != nil && cmd.ProcessState.Exited() {
+		if s, err := metrics.GetServiceCheckStatus(cmd.ProcessState.ExitCode()); err == nil {
+			status = s
+		}
+	} else if message == "" {
+		message = err.Error()
+	}
+
+	sender.ServiceCheck(nagiosServiceCheckName, status, "", scriptTags(file), message)
+}
+
+// sendSelfMetrics sends the exit code of the script, -1 if it could not
+// be run or was killed, its duration in seconds, and whether it timed out
+func sendSelfMetrics(sender aggregator.Sender, file string, cmd *exec.Cmd, duration time.Duration, timeout bool) {
+	tags := scriptTags(file)
+
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+
+	var timedOut float64
+	if timeout {
+		timedOut = 1
+	}
+
+	sender.Gauge("script.exit_code", float64(exitCode), "", tags)
+	sender.Gauge("script.duration", duration.Seconds(), "", tags)
+	sender.Gauge("script.timeout", timedOut, "", tags)
+}
+
+func scriptTags(file string) []string {
+	return []string{"script:" + filepath.Base(file)}
+}
+
+// outputWaitDelay is how long the output of a script is read after it
+// exits, or is killed, a descendant which left its process group, e.g. a
+// daemon, may keep the output open
+const outputWaitDelay = time.Second
+
+// outputPipe copies the output of the script to the writer of the command,
+// the pipe is created by run and not by exec, so that the reader can be
+// closed, and Wait does not wait for the descendants of the script
+type outputPipe struct {
+	r, w *os.File
+	dst  io.Writer
+}
+
+// run runs the command until it exits, or kills its process group when
+// the context is done, the output is read for at most outputWaitDelay
+// after that
+func run(ctx context.Context, cmd *exec.Cmd) error {
+	var pipes []*outputPipe
+	defer func() {
+		for _, p := range pipes {
+			p.r.Close()
+		}
+	}()
+
+	for _, w := range []*io.Writer{&cmd.Stdout, &cmd.Stderr} {
+		if *w == nil {
+			continue
+		}
+		r, pw, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		pipes = append(pipes, &outputPipe{r: r, w: pw, dst: *w})
+		*w = pw
+	}
+
+	err := cmd.Start()
+	// the writers are kept by the script only
+	for _, p := range pipes {
+		p.w.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	copied := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, p := range pipes {
+		wg.Add(1)
+		go func(p *outputPipe) {
+			defer wg.Done()
+			io.Copy(p.dst, p.r)
+		}(p)
+	}
+	go func() {
+		wg.Wait()
+		close(copied)
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		if err := killProcessGroup(cmd); err != nil {
+			klog.Warningf("kill %s err %s", cmd.String(), err)
+		}
+		err = <-done
+	}
+
+	select {
+	case <-copied:
+	case <-time.After(outputWaitDelay):
+		klog.Warningf("output of %s is still open after it exited, closed", cmd.String())
+		for _, p := range pipes {
+			p.r.Close()
+		}
+		<-copied
+	}
+
+	return err
+}
+
+// limitedBuffer keeps the first max bytes written to it and discards the
+// others, so that a script is not blocked by a full pipe
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if n := b.max - b.Len(); len(p) > n {
+		b.truncated = true
+		if n > 0 {
+			b.Buffer.Write(p[:n])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
 func (c *Check) getFiles() []string {
 	files, _ := filepath.Glob(c.config.filePath)
 	return files
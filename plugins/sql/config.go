@@ -0,0 +1,99 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
+	"github.com/n9e/n9e-agentd/pkg/util"
+	"github.com/n9e/n9e-agentd/pkg/util/db"
+	"sigs.k8s.io/yaml"
+)
+
+type InitConfig struct {
+	GlobalCustomQueries []db.CustomQuery `json:"global_custom_queries"`
+	Timeout             int              `json:"timeout" description:"the timeout of the connection and each query in seconds, default 10"`
+}
+
+type InstanceConfig struct {
+	Driver                 string           `json:"driver" description:"sqlite3, postgres or mysql"`
+	Dsn                    string           `json:"dsn"`
+	UseGlobalCustomQueries string           `json:"use_global_custom_queries" description:"extend,true,false"`
+	CustomQueries          []db.CustomQuery `json:"custom_queries"`
+
+	InitConfig `json:"-"`
+	timeout    time.Duration
+}
+
+type checkConfig struct {
+	InstanceConfig
+	InitConfig
+}
+
+func (p checkConfig) String() string {
+	return util.Prettify(p)
+}
+
+func defaultInstanceConfig() InstanceConfig {
+	return InstanceConfig{
+		UseGlobalCustomQueries: "true",
+	}
+}
+
+func buildConfig(rawInstance integration.Data, rawInitConfig integration.Data) (checkConfig, error) {
+	instance := defaultInstanceConfig()
+	initConfig := InitConfig{}
+
+	err := yaml.Unmarshal(rawInitConfig, &initConfig)
+	if err != nil {
+		return checkConfig{}, err
+	}
+
+	err = yaml.Unmarshal(rawInstance, &instance)
+	if err != nil {
+		return checkConfig{}, err
+	}
+
+	// the global queries are read by the query manager from the instance
+	instance.InitConfig = initConfig
+
+	if err := instance.validate(); err != nil {
+		return checkConfig{}, err
+	}
+
+	return checkConfig{
+		InitConfig:     initConfig,
+		InstanceConfig: instance,
+	}, nil
+}
+
+func (p *InstanceConfig) validate() error {
+	if p.Driver == "" {
+		return fmt.Errorf("driver is required")
+	}
+
+	drivers := sql.Drivers()
+	if i := sort.SearchStrings(drivers, p.Driver); i == len(drivers) || drivers[i] != p.Driver {
+		return fmt.Errorf("unsupported driver %q, the drivers are %v", p.Driver, drivers)
+	}
+
+	if p.Dsn == "" {
+		return fmt.Errorf("dsn is required")
+	}
+
+	switch p.UseGlobalCustomQueries {
+	case "true", "false", "extend":
+	default:
+		return fmt.Errorf("invalid use_global_custom_queries %q, must be true, false or extend", p.UseGlobalCustomQueries)
+	}
+
+	if p.Timeout <= 0 {
+		p.timeout = time.Second * 10
+	} else {
+		p.timeout = time.Second * time.Duration(p.Timeout)
+	}
+
+	return nil
+}
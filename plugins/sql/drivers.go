@@ -0,0 +1,8 @@
+package sql
+
+import (
+	// the drivers of the check, sqlite3 requires cgo
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
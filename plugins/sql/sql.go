@@ -0,0 +1,190 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
+	"github.com/DataDog/datadog-agent/pkg/collector/check"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/n9e/n9e-agentd/pkg/util/db"
+	"k8s.io/klog/v2"
+)
+
+const (
+	checkName          = "sql"
+	SERVICE_CHECK_NAME = "sql.can_connect"
+)
+
+// Check runs the custom queries against a database/sql driver
+type Check struct {
+	core.CheckBase
+	config       checkConfig
+	sender       aggregator.Sender
+	db           *sql.DB
+	queryManager *db.QueryManager
+}
+
+// Run executes the check
+func (c *Check) Run() error {
+	sender, err := aggregator.GetSender(c.ID())
+	if err != nil {
+		return err
+	}
+
+	return c.check(sender)
+}
+
+func (c *Check) check(sender aggregator.Sender) (err error) {
+	c.sender = sender
+	defer sender.Commit()
+
+	// the transformers of the custom queries panic on the unexpected values
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%s", e)
+		}
+	}()
+
+	if err := c.connect(); err != nil {
+		return err
+	}
+
+	if err := c.init(); err != nil {
+		return err
+	}
+
+	c.queryManager.Execute(nil)
+	return nil
+}
+
+// init compiles the queries once, the sender must be set before
+func (c *Check) init() error {
+	if c.queryManager != nil {
+		return nil
+	}
+
+	queryManager, err := db.NewQueryManager(c, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := queryManager.Compile_queries(); err != nil {
+		return err
+	}
+
+	c.queryManager = queryManager
+	return nil
+}
+
+// connect opens the database once and pings it on every run, the
+// connections are pooled by sql.DB
+func (c *Check) connect() (err error) {
+	service_check_tags := []string{"driver:" + c.config.Driver}
+
+	if c.db == nil {
+		if c.db, err = sql.Open(c.config.Driver, c.config.Dsn); err != nil {
+			c.sender.ServiceCheck(SERVICE_CHECK_NAME, metrics.ServiceCheckCritical, "", service_check_tags, err.Error())
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.timeout)
+	defer cancel()
+
+	if err = c.db.PingContext(ctx); err != nil {
+		c.sender.ServiceCheck(SERVICE_CHECK_NAME, metrics.ServiceCheckCritical, "", service_check_tags, err.Error())
+		return err
+	}
+	klog.V(5).Infof("Connected to %s", c.config.Driver)
+
+	c.sender.ServiceCheck(SERVICE_CHECK_NAME, metrics.ServiceCheckOK, "", service_check_tags, "")
+	return nil
+}
+
+func (c *Check) CustomQueries() []db.CustomQuery                { return c.config.CustomQueries }
+func (c *Check) GlobalCustomQueries() []db.CustomQuery          { return c.config.GlobalCustomQueries }
+func (c *Check) UseGlobalCustomQueries() string                 { return c.config.UseGlobalCustomQueries }
+func (c *Check) Sender() aggregator.Sender                      { return c.sender }
+func (c *Check) Executor(query string) ([][]interface{}, error) { return c.queryRows(query) }
+
+// queryRows returns the values of the rows as strings, the drivers return
+// the values in different types, e.g. int64 of sqlite3 and []byte of
+// postgres, which are converted by database/sql, NULL is nil
+func (c *Check) queryRows(query string) ([][]interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.timeout)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := [][]interface{}{}
+	values := make([]sql.NullString, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row := make([]interface{}, len(cols))
+		for i, v := range values {
+			if v.Valid {
+				row[i] = v.String
+			}
+		}
+		ret = append(ret, row)
+	}
+
+	return ret, rows.Err()
+}
+
+func (c *Check) Cancel() {
+	defer c.CheckBase.Cancel()
+
+	if c.db != nil {
+		c.db.Close()
+	}
+}
+
+// Configure the sql check
+func (c *Check) Configure(rawInstance integration.Data, rawInitConfig integration.Data, source string) error {
+	// Must be called before c.CommonConfigure
+	c.BuildID(rawInstance, rawInitConfig)
+
+	err := c.CommonConfigure(rawInstance, source)
+	if err != nil {
+		return fmt.Errorf("common configure failed: %s", err)
+	}
+
+	config, err := buildConfig(rawInstance, rawInitConfig)
+	if err != nil {
+		return fmt.Errorf("build config failed: %s", err)
+	}
+
+	c.config = config
+	return nil
+}
+
+func checkFactory() check.Check {
+	return &Check{
+		CheckBase: core.NewCheckBase(checkName),
+	}
+}
+
+func init() {
+	core.RegisterCheck(checkName, checkFactory)
+}
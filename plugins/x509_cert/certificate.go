@@ -0,0 +1,114 @@
+package x509_cert
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+)
+
+// certificate is a certificate read from a source, verified is true if a
+// chain to the CA bundle is built
+type certificate struct {
+	cert     *x509.Certificate
+	source   string
+	verified bool
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *certificate) tags() []string {
+	return []string{
+		"common_name:" + p.cert.Subject.CommonName,
+		"issuer:" + p.cert.Issuer.CommonName,
+		"serial:" + p.cert.SerialNumber.Text(16),
+		"source:" + p.source,
+	}
+}
+
+func (p *certificate) send(sender aggregator.Sender) {
+	tags := p.tags()
+	sender.Gauge("x509_cert.expire_days", time.Until(p.cert.NotAfter).Hours()/24, "", tags)
+	sender.Gauge("x509_cert.not_before", float64(p.cert.NotBefore.Unix()), "", tags)
+	sender.Gauge("x509_cert.verified", boolValue(p.verified), "", tags)
+}
+
+// certFile is a local file with its certificates in order
+type certFile struct {
+	path  string
+	certs []*x509.Certificate
+}
+
+// readFiles returns the certificates of the files matched by pattern, a
+// pattern without meta characters must match a file, the files which can't
+// be read are skipped and the last error is returned
+func readFiles(pattern string) ([]certFile, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("glob %s: %s", pattern, err)
+	}
+	if len(paths) == 0 {
+		if strings.ContainsAny(pattern, `*?[\`) {
+			return nil, nil
+		}
+		paths = []string{pattern}
+	}
+
+	var lastErr error
+	ret := make([]certFile, 0, len(paths))
+	for _, path := range paths {
+		certs, err := readCertificates(path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ret = append(ret, certFile{path: path, certs: certs})
+	}
+	return ret, lastErr
+}
+
+// readCertificates reads the PEM encoded certificates of a file, or a DER
+// encoded certificate or chain
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse certificate of %s: %s", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) > 0 {
+		return certs, nil
+	}
+
+	certs, err = x509.ParseCertificates(data)
+	if err != nil || len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return certs, nil
+}
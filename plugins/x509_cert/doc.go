@@ -0,0 +1,31 @@
+package x509_cert
+
+import (
+	"github.com/n9e/n9e-agentd/pkg/i18n"
+	"github.com/n9e/n9e-agentd/pkg/registry/metrics"
+)
+
+var langStrings = map[string]map[string]string{
+	"zh": map[string]string{
+		"x509_cert.expire_days": "证书剩余有效天数",
+		"x509_cert.not_before":  "证书生效时间(unix 时间戳)",
+		"x509_cert.verified":    "证书链是否验证通过",
+	},
+	"en": map[string]string{
+		"x509_cert.expire_days": "Days to the certificate expiry",
+		"x509_cert.not_before":  "Certificate not before(unix timestamp)",
+		"x509_cert.verified":    "Certificate chain verified",
+	},
+}
+
+func registerMetric() {
+	m := metrics.GetMetricGroup("x509_cert")
+	m.Register("x509_cert.expire_days")
+	m.Register("x509_cert.not_before")
+	m.Register("x509_cert.verified")
+}
+
+func init() {
+	registerMetric()
+	i18n.SetLangStrings(langStrings)
+}
@@ -0,0 +1,282 @@
+package x509_cert
+
+import (
+	gotls "crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
+	"github.com/DataDog/datadog-agent/pkg/collector/check"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	"github.com/n9e/n9e-agentd/pkg/util"
+	"github.com/n9e/n9e-agentd/pkg/util/tls"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const checkName = "x509_cert"
+
+type InitConfig struct {
+	Timeout int `json:"timeout"`
+}
+
+type InstanceConfig struct {
+	// Sources are the local files, globs are supported, or the remote
+	// endpoints as tcp://host:port or https://host[:port]
+	Sources []string `json:"sources"`
+	// ServerName is the SNI sent to the remote endpoints, default to the
+	// host of the source
+	ServerName string `json:"server_name"`
+	// TLSConfig.ca is the CA bundle the chains are verified against, the
+	// system roots are used if empty, cert and key are the client
+	// certificate sent to the remote endpoints
+	TLSConfig  tls.ClientConfig `json:"tls_config"`
+	timeout    time.Duration
+	tlsConfig  *gotls.Config
+	InitConfig `json:"-"`
+}
+
+type checkConfig struct {
+	InstanceConfig
+	InitConfig
+}
+
+func (p checkConfig) String() string {
+	return util.Prettify(p)
+}
+
+func buildConfig(rawInstance integration.Data, rawInitConfig integration.Data) (checkConfig, error) {
+	instance := InstanceConfig{}
+	initConfig := InitConfig{}
+
+	err := yaml.Unmarshal(rawInitConfig, &initConfig)
+	if err != nil {
+		return checkConfig{}, err
+	}
+
+	err = yaml.Unmarshal(rawInstance, &instance)
+	if err != nil {
+		return checkConfig{}, err
+	}
+
+	if err := instance.validate(); err != nil {
+		return checkConfig{}, err
+	}
+
+	if initConfig.Timeout <= 0 {
+		instance.timeout = time.Second * 5
+	} else {
+		instance.timeout = time.Second * time.Duration(initConfig.Timeout)
+	}
+
+	return checkConfig{
+		InitConfig:     initConfig,
+		InstanceConfig: instance,
+	}, nil
+}
+
+func (p *InstanceConfig) validate() error {
+	if len(p.Sources) == 0 {
+		return fmt.Errorf("sources is required")
+	}
+
+	for _, source := range p.Sources {
+		if _, _, err := parseSource(source); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := p.TLSConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+	if cfg == nil {
+		cfg = &gotls.Config{}
+	}
+	p.tlsConfig = cfg
+
+	return nil
+}
+
+// parseSource returns the address of a remote source, or the path of a
+// local one
+func parseSource(source string) (addr, path string, err error) {
+	if !strings.Contains(source, "://") {
+		return "", source, nil
+	}
+
+	u, err := url.Parse(source)
+	if err != nil {
+		return "", "", fmt.Errorf("parse source %s: %s", source, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return "", u.Path, nil
+	case "https":
+		if u.Port() == "" {
+			return net.JoinHostPort(u.Hostname(), "443"), "", nil
+		}
+	case "tcp", "tls":
+		if u.Port() == "" {
+			return "", "", fmt.Errorf("port is required by source %s", source)
+		}
+	default:
+		return "", "", fmt.Errorf("unsupported scheme %q of source %s", u.Scheme, source)
+	}
+
+	if u.Hostname() == "" {
+		return "", "", fmt.Errorf("host is required by source %s", source)
+	}
+	return u.Host, "", nil
+}
+
+// Check doesn't need additional fields
+type Check struct {
+	core.CheckBase
+	config checkConfig
+}
+
+// Run executes the check
+func (c *Check) Run() error {
+	sender, err := aggregator.GetSender(c.ID())
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, source := range c.config.Sources {
+		certs, err := c.collect(source)
+		if err != nil {
+			klog.Warningf("x509_cert source %s: %s", source, err)
+			errs = append(errs, err.Error())
+		}
+		for _, cert := range certs {
+			cert.send(sender)
+		}
+	}
+
+	sender.Commit()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// collect returns the certificates of a source, a glob may match several
+// files
+func (c *Check) collect(source string) ([]*certificate, error) {
+	addr, path, _ := parseSource(source)
+	if addr != "" {
+		chain, err := c.dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		return c.verify(source, c.serverName(addr), chain), nil
+	}
+
+	files, err := readFiles(path)
+
+	var ret []*certificate
+	for _, file := range files {
+		ret = append(ret, c.verify(file.path, "", file.certs)...)
+	}
+	return ret, err
+}
+
+func (c *Check) serverName(addr string) string {
+	if c.config.ServerName != "" {
+		return c.config.ServerName
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	return host
+}
+
+// dial returns the chain sent by the remote endpoint, it is verified by
+// the check instead of the handshake to report the invalid certificates
+func (c *Check) dial(addr string) ([]*x509.Certificate, error) {
+	cfg := c.config.tlsConfig.Clone()
+	cfg.ServerName = c.serverName(addr)
+	cfg.InsecureSkipVerify = true
+
+	dialer := &net.Dialer{Timeout: c.config.timeout}
+	conn, err := gotls.DialWithDialer(dialer, "tcp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate sent by %s", addr)
+	}
+	return certs, nil
+}
+
+// verify verifies each certificate of the chain against the CA bundle,
+// the rest of the chain is used as the intermediates, the leaf is also
+// verified against the server name of a remote source
+func (c *Check) verify(source, serverName string, chain []*x509.Certificate) []*certificate {
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	ret := make([]*certificate, 0, len(chain))
+	for i, cert := range chain {
+		opts := x509.VerifyOptions{
+			Roots:         c.config.tlsConfig.RootCAs,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}
+		if i == 0 {
+			opts.DNSName = serverName
+		}
+		_, err := cert.Verify(opts)
+		if err != nil {
+			klog.V(5).Infof("verify %s of %s err %s", cert.Subject.CommonName, source, err)
+		}
+
+		ret = append(ret, &certificate{
+			cert:     cert,
+			source:   source,
+			verified: err == nil,
+		})
+	}
+	return ret
+}
+
+// Configure the x509_cert check
+func (c *Check) Configure(rawInstance integration.Data, rawInitConfig integration.Data, source string) error {
+	// Must be called before c.CommonConfigure
+	c.BuildID(rawInstance, rawInitConfig)
+
+	err := c.CommonConfigure(rawInstance, source)
+	if err != nil {
+		return fmt.Errorf("common configure failed: %s", err)
+	}
+
+	config, err := buildConfig(rawInstance, rawInitConfig)
+	if err != nil {
+		return fmt.Errorf("build config failed: %s", err)
+	}
+
+	c.config = config
+	return nil
+}
+
+func checkFactory() check.Check {
+	return &Check{
+		CheckBase: core.NewCheckBase(checkName),
+	}
+}
+
+func init() {
+	core.RegisterCheck(checkName, checkFactory)
+}
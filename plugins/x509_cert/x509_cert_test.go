@@ -0,0 +1,226 @@
+package x509_cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	gotls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/n9e/n9e-agentd/pkg/util/capturesender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	der  []byte
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert returns a certificate signed by parent, or a self signed CA
+// if parent is nil
+func newTestCert(t *testing.T, cn string, serial int64, parent *testCert, notAfter time.Time) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Unix(1600000000, 0),
+		NotAfter:     notAfter,
+		DNSNames:     []string{cn},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCert{cert: cert, der: der, key: key}
+}
+
+func (p *testCert) pem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: p.der})
+}
+
+func writeFile(t *testing.T, path string, data ...[]byte) {
+	var b []byte
+	for _, d := range data {
+		b = append(b, d...)
+	}
+	require.NoError(t, ioutil.WriteFile(path, b, 0644))
+}
+
+// runCheck runs the check and returns the values of the metrics by name
+// and tags
+func runCheck(t *testing.T, config string) (map[string]float64, error) {
+	cf, err := buildConfig([]byte(config), nil)
+	require.NoError(t, err)
+	c := &Check{config: cf}
+
+	sender := capturesender.New()
+	var errs []error
+	for _, source := range cf.Sources {
+		certs, err := c.collect(source)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, cert := range certs {
+			cert.send(sender)
+		}
+	}
+
+	ret := map[string]float64{}
+	for _, m := range sender.Metrics {
+		tags := append([]string{}, m.Tags...)
+		sort.Strings(tags)
+		ret[fmt.Sprintf("%s %v", m.Name, tags)] = m.Value
+	}
+	if len(errs) > 0 {
+		return ret, errs[0]
+	}
+	return ret, nil
+}
+
+func TestParseSource(t *testing.T) {
+	cases := []struct {
+		source string
+		addr   string
+		path   string
+	}{
+		{"/etc/ssl/*.pem", "", "/etc/ssl/*.pem"},
+		{"file:///etc/ssl/a.pem", "", "/etc/ssl/a.pem"},
+		{"https://example.com", "example.com:443", ""},
+		{"https://example.com:8443/path", "example.com:8443", ""},
+		{"tcp://[::1]:465", "[::1]:465", ""},
+		{"tls://example.com:993", "example.com:993", ""},
+	}
+	for _, c := range cases {
+		addr, path, err := parseSource(c.source)
+		assert.NoError(t, err, c.source)
+		assert.Equal(t, c.addr, addr, c.source)
+		assert.Equal(t, c.path, path, c.source)
+	}
+
+	for _, source := range []string{"tcp://example.com", "udp://example.com:53", "https://:443"} {
+		_, _, err := parseSource(source)
+		assert.Error(t, err, source)
+	}
+
+	_, err := buildConfig([]byte(`{sources: []}`), nil)
+	assert.Error(t, err)
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	ca := newTestCert(t, "test-ca", 1, nil, now.Add(100*24*time.Hour))
+	leaf := newTestCert(t, "leaf.example.com", 255, ca, now.Add(10*24*time.Hour+time.Hour))
+	other := newTestCert(t, "other-ca", 2, nil, now.Add(24*time.Hour))
+
+	writeFile(t, filepath.Join(dir, "ca.pem"), ca.pem())
+	writeFile(t, filepath.Join(dir, "chain.pem"), leaf.pem(), ca.pem())
+	writeFile(t, filepath.Join(dir, "leaf.der"), leaf.der)
+	writeFile(t, filepath.Join(dir, "other.pem"), other.pem())
+	writeFile(t, filepath.Join(dir, "invalid.pem"), []byte("not a certificate"))
+
+	chain := filepath.Join(dir, "chain.pem")
+	leafTags := fmt.Sprintf("[common_name:leaf.example.com issuer:test-ca serial:ff source:%s]", chain)
+	caTags := fmt.Sprintf("[common_name:test-ca issuer:test-ca serial:1 source:%s]", chain)
+
+	values, err := runCheck(t, fmt.Sprintf(`{sources: [%s], tls_config: {ca: %s}}`,
+		chain, filepath.Join(dir, "ca.pem")))
+	require.NoError(t, err)
+	assert.Len(t, values, 6)
+	assert.Equal(t, float64(10), float64(int(values["x509_cert.expire_days "+leafTags])))
+	assert.Equal(t, float64(1600000000), values["x509_cert.not_before "+leafTags])
+	assert.Equal(t, float64(1), values["x509_cert.verified "+leafTags])
+	assert.Equal(t, float64(1), values["x509_cert.verified "+caTags])
+
+	// the chain is verified against another CA bundle
+	values, err = runCheck(t, fmt.Sprintf(`{sources: [%s], tls_config: {ca: %s}}`,
+		chain, filepath.Join(dir, "other.pem")))
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), values["x509_cert.verified "+leafTags])
+	assert.Equal(t, float64(0), values["x509_cert.verified "+caTags])
+
+	// a glob with der and pem files, the invalid file is skipped
+	values, err = runCheck(t, fmt.Sprintf(`{sources: ["%s/*"], tls_config: {ca: %s}}`,
+		dir, filepath.Join(dir, "ca.pem")))
+	assert.Error(t, err)
+	der := fmt.Sprintf("[common_name:leaf.example.com issuer:test-ca serial:ff source:%s]", filepath.Join(dir, "leaf.der"))
+	assert.Equal(t, float64(1), values["x509_cert.verified "+der])
+	assert.Len(t, values, 5*3)
+
+	// a glob without match is not an error, a missing file is
+	_, err = runCheck(t, fmt.Sprintf(`{sources: ["%s/*.crt"]}`, dir))
+	assert.NoError(t, err)
+	_, err = runCheck(t, fmt.Sprintf(`{sources: ["%s/missing.pem"]}`, dir))
+	assert.Error(t, err)
+}
+
+func TestRemote(t *testing.T) {
+	now := time.Now()
+	ca := newTestCert(t, "test-ca", 1, nil, now.Add(100*24*time.Hour))
+	leaf := newTestCert(t, "leaf.example.com", 16, ca, now.Add(30*24*time.Hour+time.Hour))
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	writeFile(t, caFile, ca.pem())
+
+	serverNames := make(chan string, 10)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	ts.TLS = &gotls.Config{
+		Certificates: []gotls.Certificate{{
+			Certificate: [][]byte{leaf.der, ca.der},
+			PrivateKey:  leaf.key,
+		}},
+		GetConfigForClient: func(hello *gotls.ClientHelloInfo) (*gotls.Config, error) {
+			serverNames <- hello.ServerName
+			return nil, nil
+		},
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	addr := ts.Listener.Addr().String()
+	source := "tcp://" + addr
+	leafTags := fmt.Sprintf("[common_name:leaf.example.com issuer:test-ca serial:10 source:%s]", source)
+
+	values, err := runCheck(t, fmt.Sprintf(`{sources: ["%s"], server_name: leaf.example.com, tls_config: {ca: %s}}`, source, caFile))
+	require.NoError(t, err)
+	assert.Equal(t, "leaf.example.com", <-serverNames)
+	assert.Len(t, values, 6)
+	assert.Equal(t, float64(30), float64(int(values["x509_cert.expire_days "+leafTags])))
+	assert.Equal(t, float64(1), values["x509_cert.verified "+leafTags])
+
+	// the leaf doesn't match the default server name, the ip of the source
+	values, err = runCheck(t, fmt.Sprintf(`{sources: ["%s"], tls_config: {ca: %s}}`, source, caFile))
+	require.NoError(t, err)
+	assert.Equal(t, "", <-serverNames)
+	assert.Equal(t, float64(0), values["x509_cert.verified "+leafTags])
+
+	ts.Close()
+	_, err = runCheck(t, fmt.Sprintf(`{sources: ["%s"]}`, source))
+	assert.Error(t, err)
+}
@@ -51,6 +51,14 @@ type RawSender interface {
 	Event(e metrics.Event)
 }
 
+// TimestampSender submits the metric samples with their own timestamp, e.g.
+// the samples collected by a script, the custom tags of the check are added
+// as by the Sender methods. The timestamp is used by the rates and the
+// monotonic counts, the gauges are flushed at it.
+type TimestampSender interface {
+	MetricSampleWithTimestamp(metric string, value float64, hostname string, tags []string, mType metrics.MetricType, timestamp float64)
+}
+
 // checkSender implements Sender
 type checkSender struct {
 	id                      check.ID
@@ -224,7 +232,20 @@ func (s *checkSender) SendRawMetricSample(sample *metrics.MetricSample) {
 	s.smsOut <- senderMetricSample{s.id, sample, false}
 }
 
+// MetricSampleWithTimestamp sends the sample with the timestamp in seconds,
+// a gauge is sent as a timestamped gauge
+func (s *checkSender) MetricSampleWithTimestamp(metric string, value float64, hostname string, tags []string, mType metrics.MetricType, timestamp float64) {
+	if mType == metrics.GaugeType {
+		mType = metrics.TimestampedGaugeType
+	}
+	s.sendMetricSampleWithTimestamp(metric, value, hostname, tags, mType, false, timestamp)
+}
+
 func (s *checkSender) sendMetricSample(metric string, value float64, hostname string, tags []string, mType metrics.MetricType, flushFirstValue bool) {
+	s.sendMetricSampleWithTimestamp(metric, value, hostname, tags, mType, flushFirstValue, timeNowNano())
+}
+
+func (s *checkSender) sendMetricSampleWithTimestamp(metric string, value float64, hostname string, tags []string, mType metrics.MetricType, flushFirstValue bool, timestamp float64) {
 	tags = append(tags, s.checkTags...)
 
 	log.Trace(mType.String(), " sample: ", metric, ": ", value, " for hostname: ", hostname, " tags: ", tags)
@@ -236,7 +257,7 @@ func (s *checkSender) sendMetricSample(metric string, value float64, hostname st
 		Tags:            tags,
 		Host:            hostname,
 		SampleRate:      1,
-		Timestamp:       timeNowNano(),
+		Timestamp:       timestamp,
 		FlushFirstValue: flushFirstValue,
 	}
 
@@ -44,6 +44,9 @@ func init() {
 	acErrors.Set("ResolveWarnings", expvar.Func(func() interface{} {
 		return errorStats.getResolveWarnings()
 	}))
+	acErrors.Set("HttpProviderStatus", expvar.Func(func() interface{} {
+		return providers.GetHttpProviderStatus()
+	}))
 }
 
 // AutoConfig is responsible to collect integrations configurations from
@@ -563,6 +566,27 @@ func (ac *AutoConfig) resolveTemplateForService(tpl integration.Config, svc list
 	return resolvedConfig, nil
 }
 
+// ReloadConfigs collects the configurations from the providers, and
+// schedules again the configurations of the check name, changed or not.
+// It waits for the in-flight polls of the providers.
+func (ac *AutoConfig) ReloadConfigs(ctx context.Context, name string) (int, error) {
+	ac.m.RLock()
+	pollers := make([]*configPoller, len(ac.providers))
+	copy(pollers, ac.providers)
+	ac.m.RUnlock()
+
+	n := 0
+	for _, pd := range pollers {
+		n += pd.reload(ctx, ac, name)
+	}
+
+	if n == 0 {
+		return 0, fmt.Errorf("no configuration found for check %s", name)
+	}
+
+	return n, nil
+}
+
 // GetLoadedConfigs returns configs loaded
 func (ac *AutoConfig) GetLoadedConfigs() map[string]integration.Config {
 	if ac == nil || ac.store == nil {
@@ -521,9 +521,10 @@ func TestSkipSecretDecrypt(t *testing.T) {
 	secretsDecrypt = mockDecrypt.getDecryptFunc()
 	defer func() { secretsDecrypt = originalSecretsDecrypt }()
 
-	cfg := config.Mock()
-	cfg.Set("secret_backend_skip_checks", true)
-	defer cfg.Set("secret_backend_skip_checks", false)
+	// the option is read from config.C, MockConfig.Set only writes the raw
+	// value of a copy
+	config.C.SecretBackendSkipChecks = true
+	defer func() { config.C.SecretBackendSkipChecks = false }()
 
 	service := sharedService
 	ac.processNewService(ctx, &service)
@@ -8,6 +8,7 @@ package autodiscovery
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
@@ -26,6 +27,11 @@ type configPoller struct {
 	pollInterval time.Duration
 	stopChan     chan struct{}
 	healthHandle *health.Handle
+	m            sync.Mutex // serializes the polls and the reloads
+
+	cancelM    sync.Mutex
+	cancelPoll context.CancelFunc // cancels the in-flight poll
+	reloading  int                // number of the reloads waiting for or holding m
 }
 
 func newConfigPoller(provider providers.ConfigProvider, canPoll bool, interval time.Duration) *configPoller {
@@ -82,38 +88,126 @@ func (pd *configPoller) poll(ac *AutoConfig) {
 			ticker.Stop()
 			return
 		case <-ticker.C:
-			log.Tracef("Polling %s config provider", pd.provider.String())
-			// Check if the CPupdate cache is up to date. Fill it and trigger a Collect() if outdated.
-			upToDate, err := pd.provider.IsUpToDate(ctx)
-			if err != nil {
-				log.Errorf("Cache processing of %v configuration provider failed: %v", pd.provider, err)
-			}
-			if upToDate {
-				log.Debugf("No modifications in the templates stored in %v configuration provider", pd.provider)
-				break
+			pd.m.Lock()
+			if pollCtx, done, ok := pd.startPoll(ctx); ok {
+				pd.pollOnce(pollCtx, ac)
+				done()
 			}
+			pd.m.Unlock()
+		}
+	}
+}
 
-			// retrieve the list of newly added configurations as well
-			// as removed configurations
-			newConfigs, removedConfigs := pd.collect(ctx)
-			if len(newConfigs) > 0 || len(removedConfigs) > 0 {
-				log.Infof("%v provider: collected %d new configurations, removed %d", pd.provider, len(newConfigs), len(removedConfigs))
-			} else {
-				log.Debugf("%v provider: no configuration change", pd.provider)
-			}
-			// Process removed configs first to handle the case where a
-			// container churn would result in the same configuration hash.
-			ac.processRemovedConfigs(removedConfigs)
-			// We can also remove any cached template
-			ac.removeConfigTemplates(removedConfigs)
-
-			for _, config := range newConfigs {
-				config.Provider = pd.provider.String()
-				resolvedConfigs := ac.processNewConfig(config)
-				ac.schedule(resolvedConfigs)
-			}
+// startPoll returns the context of a poll, which is canceled by a reload so
+// that a long-poll of the provider, e.g. the watch of the http provider,
+// doesn't delay it. ok is false if a reload is pending, it collects the
+// configurations anyway.
+func (pd *configPoller) startPoll(ctx context.Context) (pollCtx context.Context, done func(), ok bool) {
+	pd.cancelM.Lock()
+	defer pd.cancelM.Unlock()
+
+	if pd.reloading > 0 {
+		return nil, nil, false
+	}
+
+	pollCtx, cancel := context.WithCancel(ctx)
+	pd.cancelPoll = cancel
+	return pollCtx, func() {
+		pd.cancelM.Lock()
+		pd.cancelPoll = nil
+		pd.cancelM.Unlock()
+		cancel()
+	}, true
+}
+
+func (pd *configPoller) pollOnce(ctx context.Context, ac *AutoConfig) {
+	log.Tracef("Polling %s config provider", pd.provider.String())
+	// Check if the CPupdate cache is up to date. Fill it and trigger a Collect() if outdated.
+	upToDate, err := pd.provider.IsUpToDate(ctx)
+	if ctx.Err() == context.Canceled {
+		log.Debugf("Polling %v configuration provider is canceled by a reload", pd.provider)
+		return
+	}
+	if err != nil {
+		log.Errorf("Cache processing of %v configuration provider failed: %v", pd.provider, err)
+	}
+	if upToDate {
+		log.Debugf("No modifications in the templates stored in %v configuration provider", pd.provider)
+		return
+	}
+
+	// retrieve the list of newly added configurations as well
+	// as removed configurations
+	newConfigs, removedConfigs := pd.collect(ctx)
+	if len(newConfigs) > 0 || len(removedConfigs) > 0 {
+		log.Infof("%v provider: collected %d new configurations, removed %d", pd.provider, len(newConfigs), len(removedConfigs))
+	} else {
+		log.Debugf("%v provider: no configuration change", pd.provider)
+	}
+	pd.apply(ac, newConfigs, removedConfigs)
+}
+
+// reload collects the configurations of the provider and applies the changes,
+// the unchanged configurations of the check name are unscheduled and scheduled
+// again. It returns the number of configurations of the check.
+func (pd *configPoller) reload(ctx context.Context, ac *AutoConfig, name string) int {
+	pd.cancelM.Lock()
+	pd.reloading++
+	if pd.cancelPoll != nil {
+		pd.cancelPoll()
+	}
+	pd.cancelM.Unlock()
+
+	defer func() {
+		pd.cancelM.Lock()
+		pd.reloading--
+		pd.cancelM.Unlock()
+	}()
+
+	pd.m.Lock()
+	defer pd.m.Unlock()
+
+	newConfigs, removedConfigs := pd.collect(ctx)
+
+	n := 0
+	for _, c := range pd.configs {
+		if c.Name != name {
+			continue
+		}
+		n++
+		if !containsConfig(newConfigs, &c) {
+			removedConfigs = append(removedConfigs, c)
+			newConfigs = append(newConfigs, c)
+		}
+	}
+
+	log.Infof("%v provider: reload %d configurations of %s", pd.provider, n, name)
+	pd.apply(ac, newConfigs, removedConfigs)
+
+	return n
+}
+
+func (pd *configPoller) apply(ac *AutoConfig, newConfigs, removedConfigs []integration.Config) {
+	// Process removed configs first to handle the case where a
+	// container churn would result in the same configuration hash.
+	ac.processRemovedConfigs(removedConfigs)
+	// We can also remove any cached template
+	ac.removeConfigTemplates(removedConfigs)
+
+	for _, config := range newConfigs {
+		config.Provider = pd.provider.String()
+		resolvedConfigs := ac.processNewConfig(config)
+		ac.schedule(resolvedConfigs)
+	}
+}
+
+func containsConfig(configs []integration.Config, c *integration.Config) bool {
+	for _, config := range configs {
+		if config.Equal(c) {
+			return true
 		}
 	}
+	return false
 }
 
 // collect is just a convenient wrapper to fetch configurations from a provider and
@@ -0,0 +1,178 @@
+package autodiscovery
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/scheduler"
+	"github.com/n9e/n9e-agentd/pkg/config"
+)
+
+func TestMain(m *testing.M) {
+	config.Mock()
+	os.Exit(m.Run())
+}
+
+// staticProvider returns its configs on every Collect
+type staticProvider struct {
+	MockProvider
+	sync.Mutex
+	configs []integration.Config
+}
+
+func (p *staticProvider) Collect(ctx context.Context) ([]integration.Config, error) {
+	p.Lock()
+	defer p.Unlock()
+	return p.configs, nil
+}
+
+func (p *staticProvider) set(configs ...integration.Config) {
+	p.Lock()
+	defer p.Unlock()
+	p.configs = configs
+}
+
+// recordingScheduler records the names and instances of the configs
+type recordingScheduler struct {
+	scheduled   []string
+	unscheduled []string
+}
+
+func configKeys(configs []integration.Config) []string {
+	var keys []string
+	for _, c := range configs {
+		for _, instance := range c.Instances {
+			keys = append(keys, c.Name+" "+string(instance))
+		}
+	}
+	return keys
+}
+
+func (s *recordingScheduler) Schedule(configs []integration.Config) {
+	s.scheduled = append(s.scheduled, configKeys(configs)...)
+}
+
+func (s *recordingScheduler) Unschedule(configs []integration.Config) {
+	s.unscheduled = append(s.unscheduled, configKeys(configs)...)
+}
+
+func (s *recordingScheduler) Stop() {}
+
+func (s *recordingScheduler) reset() {
+	s.scheduled, s.unscheduled = nil, nil
+}
+
+func testConfig(name, instance string) integration.Config {
+	return integration.Config{Name: name, Instances: []integration.Data{integration.Data(instance)}}
+}
+
+func newReloadTest() (*AutoConfig, *staticProvider, *recordingScheduler) {
+	s := &recordingScheduler{}
+	ac := NewAutoConfig(scheduler.NewMetaScheduler())
+	ac.AddScheduler("test", s, false)
+
+	p := &staticProvider{}
+	p.set(testConfig("foo", "a: 1"), testConfig("foo", "a: 2"), testConfig("bar", "b: 1"))
+	ac.AddConfigProvider(p, false, 0)
+	ac.LoadAndRun()
+	s.reset()
+
+	return ac, p, s
+}
+
+func TestReloadConfigsUnchanged(t *testing.T) {
+	ac, _, s := newReloadTest()
+
+	// the unchanged configs of the check are scheduled again
+	n, err := ac.ReloadConfigs(context.Background(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.ElementsMatch(t, []string{"foo a: 1", "foo a: 2"}, s.unscheduled)
+	assert.ElementsMatch(t, []string{"foo a: 1", "foo a: 2"}, s.scheduled)
+	assert.Len(t, ac.GetLoadedConfigs(), 3)
+}
+
+func TestReloadConfigsChanged(t *testing.T) {
+	ac, p, s := newReloadTest()
+
+	// the changes of the other checks are applied as by a poll
+	p.set(testConfig("foo", "a: 1"), testConfig("foo", "a: 3"), testConfig("bar", "b: 2"))
+	n, err := ac.ReloadConfigs(context.Background(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.ElementsMatch(t, []string{"foo a: 1", "foo a: 2", "bar b: 1"}, s.unscheduled)
+	assert.ElementsMatch(t, []string{"foo a: 1", "foo a: 3", "bar b: 2"}, s.scheduled)
+	assert.Len(t, ac.GetLoadedConfigs(), 3)
+}
+
+func TestReloadConfigsUnknown(t *testing.T) {
+	ac, p, s := newReloadTest()
+
+	n, err := ac.ReloadConfigs(context.Background(), "baz")
+	assert.Error(t, err)
+	assert.Zero(t, n)
+	assert.Empty(t, s.unscheduled)
+	assert.Empty(t, s.scheduled)
+
+	// the configs of the check are removed by the provider
+	p.set(testConfig("bar", "b: 1"))
+	n, err = ac.ReloadConfigs(context.Background(), "foo")
+	assert.Error(t, err)
+	assert.Zero(t, n)
+	assert.ElementsMatch(t, []string{"foo a: 1", "foo a: 2"}, s.unscheduled)
+	assert.Empty(t, s.scheduled)
+}
+
+// watchProvider holds IsUpToDate until its context is done, as a long-poll
+type watchProvider struct {
+	staticProvider
+	watching chan struct{}
+}
+
+func (p *watchProvider) IsUpToDate(ctx context.Context) (bool, error) {
+	select {
+	case p.watching <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	return false, ctx.Err()
+}
+
+func TestReloadConfigsCancelPoll(t *testing.T) {
+	s := &recordingScheduler{}
+	ac := NewAutoConfig(scheduler.NewMetaScheduler())
+	ac.AddScheduler("test", s, false)
+
+	p := &watchProvider{watching: make(chan struct{})}
+	p.set(testConfig("foo", "a: 1"))
+	ac.AddConfigProvider(p, true, 10*time.Millisecond)
+	ac.LoadAndRun()
+
+	select {
+	case <-p.watching:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the provider is not polled")
+	}
+
+	// the reload doesn't wait for the poll
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n, err := ac.ReloadConfigs(context.Background(), "foo")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reload is blocked by the poll")
+	}
+}
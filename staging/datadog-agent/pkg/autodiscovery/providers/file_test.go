@@ -76,7 +76,8 @@ func TestNewYamlConfigProvider(t *testing.T) {
 
 func TestCollect(t *testing.T) {
 	ctx := context.Background()
-	config.Datadog.Set("ignore_autoconf", []string{"ignored"})
+	config.Mock()
+	config.C.IgnoreAutoconf = []string{"ignored"}
 	paths := []string{"tests", "foo/bar"}
 	provider := NewFileConfigProvider(paths)
 	configs, err := provider.Collect(ctx)
@@ -3,11 +3,15 @@ package providers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
 	"github.com/DataDog/datadog-agent/pkg/autodiscovery/providers/names"
@@ -15,6 +19,12 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/util/log"
 	"github.com/n9e/n9e-agentd/pkg/api"
 	"github.com/n9e/n9e-agentd/pkg/config"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultWatchTimeout = 20 * time.Second
+	watchGracePeriod    = 5 * time.Second
 )
 
 type Client struct {
@@ -40,7 +50,17 @@ func newClient(endpoints, path, agentID, token string) (*Client, error) {
 	return cli, nil
 }
 
+// errNotModified is returned when the server replies 304 Not Modified
+var errNotModified = errors.New("not modified")
+
 func (c *Client) get(path string) ([]byte, error) {
+	_, body, err := c.request(context.Background(), path, "")
+	return body, err
+}
+
+// request sends a GET request with If-None-Match if etag is not empty,
+// it returns the header and the body of the response
+func (c *Client) request(ctx context.Context, path, etag string) (http.Header, []byte, error) {
 	url := c.domain.Current() + path
 	log.Debugf("get %s", url)
 
@@ -48,39 +68,72 @@ func (c *Client) get(path string) ([]byte, error) {
 	logURL := log.SanitizeURL(url) // sanitized url that can be logged
 	if err != nil {
 		c.domain.Next()
-		return nil, fmt.Errorf("Could not create request for transaction to invalid URL %q (dropping transaction): %s", logURL, err)
+		return nil, nil, fmt.Errorf("Could not create request for transaction to invalid URL %q (dropping transaction): %s", logURL, err)
+	}
+	req = req.WithContext(ctx)
+	req.Header = c.Header.Clone()
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
 	}
-	//req = req.WithContext(ctx)
-	req.Header = c.Header
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, err
+		// try the next endpoint on the next call, unless the request is
+		// canceled, e.g. a watch by a reload
+		if ctx.Err() != context.Canceled {
+			c.domain.Next()
+		}
+		return nil, nil, err
 	}
+	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("Fail to read the response Body: %s", err)
+		c.domain.Next()
+		return nil, nil, fmt.Errorf("Fail to read the response Body: %s", err)
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		return resp.Header, nil, errNotModified
 	}
 
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("Error code %q received while sending transaction to %q: %s, dropping it", resp.Status, logURL, string(body))
+		if resp.StatusCode >= 500 {
+			c.domain.Next()
+		}
+		return nil, nil, fmt.Errorf("Error code %q received while sending transaction to %q: %s, dropping it", resp.Status, logURL, string(body))
 	}
 
-	return body, nil
+	return resp.Header, body, nil
+}
+
+func (c *Client) getCollectRules() ([]api.CollectRule, string, error) {
+	return c.watchCollectRules(context.Background(), "", 0)
 }
 
-func (c *Client) getCollectRules() ([]api.CollectRule, error) {
-	b, err := c.get(api.RoutePathGetCollectRules + "?ident=" + url.QueryEscape(c.agentID))
+// watchCollectRules returns the rules and their ETag, or errNotModified if the
+// rules still match etag. With a timeout, the server holds the request until
+// the rules change or the timeout expires.
+func (c *Client) watchCollectRules(ctx context.Context, etag string, timeout time.Duration) ([]api.CollectRule, string, error) {
+	path := api.RoutePathGetCollectRules + "?ident=" + url.QueryEscape(c.agentID)
+	if timeout > 0 {
+		path += fmt.Sprintf("&watch=true&timeout=%d", int(timeout.Seconds()))
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout+watchGracePeriod)
+		defer cancel()
+	}
+
+	header, b, err := c.request(ctx, path, etag)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	var rules api.CollectRulesWrap
 	if err := json.Unmarshal(b, &rules); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return rules.Data, nil
+	return rules.Data, header.Get("ETag"), nil
 }
 
 func (c *Client) getCollectRulesSummary() (*api.CollectRulesSummary, error) {
@@ -101,34 +154,56 @@ func (c *Client) getCollectRulesSummary() (*api.CollectRulesSummary, error) {
 // HttpConfigProvider implements the Config Provider interface
 // It should be called periodically and returns templates from http for AutoConf.
 type HttpConfigProvider struct {
-	Client      *Client
-	templateDir string
-	cache       *ProviderCache
+	Client       *Client
+	templateDir  string
+	cache        *ProviderCache
+	rulesCache   *collectRulesCache
+	collected    bool              // at least one rule set has been returned by Collect
+	etag         string            // ETag of the rules in use, set if the server supports conditional requests
+	summaryHash  string            // hash of the last summary
+	pending      []api.CollectRule // rules fetched by IsUpToDate, returned by the next Collect
+	watch        bool
+	watchTimeout time.Duration
 }
 
 // NewHttpConfigProvider creates a client connection to http and create a new HttpConfigProvider
 func NewHttpConfigProvider(cf config.ConfigurationProviders) (ConfigProvider, error) {
-	cli, err := newClient(cf.TemplateURL, cf.TemplateDir, config.C.Ident, cf.Token)
+	return newHttpConfigProvider(cf, config.C.Ident, filepath.Join(config.C.RunPath, collectRulesCacheFile))
+}
+
+func newHttpConfigProvider(cf config.ConfigurationProviders, ident, cacheFile string) (*HttpConfigProvider, error) {
+	cli, err := newClient(cf.TemplateURL, cf.TemplateDir, ident, cf.Token)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to instantiate the http client: %s", err)
 	}
 	cache := NewCPCache()
-	return &HttpConfigProvider{
-		Client:      cli,
-		templateDir: cf.TemplateDir,
-		cache:       cache,
-	}, nil
+	p := &HttpConfigProvider{
+		Client:       cli,
+		templateDir:  cf.TemplateDir,
+		cache:        cache,
+		rulesCache:   newCollectRulesCache(cacheFile),
+		watch:        cf.Watch,
+		watchTimeout: defaultWatchTimeout,
+	}
+	if cf.WatchTimeout > 0 {
+		p.watchTimeout = time.Duration(cf.WatchTimeout) * time.Second
+	}
+
+	httpStatus.set(&HttpProviderStatus{CacheFile: cacheFile})
+
+	return p, nil
 }
 
 // Collect retrieves templates from http, builds Config objects and returns them
-// TODO: cache templates and last-modified index to avoid future full crawl if no template changed.
+// The last good rule set is stored on disk, and is used when the server
+// can't be reached before any rule set has been collected.
 func (p *HttpConfigProvider) Collect(ctx context.Context) ([]integration.Config, error) {
-	rules, err := p.Client.getCollectRules()
+	rules, err := p.collectRules()
 	if err != nil {
-		log.Errorf("GetCollectRules err %s", err)
 		return nil, err
 	}
 	log.Debugf("Collect() get %d rules", len(rules))
+	p.collected = true
 
 	var configs []integration.Config
 	for _, rule := range rules {
@@ -143,6 +218,50 @@ func (p *HttpConfigProvider) Collect(ctx context.Context) ([]integration.Config,
 	return configs, nil
 }
 
+func (p *HttpConfigProvider) collectRules() ([]api.CollectRule, error) {
+	// the rules fetched by IsUpToDate are cached as the ones fetched here
+	rules, etag, err := p.pending, p.etag, error(nil)
+	if rules != nil {
+		p.pending = nil
+	} else {
+		rules, etag, err = p.Client.getCollectRules()
+	}
+	if err == nil {
+		p.etag = etag
+		httpStatus.fresh(p.Client.domain.Current())
+		if err := p.rulesCache.save(rules); err != nil {
+			log.Warnf("unable to write the collect rules cache %s: %s", p.rulesCache.path, err)
+		}
+		return rules, nil
+	}
+	log.Errorf("GetCollectRules err %s", err)
+
+	// force a new Collect on the next poll
+	p.cache = NewCPCache()
+	p.etag = ""
+	p.summaryHash = ""
+
+	if p.collected {
+		// autodiscovery keeps the configs of the previous Collect
+		httpStatus.stale(time.Time{}, err)
+		return nil, err
+	}
+
+	snapshot, cacheErr := p.rulesCache.load()
+	if cacheErr != nil {
+		log.Warnf("unable to load the collect rules cache %s: %s", p.rulesCache.path, cacheErr)
+		httpStatus.stale(time.Time{}, err)
+		return nil, err
+	}
+
+	savedAt := time.Unix(snapshot.SavedAt, 0)
+	log.Warnf("n9e server is unreachable, use %d collect rules from %s, stale since %s",
+		len(snapshot.Rules), p.rulesCache.path, savedAt)
+	httpStatus.stale(savedAt, err)
+
+	return snapshot.Rules, nil
+}
+
 func (p *HttpConfigProvider) convertConfig(rule api.CollectRule) (*integration.Config, error) {
 	config, err := ParseJSONConfig([]byte(rule.Data))
 	if err != nil {
@@ -152,17 +271,113 @@ func (p *HttpConfigProvider) convertConfig(rule api.CollectRule) (*integration.C
 	config.Provider = names.Http
 	config.Source = fmt.Sprintf("http:%s:%d", rule.Name, rule.ID)
 
+	if rule.Interval > 0 || rule.Tags != "" {
+		tags := parseRuleTags(rule.Tags)
+		for i, instance := range config.Instances {
+			if config.Instances[i], err = mergeRuleInstance(instance, rule.Interval, tags); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return config, nil
 }
 
+// parseRuleTags parses the tags of a collect rule, e.g. "a:b,c:d" or "a=b c=d",
+// the tags without a key, e.g. ":b", are dropped
+func parseRuleTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		i := strings.IndexAny(tag, ":=")
+		if i == 0 {
+			log.Warnf("invalid collect rule tag %q, ignored", tag)
+			continue
+		}
+		if i > 0 {
+			tag = tag[:i] + ":" + tag[i+1:]
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// mergeRuleInstance sets the interval and the tags of the rule into the
+// instance, the values of the instance win
+func mergeRuleInstance(instance integration.Data, interval int, tags []string) (integration.Data, error) {
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(instance, &raw); err != nil {
+		return nil, err
+	}
+
+	if _, ok := raw["min_collection_interval"]; !ok && interval > 0 {
+		raw["min_collection_interval"] = interval
+	}
+
+	if len(tags) > 0 {
+		var instanceTags []interface{}
+		if v, ok := raw["tags"]; ok && v != nil {
+			if instanceTags, ok = v.([]interface{}); !ok {
+				return nil, fmt.Errorf("tags must be a list, got %T", v)
+			}
+		}
+
+		keys := map[string]bool{}
+		for _, tag := range instanceTags {
+			keys[tagKey(fmt.Sprint(tag))] = true
+		}
+		for _, tag := range tags {
+			if !keys[tagKey(tag)] {
+				instanceTags = append(instanceTags, tag)
+			}
+		}
+		raw["tags"] = instanceTags
+	}
+
+	return yaml.Marshal(raw)
+}
+
+func tagKey(tag string) string {
+	if i := strings.Index(tag, ":"); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
 // IsUpToDate updates the list of AD templates versions in the Agent's cache and checks the list is up to date compared to http's data.
+// If the server supports conditional requests, the rules are fetched with If-None-Match
+// (held by the server until they change in watch mode), and kept for the next Collect.
+// Otherwise the summary is compared, by its hash if the server provides one.
 func (p *HttpConfigProvider) IsUpToDate(ctx context.Context) (bool, error) {
+	if p.etag != "" && !httpStatus.isStale() {
+		return p.isUpToDateByETag(ctx)
+	}
+
 	summary, err := p.Client.getCollectRulesSummary()
 	if err != nil {
 		log.Errorf("IsUpToDate %s", err)
 		return false, err
 	}
 
+	if httpStatus.isStale() {
+		log.Infof("the server is reachable again, refresh the stale rules of %v", p.String())
+		p.cache.NumAdTemplates = summary.Total
+		p.cache.LatestTemplateIdx = float64(summary.LatestUpdatedAt)
+		p.summaryHash = summary.Hash
+		return false, nil
+	}
+
+	if summary.Hash != "" {
+		if summary.Hash == p.summaryHash {
+			log.Debugf("cache up to date for %v", p.String())
+			return true, nil
+		}
+		log.Infof("hash was %q and is now %q", p.summaryHash, summary.Hash)
+		p.summaryHash = summary.Hash
+		return false, nil
+	}
+
 	adListUpdated := false
 	dateIdx := p.cache.LatestTemplateIdx
 
@@ -187,6 +402,39 @@ func (p *HttpConfigProvider) IsUpToDate(ctx context.Context) (bool, error) {
 	return true, nil
 }
 
+func (p *HttpConfigProvider) isUpToDateByETag(ctx context.Context) (bool, error) {
+	var timeout time.Duration
+	if p.watch {
+		timeout = p.watchTimeout
+		// return before the deadline of the poller
+		if deadline, ok := ctx.Deadline(); ok {
+			if d := time.Until(deadline) - watchGracePeriod; d < timeout {
+				timeout = d
+			}
+		}
+		if timeout < time.Second {
+			timeout = time.Second
+		}
+	}
+
+	rules, etag, err := p.Client.watchCollectRules(ctx, p.etag, timeout)
+	if err == errNotModified {
+		log.Debugf("cache up to date for %v", p.String())
+		return true, nil
+	}
+	if err != nil {
+		if ctx.Err() != context.Canceled {
+			log.Errorf("IsUpToDate %s", err)
+		}
+		return false, err
+	}
+
+	log.Infof("etag was %s and is now %s", p.etag, etag)
+	p.pending = rules
+	p.etag = etag
+	return false, nil
+}
+
 // String returns a string representation of the HttpConfigProvider
 func (p *HttpConfigProvider) String() string {
 	return names.Http
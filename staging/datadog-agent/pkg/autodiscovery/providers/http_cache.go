@@ -0,0 +1,167 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/n9e/n9e-agentd/pkg/api"
+)
+
+const collectRulesCacheFile = "collect-rules.json"
+
+// collectRulesSnapshot is the on-disk format of the last good rule set
+type collectRulesSnapshot struct {
+	SavedAt int64             `json:"saved_at"` // unix seconds of the fetch
+	Rules   []api.CollectRule `json:"rules"`
+}
+
+// collectRulesCache keeps the last good rule set under run_path
+type collectRulesCache struct {
+	path string
+}
+
+func newCollectRulesCache(path string) *collectRulesCache {
+	return &collectRulesCache{path: path}
+}
+
+// save writes the rules atomically: the rule set on disk is either the
+// previous one or the new one, never a partial write
+func (c *collectRulesCache) save(rules []api.CollectRule) error {
+	b, err := json.Marshal(&collectRulesSnapshot{
+		SavedAt: time.Now().Unix(),
+		Rules:   rules,
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err := os.Rename(tmpName, c.path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return nil
+}
+
+func (c *collectRulesCache) load() (*collectRulesSnapshot, error) {
+	b, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &collectRulesSnapshot{}
+	if err := json.Unmarshal(b, snapshot); err != nil {
+		return nil, fmt.Errorf("invalid cache file: %s", err)
+	}
+
+	return snapshot, nil
+}
+
+// HttpProviderStatus is the status of the http config provider, reported
+// in the autoconfig expvar
+type HttpProviderStatus struct {
+	Endpoint    string `json:"endpoint"`     // endpoint of the last successful fetch
+	CacheFile   string `json:"cache_file"`   //
+	LastSuccess int64  `json:"last_success"` // unix seconds
+	Stale       bool   `json:"stale"`        // the rules in use were not fetched by the last poll
+	StaleSince  int64  `json:"stale_since"`  // unix seconds of the fetch of the rules in use
+	LastError   string `json:"last_error"`   //
+}
+
+type httpProviderStatus struct {
+	sync.RWMutex
+	status *HttpProviderStatus
+}
+
+var httpStatus = &httpProviderStatus{}
+
+// GetHttpProviderStatus returns the status of the http config provider,
+// nil if the provider is not enabled
+func GetHttpProviderStatus() *HttpProviderStatus {
+	httpStatus.RLock()
+	defer httpStatus.RUnlock()
+
+	if httpStatus.status == nil {
+		return nil
+	}
+
+	status := *httpStatus.status
+	return &status
+}
+
+func (p *httpProviderStatus) set(status *HttpProviderStatus) {
+	p.Lock()
+	defer p.Unlock()
+	p.status = status
+}
+
+func (p *httpProviderStatus) fresh(endpoint string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.status == nil {
+		p.status = &HttpProviderStatus{}
+	}
+	p.status.Endpoint = endpoint
+	p.status.LastSuccess = time.Now().Unix()
+	p.status.Stale = false
+	p.status.StaleSince = 0
+	p.status.LastError = ""
+}
+
+// stale marks the rules in use as stale, since defaults to the last successful fetch
+func (p *httpProviderStatus) stale(since time.Time, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.status == nil {
+		p.status = &HttpProviderStatus{}
+	}
+	p.status.LastError = err.Error()
+
+	switch {
+	case !since.IsZero():
+		p.status.StaleSince = since.Unix()
+	case p.status.Stale && p.status.StaleSince > 0:
+		// keep it
+	case p.status.LastSuccess > 0:
+		p.status.StaleSince = p.status.LastSuccess
+	default:
+		p.status.StaleSince = time.Now().Unix()
+	}
+	p.status.Stale = true
+}
+
+func (p *httpProviderStatus) isStale() bool {
+	p.RLock()
+	defer p.RUnlock()
+	return p.status != nil && p.status.Stale
+}
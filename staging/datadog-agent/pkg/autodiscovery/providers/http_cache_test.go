@@ -0,0 +1,217 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/n9e/n9e-agentd/pkg/api"
+	"github.com/n9e/n9e-agentd/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unreachableURL refuses the connections
+const unreachableURL = "http://127.0.0.1:1"
+
+var testCollectRules = []api.CollectRule{
+	{ID: 1, Name: "ping", Type: "port", Data: `{"instances": [{"host": "localhost", "port": 22}]}`},
+	{ID: 2, Name: "date", Type: "script", Data: `{"instances": [{"file_path": "date.sh"}]}`},
+}
+
+func cacheFiles(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+func TestCollectRulesCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "run_path")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cache := newCollectRulesCache(filepath.Join(dir, collectRulesCacheFile))
+
+	_, err = cache.load()
+	assert.True(t, os.IsNotExist(err), "%v", err)
+
+	require.NoError(t, cache.save(testCollectRules[:1]))
+	snapshot, err := cache.load()
+	require.NoError(t, err)
+	assert.Equal(t, testCollectRules[:1], snapshot.Rules)
+	assert.InDelta(t, time.Now().Unix(), snapshot.SavedAt, 5)
+
+	// the file is replaced, a reader of the previous one still sees it
+	// entirely
+	f, err := os.Open(cache.path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, cache.save(testCollectRules))
+	assert.Equal(t, []string{collectRulesCacheFile}, cacheFiles(t, dir), "no temporary file is left")
+
+	prev := &collectRulesSnapshot{}
+	require.NoError(t, json.NewDecoder(f).Decode(prev))
+	assert.Equal(t, testCollectRules[:1], prev.Rules)
+
+	snapshot, err = cache.load()
+	require.NoError(t, err)
+	assert.Equal(t, testCollectRules, snapshot.Rules)
+
+	require.NoError(t, ioutil.WriteFile(cache.path, []byte(`{"rules": [`), 0644))
+	_, err = cache.load()
+	assert.Contains(t, err.Error(), "invalid cache file")
+}
+
+func TestCollectRulesCacheSaveError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "run_path")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the rename fails on a directory that is not empty
+	path := filepath.Join(dir, collectRulesCacheFile)
+	require.NoError(t, os.MkdirAll(filepath.Join(path, "keep"), 0755))
+
+	cache := newCollectRulesCache(path)
+	assert.Error(t, cache.save(testCollectRules))
+	assert.Equal(t, []string{collectRulesCacheFile}, cacheFiles(t, dir), "the temporary file is removed")
+
+	cache = newCollectRulesCache(filepath.Join(dir, "missing", collectRulesCacheFile))
+	assert.Error(t, cache.save(testCollectRules))
+}
+
+func TestHttpProviderCacheFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "run_path")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, collectRulesCacheFile)
+	savedAt := time.Now().Add(-time.Hour).Unix()
+	b, err := json.Marshal(&collectRulesSnapshot{SavedAt: savedAt, Rules: testCollectRules})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, b, 0644))
+
+	// the server is unreachable at startup, the cached rules are used
+	p, err := newHttpConfigProvider(config.ConfigurationProviders{TemplateURL: unreachableURL}, "test", path)
+	require.NoError(t, err)
+
+	configs, err := p.Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+	assert.Equal(t, "port", configs[0].Name)
+	assert.Equal(t, "http:ping:1", configs[0].Source)
+	assert.Equal(t, "script", configs[1].Name)
+
+	status := GetHttpProviderStatus()
+	require.NotNil(t, status)
+	assert.True(t, status.Stale)
+	assert.Equal(t, savedAt, status.StaleSince)
+	assert.Equal(t, path, status.CacheFile)
+	assert.Zero(t, status.LastSuccess)
+	assert.NotEmpty(t, status.LastError)
+
+	// the cache is read at startup only, the configs of the previous
+	// Collect are kept by autodiscovery
+	_, err = p.Collect(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, savedAt, GetHttpProviderStatus().StaleSince)
+
+	// without a cache, the error is returned
+	p, err = newHttpConfigProvider(config.ConfigurationProviders{TemplateURL: unreachableURL}, "test", filepath.Join(dir, "missing.json"))
+	require.NoError(t, err)
+	_, err = p.Collect(context.Background())
+	assert.Error(t, err)
+	assert.True(t, GetHttpProviderStatus().Stale)
+}
+
+func TestHttpProviderStaleSince(t *testing.T) {
+	dir, err := ioutil.TempDir("", "run_path")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, api.RoutePathGetCollectRules, r.URL.Path)
+		json.NewEncoder(w).Encode(api.CollectRulesWrap{Data: testCollectRules}) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	path := filepath.Join(dir, collectRulesCacheFile)
+	p, err := newHttpConfigProvider(config.ConfigurationProviders{TemplateURL: server.URL}, "test", path)
+	require.NoError(t, err)
+
+	configs, err := p.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, configs, 2)
+
+	status := GetHttpProviderStatus()
+	assert.False(t, status.Stale)
+	assert.Equal(t, server.URL, status.Endpoint)
+	assert.InDelta(t, time.Now().Unix(), status.LastSuccess, 5)
+
+	// the fetched rules are cached
+	snapshot, err := p.rulesCache.load()
+	require.NoError(t, err)
+	assert.Equal(t, testCollectRules, snapshot.Rules)
+
+	// the rules in use are stale since the last successful fetch
+	server.Close()
+	_, err = p.Collect(context.Background())
+	assert.Error(t, err)
+
+	stale := GetHttpProviderStatus()
+	assert.True(t, stale.Stale)
+	assert.Equal(t, status.LastSuccess, stale.StaleSince)
+	assert.Equal(t, status.LastSuccess, stale.LastSuccess)
+	assert.NotEmpty(t, stale.LastError)
+}
+
+func TestHttpProviderCacheETag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "run_path")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var version int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"%d"`, version)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		json.NewEncoder(w).Encode(api.CollectRulesWrap{Data: testCollectRules[:version+1]}) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	p, err := newHttpConfigProvider(config.ConfigurationProviders{TemplateURL: server.URL}, "test", filepath.Join(dir, collectRulesCacheFile))
+	require.NoError(t, err)
+
+	_, err = p.Collect(context.Background())
+	require.NoError(t, err)
+
+	// the rules fetched by IsUpToDate are cached once collected
+	version = 1
+	upToDate, err := p.IsUpToDate(context.Background())
+	require.NoError(t, err)
+	assert.False(t, upToDate)
+
+	configs, err := p.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, configs, 2)
+
+	snapshot, err := p.rulesCache.load()
+	require.NoError(t, err)
+	assert.Equal(t, testCollectRules, snapshot.Rules)
+	assert.InDelta(t, time.Now().Unix(), GetHttpProviderStatus().LastSuccess, 5)
+}
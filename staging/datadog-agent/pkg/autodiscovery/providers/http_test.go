@@ -0,0 +1,146 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
+	"github.com/n9e/n9e-agentd/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestParseRuleTags(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"colon", "a:b,c:d", []string{"a:b", "c:d"}},
+		{"equal", "a=b c=d", []string{"a:b", "c:d"}},
+		{"mixed separators", " a:b,\tc=d\ne:f, ", []string{"a:b", "c:d", "e:f"}},
+		{"empty tags", ",, ,a:b,", []string{"a:b"}},
+		{"only the first separator", "url=http://host:80,a:b=c", []string{"url:http://host:80", "a:b=c"}},
+		{"no value", "a,b:,c=", []string{"a", "b:", "c:"}},
+		{"no key", ":b,=c,d:e", []string{"d:e"}},
+		{"only separators", ": =", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseRuleTags(tt.in))
+		})
+	}
+}
+
+func TestMergeRuleInstance(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance string
+		interval int
+		tags     []string
+		want     map[string]interface{}
+		wantErr  bool
+	}{
+		{
+			name:     "rule values",
+			instance: "host: localhost",
+			interval: 30,
+			tags:     []string{"a:b", "c:d"},
+			want: map[string]interface{}{
+				"host":                    "localhost",
+				"min_collection_interval": 30,
+				"tags":                    []interface{}{"a:b", "c:d"},
+			},
+		},
+		{
+			name:     "instance interval wins",
+			instance: "min_collection_interval: 10",
+			interval: 30,
+			want:     map[string]interface{}{"min_collection_interval": 10},
+		},
+		{
+			name:     "instance tags win",
+			instance: "tags: [a:instance, x]",
+			tags:     []string{"a:rule", "c:d", "x:rule"},
+			want:     map[string]interface{}{"tags": []interface{}{"a:instance", "x", "c:d"}},
+		},
+		{
+			name:     "no rule values",
+			instance: "tags: [a:b]",
+			want:     map[string]interface{}{"tags": []interface{}{"a:b"}},
+		},
+		{
+			name:     "null tags",
+			instance: "tags: null",
+			tags:     []string{"a:b"},
+			want:     map[string]interface{}{"tags": []interface{}{"a:b"}},
+		},
+		{
+			name:     "tags are not a list",
+			instance: "tags: a:b",
+			tags:     []string{"c:d"},
+			wantErr:  true,
+		},
+		{
+			name:     "instance is not a map",
+			instance: "[a, b]",
+			interval: 30,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := mergeRuleInstance(integration.Data(tt.instance), tt.interval, tt.tags)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			got := map[string]interface{}{}
+			require.NoError(t, yaml.Unmarshal(data, &got))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestConvertConfigRuleValues(t *testing.T) {
+	p := &HttpConfigProvider{}
+	config, err := p.convertConfig(api.CollectRule{
+		ID:       1,
+		Name:     "ping",
+		Type:     "port",
+		Data:     `{"instances": [{"port": 22}, {"port": 80, "min_collection_interval": 10, "tags": ["env:dev"]}]}`,
+		Interval: 30,
+		Tags:     "env=prod,team=ops",
+	})
+	require.NoError(t, err)
+	require.Len(t, config.Instances, 2)
+
+	var instances []map[string]interface{}
+	for _, data := range config.Instances {
+		instance := map[string]interface{}{}
+		require.NoError(t, yaml.Unmarshal(data, &instance))
+		instances = append(instances, instance)
+	}
+	assert.Equal(t, []map[string]interface{}{{
+		"port":                    22,
+		"min_collection_interval": 30,
+		"tags":                    []interface{}{"env:prod", "team:ops"},
+	}, {
+		"port":                    80,
+		"min_collection_interval": 10,
+		"tags":                    []interface{}{"env:dev", "team:ops"},
+	}}, instances)
+
+	// the tags of the rule must be merged into a list
+	_, err = p.convertConfig(api.CollectRule{
+		Type: "port",
+		Data: `{"instances": [{"port": 22, "tags": "env:dev"}]}`,
+		Tags: "team=ops",
+	})
+	assert.Error(t, err)
+}
@@ -194,9 +194,10 @@ func TestGetPrometheusConfigs(t *testing.T) {
 			wantErr: false,
 		},
 	}
+	config.Mock()
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			config.Datadog.Set("prometheus_scrape.checks", tt.config)
+			config.C.PrometheusScrape.Checks = tt.config
 			checks, err := getPrometheusConfigs()
 			if (err != nil) != tt.wantErr {
 				t.Errorf("getPrometheusConfigs() error = %v, wantErr %v", err, tt.wantErr)
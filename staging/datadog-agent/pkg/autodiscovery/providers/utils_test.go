@@ -112,6 +112,8 @@ func TestParseCheckNames(t *testing.T) {
 }
 
 func TestBuildStoreKey(t *testing.T) {
+	config.Mock()
+	config.C.AutoconfTemplateDir = "/datadog/check_configs"
 	res := buildStoreKey()
 	assert.Equal(t, "/datadog/check_configs", res)
 	res = buildStoreKey("")
@@ -217,6 +217,19 @@ func (c *Collector) GetAllInstanceIDs(checkName string) []check.ID {
 	return instances
 }
 
+// GetChecks returns all the check instances
+func (c *Collector) GetChecks() []check.Check {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	checks := make([]check.Check, 0, len(c.checks))
+	for _, ch := range c.checks {
+		checks = append(checks, ch)
+	}
+
+	return checks
+}
+
 // ReloadAllCheckInstances completely restarts a check with a new configuration
 func (c *Collector) ReloadAllCheckInstances(name string, newInstances []check.Check) ([]check.ID, error) {
 	if !c.started() {
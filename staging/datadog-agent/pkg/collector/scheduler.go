@@ -92,6 +92,11 @@ func (s *CheckScheduler) Unschedule(configs []integration.Config) {
 		ids := s.configToChecks[digest]
 		stopped := map[check.ID]struct{}{}
 		for _, id := range ids {
+			// the check may have been stopped already, e.g. through the API
+			if _, found := s.collector.get(id); !found {
+				stopped[id] = struct{}{}
+				continue
+			}
 			// `StopCheck` might time out so we don't risk to block
 			// the polling loop forever
 			err := s.collector.StopCheck(id)
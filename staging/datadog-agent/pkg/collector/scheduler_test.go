@@ -12,6 +12,7 @@ import (
 
 	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
 	"github.com/DataDog/datadog-agent/pkg/collector/check"
+	"github.com/n9e/n9e-agentd/pkg/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -114,3 +115,49 @@ func TestGetChecksFromConfigs(t *testing.T) {
 		"Loader: core, Check: check_c",
 	}, actualChecks)
 }
+
+type idleCheck struct {
+	core.CheckBase
+}
+
+func (c *idleCheck) Run() error {
+	return nil
+}
+
+func (c *idleCheck) Cancel() {}
+
+type idleLoader struct{}
+
+func (l *idleLoader) Name() string {
+	return "core"
+}
+
+func (l *idleLoader) Load(config integration.Config, instance integration.Data) (check.Check, error) {
+	return &idleCheck{CheckBase: core.NewCheckBase(config.Name)}, nil
+}
+
+func TestUnscheduleStoppedCheck(t *testing.T) {
+	config.Mock()
+	c := NewCollector()
+	defer c.Stop()
+
+	s := &CheckScheduler{collector: c, configToChecks: make(map[string][]check.ID)}
+	s.AddLoader(&idleLoader{})
+
+	conf := integration.Config{Name: "stopped_check", Instances: []integration.Data{integration.Data("{}")}}
+	s.Schedule([]integration.Config{conf})
+	assert.Len(t, c.GetChecks(), 1)
+
+	// the check is stopped outside of the scheduler, e.g. through the API
+	id := check.ID("stopped_check")
+	assert.NoError(t, c.StopCheck(id))
+
+	s.Unschedule([]integration.Config{conf})
+	assert.NotContains(t, s.configToChecks, conf.Digest())
+	assert.NotContains(t, errorStats.getRunErrors(), id)
+
+	// it can be scheduled again
+	s.Schedule([]integration.Config{conf})
+	assert.Len(t, c.GetChecks(), 1)
+	assert.Equal(t, []check.ID{id}, s.configToChecks[conf.Digest()])
+}
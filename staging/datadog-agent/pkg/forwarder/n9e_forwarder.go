@@ -26,3 +26,10 @@ func (f *N9eForwarder) SubmitSeries(payload Payloads, extra http.Header) error {
 	transactions := f.createHTTPTransactions(n9eSeriesEndpoint, payload, false, extra)
 	return f.sendHTTPTransactions(transactions)
 }
+
+// SubmitSketchSeries will send the sketches, converted to n9e samples by the
+// payload processor, to the series endpoint.
+func (f *N9eForwarder) SubmitSketchSeries(payload Payloads, extra http.Header) error {
+	transactions := f.createHTTPTransactions(n9eSeriesEndpoint, payload, false, extra)
+	return f.sendHTTPTransactions(transactions)
+}
@@ -0,0 +1,90 @@
+package forwarder
+
+import (
+	"net/http"
+)
+
+// RemoteWriteForwarder sends the series to the prometheus remote write
+// endpoints, the other payloads are dropped
+type RemoteWriteForwarder struct {
+	*DefaultForwarder
+	headers map[string]string
+}
+
+// NewRemoteWriteForwarder returns a forwarder of the remote write output,
+// the keys of options.KeysPerDomain are the endpoints, the api keys are not
+// used
+func NewRemoteWriteForwarder(options *Options, headers map[string]string) *RemoteWriteForwarder {
+	options.DisableAPIKeyChecking = true
+	return &RemoteWriteForwarder{
+		DefaultForwarder: NewDefaultForwarder(options),
+		headers:          headers,
+	}
+}
+
+func (f *RemoteWriteForwarder) submit(payload Payloads, extra http.Header) error {
+	header := make(http.Header)
+	for k := range extra {
+		header.Set(k, extra.Get(k))
+	}
+	for k, v := range f.headers {
+		header.Set(k, v)
+	}
+
+	transactions := f.createHTTPTransactions(remoteWriteEndpoint, payload, false, header)
+	for _, t := range transactions {
+		t.Headers.Del(apiHTTPHeaderKey)
+	}
+	return f.sendHTTPTransactions(transactions)
+}
+
+// SubmitV1Series sends the remote write requests of the series
+func (f *RemoteWriteForwarder) SubmitV1Series(payload Payloads, extra http.Header) error {
+	return f.submit(payload, extra)
+}
+
+// SubmitSeries sends the remote write requests of the series
+func (f *RemoteWriteForwarder) SubmitSeries(payload Payloads, extra http.Header) error {
+	return f.submit(payload, extra)
+}
+
+// SubmitSketchSeries sends the remote write requests of the quantiles of
+// the sketches
+func (f *RemoteWriteForwarder) SubmitSketchSeries(payload Payloads, extra http.Header) error {
+	return f.submit(payload, extra)
+}
+
+// SubmitV1Intake drops the payload, it is not supported by remote write
+func (f *RemoteWriteForwarder) SubmitV1Intake(payload Payloads, extra http.Header) error {
+	return nil
+}
+
+// SubmitV1CheckRuns drops the payload, it is not supported by remote write
+func (f *RemoteWriteForwarder) SubmitV1CheckRuns(payload Payloads, extra http.Header) error {
+	return nil
+}
+
+// SubmitEvents drops the payload, it is not supported by remote write
+func (f *RemoteWriteForwarder) SubmitEvents(payload Payloads, extra http.Header) error {
+	return nil
+}
+
+// SubmitServiceChecks drops the payload, it is not supported by remote write
+func (f *RemoteWriteForwarder) SubmitServiceChecks(payload Payloads, extra http.Header) error {
+	return nil
+}
+
+// SubmitHostMetadata drops the payload, it is not supported by remote write
+func (f *RemoteWriteForwarder) SubmitHostMetadata(payload Payloads, extra http.Header) error {
+	return nil
+}
+
+// SubmitAgentChecksMetadata drops the payload, it is not supported by remote write
+func (f *RemoteWriteForwarder) SubmitAgentChecksMetadata(payload Payloads, extra http.Header) error {
+	return nil
+}
+
+// SubmitMetadata drops the payload, it is not supported by remote write
+func (f *RemoteWriteForwarder) SubmitMetadata(payload Payloads, extra http.Header) error {
+	return nil
+}
@@ -28,6 +28,9 @@ var (
 	n9eV1SeriesEndpoint = transaction.Endpoint{Route: api.N9eV1SeriesEndpoint, Name: "n9e_series_v1"}
 	n9eSeriesEndpoint   = transaction.Endpoint{Route: api.N9eSeriesEndpoint, Name: "n9e_series_v2"}
 
+	// the domain is the url of the remote write endpoint
+	remoteWriteEndpoint = transaction.Endpoint{Route: "", Name: "remote_write"}
+
 	v1SeriesEndpoint       = transaction.Endpoint{Route: "/api/v1/series", Name: "series_v1"}
 	v1CheckRunsEndpoint    = transaction.Endpoint{Route: "/api/v1/check_run", Name: "check_run_v1"}
 	v1IntakeEndpoint       = transaction.Endpoint{Route: "/api/v1/intake", Name: "intake"}
@@ -100,6 +103,7 @@ func initEndpointExpvars() {
 
 		n9eSeriesEndpoint,
 		n9eV1SeriesEndpoint,
+		remoteWriteEndpoint,
 	}
 
 	for _, endpoint := range endpoints {
@@ -30,6 +30,8 @@ func (m ContextMetrics) AddSample(contextKey ckey.ContextKey, sample *MetricSamp
 		switch sample.Mtype {
 		case GaugeType:
 			m[contextKey] = &Gauge{}
+		case TimestampedGaugeType:
+			m[contextKey] = &TimestampedGauge{}
 		case RateType:
 			m[contextKey] = &Rate{}
 		case CountType:
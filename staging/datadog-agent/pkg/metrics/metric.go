@@ -24,7 +24,7 @@ func SetProcessor(p PayloadProcessor) {
 
 type PayloadProcessor interface {
 	Process(Series) proto.Message
-	ProcessSketch(SketchSeriesList) error
+	ProcessSketch(SketchSeriesList) proto.Message
 	Tags([]string) []string
 	MetricName(string) string
 }
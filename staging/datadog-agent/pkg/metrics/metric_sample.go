@@ -28,6 +28,7 @@ const (
 	HistorateType                        // ?
 	SetType                              // ?
 	DistributionType                     // quantile
+	TimestampedGaugeType                 // gauge with the timestamps of its samples
 )
 
 // DistributionMetricTypes contains the MetricTypes that are used for percentiles
@@ -65,6 +66,8 @@ func (m MetricType) String() string {
 		return "Set"
 	case DistributionType:
 		return "Distribution"
+	case TimestampedGaugeType:
+		return "TimestampedGauge"
 	default:
 		return ""
 	}
@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	prompb "buf.build/gen/go/prometheus/prometheus/protocolbuffers/go"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	agentpayload "github.com/n9e/agent-payload/gogen"
+	"github.com/n9e/n9e-agentd/pkg/util"
+	protov2 "google.golang.org/protobuf/proto"
+)
+
+const (
+	remoteWriteMetricNameLabel = "__name__"
+	remoteWriteIdentLabel      = "ident"
+)
+
+// MarshalRemoteWrite encodes the series as snappy compressed prometheus
+// remote write requests of at most maxSamples samples each
+func (series Series) MarshalRemoteWrite(maxSamples int) ([][]byte, error) {
+	if processor == nil {
+		return nil, fmt.Errorf("the payload processor is required by the remote write output")
+	}
+	return marshalRemoteWrite(processor.Process(series), maxSamples)
+}
+
+// MarshalRemoteWrite encodes the quantiles of the sketches as snappy
+// compressed prometheus remote write requests
+func (sl SketchSeriesList) MarshalRemoteWrite(maxSamples int) ([][]byte, error) {
+	if processor == nil {
+		return nil, fmt.Errorf("the payload processor is required by the remote write output")
+	}
+	return marshalRemoteWrite(processor.ProcessSketch(sl), maxSamples)
+}
+
+func marshalRemoteWrite(msg proto.Message, maxSamples int) ([][]byte, error) {
+	payload, ok := msg.(*agentpayload.N9EMetricsPayload)
+	if !ok {
+		return nil, fmt.Errorf("unexpected payload %T", msg)
+	}
+
+	if maxSamples <= 0 {
+		maxSamples = len(payload.Samples)
+	}
+
+	var ret [][]byte
+	for start := 0; start < len(payload.Samples); start += maxSamples {
+		end := start + maxSamples
+		if end > len(payload.Samples) {
+			end = len(payload.Samples)
+		}
+
+		req := &prompb.WriteRequest{
+			Timeseries: make([]*prompb.TimeSeries, 0, end-start),
+		}
+		for _, sample := range payload.Samples[start:end] {
+			req.Timeseries = append(req.Timeseries, remoteWriteTimeSeries(sample))
+		}
+
+		b, err := protov2.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, snappy.Encode(nil, b))
+	}
+
+	return ret, nil
+}
+
+// remoteWriteTimeSeries converts a sample, the metric name and the tags are
+// already sanitized by the processor, the time is in seconds
+func remoteWriteTimeSeries(sample *agentpayload.N9EMetricsPayload_Sample) *prompb.TimeSeries {
+	labels := make([]*prompb.Label, 0, len(sample.Tags)+2)
+	labels = append(labels, &prompb.Label{
+		Name:  remoteWriteMetricNameLabel,
+		Value: remoteWriteName(sample.Metric),
+	})
+	if sample.Ident != "" {
+		labels = append(labels, &prompb.Label{Name: remoteWriteIdentLabel, Value: sample.Ident})
+	}
+
+	keys := make([]string, 0, len(sample.Tags))
+	for k := range sample.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// the tags with the same label name once sanitized, e.g. 1st and _1st,
+	// are deduped, the first one by the tag name in byte order is kept
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		v := sample.Tags[k]
+		name := remoteWriteName(k)
+		// the reserved labels and the empty values are dropped
+		if v == "" || seen[name] || strings.HasPrefix(name, "__") ||
+			(name == remoteWriteIdentLabel && sample.Ident != "") {
+			continue
+		}
+		seen[name] = true
+		labels = append(labels, &prompb.Label{Name: name, Value: v})
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+
+	return &prompb.TimeSeries{
+		Labels: labels,
+		Samples: []*prompb.Sample{{
+			Value:     sample.Value,
+			Timestamp: sample.Time * 1000,
+		}},
+	}
+}
+
+// remoteWriteName returns a valid prometheus metric or label name
+func remoteWriteName(name string) string {
+	name = util.SanitizeMetric(name)
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return "_" + name
+	}
+	return name
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/serializer/stream"
 	"github.com/DataDog/datadog-agent/pkg/telemetry"
 	"github.com/DataDog/datadog-agent/pkg/util/common"
+	"github.com/gogo/protobuf/proto"
 	"github.com/n9e/agent-payload/gogen"
 	"github.com/n9e/n9e-agentd/pkg/config"
 )
@@ -236,6 +237,10 @@ func encodeVarintAgentPayload(dAtA []byte, offset int, v uint64) int {
 
 // Marshal encodes this series list.
 func (sl SketchSeriesList) Marshal() ([]byte, error) {
+	if config.C.EnableN9eProvider {
+		return proto.Marshal(processor.ProcessSketch(sl))
+	}
+
 	pb := &gogen.SketchPayload{
 		Sketches: make([]gogen.SketchPayload_Sketch, 0, len(sl)),
 	}
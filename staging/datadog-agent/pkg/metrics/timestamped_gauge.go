@@ -0,0 +1,27 @@
+package metrics
+
+// TimestampedGauge tracks the values of a metric sampled with their own
+// timestamp, e.g. by a script, every sample is flushed at its timestamp
+type TimestampedGauge struct {
+	points []Point
+}
+
+func (g *TimestampedGauge) addSample(sample *MetricSample, timestamp float64) {
+	g.points = append(g.points, Point{Ts: timestamp, Value: sample.Value})
+}
+
+func (g *TimestampedGauge) flush(timestamp float64) ([]*Serie, error) {
+	points := g.points
+	g.points = nil
+
+	if len(points) == 0 {
+		return []*Serie{}, NoSerieError{}
+	}
+
+	return []*Serie{
+		{
+			Points: points,
+			MType:  APIGaugeType,
+		},
+	}, nil
+}
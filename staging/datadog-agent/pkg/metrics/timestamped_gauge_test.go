@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampedGaugeSampling(t *testing.T) {
+	mGauge := TimestampedGauge{}
+
+	mGauge.addSample(&MetricSample{Value: 1}, 50)
+	mGauge.addSample(&MetricSample{Value: 2}, 55)
+
+	// the samples are flushed at their timestamps
+	series, err := mGauge.flush(60)
+	assert.NoError(t, err)
+	assert.Len(t, series, 1)
+	assert.Equal(t, []Point{{Ts: 50, Value: 1}, {Ts: 55, Value: 2}}, series[0].Points)
+	assert.Equal(t, APIGaugeType, series[0].MType)
+
+	_, err = mGauge.flush(70)
+	assert.Equal(t, NoSerieError{}, err)
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/util/compression"
 	"github.com/DataDog/datadog-agent/pkg/util/log"
 	"github.com/n9e/n9e-agentd/pkg/config"
+	fconfig "github.com/n9e/n9e-agentd/pkg/config/forwarder"
 )
 
 const (
@@ -40,6 +41,7 @@ var (
 	protobufExtraHeaders                http.Header
 	jsonExtraHeadersWithCompression     http.Header
 	protobufExtraHeadersWithCompression http.Header
+	remoteWriteExtraHeaders             http.Header
 
 	expvars                                 = expvar.NewMap("serializer")
 	expvarsSendEventsErrItemTooBigs         = expvar.Int{}
@@ -72,6 +74,11 @@ func initExtraHeaders() {
 		protobufExtraHeadersWithCompression.Set(k, protobufExtraHeaders.Get(k))
 	}
 
+	remoteWriteExtraHeaders = make(http.Header)
+	remoteWriteExtraHeaders.Set("Content-Type", protobufContentType)
+	remoteWriteExtraHeaders.Set("Content-Encoding", "snappy")
+	remoteWriteExtraHeaders.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
 	if compression.ContentEncoding != "" {
 		jsonExtraHeadersWithCompression.Set("Content-Encoding", compression.ContentEncoding)
 		protobufExtraHeadersWithCompression.Set("Content-Encoding", compression.ContentEncoding)
@@ -294,6 +301,10 @@ func (s *Serializer) SendSeries(series marshaler.StreamJSONMarshaler) error {
 		return nil
 	}
 
+	if config.C.Forwarder.Output == fconfig.OutputRemoteWrite {
+		return s.sendRemoteWrite(series, s.Forwarder.SubmitSeries)
+	}
+
 	useV1API := !config.C.UseV2Api.Series
 
 	var seriesPayloads forwarder.Payloads
@@ -323,7 +334,12 @@ func (s *Serializer) SendSketch(sketches marshaler.Marshaler) error {
 		return nil
 	}
 
-	if s.enableSketchProtobufStream {
+	if config.C.Forwarder.Output == fconfig.OutputRemoteWrite {
+		return s.sendRemoteWrite(sketches, s.Forwarder.SubmitSketchSeries)
+	}
+
+	// the n9e payload is built by the payload processor, not streamed
+	if s.enableSketchProtobufStream && !config.C.EnableN9eProvider {
 		payloads, err := sketches.MarshalSplitCompress(marshaler.DefaultBufferContext())
 		if err == nil {
 			return s.Forwarder.SubmitSketchSeries(payloads, protobufExtraHeadersWithCompression)
@@ -341,6 +357,33 @@ func (s *Serializer) SendSketch(sketches marshaler.Marshaler) error {
 	return s.Forwarder.SubmitSketchSeries(splitSketches, extraHeaders)
 }
 
+// remoteWriteMarshaler is implemented by the series and the sketches which
+// can be sent to a prometheus remote write endpoint
+type remoteWriteMarshaler interface {
+	MarshalRemoteWrite(maxSamples int) ([][]byte, error)
+}
+
+func (s *Serializer) sendRemoteWrite(m interface{}, submit func(payload forwarder.Payloads, extra http.Header) error) error {
+	rw, ok := m.(remoteWriteMarshaler)
+	if !ok {
+		return fmt.Errorf("dropping payload: %T can't be sent to a remote write endpoint", m)
+	}
+
+	payloads, err := rw.MarshalRemoteWrite(config.C.Forwarder.RemoteWrite.MaxSamplesPerSend)
+	if err != nil {
+		return fmt.Errorf("dropping remote write payload: %s", err)
+	}
+	if len(payloads) == 0 {
+		return nil
+	}
+
+	ret := make(forwarder.Payloads, 0, len(payloads))
+	for i := range payloads {
+		ret = append(ret, &payloads[i])
+	}
+	return submit(ret, remoteWriteExtraHeaders)
+}
+
 // SendMetadata serializes a metadata payload and sends it to the forwarder
 func (s *Serializer) SendMetadata(m marshaler.Marshaler) error {
 	return s.sendMetadata(m, s.Forwarder.SubmitMetadata)